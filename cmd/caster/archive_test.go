@@ -0,0 +1,79 @@
+package caster
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeArchiveTree(t *testing.T, workingDir string, modTime time.Time) {
+	t.Helper()
+	files := map[string]string{
+		"grafana/Deployment_grafana.yaml": "kind: Deployment\n",
+		"grafana/notes.txt":               "not a manifest\n",
+		"alpha/ConfigMap_settings.yaml":   "kind: ConfigMap\n",
+		"pre/grafana.yaml":                "kind: Deployment\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(workingDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestWriteSourceArchive(t *testing.T) {
+	var archives [][]byte
+	for i, modTime := range []time.Time{time.Unix(1000, 0), time.Unix(2000, 0)} {
+		workingDir := filepath.Join(t.TempDir(), "working")
+		writeArchiveTree(t, workingDir, modTime)
+		archivePath := filepath.Join(t.TempDir(), "src.tar.gz")
+		if err := writeSourceArchive(workingDir, archivePath); err != nil {
+			t.Fatalf("run %d: unexpected error: %v", i, err)
+		}
+		data, err := os.ReadFile(archivePath)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		archives = append(archives, data)
+	}
+	if !bytes.Equal(archives[0], archives[1]) {
+		t.Errorf("expected the same files to give byte-identical archives regardless of their timestamps")
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archives[0]))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	var names []string
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !header.ModTime.IsZero() && header.ModTime.Unix() != 0 {
+			t.Errorf("expected %s to have a fixed timestamp, got %v", header.Name, header.ModTime)
+		}
+		names = append(names, header.Name)
+	}
+	expected := []string{"working/alpha/ConfigMap_settings.yaml", "working/grafana/Deployment_grafana.yaml"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the yaml files outside pre in lexical order %v, got %v", expected, names)
+	}
+}
@@ -43,35 +43,59 @@ type targettool struct {
 	Type []string
 }
 
-func Cast(configs []utils.Config, filesDir string, workingDir string, stacksDir string) {
+// Cast builds a stack from the selected tools, and returns the path of its
+// package directory.
+func Cast(configs []utils.Config, filesDir string, workingDir string, stacksDir string) (string, error) {
 	log.Info("Starting up the menu...")
 
-	castname, imagename, toolTypes := handleInteractiveForm(workingDir)
+	castname, imagename, toolTypes, err := handleInteractiveForm(workingDir)
+	if err != nil {
+		return "", err
+	}
 
 	accessible, _ := strconv.ParseBool(os.Getenv("ACCESSIBLE"))
-	err := spinner.New().
+	var castErr error
+	err = spinner.New().
 		Title("Preparing your stack...").
 		Accessible(accessible).
 		Action(func() {
-			if err := CastTool(configs, toolTypes, filesDir, workingDir); err != nil {
-				log.Fatalf("Error during preparation: %v", err)
-			}
+			castErr = CastTool(configs, toolTypes, filesDir, workingDir)
 		}).
 		Run()
 	if err != nil {
-		log.Fatalf("Error during preparation: %v", err)
+		return "", fmt.Errorf("error during preparation: %w", err)
+	}
+	if castErr != nil {
+		return "", fmt.Errorf("error during preparation: %w", castErr)
 	}
 
-	packageDir := PreparePackageDirectory(stacksDir, castname)
-	CopyFilesWithSpinner(filesDir, packageDir, imagename)
-	AppendStringToYAMLFile(filepath.Join(packageDir, "crossplane.yaml"), fmt.Sprintf("  package: %s", imagename))
-	displaySuccessMessage(castname)
+	packageDir, err := PreparePackageDirectory(workingDir, stacksDir, castname)
+	if err != nil {
+		return "", err
+	}
+	err = CopyFilesWithSpinner(filesDir, packageDir, imagename)
+	if err != nil {
+		return "", err
+	}
+	err = AppendStringToYAMLFile(filepath.Join(packageDir, "crossplane.yaml"), fmt.Sprintf("  package: %s", imagename))
+	if err != nil {
+		return "", utils.NewError(utils.RenderError, err)
+	}
+	// The record goes in last, as it holds the digest of the other files
+	record := utils.NewReleaseRecord(castname, configs, toolTypes, workingDir)
+	if err := utils.WriteReleaseRecord(record, packageDir); err != nil {
+		return "", utils.NewError(utils.RenderError, err)
+	}
+	if !utils.Quiet() {
+		displaySuccessMessage(castname)
+	}
+	return packageDir, nil
 }
 
-func handleInteractiveForm(workingDir string) (string, string, []string) {
+func handleInteractiveForm(workingDir string) (string, string, []string, error) {
 	files, err := os.ReadDir(workingDir)
 	if err != nil {
-		log.Fatalf("Failed to read working directory: %v", err)
+		return "", "", nil, fmt.Errorf("failed to read working directory: %w", err)
 	}
 
 	names := []string{"all"}
@@ -141,7 +165,7 @@ func handleInteractiveForm(workingDir string) (string, string, []string) {
 	))
 
 	if err := huh.NewForm(form...).Run(); err != nil {
-		log.Fatalf("Interactive form failed: %v", err)
+		return "", "", nil, fmt.Errorf("interactive form failed: %w", err)
 	}
 
 	// Handle "all" selection
@@ -150,7 +174,7 @@ func handleInteractiveForm(workingDir string) (string, string, []string) {
 		toolTypes = removeElement(toolTypes, "all")
 	}
 
-	return castname, imagename, toolTypes
+	return castname, imagename, toolTypes, nil
 }
 
 func removeElement(slice []string, element string) []string {
@@ -174,27 +198,29 @@ func CastTool(configs []utils.Config, toolTypes []string, filesDir, workingDir s
 	for _, tool := range toolTypes {
 		config, exists := configMap[tool]
 		if !exists {
-			return fmt.Errorf("tool %s not found in config map", tool)
+			return utils.Errorf(utils.ConfigError, "tool %s not found in config map", tool)
 		}
 
+		stopCompile := utils.StartStage(config.Name, utils.StageCompile)
 		err := utils.CreateCrossplaneObject(config, filesDir, workingDir)
 		if err != nil {
-			return fmt.Errorf("failed to create crossplane object for %s: %v", config.Name, err)
+			return fmt.Errorf("failed to create crossplane object for %s: %w", config.Name, err)
 		}
+		stopCompile()
 
 		err = utils.ProcessNamespaceFiles(filesDir)
 		if err != nil {
-			log.Fatalf("Failed to process namespace files for %s: %v", config.Name, err)
+			return utils.Errorf(utils.RenderError, "failed to process namespace files for %s: %w", config.Name, err)
 		}
 
 		err = utils.RemoveEmptyYAMLFiles(filesDir)
 		if err != nil {
-			return fmt.Errorf("failed to remove empty YAML files for %s: %v", config.Name, err)
+			return utils.Errorf(utils.RenderError, "failed to remove empty YAML files for %s: %w", config.Name, err)
 		}
 
 		namespaceFile, crdFile, secretFile, externalSecretFile, objectFile, err := FetchFilesAndCategorizeByPrefix(filesDir, tool)
 		if err != nil {
-			return fmt.Errorf("failed to fetch and categorize files for %s: %v", config.Name, err)
+			return utils.Errorf(utils.RenderError, "failed to fetch and categorize files for %s: %w", config.Name, err)
 		}
 
 		config.CRDFiles = append(config.CRDFiles, crdFile...)
@@ -224,50 +250,63 @@ func CastTool(configs []utils.Config, toolTypes []string, filesDir, workingDir s
 		}
 
 		if !rawSecrets {
-			return fmt.Errorf("fix secrets and try again")
+			return utils.Errorf(utils.ValidationError, "fix secrets and try again")
 		}
 	}
 
 	return nil
 }
 
-func PreparePackageDirectory(stacksDir, castname string) string {
+func PreparePackageDirectory(workingDir, stacksDir, castname string) (string, error) {
 	packageDir := filepath.Join(stacksDir, castname)
 	err := os.MkdirAll(packageDir, 0755)
 	if err != nil {
-		log.Fatalf("Failed to create package directory: %s", err)
+		return "", fmt.Errorf("failed to create package directory: %w", err)
+	}
+	err = writeSourceArchive(workingDir, filepath.Join(packageDir, "src-yamls.tar.gz"))
+	if err != nil {
+		return "", fmt.Errorf("failed to archive source yamls: %w", err)
 	}
-	utils.RunCommand("find working -type f -name \"*.yaml\" ! -path \"working/pre/*\" | tar -czvf stacks/" + castname + "/src-yamls.tar.gz -T -")
 
-	return packageDir
+	return packageDir, nil
 }
 
-func CopyFilesWithSpinner(filesDir, packageDir string, imagename string) {
+func CopyFilesWithSpinner(filesDir, packageDir string, imagename string) error {
+	var copyErr error
 	err := spinner.New().
 		Title("Compiling files and creating image...").
 		Action(func() {
-			err := utils.CopyYAMLFiles("cmd/utils/templates", packageDir)
-			if err != nil {
-				log.Fatalf("failed to copy YAML files: %s", err)
-			}
-
-			err = utils.CopyYAMLFiles("templates", packageDir)
-			if err != nil {
-				log.Fatalf("failed to copy YAML files: %s", err)
-			}
-			err = utils.CopyFile("cmd/utils/templates/deploy.sh", packageDir+"/deploy.sh")
-			if err != nil {
-				log.Fatalf("failed to copy deploy.sh : %s", err)
-			}
-			err = BuildAndPushImage(imagename)
-			if err != nil {
-				log.Fatalf("failed to build image : %s", err)
-			}
+			copyErr = copyFilesAndBuildImage(filesDir, packageDir, imagename)
 		}).
 		Run()
 	if err != nil {
-		log.Fatalf("Failed to copy files to package directory: %v", err)
+		return fmt.Errorf("failed to copy files to package directory: %w", err)
+	}
+	return copyErr
+}
+
+func copyFilesAndBuildImage(filesDir, packageDir string, imagename string) error {
+	err := utils.CopyYAMLFiles("cmd/utils/templates", packageDir)
+	if err != nil {
+		return fmt.Errorf("failed to copy YAML files: %w", err)
 	}
+
+	err = utils.CopyYAMLFiles("templates", packageDir)
+	if err != nil {
+		return fmt.Errorf("failed to copy YAML files: %w", err)
+	}
+	err = utils.CopyFile("cmd/utils/templates/deploy.sh", packageDir+"/deploy.sh")
+	if err != nil {
+		return fmt.Errorf("failed to copy deploy.sh: %w", err)
+	}
+	// docker_forge copies output/*.yaml, so build from the directory holding filesDir
+	stopBuild := utils.StartStage("stack", utils.StageBuild)
+	err = BuildAndPushImage(imagename, filepath.Dir(filesDir))
+	stopBuild()
+	if err != nil {
+		return fmt.Errorf("failed to build image: %w", err)
+	}
+	return nil
 }
 
 func displaySuccessMessage(castname string) {
@@ -313,8 +352,8 @@ func FetchFilesAndCategorizeByPrefix(dir string, prefix string) (namespaceFiles,
 	return namespaceFiles, crdFiles, secretFiles, externalSecretFiles, objectFiles, nil
 }
 
-func BuildAndPushImage(imageName string) error {
-	cmd := exec.Command("docker", "buildx", "build", "-t", imageName, "--platform", "linux/amd64,linux/arm64", "-f", "docker_forge", "--push", ".")
+func BuildAndPushImage(imageName string, contextDir string) error {
+	cmd := exec.Command("docker", "buildx", "build", "-t", imageName, "--platform", "linux/amd64,linux/arm64", "-f", "docker_forge", "--push", contextDir)
 
 	// Capture stdout and stderr
 	stdoutPipe, err := cmd.StdoutPipe()
@@ -0,0 +1,192 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package cleaner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Options selects what Clean removes.
+type Options struct {
+	// Working removes the smelted tools in the working directory.
+	Working bool
+	// Cache removes intermediate files left behind by earlier runs: run
+	// directories kept with --keep-workdir or left by a crash, and the
+	// output directory used by older versions.
+	Cache bool
+	// KeepStacks removes all but the newest KeepStacks stacks. A negative
+	// value keeps all stacks.
+	KeepStacks int
+	// DryRun only reports what would be removed.
+	DryRun bool
+}
+
+// Clean removes the selected directories of the workspace and returns the
+// paths it removed (or would remove, for a dry run).
+func Clean(options Options, workspace utils.Workspace) ([]string, error) {
+	var targets []string
+
+	if options.Working {
+		working, err := workingTargets(workspace.WorkingDir())
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, working...)
+	}
+	if options.Cache {
+		cache, locks, err := cacheTargets(workspace, options.DryRun)
+		// The run directories stay locked until they are gone, so no run
+		// can start using them in between
+		defer releaseAll(locks)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, cache...)
+	}
+	if options.KeepStacks >= 0 {
+		stacks, err := oldStacks(workspace.StacksDir(), options.KeepStacks)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, stacks...)
+	}
+
+	for _, target := range targets {
+		if options.DryRun {
+			log.Infof("Would remove %s", target)
+			continue
+		}
+		log.Infof("Removing %s", target)
+		if err := os.RemoveAll(target); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", target, err)
+		}
+	}
+	return targets, nil
+}
+
+// workingTargets returns the smelted tool directories and the rendered files
+// in working/pre, keeping the directories themselves.
+func workingTargets(workingDir string) ([]string, error) {
+	var targets []string
+	files, err := os.ReadDir(workingDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read working directory: %w", err)
+	}
+	for _, file := range files {
+		if file.IsDir() && file.Name() != "pre" {
+			targets = append(targets, filepath.Join(workingDir, file.Name()))
+		}
+	}
+	for _, pattern := range []string{"*.yaml", "pre/*.yaml"} {
+		matches, err := filepath.Glob(filepath.Join(workingDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, matches...)
+	}
+	return targets, nil
+}
+
+// cacheTargets returns the workspace's run directories which are not in use
+// by another run, and the yaml files in the legacy output directory. The run
+// directories are returned locked, except for a dry run, which only checks
+// whether they are in use.
+func cacheTargets(workspace utils.Workspace, dryRun bool) ([]string, []*utils.RunLock, error) {
+	var targets []string
+	var locks []*utils.RunLock
+	runDirs, err := filepath.Glob(filepath.Join(os.TempDir(), workspace.RunDirPattern()))
+	if err != nil {
+		return nil, locks, err
+	}
+	for _, runDir := range runDirs {
+		if dryRun {
+			holder, err := utils.RunLockHolder(runDir)
+			if err != nil {
+				log.Infof("Skipping %s: %v", runDir, err)
+				continue
+			}
+			if holder != "" {
+				log.Infof("Skipping %s, it is in use by %s", runDir, holder)
+				continue
+			}
+		} else {
+			lock, err := utils.AcquireRunLock(runDir, "clean", 0)
+			if err != nil {
+				log.Infof("Skipping %s, it is in use: %v", runDir, err)
+				continue
+			}
+			locks = append(locks, lock)
+		}
+		targets = append(targets, runDir)
+	}
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(workspace.OutputDir(), pattern))
+		if err != nil {
+			return nil, locks, err
+		}
+		targets = append(targets, matches...)
+	}
+	return targets, locks, nil
+}
+
+func releaseAll(locks []*utils.RunLock) {
+	for _, lock := range locks {
+		lock.Release()
+	}
+}
+
+// oldStacks returns the stacks beyond the keep newest, by modification time.
+func oldStacks(stacksDir string, keep int) ([]string, error) {
+	type stack struct {
+		path    string
+		modTime int64
+	}
+	var stacks []stack
+
+	files, err := os.ReadDir(stacksDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read stacks directory: %w", err)
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file info for %s: %w", file.Name(), err)
+		}
+		stacks = append(stacks, stack{path: filepath.Join(stacksDir, file.Name()), modTime: info.ModTime().Unix()})
+	}
+
+	sort.Slice(stacks, func(i, j int) bool {
+		return stacks[i].modTime > stacks[j].modTime
+	})
+
+	var targets []string
+	for i, stack := range stacks {
+		if i >= keep {
+			targets = append(targets, stack.path)
+		}
+	}
+	return targets, nil
+}
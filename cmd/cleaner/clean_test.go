@@ -0,0 +1,179 @@
+package cleaner
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+func writeFiles(t *testing.T, root string, files []string) {
+	t.Helper()
+	for _, file := range files {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("kind: ConfigMap\n"), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func relativePaths(t *testing.T, root string, paths []string) []string {
+	t.Helper()
+	relative := []string{}
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		relative = append(relative, filepath.ToSlash(rel))
+	}
+	sort.Strings(relative)
+	return relative
+}
+
+func TestWorkingTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		expected []string
+	}{
+		{"empty", nil, []string{}},
+		{"tools and rendered files", []string{"grafana/Deployment_grafana.yaml", "pre/grafana.yaml", "stray.yaml"},
+			[]string{"grafana", "pre/grafana.yaml", "stray.yaml"}},
+		{"other files in pre are kept", []string{"pre/notes.txt"}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			workingDir := t.TempDir()
+			writeFiles(t, workingDir, test.files)
+			targets, err := workingTargets(workingDir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := relativePaths(t, workingDir, targets); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestOldStacks(t *testing.T) {
+	tests := []struct {
+		name     string
+		keep     int
+		expected []string
+	}{
+		{"keep all", 3, []string{}},
+		{"keep newest", 1, []string{"stack-1", "stack-2"}},
+		{"keep none", 0, []string{"stack-1", "stack-2", "stack-3"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stacksDir := t.TempDir()
+			for i, name := range []string{"stack-1", "stack-2", "stack-3"} {
+				path := filepath.Join(stacksDir, name)
+				if err := os.Mkdir(path, 0755); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				modTime := time.Unix(int64(1000*(i+1)), 0)
+				if err := os.Chtimes(path, modTime, modTime); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			targets, err := oldStacks(stacksDir, test.keep)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := relativePaths(t, stacksDir, targets); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestCacheTargets(t *testing.T) {
+	tests := []struct {
+		name   string
+		dryRun bool
+	}{
+		{"remove", false},
+		{"dry run", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("TMPDIR", t.TempDir())
+			workspace := utils.Workspace{Name: "test", Root: t.TempDir()}
+			other := utils.Workspace{Name: "other", Root: t.TempDir()}
+			writeFiles(t, workspace.Root, []string{"output/legacy.yaml"})
+
+			leftOver, err := utils.NewRunDir(workspace, true)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			leftOver.Cleanup()
+			inUse, err := utils.NewRunDir(workspace, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer inUse.Cleanup()
+			otherRun, err := utils.NewRunDir(other, true)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			otherRun.Cleanup()
+			// A run directory without a lock file, e.g. from a crashed run
+			crashed, err := os.MkdirTemp("", workspace.RunDirPattern())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			targets, locks, err := cacheTargets(workspace, test.dryRun)
+			releaseAll(locks)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := []string{leftOver.Path, crashed, filepath.Join(workspace.OutputDir(), "legacy.yaml")}
+			sort.Strings(expected)
+			sort.Strings(targets)
+			if !reflect.DeepEqual(targets, expected) {
+				t.Errorf("expected only the workspace's unused run directories and legacy output %v, got %v", expected, targets)
+			}
+			_, err = os.Stat(filepath.Join(crashed, utils.RunLockFile))
+			if test.dryRun && !os.IsNotExist(err) {
+				t.Errorf("expected a dry run not to create lock files, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCleanDryRun(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	workspace := utils.Workspace{Name: "test", Root: t.TempDir()}
+	writeFiles(t, workspace.Root, []string{"working/grafana/Deployment_grafana.yaml"})
+
+	removed, err := Clean(Options{Working: true, Cache: true, KeepStacks: -1, DryRun: true}, workspace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(removed) != 1 {
+		t.Errorf("expected the tool directory to be listed, got %v", removed)
+	}
+	if _, err := os.Stat(filepath.Join(workspace.WorkingDir(), "grafana")); err != nil {
+		t.Errorf("expected a dry run to remove nothing: %v", err)
+	}
+
+	removed, err = Clean(Options{Working: true, KeepStacks: -1}, workspace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workspace.WorkingDir(), "grafana")); !os.IsNotExist(err) {
+		t.Errorf("expected %v to be removed, got %v", removed, err)
+	}
+}
@@ -0,0 +1,253 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package forger
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+)
+
+// Inventory is what forge installed on a cluster: the release records of its
+// stacks and the objects carrying forge's annotations.
+type Inventory struct {
+	// Name names the cluster in reports, e.g. its context.
+	Name     string
+	Releases []utils.ReleaseRecord
+	// Objects are keyed by kind, group, namespace and name, which don't
+	// depend on the API versions a cluster serves.
+	Objects map[string]InventoryObject
+}
+
+// InventoryObject is an object generated by forge.
+type InventoryObject struct {
+	Tool         string
+	SourceDigest string
+	// ObjectDigest is the digest of the object as applied, missing on objects
+	// smelted before it was stamped.
+	ObjectDigest string
+}
+
+// KubeConfigForContext returns the client configuration of a context of the
+// kubeconfig, like kubectl --context.
+func KubeConfigForContext(kubeconfig, contextName string) (*rest.Config, error) {
+	kubeConfig, err := kubectlConfig(KubectlOptions{Kubeconfig: kubeconfig, Context: contextName})
+	if err != nil {
+		return nil, utils.Errorf(utils.ConfigError, "failed to configure Kubernetes client for %s: %w", contextName, err)
+	}
+	return kubeConfig, nil
+}
+
+// TakeInventory lists the release record and forge's objects in the
+// cluster. Forge's objects are only marked by annotations, so every kind the
+// cluster serves is listed, reading only the objects' metadata.
+func TakeInventory(name string, kubeConfig *rest.Config) (Inventory, error) {
+	inventory := Inventory{Name: name, Objects: map[string]InventoryObject{}}
+	releases, err := InstalledReleases(kubeConfig)
+	if err != nil {
+		return inventory, err
+	}
+	inventory.Releases = releases
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(kubeConfig)
+	if err != nil {
+		return inventory, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	metadataClient, err := metadata.NewForConfig(kubeConfig)
+	if err != nil {
+		return inventory, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	lists, err := discoveryClient.ServerPreferredResources()
+	if err != nil {
+		// Unavailable aggregated APIs leave out their groups only
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return inventory, fmt.Errorf("failed to discover the resources of %s: %w", name, err)
+		}
+		log.Warnf("Leaving out API groups of %s: %v", name, err)
+	}
+	for _, list := range discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list"}}, lists) {
+		groupVersion, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			if strings.Contains(resource.Name, "/") {
+				// Subresources belong to objects listed already
+				continue
+			}
+			objects, err := metadataClient.Resource(groupVersion.WithResource(resource.Name)).List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				log.Warnf("Failed to list %s on %s: %v", resource.Name, name, err)
+				continue
+			}
+			for _, object := range objects.Items {
+				tool := object.Annotations[utils.AnnotationTool]
+				if tool == "" {
+					continue
+				}
+				id := inventoryID(groupVersion.Group, resource.Kind, object.Namespace, object.Name)
+				inventory.Objects[id] = InventoryObject{
+					Tool:         tool,
+					SourceDigest: object.Annotations[utils.AnnotationSourceDigest],
+					ObjectDigest: object.Annotations[utils.AnnotationObjectDigest],
+				}
+			}
+		}
+	}
+	return inventory, nil
+}
+
+func inventoryID(group, kind, namespace, name string) string {
+	if group != "" {
+		kind += "." + group
+	}
+	if namespace != "" {
+		name = namespace + "/" + name
+	}
+	return kind + " " + name
+}
+
+// tools returns the tools of the inventory, from the release records or
+// else from the objects.
+func (i Inventory) tools() map[string]utils.ToolRecord {
+	tools := map[string]utils.ToolRecord{}
+	if len(i.Releases) > 0 {
+		for _, release := range i.Releases {
+			for _, tool := range release.Tools {
+				tools[tool.Name] = tool
+			}
+		}
+		return tools
+	}
+	for _, object := range i.Objects {
+		tools[object.Tool] = utils.ToolRecord{Name: object.Tool}
+	}
+	return tools
+}
+
+// releases returns the release records of the inventory by stack.
+func (i Inventory) releases() map[string]utils.ReleaseRecord {
+	releases := map[string]utils.ReleaseRecord{}
+	for _, release := range i.Releases {
+		releases[release.Stack] = release
+	}
+	return releases
+}
+
+// CompareInventories returns the differences between two clusters: their
+// forge releases and stacks, tools missing on one of them, tools at other
+// chart versions, and objects which are missing or were generated from
+// different sources.
+func CompareInventories(a, b Inventory) []string {
+	var differences []string
+	aReleases, bReleases := a.releases(), b.releases()
+	for _, stack := range unionKeys(aReleases, bReleases) {
+		aRelease, onA := aReleases[stack]
+		bRelease, onB := bReleases[stack]
+		switch {
+		case !onA || !onB:
+			differences = append(differences, fmt.Sprintf("stack %s: only on %s", stack, onlyOn(onA, a, b)))
+		case aRelease.StackDigest != bRelease.StackDigest:
+			differences = append(differences, fmt.Sprintf("stack %s: %s on %s, %s on %s", stack, aRelease.StackDigest, a.Name, bRelease.StackDigest, b.Name))
+		case aRelease.ForgeVersion != bRelease.ForgeVersion:
+			differences = append(differences, fmt.Sprintf("stack %s: cast with forge %s on %s, %s on %s", stack, aRelease.ForgeVersion, a.Name, bRelease.ForgeVersion, b.Name))
+		}
+	}
+
+	aTools, bTools := a.tools(), b.tools()
+	for _, name := range unionKeys(aTools, bTools) {
+		aTool, onA := aTools[name]
+		bTool, onB := bTools[name]
+		switch {
+		case !onA || !onB:
+			differences = append(differences, fmt.Sprintf("tool %s: only on %s", name, onlyOn(onA, a, b)))
+		case aTool.Chart != bTool.Chart || aTool.ChartVersion != bTool.ChartVersion:
+			differences = append(differences, fmt.Sprintf("tool %s: chart %s on %s, %s on %s", name, chartVersion(aTool), a.Name, chartVersion(bTool), b.Name))
+		}
+	}
+
+	for _, id := range unionKeys(a.Objects, b.Objects) {
+		aObject, onA := a.Objects[id]
+		bObject, onB := b.Objects[id]
+		if !onA || !onB {
+			object := aObject
+			if onB {
+				object = bObject
+			}
+			// A missing tool is reported once, not for each of its objects
+			_, toolOnA := aTools[object.Tool]
+			_, toolOnB := bTools[object.Tool]
+			if !toolOnA || !toolOnB {
+				continue
+			}
+			differences = append(differences, fmt.Sprintf("%s: only on %s", id, onlyOn(onA, a, b)))
+			continue
+		}
+		if aObject.differsFrom(bObject) {
+			differences = append(differences, fmt.Sprintf("%s: generated from different %s configuration", id, aObject.Tool))
+		}
+	}
+	return differences
+}
+
+// differsFrom reports whether two objects were generated differently: by
+// their object digests, which cover every transformation, or by their
+// source digests if either object has no object digest.
+func (o InventoryObject) differsFrom(other InventoryObject) bool {
+	if o.ObjectDigest != "" && other.ObjectDigest != "" {
+		return o.ObjectDigest != other.ObjectDigest
+	}
+	return o.SourceDigest != other.SourceDigest
+}
+
+func onlyOn(onA bool, a, b Inventory) string {
+	if onA {
+		return a.Name
+	}
+	return b.Name
+}
+
+func chartVersion(tool utils.ToolRecord) string {
+	source := toolSource(tool)
+	if tool.ChartVersion != "" {
+		return source + "@" + tool.ChartVersion
+	}
+	return source
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,92 @@
+package forger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+func TestCompareInventories(t *testing.T) {
+	staging := Inventory{
+		Name: "staging",
+		Releases: []utils.ReleaseRecord{{ForgeVersion: "v1.2.0", Stack: "platform", StackDigest: "sha256:new", Tools: []utils.ToolRecord{
+			{Name: "grafana", Chart: "grafana", ChartVersion: "8.5.1"},
+			{Name: "loki", Chart: "loki", ChartVersion: "6.0.0"},
+		}}},
+		Objects: map[string]InventoryObject{
+			"Deployment.apps grafana/grafana": {Tool: "grafana", SourceDigest: "sha256:a"},
+			"Service grafana/grafana":         {Tool: "grafana", SourceDigest: "sha256:b"},
+			"ConfigMap grafana/dashboards":    {Tool: "grafana", SourceDigest: "sha256:c"},
+			"StatefulSet.apps loki/loki":      {Tool: "loki", SourceDigest: "sha256:d"},
+		},
+	}
+	prod := Inventory{
+		Name: "prod",
+		Releases: []utils.ReleaseRecord{{ForgeVersion: "v1.2.0", Stack: "platform", StackDigest: "sha256:old", Tools: []utils.ToolRecord{
+			{Name: "grafana", Chart: "grafana", ChartVersion: "8.4.0"},
+		}}},
+		Objects: map[string]InventoryObject{
+			"Deployment.apps grafana/grafana": {Tool: "grafana", SourceDigest: "sha256:changed"},
+			"Service grafana/grafana":         {Tool: "grafana", SourceDigest: "sha256:b"},
+		},
+	}
+
+	differences := CompareInventories(staging, prod)
+	expected := []string{
+		"stack platform: sha256:new on staging, sha256:old on prod",
+		"tool grafana: chart grafana@8.5.1 on staging, grafana@8.4.0 on prod",
+		"tool loki: only on staging",
+		"ConfigMap grafana/dashboards: only on staging",
+		"Deployment.apps grafana/grafana: generated from different grafana configuration",
+	}
+	if strings.Join(differences, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected differences:\n%s", strings.Join(differences, "\n"))
+	}
+
+	if differences := CompareInventories(staging, staging); len(differences) != 0 {
+		t.Errorf("expected no differences between the same inventories, got %v", differences)
+	}
+}
+
+func TestCompareInventoriesWithoutRecords(t *testing.T) {
+	a := Inventory{Name: "a", Objects: map[string]InventoryObject{"Service grafana/grafana": {Tool: "grafana"}}}
+	b := Inventory{Name: "b", Objects: map[string]InventoryObject{}}
+	differences := CompareInventories(a, b)
+	if len(differences) != 1 || differences[0] != "tool grafana: only on a" {
+		t.Errorf("expected the tools to be read from the objects, got %v", differences)
+	}
+}
+
+func TestCompareInventoriesPerStack(t *testing.T) {
+	platform := utils.ReleaseRecord{Stack: "platform", StackDigest: "sha256:a", ForgeVersion: "v1.2.0"}
+	monitoring := utils.ReleaseRecord{Stack: "monitoring", StackDigest: "sha256:b", ForgeVersion: "v1.2.0"}
+	upgraded := utils.ReleaseRecord{Stack: "platform", StackDigest: "sha256:a", ForgeVersion: "v1.3.0"}
+	a := Inventory{Name: "a", Releases: []utils.ReleaseRecord{monitoring, platform}, Objects: map[string]InventoryObject{}}
+	b := Inventory{Name: "b", Releases: []utils.ReleaseRecord{upgraded}, Objects: map[string]InventoryObject{}}
+	differences := CompareInventories(a, b)
+	expected := []string{
+		"stack monitoring: only on a",
+		"stack platform: cast with forge v1.2.0 on a, v1.3.0 on b",
+	}
+	if strings.Join(differences, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected differences:\n%s", strings.Join(differences, "\n"))
+	}
+}
+
+func TestCompareInventoriesObjectDigests(t *testing.T) {
+	a := Inventory{Name: "a", Objects: map[string]InventoryObject{
+		// Same source, but e.g. another storage class on b
+		"PersistentVolumeClaim grafana/data": {Tool: "grafana", SourceDigest: "sha256:a", ObjectDigest: "sha256:standard"},
+		// Smelted before object digests, so only the sources are compared
+		"Service grafana/grafana": {Tool: "grafana", SourceDigest: "sha256:a"},
+	}}
+	b := Inventory{Name: "b", Objects: map[string]InventoryObject{
+		"PersistentVolumeClaim grafana/data": {Tool: "grafana", SourceDigest: "sha256:a", ObjectDigest: "sha256:fast"},
+		"Service grafana/grafana":            {Tool: "grafana", SourceDigest: "sha256:a", ObjectDigest: "sha256:b"},
+	}}
+	differences := CompareInventories(a, b)
+	if len(differences) != 1 || differences[0] != "PersistentVolumeClaim grafana/data: generated from different grafana configuration" {
+		t.Errorf("expected only the claim to differ, got %v", differences)
+	}
+}
@@ -34,27 +34,61 @@ import (
 	"k8s.io/client-go/util/homedir"
 )
 
-func Forge(stacksPath string) {
-	log.SetOutput(os.Stdout)
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-	})
-	log.SetLevel(log.DebugLevel)
+func Forge(stacksPath string, lockWait time.Duration) error {
+	utils.LogToStdout()
 	log.Info("Starting Cluster Forge...")
 
-	kubeConfigPath := determineKubeConfigPath()
-	_, err := getKubeConfig(kubeConfigPath)
+	kubeConfigPath, err := determineKubeConfigPath()
+	if err != nil {
+		return err
+	}
+	kubeConfig, err := getKubeConfig(kubeConfigPath)
+	if err != nil {
+		return utils.Errorf(utils.ConfigError, "failed to configure Kubernetes client: %w", err)
+	}
+
+	stacks, err := getStacks(stacksPath)
+	if err != nil {
+		return err
+	}
+	selectedStack, err := getUserSelection(stacks)
 	if err != nil {
-		log.Fatalf("Failed to configure Kubernetes client: %v", err)
+		return err
 	}
 
-	stacks := getStacks(stacksPath)
-	selectedStack := getUserSelection(stacks)
+	return Apply(kubeConfig, filepath.Join(stacksPath, selectedStack), lockWait)
+}
 
-	runStackLogic(filepath.Join(stacksPath, selectedStack))
+// Apply deploys the stack in stackPath to the cluster, holding the cluster
+// Lease while it runs.
+func Apply(kubeConfig *rest.Config, stackPath string, lockWait time.Duration) error {
+	lock, err := acquireClusterLock(kubeConfig, lockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	return runStackLogic(stackPath)
+}
+
+// Reapply applies the composition, stack and release record of an already
+// deployed stack again, correcting drift without reinstalling Crossplane.
+func Reapply(kubeConfig *rest.Config, stackPath string, lockWait time.Duration) error {
+	lock, err := acquireClusterLock(kubeConfig, lockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	for _, filename := range []string{"composition.yaml", "stack.yaml"} {
+		if err := runKubectl("apply", "-f", filepath.Join(stackPath, filename)); err != nil {
+			return err
+		}
+	}
+	return recordRelease(stackPath)
 }
 
-func determineKubeConfigPath() string {
+func determineKubeConfigPath() (string, error) {
 	kubeConfigPath := os.Getenv("KUBECONFIG")
 	defaultKubeConfigPath := filepath.Join(homedir.HomeDir(), ".kube", "config")
 
@@ -72,22 +106,22 @@ func determineKubeConfigPath() string {
 		)
 
 		if err := form.Run(); err != nil {
-			log.Fatalf("Failed to get user input: %v", err)
+			return "", fmt.Errorf("failed to get user input: %w", err)
 		}
 
 		if useEnvKubeconfig {
 			log.Infof("Using KUBECONFIG environment variable path: %s", kubeConfigPath)
-			return kubeConfigPath
+			return kubeConfigPath, nil
 		}
 	}
 
 	if _, err := os.Stat(defaultKubeConfigPath); os.IsNotExist(err) {
 		log.Warnf("Kubeconfig file not found at %s. Falling back to in-cluster configuration.", defaultKubeConfigPath)
-		return ""
+		return "", nil
 	}
 
 	log.Infof("Using default kubeconfig path: %s", defaultKubeConfigPath)
-	return defaultKubeConfigPath
+	return defaultKubeConfigPath, nil
 }
 
 func getKubeConfigPath(defaultPath, kubeconfigEnv string) (string, error) {
@@ -105,7 +139,7 @@ func getKubeConfigPath(defaultPath, kubeconfigEnv string) (string, error) {
 		)
 
 		if err := form.Run(); err != nil {
-			log.Fatalf("Failed to get user input: %v", err)
+			return "", fmt.Errorf("failed to get user input: %w", err)
 		}
 
 		if useEnvKubeconfig {
@@ -136,7 +170,12 @@ func getKubeConfig(kubeconfigPath string) (*rest.Config, error) {
 		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
 	}
 
-	contextName := getUserContextSelection(rawConfig.Contexts)
+	contextName, err := getUserContextSelection(rawConfig.Contexts)
+	if err != nil {
+		return nil, err
+	}
+	// kubectl deploys to the chosen cluster too, the one the Lease is taken on
+	UseKubectlOptions(KubectlOptions{Kubeconfig: kubeconfigPath, Context: contextName})
 	configOverrides := &clientcmd.ConfigOverrides{CurrentContext: contextName}
 	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
@@ -146,7 +185,7 @@ func getKubeConfig(kubeconfigPath string) (*rest.Config, error) {
 	return config.ClientConfig()
 }
 
-func getUserContextSelection(contexts map[string]*clientcmdapi.Context) string {
+func getUserContextSelection(contexts map[string]*clientcmdapi.Context) (string, error) {
 	var contextNames []string
 	for name := range contexts {
 		contextNames = append(contextNames, name)
@@ -164,18 +203,18 @@ func getUserContextSelection(contexts map[string]*clientcmdapi.Context) string {
 	)
 
 	if err := form.Run(); err != nil {
-		log.Fatalf("Failed to get user input: %v", err)
+		return "", fmt.Errorf("failed to get user input: %w", err)
 	}
 
 	if selectedContext == "" {
-		log.Fatal("No context selected. Exiting.")
+		return "", fmt.Errorf("no context selected")
 	}
 
 	log.Infof("Selected context: %s", selectedContext)
-	return selectedContext
+	return selectedContext, nil
 }
 
-func getStacks(baseDir string) []string {
+func getStacks(baseDir string) ([]string, error) {
 	var stacks []struct {
 		name    string
 		modTime int64
@@ -183,14 +222,14 @@ func getStacks(baseDir string) []string {
 
 	files, err := os.ReadDir(baseDir)
 	if err != nil {
-		log.Fatalf("Failed to read stacks directory: %v", err)
+		return nil, fmt.Errorf("failed to read stacks directory: %w", err)
 	}
 
 	for _, file := range files {
 		if file.IsDir() {
 			info, err := file.Info()
 			if err != nil {
-				log.Fatalf("Failed to get file info for %s: %v", file.Name(), err)
+				return nil, fmt.Errorf("failed to get file info for %s: %w", file.Name(), err)
 			}
 			stacks = append(stacks, struct {
 				name    string
@@ -211,10 +250,10 @@ func getStacks(baseDir string) []string {
 		result = append(result, stack.name)
 	}
 
-	return result
+	return result, nil
 }
 
-func getUserSelection(stacks []string) string {
+func getUserSelection(stacks []string) (string, error) {
 	var selectedStack string
 	form := huh.NewForm(
 		huh.NewGroup(
@@ -226,21 +265,21 @@ func getUserSelection(stacks []string) string {
 		),
 	)
 	if err := form.Run(); err != nil {
-		log.Fatalf("Failed to get user input: %v", err)
+		return "", fmt.Errorf("failed to get user input: %w", err)
 	}
 	if selectedStack == "" {
-		log.Fatal("No stack selected. Exiting.")
+		return "", fmt.Errorf("no stack selected")
 	}
 	log.Infof("Selected stack: %s", selectedStack)
-	return selectedStack
+	return selectedStack, nil
 }
 
-func runStackLogic(stackPath string) {
+func runStackLogic(stackPath string) error {
 	log.Infof("Deploying stack from: %s", stackPath)
 
 	// Helper function to apply YAML files
-	applyFile := func(filename string) {
-		utils.RunCommand(fmt.Sprintf("kubectl apply -f %s/%s", stackPath, filename))
+	applyFile := func(filename string) error {
+		return runKubectl("apply", "-f", filepath.Join(stackPath, filename))
 	}
 
 	// Helper function to wait for a CRD
@@ -253,7 +292,9 @@ func runStackLogic(stackPath string) {
 	}
 
 	// Apply base Crossplane YAML
-	applyFile("crossplane_base.yaml")
+	if err := applyFile("crossplane_base.yaml"); err != nil {
+		return err
+	}
 
 	// Wait for required CRDs
 	requiredCRDs := []string{
@@ -266,49 +307,75 @@ func runStackLogic(stackPath string) {
 
 	for _, crd := range requiredCRDs {
 		if err := waitForCRDWithError(crd); err != nil {
-			return
+			return utils.NewError(utils.ApplyError, err)
 		}
 	}
 
 	// Apply Crossplane and provider YAML files
-	applyFile("crossplane.yaml")
-	utils.RunCommand("kubectl wait --for=condition=Healthy providers/provider-kubernetes --timeout=60s")
-	applyFile("crossplane_provider.yaml")
+	if err := applyFile("crossplane.yaml"); err != nil {
+		return err
+	}
+	if err := runKubectl("wait", "--for=condition=Healthy", "providers/provider-kubernetes", "--timeout=60s"); err != nil {
+		return err
+	}
+	if err := applyFile("crossplane_provider.yaml"); err != nil {
+		return err
+	}
 
 	// Apply composition and stack YAML files
-	applyFile("composition.yaml")
+	if err := applyFile("composition.yaml"); err != nil {
+		return err
+	}
 
 	// Restart Crossplane pods and wait for readiness
-	utils.RunCommand("kubectl delete pods --all -n crossplane-system")
-	utils.RunCommand("kubectl wait --for=condition=Ready --timeout=600s pods --all -n crossplane-system")
+	if err := runKubectl("delete", "pods", "--all", "-n", "crossplane-system"); err != nil {
+		return err
+	}
+	if err := runKubectl("wait", "--for=condition=Ready", "--timeout=600s", "pods", "--all", "-n", "crossplane-system"); err != nil {
+		return err
+	}
 
-	applyFile("stack.yaml")
+	if err := applyFile("stack.yaml"); err != nil {
+		return err
+	}
+	if err := recordRelease(stackPath); err != nil {
+		return err
+	}
 
 	log.Info("Deployment complete!")
+	return nil
 }
 
-func applyMatchingFiles(dir string, pattern string, server_side bool) {
+// runApplyCommand runs a command against the cluster, failing with an apply error.
+func runApplyCommand(cmd string) error {
+	return utils.NewError(utils.ApplyError, utils.RunCommand(cmd))
+}
+
+func applyMatchingFiles(dir string, pattern string, server_side bool) error {
 	files, err := filepath.Glob(filepath.Join(dir, pattern))
 	if err != nil {
-		log.Fatalf("Failed to find files matching pattern %s: %v", pattern, err)
+		return fmt.Errorf("failed to find files matching pattern %s: %w", pattern, err)
 	}
 
 	for _, file := range files {
-		command := fmt.Sprintf("kubectl apply -f %s", file)
+		args := []string{"apply", "-f", file}
 		if server_side {
-			command += " --server-side"
+			args = append(args, "--server-side")
+		}
+		if err := runKubectl(args...); err != nil {
+			return err
 		}
-		utils.RunCommand(command)
 	}
+	return nil
 }
 
-func installHelmChart(repoName, repoURL, releaseName, chartName string) {
+func installHelmChart(repoName, repoURL, releaseName, chartName string) error {
 	log.Infof("Installing Helm chart %s from repository %s", chartName, repoURL)
 	cmd := fmt.Sprintf(
 		"helm repo add %s %s && helm repo update && helm upgrade --install %s %s",
 		repoName, repoURL, releaseName, chartName,
 	)
-	utils.RunCommand(cmd)
+	return runApplyCommand(cmd)
 }
 
 // waitForCRD waits for a specific CRD to be available and in Established condition
@@ -317,14 +384,14 @@ func waitForCRD(crdName string) error {
 
 	for {
 		// Check if the CRD exists
-		if err := exec.Command("kubectl", "get", "crd", crdName).Run(); err != nil {
+		if err := exec.Command(kubectlBinary, append(kubectlOptions.kubectlArgs(), "get", "crd", crdName)...).Run(); err != nil {
 			fmt.Printf("CRD %s is not found. Retrying in 5 seconds...\n", crdName)
 			time.Sleep(5 * time.Second)
 			continue
 		}
 
 		// Wait for the CRD to reach the Established condition
-		cmd := exec.Command("kubectl", "wait", "--for=condition=Established", "crd/"+crdName, "--timeout=60s")
+		cmd := exec.Command(kubectlBinary, append(kubectlOptions.kubectlArgs(), "wait", "--for=condition=Established", "crd/"+crdName, "--timeout=60s")...)
 		if output, err := cmd.CombinedOutput(); err != nil {
 			fmt.Printf("CRD %s is not ready: %s. Retrying in 5 seconds...\n", crdName, strings.TrimSpace(string(output)))
 			time.Sleep(5 * time.Second)
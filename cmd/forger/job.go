@@ -0,0 +1,261 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package forger
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// DefaultImage is the forge image which runs in-cluster jobs.
+	DefaultImage = "ghcr.io/silogen/cluster-forge:latest"
+
+	jobNamespace      = "cluster-forge"
+	jobServiceAccount = "cluster-forge-apply"
+	// maxConfigMapSize leaves room below the 1 MiB object limit for metadata.
+	maxConfigMapSize = 900 * 1024
+	stackMountPath   = "/stack"
+)
+
+// KubeConfig returns the client configuration of the cluster to deploy to,
+// asking which kubeconfig and context to use like the forge command unless
+// kubectl options are in use.
+func KubeConfig() (*rest.Config, error) {
+	if kubectlOptions.enabled() {
+		kubeConfig, err := kubectlConfig(kubectlOptions)
+		if err != nil {
+			return nil, utils.Errorf(utils.ConfigError, "failed to configure Kubernetes client: %w", err)
+		}
+		return kubeConfig, nil
+	}
+	kubeConfigPath, err := determineKubeConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	kubeConfig, err := getKubeConfig(kubeConfigPath)
+	if err != nil {
+		return nil, utils.Errorf(utils.ConfigError, "failed to configure Kubernetes client: %w", err)
+	}
+	return kubeConfig, nil
+}
+
+// ApplyInCluster deploys the stack in stackPath from a Job inside the
+// cluster, for when the API server can accept the Job but not a whole deploy
+// from the local machine. The stack is passed to the Job in a ConfigMap, and
+// the Job runs the forge image as a ServiceAccount allowed to deploy it.
+// It waits up to timeout for the Job and prints its log.
+func ApplyInCluster(kubeConfig *rest.Config, stackPath string, image string, timeout time.Duration) error {
+	client, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	ctx := context.Background()
+	stackName := filepath.Base(stackPath)
+
+	configMap, err := stackConfigMap(stackPath, "stack-"+stackName)
+	if err != nil {
+		return err
+	}
+	if err := ensureJobAccess(ctx, client); err != nil {
+		return utils.NewError(utils.ApplyError, err)
+	}
+	if err := createOrUpdateConfigMap(ctx, client, configMap); err != nil {
+		return utils.Errorf(utils.ApplyError, "failed to upload stack %s: %w", stackName, err)
+	}
+
+	job := applyJob(fmt.Sprintf("forge-apply-%s-%d", stackName, time.Now().Unix()), image, configMap.Name)
+	job, err = client.BatchV1().Jobs(jobNamespace).Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		return utils.Errorf(utils.ApplyError, "failed to create job: %w", err)
+	}
+	log.Infof("Created job %s/%s to deploy stack %s", jobNamespace, job.Name, stackName)
+	fmt.Printf("Deploying %s from job %s/%s...\n", stackName, jobNamespace, job.Name)
+
+	succeeded, waitErr := waitForJob(ctx, client, job.Name, timeout)
+	printJobLogs(ctx, client, job.Name)
+	if waitErr != nil {
+		return utils.NewError(utils.ApplyError, waitErr)
+	}
+	if !succeeded {
+		return utils.Errorf(utils.ApplyError, "job %s/%s failed, see its log above", jobNamespace, job.Name)
+	}
+	return nil
+}
+
+// stackConfigMap packs the yaml files of a stack into a ConfigMap.
+func stackConfigMap(stackPath, name string) (*corev1.ConfigMap, error) {
+	files, err := filepath.Glob(filepath.Join(stackPath, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, utils.Errorf(utils.ConfigError, "no yaml files found in stack %s", stackPath)
+	}
+	data := map[string]string{}
+	size := 0
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		data[filepath.Base(file)] = string(content)
+		size += len(content)
+	}
+	if size > maxConfigMapSize {
+		return nil, utils.Errorf(utils.ValidationError, "stack %s is %d KiB, too large to pass to a job in a ConfigMap (max %d KiB)", stackPath, size/1024, maxConfigMapSize/1024)
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: jobNamespace},
+		Data:       data,
+	}, nil
+}
+
+// applyJob returns a Job which deploys the stack in the given ConfigMap.
+func applyJob(name, image, configMapName string) *batchv1.Job {
+	backoffLimit := int32(0)
+	ttl := int32(24 * 60 * 60)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      truncateName(name),
+			Namespace: jobNamespace,
+			Labels:    map[string]string{"app.kubernetes.io/name": "cluster-forge-apply"},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					ServiceAccountName: jobServiceAccount,
+					RestartPolicy:      corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:  "forge",
+						Image: image,
+						Args:  []string{"forge", "apply-stack", stackMountPath},
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "stack",
+							MountPath: stackMountPath,
+							ReadOnly:  true,
+						}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "stack",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// truncateName keeps a generated name within the 63 characters allowed.
+func truncateName(name string) string {
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
+
+// ensureJobAccess creates the namespace and ServiceAccount the jobs run in.
+// Deploying a stack installs Crossplane and its providers, so the account
+// is bound to cluster-admin.
+func ensureJobAccess(ctx context.Context, client kubernetes.Interface) error {
+	_, err := client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: jobNamespace},
+	}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create namespace %s: %w", jobNamespace, err)
+	}
+	_, err = client.CoreV1().ServiceAccounts(jobNamespace).Create(ctx, &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: jobServiceAccount, Namespace: jobNamespace},
+	}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create service account %s: %w", jobServiceAccount, err)
+	}
+	_, err = client.RbacV1().ClusterRoleBindings().Create(ctx, &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: jobServiceAccount},
+		RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: "cluster-admin"},
+		Subjects:   []rbacv1.Subject{{Kind: "ServiceAccount", Name: jobServiceAccount, Namespace: jobNamespace}},
+	}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to bind service account %s: %w", jobServiceAccount, err)
+	}
+	return nil
+}
+
+func createOrUpdateConfigMap(ctx context.Context, client kubernetes.Interface, configMap *corev1.ConfigMap) error {
+	configMaps := client.CoreV1().ConfigMaps(configMap.Namespace)
+	_, err := configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// waitForJob waits for the job to finish and reports whether it succeeded.
+func waitForJob(ctx context.Context, client kubernetes.Interface, name string, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		job, err := client.BatchV1().Jobs(jobNamespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get job %s/%s: %w", jobNamespace, name, err)
+		}
+		if job.Status.Succeeded > 0 {
+			return true, nil
+		}
+		if job.Status.Failed > 0 {
+			return false, nil
+		}
+		if time.Now().After(deadline) {
+			return false, fmt.Errorf("job %s/%s did not finish within %s, it is still running in the cluster", jobNamespace, name, timeout)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// printJobLogs prints the log of the job's pod.
+func printJobLogs(ctx context.Context, client kubernetes.Interface, name string) {
+	pods, err := client.CoreV1().Pods(jobNamespace).List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + name})
+	if err != nil || len(pods.Items) == 0 {
+		log.Warnf("Failed to find the pod of job %s/%s: %v", jobNamespace, name, err)
+		return
+	}
+	logs, err := client.CoreV1().Pods(jobNamespace).GetLogs(pods.Items[0].Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+	if err != nil {
+		log.Warnf("Failed to get the log of job %s/%s: %v", jobNamespace, name, err)
+		return
+	}
+	fmt.Print(string(logs))
+}
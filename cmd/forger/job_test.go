@@ -0,0 +1,58 @@
+package forger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+func TestStackConfigMap(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"stack.yaml":       "kind: XForge\n",
+		"composition.yaml": "kind: Composition\n",
+		"src-yamls.tar.gz": "not a manifest",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	configMap, err := stackConfigMap(dir, "stack-test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(configMap.Data) != 2 || configMap.Data["stack.yaml"] != "kind: XForge\n" {
+		t.Errorf("expected only the yaml files in the ConfigMap, got %v", configMap.Data)
+	}
+
+	large := strings.Repeat("a", maxConfigMapSize+1)
+	if err := os.WriteFile(filepath.Join(dir, "large.yaml"), []byte(large), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	_, err = stackConfigMap(dir, "stack-test")
+	if utils.ClassOf(err) != utils.ValidationError {
+		t.Errorf("expected a validation error for a stack too large for a ConfigMap, got: %v", err)
+	}
+}
+
+func TestApplyJob(t *testing.T) {
+	job := applyJob("forge-apply-"+strings.Repeat("long-stack-name-", 5), DefaultImage, "stack-test")
+	if len(job.Name) > 63 || strings.HasSuffix(job.Name, "-") {
+		t.Errorf("expected a valid job name, got %s", job.Name)
+	}
+	container := job.Spec.Template.Spec.Containers[0]
+	if strings.Join(container.Args, " ") != "forge apply-stack "+stackMountPath {
+		t.Errorf("unexpected job arguments: %v", container.Args)
+	}
+	if job.Spec.Template.Spec.ServiceAccountName != jobServiceAccount {
+		t.Errorf("expected the job to run as %s, got %s", jobServiceAccount, job.Spec.Template.Spec.ServiceAccountName)
+	}
+	if *job.Spec.BackoffLimit != 0 {
+		t.Errorf("expected a failed deploy not to be retried")
+	}
+}
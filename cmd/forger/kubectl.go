@@ -0,0 +1,124 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package forger
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// KubectlOptions are kubectl's --kubeconfig and --context flags. When they
+// are set, or when running as a kubectl plugin, the cluster is chosen like
+// kubectl does, from the flags, $KUBECONFIG and the current context, instead
+// of asking.
+type KubectlOptions struct {
+	Kubeconfig string
+	Context    string
+	// NoPrompt chooses the cluster like kubectl even without the flags, as
+	// the plugin and the read-only commands do.
+	NoPrompt bool
+}
+
+var kubectlOptions KubectlOptions
+
+// kubectlBinary is the kubectl forge runs, replaced in tests.
+var kubectlBinary = "kubectl"
+
+// UseKubectlOptions sets how the cluster is chosen for the rest of the run.
+func UseKubectlOptions(options KubectlOptions) {
+	kubectlOptions = options
+}
+
+func (o KubectlOptions) enabled() bool {
+	return o.NoPrompt || o.Kubeconfig != "" || o.Context != ""
+}
+
+// kubectlArgs are the flags passing the options on to kubectl.
+func (o KubectlOptions) kubectlArgs() []string {
+	var args []string
+	if o.Kubeconfig != "" {
+		args = append(args, "--kubeconfig", o.Kubeconfig)
+	}
+	if o.Context != "" {
+		args = append(args, "--context", o.Context)
+	}
+	return args
+}
+
+// runKubectl runs kubectl with args against the cluster chosen for the run,
+// failing with an apply error.
+func runKubectl(args ...string) error {
+	args = append(kubectlOptions.kubectlArgs(), args...)
+	output, err := exec.Command(kubectlBinary, args...).CombinedOutput()
+	if err != nil {
+		return utils.Errorf(utils.ApplyError, "command kubectl %s failed: %w\nOutput: %s", strings.Join(args, " "), err, string(output))
+	}
+	log.Info(string(output))
+	return nil
+}
+
+// kubectlConfig loads the client configuration following kubectl's
+// conventions, without prompting.
+func kubectlConfig(options KubectlOptions) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = options.Kubeconfig
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: options.Context})
+	return config.ClientConfig()
+}
+
+// Drift compares the composition and stack of the stack in stackPath, the
+// objects Reapply would apply, with the cluster using kubectl diff. It
+// returns the diff, which is empty if the cluster matches the stack.
+func Drift(stackPath string) (string, error) {
+	args := append([]string{"diff"}, kubectlOptions.kubectlArgs()...)
+	for _, filename := range []string{"composition.yaml", "stack.yaml"} {
+		args = append(args, "-f", filepath.Join(stackPath, filename))
+	}
+	log.Debugf("Running kubectl %s", strings.Join(args, " "))
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(kubectlBinary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	// kubectl diff exits with 1 when there are differences, and above 1 when
+	// it fails
+	var exitErr *exec.ExitError
+	if err != nil && (!errors.As(err, &exitErr) || exitErr.ExitCode() != 1) {
+		return "", utils.Errorf(utils.ApplyError, "failed to compare %s with the cluster: %v: %s", stackPath, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// LatestStack returns the path of the newest stack in stacksDir.
+func LatestStack(stacksDir string) (string, error) {
+	stacks, err := getStacks(stacksDir)
+	if err != nil {
+		return "", utils.NewError(utils.ConfigError, err)
+	}
+	if len(stacks) == 0 {
+		return "", utils.Errorf(utils.ConfigError, "no stacks in %s, run cast first", stacksDir)
+	}
+	return filepath.Join(stacksDir, stacks[0]), nil
+}
@@ -0,0 +1,167 @@
+package forger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: admin
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+users:
+- name: admin
+  user:
+    token: secret
+`
+
+func TestKubectlConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	config, err := kubectlConfig(KubectlOptions{Kubeconfig: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Host != "https://dev.example.com" {
+		t.Errorf("expected the current context to be used, got %s", config.Host)
+	}
+
+	t.Setenv("KUBECONFIG", path)
+	config, err = kubectlConfig(KubectlOptions{Context: "prod", NoPrompt: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Host != "https://prod.example.com" {
+		t.Errorf("expected --context to select the prod cluster, got %s", config.Host)
+	}
+
+	if _, err := kubectlConfig(KubectlOptions{Kubeconfig: path, Context: "missing"}); err == nil {
+		t.Errorf("expected an error for an unknown context")
+	}
+}
+
+func TestKubectlArgs(t *testing.T) {
+	if args := (KubectlOptions{NoPrompt: true}).kubectlArgs(); len(args) != 0 {
+		t.Errorf("expected no flags without options, got %v", args)
+	}
+	args := KubectlOptions{Kubeconfig: "/tmp/config", Context: "prod"}.kubectlArgs()
+	if strings.Join(args, " ") != "--kubeconfig /tmp/config --context prod" {
+		t.Errorf("unexpected kubectl flags: %v", args)
+	}
+}
+
+func TestRunKubectl(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script standing in for kubectl")
+	}
+	defer func(binary string) { kubectlBinary = binary }(kubectlBinary)
+	defer UseKubectlOptions(KubectlOptions{})
+
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	kubectlBinary = filepath.Join(dir, "kubectl")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s\n", argsFile)
+	if err := os.WriteFile(kubectlBinary, []byte(script), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	UseKubectlOptions(KubectlOptions{Kubeconfig: "/tmp/config", Context: "prod"})
+	if err := runKubectl("apply", "-f", "stack.yaml"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(args) != "--kubeconfig /tmp/config --context prod apply -f stack.yaml\n" {
+		t.Errorf("expected kubectl to run against the chosen cluster, got %q", args)
+	}
+
+	if err := os.WriteFile(kubectlBinary, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := runKubectl("apply", "-f", "stack.yaml"); utils.ClassOf(err) != utils.ApplyError {
+		t.Errorf("expected an apply error, got %v", err)
+	}
+}
+
+func TestLatestStack(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LatestStack(dir); utils.ClassOf(err) != utils.ConfigError {
+		t.Errorf("expected a config error without stacks, got: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "stack1"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	stackPath, err := LatestStack(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stackPath != filepath.Join(dir, "stack1") {
+		t.Errorf("expected stack1, got %s", stackPath)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script standing in for kubectl")
+	}
+	defer func(binary string) { kubectlBinary = binary }(kubectlBinary)
+	tests := []struct {
+		name      string
+		exitCode  int
+		output    string
+		expected  string
+		wantError bool
+	}{
+		{"no drift", 0, "", "", false},
+		{"drift", 1, "-  replicas: 1\n+  replicas: 2\n", "-  replicas: 1\n+  replicas: 2\n", false},
+		{"kubectl fails", 2, "", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubectlBinary = filepath.Join(t.TempDir(), "kubectl")
+			script := fmt.Sprintf("#!/bin/sh\nprintf '%%s' '%s'\necho 'some error' >&2\nexit %d\n", test.output, test.exitCode)
+			if err := os.WriteFile(kubectlBinary, []byte(script), 0755); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			diff, err := Drift(t.TempDir())
+			if test.wantError {
+				if err == nil || utils.ClassOf(err) != utils.ApplyError {
+					t.Errorf("expected an apply error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff != test.expected {
+				t.Errorf("expected diff %q, got %q", test.expected, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package forger
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	leaseName      = "cluster-forge"
+	leaseNamespace = "kube-system"
+	leaseDuration  = 60 * time.Second
+)
+
+// clusterLock is a Lease held in the target cluster while a stack is being
+// deployed, so two forge runs against the same cluster don't interleave.
+type clusterLock struct {
+	client   kubernetes.Interface
+	identity string
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// acquireClusterLock takes the forge Lease in the cluster. If another run
+// holds it, it waits for up to wait before giving up with an error naming the
+// holder. The Lease is renewed in the background until release.
+func acquireClusterLock(config *rest.Config, wait time.Duration) (*clusterLock, error) {
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	hostname, _ := os.Hostname()
+	lock := &clusterLock{
+		client:   client,
+		identity: fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	deadline := time.Now().Add(wait)
+	waiting := false
+	for {
+		holder, err := lock.tryAcquire()
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire lease %s/%s: %w", leaseNamespace, leaseName, err)
+		}
+		if holder == "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("another forge run (%s) is deploying to this cluster, see lease %s/%s; wait for it to finish or use --lock-wait to queue behind it",
+				holder, leaseNamespace, leaseName)
+		}
+		if !waiting {
+			log.Infof("Waiting for lease %s/%s held by %s", leaseNamespace, leaseName, holder)
+			waiting = true
+		}
+		time.Sleep(2 * time.Second)
+	}
+
+	go lock.renew()
+	return lock, nil
+}
+
+// tryAcquire takes the Lease if it is free or expired. It returns the current
+// holder if someone else has it.
+func (l *clusterLock) tryAcquire() (string, error) {
+	ctx := context.Background()
+	leases := l.client.CoordinationV1().Leases(leaseNamespace)
+	now := metav1.NewMicroTime(time.Now())
+	durationSeconds := int32(leaseDuration.Seconds())
+
+	lease, err := leases.Get(ctx, leaseName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: leaseName, Namespace: leaseNamespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &l.identity,
+				LeaseDurationSeconds: &durationSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return "another run", nil
+		}
+		return "", err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if holder := leaseHolder(lease); holder != "" && holder != l.identity {
+		return holder, nil
+	}
+	lease.Spec.HolderIdentity = &l.identity
+	lease.Spec.LeaseDurationSeconds = &durationSeconds
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return "another run", nil
+	}
+	return "", err
+}
+
+// ClusterLockHolder returns who holds the forge Lease of the cluster, or ""
+// if no run is deploying to it.
+func ClusterLockHolder(kubeConfig *rest.Config) (string, error) {
+	client, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	lease, err := client.CoordinationV1().Leases(leaseNamespace).Get(context.Background(), leaseName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read the cluster Lease: %w", err)
+	}
+	return leaseHolder(lease), nil
+}
+
+// leaseHolder returns the holder of an unexpired Lease, or "" if it is free.
+func leaseHolder(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" || lease.Spec.RenewTime == nil {
+		return ""
+	}
+	duration := leaseDuration
+	if lease.Spec.LeaseDurationSeconds != nil {
+		duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	if time.Now().After(lease.Spec.RenewTime.Add(duration)) {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+func (l *clusterLock) renew() {
+	defer close(l.done)
+	ticker := time.NewTicker(leaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ours, err := l.renewOnce()
+			if err != nil {
+				log.Warnf("Failed to renew lease %s/%s: %v", leaseNamespace, leaseName, err)
+				continue
+			}
+			if !ours {
+				// The Lease expired and another run took it, so renewing it
+				// would take it back from under that run
+				log.Errorf("Lost lease %s/%s to another forge run, which may now deploy to this cluster too", leaseNamespace, leaseName)
+				return
+			}
+		}
+	}
+}
+
+// renewOnce renews the Lease if it is still held by this run, and reports
+// whether it is.
+func (l *clusterLock) renewOnce() (bool, error) {
+	ctx := context.Background()
+	leases := l.client.CoordinationV1().Leases(leaseNamespace)
+	lease, err := leases.Get(ctx, leaseName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.identity {
+		return false, nil
+	}
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	// The update fails on a conflict if another run took the Lease since
+	// it was read
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err == nil, err
+}
+
+// release stops renewing the Lease and deletes it, if it is still ours.
+func (l *clusterLock) release() {
+	close(l.stop)
+	<-l.done
+
+	ctx := context.Background()
+	leases := l.client.CoordinationV1().Leases(leaseNamespace)
+	lease, err := leases.Get(ctx, leaseName, metav1.GetOptions{})
+	if err != nil {
+		log.Warnf("Failed to release lease %s/%s: %v", leaseNamespace, leaseName, err)
+		return
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.identity {
+		return
+	}
+	err = leases.Delete(ctx, leaseName, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion}})
+	if err != nil {
+		log.Warnf("Failed to release lease %s/%s: %v", leaseNamespace, leaseName, err)
+	}
+}
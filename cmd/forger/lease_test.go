@@ -0,0 +1,46 @@
+package forger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRenewLease(t *testing.T) {
+	holder := "other-run"
+	renewed := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	client := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: leaseName, Namespace: leaseNamespace},
+		Spec:       coordinationv1.LeaseSpec{HolderIdentity: &holder, RenewTime: &renewed},
+	})
+	lock := &clusterLock{client: client, identity: "this-run"}
+	readRenewTime := func() time.Time {
+		lease, err := client.CoordinationV1().Leases(leaseNamespace).Get(context.Background(), leaseName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return lease.Spec.RenewTime.Time
+	}
+
+	// Another run took the Lease, which is left alone
+	ours, err := lock.renewOnce()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ours || !readRenewTime().Equal(renewed.Time) {
+		t.Errorf("expected the Lease of another run not to be renewed")
+	}
+
+	lock.identity = holder
+	ours, err = lock.renewOnce()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ours || !readRenewTime().After(renewed.Time) {
+		t.Errorf("expected the Lease to be renewed")
+	}
+}
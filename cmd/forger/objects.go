@@ -0,0 +1,160 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package forger
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// objectsResource are the provider-kubernetes Objects the stack's
+// composition wraps the tools' manifests in.
+var objectsResource = schema.GroupVersionResource{Group: "kubernetes.crossplane.io", Version: "v1alpha1", Resource: "objects"}
+
+// compositionResourceRe finds the names of the Objects in a composition.
+var compositionResourceRe = regexp.MustCompile(`gotemplating\.fn\.crossplane\.io/composition-resource-name:\s*(\S+)`)
+
+// ObjectStatus is the state of one of the stack's Objects in the cluster.
+// Synced means the provider could apply the manifest, Ready that the object
+// it manages is ready.
+type ObjectStatus struct {
+	Name    string
+	Found   bool
+	Synced  string
+	Ready   string
+	Message string
+}
+
+// Healthy reports whether the Object exists and is synced and ready.
+func (s ObjectStatus) Healthy() bool {
+	return s.Found && s.Synced == "True" && s.Ready == "True"
+}
+
+// StackObjects returns the names of the Objects in the composition of the
+// stack in stackPath.
+func StackObjects(stackPath string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(stackPath, "composition.yaml"))
+	if err != nil {
+		return nil, utils.NewError(utils.ConfigError, err)
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, match := range compositionResourceRe.FindAllStringSubmatch(string(data), -1) {
+		name := strings.Trim(match[1], `"'`)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ObjectStatuses returns the state of the named Objects in the cluster, in
+// the order of names. Objects which are missing are reported as not found.
+func ObjectStatuses(kubeConfig *rest.Config, names []string) ([]ObjectStatus, error) {
+	client, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	found := map[string]ObjectStatus{}
+	list, err := client.Resource(objectsResource).List(context.Background(), metav1.ListOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, utils.Errorf(utils.ApplyError, "failed to list the stack's Objects: %w", err)
+	}
+	// Without the Object CRD, none of the Objects are in the cluster
+	if err == nil {
+		for _, object := range list.Items {
+			status := objectStatus(object)
+			found[status.Name] = status
+		}
+	}
+	var statuses []ObjectStatus
+	for _, name := range names {
+		status, exists := found[name]
+		if !exists {
+			status = ObjectStatus{Name: name}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// objectStatus reads the conditions of an Object. Composed Objects are named
+// by crossplane, so the name in the composition is taken from the
+// annotations where set.
+func objectStatus(object unstructured.Unstructured) ObjectStatus {
+	status := ObjectStatus{Name: object.GetName(), Found: true, Synced: "Unknown", Ready: "Unknown"}
+	annotations := object.GetAnnotations()
+	for _, key := range []string{"crossplane.io/composition-resource-name", "gotemplating.fn.crossplane.io/composition-resource-name"} {
+		if name := annotations[key]; name != "" {
+			status.Name = name
+			break
+		}
+	}
+	conditions, _, _ := unstructured.NestedSlice(object.Object, "status", "conditions")
+	for _, condition := range conditions {
+		conditionMap, _ := condition.(map[string]interface{})
+		value, _ := conditionMap["status"].(string)
+		message, _ := conditionMap["message"].(string)
+		switch conditionMap["type"] {
+		case "Synced":
+			status.Synced = value
+		case "Ready":
+			status.Ready = value
+		default:
+			continue
+		}
+		if value != "True" && message != "" && status.Message == "" {
+			status.Message = strings.SplitN(message, "\n", 2)[0]
+		}
+	}
+	return status
+}
+
+// FormatObjectStatuses lists the Objects which are not synced and ready.
+func FormatObjectStatuses(statuses []ObjectStatus) string {
+	var builder strings.Builder
+	writer := tabwriter.NewWriter(&builder, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "OBJECT\tSYNCED\tREADY\tMESSAGE")
+	for _, status := range statuses {
+		if status.Healthy() {
+			continue
+		}
+		if !status.Found {
+			fmt.Fprintf(writer, "%s\t-\t-\tnot found in the cluster\n", status.Name)
+			continue
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", status.Name, status.Synced, status.Ready, status.Message)
+	}
+	writer.Flush()
+	return builder.String()
+}
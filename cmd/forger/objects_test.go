@@ -0,0 +1,79 @@
+package forger
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testComposition = `apiVersion: apiextensions.crossplane.io/v1
+kind: Composition
+spec:
+  pipeline:
+  - input:
+      inline:
+        template: |
+          ---
+          apiVersion: kubernetes.crossplane.io/v1alpha1
+          kind: Object
+          metadata:
+            name: grafana-deployment
+            annotations:
+              gotemplating.fn.crossplane.io/composition-resource-name: grafana-deployment
+          ---
+          apiVersion: kubernetes.crossplane.io/v1alpha1
+          kind: Object
+          metadata:
+            name: grafana-service
+            annotations:
+              gotemplating.fn.crossplane.io/composition-resource-name: grafana-service
+`
+
+func TestStackObjects(t *testing.T) {
+	stackPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(stackPath, "composition.yaml"), []byte(testComposition), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names, err := StackObjects(stackPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"grafana-deployment", "grafana-service"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestObjectStatus(t *testing.T) {
+	object := unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":        "xgrafana-abc12",
+			"annotations": map[string]interface{}{"crossplane.io/composition-resource-name": "grafana-deployment"},
+		},
+		"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Synced", "status": "False", "message": "cannot apply: admission denied\ndetails"},
+			map[string]interface{}{"type": "Ready", "status": "True"},
+		}},
+	}}
+	status := objectStatus(object)
+	expected := ObjectStatus{Name: "grafana-deployment", Found: true, Synced: "False", Ready: "True", Message: "cannot apply: admission denied"}
+	if status != expected {
+		t.Errorf("expected %+v, got %+v", expected, status)
+	}
+
+	table := FormatObjectStatuses([]ObjectStatus{
+		status,
+		{Name: "grafana-service", Found: true, Synced: "True", Ready: "True"},
+		{Name: "grafana-config"},
+	})
+	if strings.Contains(table, "grafana-service") {
+		t.Errorf("expected healthy Objects to be left out:\n%s", table)
+	}
+	if !strings.Contains(table, "grafana-deployment") || !strings.Contains(table, "not found") {
+		t.Errorf("expected the unhealthy and missing Objects to be listed:\n%s", table)
+	}
+}
@@ -0,0 +1,163 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package forger
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/silogen/cluster-forge/cmd/immutable"
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// sourceArchive is the archive of the tools' manifests cast into a stack.
+const sourceArchive = "src-yamls.tar.gz"
+
+// StackManifests returns the tools' manifests in the source archive of the
+// stack, by their path in it. With a release record, only the manifests of
+// the tools it lists are returned.
+func StackManifests(stackPath string) (map[string][]byte, error) {
+	record, err := utils.ReadReleaseRecordFile(stackPath)
+	if err != nil {
+		return nil, utils.NewError(utils.ConfigError, err)
+	}
+	var tools map[string]bool
+	if record != nil {
+		tools = map[string]bool{}
+		for _, tool := range record.Tools {
+			tools[tool.Name] = true
+		}
+	}
+
+	file, err := os.Open(filepath.Join(stackPath, sourceArchive))
+	if err != nil {
+		return nil, utils.NewError(utils.ConfigError, err)
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, utils.Errorf(utils.ConfigError, "failed to read %s: %w", sourceArchive, err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	manifests := map[string][]byte{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return manifests, nil
+		}
+		if err != nil {
+			return nil, utils.Errorf(utils.ConfigError, "failed to read %s: %w", sourceArchive, err)
+		}
+		// Entries are <working directory>/<tool>/<file>
+		parts := strings.Split(header.Name, "/")
+		if header.Typeflag != tar.TypeReg || len(parts) != 3 || path.Ext(header.Name) != ".yaml" {
+			continue
+		}
+		if tools != nil && !tools[parts[1]] {
+			continue
+		}
+		content, err := io.ReadAll(tarReader)
+		if err != nil {
+			return nil, utils.Errorf(utils.ConfigError, "failed to read %s: %w", sourceArchive, err)
+		}
+		manifests[header.Name] = content
+	}
+}
+
+// LiveRecreates compares the manifests with the objects in the cluster and
+// returns the objects which applying the manifests can't update in place, as
+// immutable fields changed. Objects missing in the cluster are left out.
+func LiveRecreates(kubeConfig *rest.Config, manifests map[string][]byte) ([]immutable.Change, error) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	client, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+
+	paths := make([]string, 0, len(manifests))
+	for manifestPath := range manifests {
+		paths = append(paths, manifestPath)
+	}
+	sort.Strings(paths)
+	var changes []immutable.Change
+	for _, manifestPath := range paths {
+		manifest := manifests[manifestPath]
+		var object struct {
+			APIVersion string `yaml:"apiVersion"`
+			Kind       string `yaml:"kind"`
+			Metadata   struct {
+				Name      string `yaml:"name"`
+				Namespace string `yaml:"namespace"`
+			} `yaml:"metadata"`
+		}
+		if err := yaml.Unmarshal(manifest, &object); err != nil || !immutable.Covers(object.APIVersion, object.Kind) {
+			continue
+		}
+		groupVersion, err := schema.ParseGroupVersion(object.APIVersion)
+		if err != nil {
+			continue
+		}
+		mapping, err := mapper.RESTMapping(groupVersion.WithKind(object.Kind).GroupKind(), groupVersion.Version)
+		if err != nil {
+			log.Debugf("Not checking %s, its kind is not served: %v", manifestPath, err)
+			continue
+		}
+		resource := client.Resource(mapping.Resource)
+		var live dynamic.ResourceInterface = resource
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			live = resource.Namespace(object.Metadata.Namespace)
+		}
+		current, err := live.Get(context.Background(), object.Metadata.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, utils.Errorf(utils.ApplyError, "failed to read %s %s: %w", object.Kind, object.Metadata.Name, err)
+		}
+		liveManifest, err := yaml.Marshal(current.Object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s %s: %w", object.Kind, object.Metadata.Name, err)
+		}
+		if change := immutable.CheckLive(liveManifest, manifest); change != nil {
+			changes = append(changes, *change)
+		}
+	}
+	return changes, nil
+}
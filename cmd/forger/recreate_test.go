@@ -0,0 +1,70 @@
+package forger
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+func TestStackManifests(t *testing.T) {
+	stackPath := t.TempDir()
+	file, err := os.Create(filepath.Join(stackPath, sourceArchive))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, name := range []string{"working/grafana/Deployment_grafana.yaml", "working/grafana/README.md", "working/loki/Service_loki.yaml"} {
+		content := []byte("kind: Service\n")
+		if err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := tarWriter.Write(content); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for _, closer := range []interface{ Close() error }{tarWriter, gzipWriter, file} {
+		if err := closer.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	manifests, err := StackManifests(stackPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if paths := manifestPaths(manifests); !reflect.DeepEqual(paths, []string{"working/grafana/Deployment_grafana.yaml", "working/loki/Service_loki.yaml"}) {
+		t.Errorf("unexpected manifests %v", paths)
+	}
+
+	// The release record limits the manifests to the tools in the stack
+	if err := os.WriteFile(filepath.Join(stackPath, "composition.yaml"), []byte(testComposition), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	record := utils.ReleaseRecord{FormatVersion: utils.ReleaseFormatVersion, Stack: "platform", Tools: []utils.ToolRecord{{Name: "loki"}}}
+	if err := utils.WriteReleaseRecord(record, stackPath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	manifests, err = StackManifests(stackPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if paths := manifestPaths(manifests); !reflect.DeepEqual(paths, []string{"working/loki/Service_loki.yaml"}) {
+		t.Errorf("unexpected manifests %v", paths)
+	}
+}
+
+func manifestPaths(manifests map[string][]byte) []string {
+	var paths []string
+	for path := range manifests {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
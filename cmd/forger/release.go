@@ -0,0 +1,108 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package forger
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// recordRelease applies the release record of the stack, so the cluster
+// knows what it runs. It goes to the cluster the stack was deployed to.
+// Stacks cast before records existed have none.
+func recordRelease(stackPath string) error {
+	path := filepath.Join(stackPath, utils.ReleaseRecordFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		log.Warnf("Stack %s has no %s, cast it again to record the release in the cluster", stackPath, utils.ReleaseRecordFile)
+		return nil
+	}
+	return runKubectl("apply", "-f", path)
+}
+
+// InstalledReleases reads the records of the stacks deployed to the
+// cluster, sorted by stack. There is one per stack, so a cluster running
+// several stacks, e.g. from several ForgeReleases, has several.
+func InstalledReleases(kubeConfig *rest.Config) ([]utils.ReleaseRecord, error) {
+	client, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	configMaps, err := client.CoreV1().ConfigMaps(utils.ReleaseNamespace).List(context.Background(), metav1.ListOptions{LabelSelector: utils.ReleaseRecordLabel + "=true"})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the release records: %w", err)
+	}
+	var records []utils.ReleaseRecord
+	for _, configMap := range configMaps.Items {
+		record, err := utils.ParseReleaseRecord(configMap.Data)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", configMap.Namespace, configMap.Name, err)
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Stack < records[j].Stack })
+	return records, nil
+}
+
+// FindRelease returns the record of the stack, or nil if it isn't installed.
+func FindRelease(records []utils.ReleaseRecord, stack string) *utils.ReleaseRecord {
+	for i := range records {
+		if records[i].Stack == stack {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+// FormatRelease describes the installed release and its tools.
+func FormatRelease(record utils.ReleaseRecord) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Installed stack: %s (%s), cast with forge %s\n", record.Stack, record.StackDigest, record.ForgeVersion)
+	w := tabwriter.NewWriter(&b, 0, 4, 3, ' ', 0)
+	fmt.Fprintln(w, "TOOL\tNAMESPACE\tSOURCE\tVERSION")
+	for _, tool := range record.Tools {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", tool.Name, tool.Namespace, toolSource(tool), tool.ChartVersion)
+	}
+	w.Flush()
+	return b.String()
+}
+
+// toolSource returns where a tool's manifests came from.
+func toolSource(tool utils.ToolRecord) string {
+	switch {
+	case tool.Chart != "":
+		return tool.Chart
+	case tool.ManifestURL != "":
+		return tool.ManifestURL
+	default:
+		return tool.SourceFile
+	}
+}
@@ -0,0 +1,52 @@
+package forger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+func TestRecordRelease(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script standing in for kubectl")
+	}
+	defer func(binary string) { kubectlBinary = binary }(kubectlBinary)
+	defer UseKubectlOptions(KubectlOptions{})
+
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	kubectlBinary = filepath.Join(dir, "kubectl")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s\n", argsFile)
+	if err := os.WriteFile(kubectlBinary, []byte(script), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stackPath := t.TempDir()
+
+	// A stack without a record is deployed without one
+	if err := recordRelease(stackPath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(argsFile); err == nil {
+		t.Errorf("expected kubectl not to run without a release record")
+	}
+
+	path := filepath.Join(stackPath, utils.ReleaseRecordFile)
+	if err := os.WriteFile(path, []byte("kind: ConfigMap\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	UseKubectlOptions(KubectlOptions{Kubeconfig: "/tmp/config", Context: "prod"})
+	if err := recordRelease(stackPath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := fmt.Sprintf("--kubeconfig /tmp/config --context prod apply -f %s\n", path); string(args) != expected {
+		t.Errorf("expected the record to be applied to the deployed cluster, got %q", args)
+	}
+}
@@ -0,0 +1,245 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package immutable
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// field is the path of an immutable field below the object's root.
+type field struct {
+	path []string
+	// setOnly fields are filled in by the API server when left out, so only
+	// a change between two set values needs the object to be recreated.
+	setOnly bool
+}
+
+// immutableFields are the fields of each group/Kind which the API server
+// refuses to update.
+var immutableFields = map[string][]field{
+	"apps/Deployment": {{path: []string{"spec", "selector"}}},
+	"apps/ReplicaSet": {{path: []string{"spec", "selector"}}},
+	"apps/DaemonSet":  {{path: []string{"spec", "selector"}}},
+	"apps/StatefulSet": {
+		{path: []string{"spec", "selector"}},
+		{path: []string{"spec", "serviceName"}},
+		{path: []string{"spec", "volumeClaimTemplates"}},
+		{path: []string{"spec", "podManagementPolicy"}, setOnly: true},
+	},
+	"batch/Job": {
+		{path: []string{"spec", "selector"}, setOnly: true},
+		{path: []string{"spec", "template"}},
+	},
+	"/Service": {
+		{path: []string{"spec", "clusterIP"}, setOnly: true},
+		{path: []string{"spec", "clusterIPs"}, setOnly: true},
+	},
+	"/PersistentVolumeClaim": {
+		{path: []string{"spec", "storageClassName"}, setOnly: true},
+		{path: []string{"spec", "accessModes"}},
+		{path: []string{"spec", "volumeMode"}, setOnly: true},
+		{path: []string{"spec", "volumeName"}, setOnly: true},
+		{path: []string{"spec", "selector"}},
+	},
+}
+
+// Change is an object whose update changes immutable fields, so it has to be
+// deleted and created again.
+type Change struct {
+	Kind      string
+	Name      string
+	Namespace string
+	Fields    []string
+	// Manifest is the new version of the object.
+	Manifest []byte
+}
+
+func (c Change) String() string {
+	name := c.Kind + " " + c.Name
+	if c.Namespace != "" {
+		name = c.Kind + " " + c.Namespace + "/" + c.Name
+	}
+	return fmt.Sprintf("%s must be recreated, immutable fields changed: %s", name, strings.Join(c.Fields, ", "))
+}
+
+// Check compares two versions of an object's manifest and returns the
+// change if the update touches immutable fields, or nil if the object can be
+// updated in place. Manifests which aren't Kubernetes objects are ignored.
+func Check(previous, current []byte) *Change {
+	return check(previous, current, reflect.DeepEqual)
+}
+
+// CheckLive compares an object in the cluster, as YAML, with the manifest
+// which is to be applied to it, and returns the change if applying it touches
+// immutable fields. The API server fills in defaults, so only the values set
+// in the manifest are compared.
+func CheckLive(live, manifest []byte) *Change {
+	return check(live, manifest, contains)
+}
+
+// Covers reports whether objects of the kind have immutable fields checked.
+func Covers(apiVersion, kind string) bool {
+	groupKind := groupOf(apiVersion) + "/" + kind
+	_, exists := immutableFields[groupKind]
+	return exists || groupKind == "/ConfigMap" || groupKind == "/Secret"
+}
+
+func groupOf(apiVersion string) string {
+	if slash := strings.Index(apiVersion, "/"); slash >= 0 {
+		return apiVersion[:slash]
+	}
+	return ""
+}
+
+// check returns the change between two versions of an object, comparing the
+// values of immutable fields with equal.
+func check(previous, current []byte, equal func(previous, current interface{}) bool) *Change {
+	var previousObject, currentObject map[interface{}]interface{}
+	if yaml.Unmarshal(previous, &previousObject) != nil || yaml.Unmarshal(current, &currentObject) != nil {
+		return nil
+	}
+	kind, _ := currentObject["kind"].(string)
+	apiVersion, _ := currentObject["apiVersion"].(string)
+	if kind == "" || kind != previousObject["kind"] {
+		return nil
+	}
+	group := groupOf(apiVersion)
+
+	var changed []string
+	for _, field := range immutableFields[group+"/"+kind] {
+		previousValue, previousSet := lookup(previousObject, field.path)
+		currentValue, currentSet := lookup(currentObject, field.path)
+		if field.setOnly && (!previousSet || !currentSet) {
+			continue
+		}
+		if previousSet != currentSet || !equal(previousValue, currentValue) {
+			changed = append(changed, strings.Join(field.path, "."))
+		}
+	}
+	changed = append(changed, checkSpecialCases(group+"/"+kind, previousObject, currentObject, equal)...)
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Strings(changed)
+
+	change := &Change{Kind: kind, Fields: changed, Manifest: current}
+	if metadata, ok := currentObject["metadata"].(map[interface{}]interface{}); ok {
+		change.Name, _ = metadata["name"].(string)
+		change.Namespace, _ = metadata["namespace"].(string)
+	}
+	return change
+}
+
+// checkSpecialCases covers the immutable fields which depend on other fields.
+func checkSpecialCases(groupKind string, previousObject, currentObject map[interface{}]interface{}, equal func(previous, current interface{}) bool) []string {
+	var changed []string
+	switch groupKind {
+	case "/ConfigMap", "/Secret":
+		// Once marked immutable, the data can't change and the mark can't
+		// be removed
+		if immutable, _ := previousObject["immutable"].(bool); !immutable {
+			return nil
+		}
+		if current, _ := currentObject["immutable"].(bool); !current {
+			changed = append(changed, "immutable")
+		}
+		for _, key := range []string{"data", "binaryData", "stringData"} {
+			if !equal(previousObject[key], currentObject[key]) {
+				changed = append(changed, key)
+			}
+		}
+	}
+	return changed
+}
+
+// contains reports whether the live value holds everything set in the
+// manifest's value. Scalars are compared as text, as the API server returns
+// e.g. quantities as strings.
+func contains(live, manifest interface{}) bool {
+	switch manifest := manifest.(type) {
+	case nil:
+		return true
+	case map[interface{}]interface{}:
+		liveMap, ok := live.(map[interface{}]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range manifest {
+			if !contains(liveMap[key], value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		liveList, ok := live.([]interface{})
+		if !ok || len(liveList) != len(manifest) {
+			return false
+		}
+		for i, value := range manifest {
+			if !contains(liveList[i], value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return live != nil && fmt.Sprint(live) == fmt.Sprint(manifest)
+	}
+}
+
+func lookup(object map[interface{}]interface{}, path []string) (interface{}, bool) {
+	var value interface{} = object
+	for _, key := range path {
+		node, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = node[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
+
+// Plan returns a shell script which recreates the changed objects with their
+// new manifests. kubectl replace --force deletes each object and creates it
+// again, so run it after the new stack is deployed, during a maintenance
+// window: the objects are briefly missing, and recreating a
+// PersistentVolumeClaim may lose its data.
+func Plan(changes []Change) string {
+	var sb strings.Builder
+	sb.WriteString("#!/bin/sh\n")
+	sb.WriteString("# Recreates objects whose immutable fields changed. Review before running.\n")
+	sb.WriteString("set -e\n")
+	for _, change := range changes {
+		fmt.Fprintf(&sb, "\n# %s\n", change)
+		if change.Kind == "PersistentVolumeClaim" {
+			sb.WriteString("# WARNING: the claim's volume and its data may be lost\n")
+		}
+		sb.WriteString("kubectl replace --force -f - <<'MANIFEST'\n")
+		sb.Write(change.Manifest)
+		if len(change.Manifest) > 0 && change.Manifest[len(change.Manifest)-1] != '\n' {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("MANIFEST\n")
+	}
+	return sb.String()
+}
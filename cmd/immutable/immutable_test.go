@@ -0,0 +1,164 @@
+package immutable
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: test
+spec:
+  replicas: %s
+  selector:
+    matchLabels:
+      app: %s
+`
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		current  string
+		fields   []string
+	}{
+		{
+			name:     "mutable change",
+			previous: fmt.Sprintf(deployment, "1", "web"),
+			current:  fmt.Sprintf(deployment, "3", "web"),
+		},
+		{
+			name:     "selector change",
+			previous: fmt.Sprintf(deployment, "1", "web"),
+			current:  fmt.Sprintf(deployment, "1", "frontend"),
+			fields:   []string{"spec.selector"},
+		},
+		{
+			name:     "service clusterIP set by the server",
+			previous: "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\nspec:\n  clusterIP: 10.0.0.1\n",
+			current:  "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\nspec:\n  type: ClusterIP\n",
+		},
+		{
+			name:     "service clusterIP change",
+			previous: "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\nspec:\n  clusterIP: 10.0.0.1\n",
+			current:  "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\nspec:\n  clusterIP: None\n",
+			fields:   []string{"spec.clusterIP"},
+		},
+		{
+			name:     "statefulset claim templates",
+			previous: "apiVersion: apps/v1\nkind: StatefulSet\nmetadata:\n  name: db\nspec:\n  serviceName: db\n  volumeClaimTemplates:\n  - metadata:\n      name: data\n    spec:\n      resources:\n        requests:\n          storage: 1Gi\n",
+			current:  "apiVersion: apps/v1\nkind: StatefulSet\nmetadata:\n  name: db\nspec:\n  serviceName: db-headless\n  volumeClaimTemplates:\n  - metadata:\n      name: data\n    spec:\n      resources:\n        requests:\n          storage: 5Gi\n",
+			fields:   []string{"spec.serviceName", "spec.volumeClaimTemplates"},
+		},
+		{
+			name:     "job template",
+			previous: "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migrate\nspec:\n  template:\n    spec:\n      containers:\n      - image: app:v1\n",
+			current:  "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migrate\nspec:\n  template:\n    spec:\n      containers:\n      - image: app:v2\n",
+			fields:   []string{"spec.template"},
+		},
+		{
+			name:     "immutable configmap",
+			previous: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\nimmutable: true\ndata:\n  a: \"1\"\n",
+			current:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\nimmutable: true\ndata:\n  a: \"2\"\n",
+			fields:   []string{"data"},
+		},
+		{
+			name:     "mutable configmap",
+			previous: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  a: \"1\"\n",
+			current:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  a: \"2\"\n",
+		},
+		{
+			name:     "not an object",
+			previous: "kind: Service\nspec: original\n",
+			current:  "kind: Service\nspec: changed\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			change := Check([]byte(test.previous), []byte(test.current))
+			if len(test.fields) == 0 {
+				if change != nil {
+					t.Fatalf("expected no recreation, got: %s", change)
+				}
+				return
+			}
+			if change == nil {
+				t.Fatalf("expected %v to need a recreation", test.fields)
+			}
+			if strings.Join(change.Fields, ",") != strings.Join(test.fields, ",") {
+				t.Errorf("expected fields %v, got %v", test.fields, change.Fields)
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	previous := fmt.Sprintf(deployment, "1", "web")
+	current := fmt.Sprintf(deployment, "1", "frontend")
+	change := Check([]byte(previous), []byte(current))
+	if change == nil {
+		t.Fatalf("expected the selector change to need a recreation")
+	}
+	if change.Name != "web" || change.Namespace != "test" {
+		t.Errorf("unexpected object %s/%s", change.Namespace, change.Name)
+	}
+
+	plan := Plan([]Change{*change})
+	if !strings.Contains(plan, "kubectl replace --force -f - <<'MANIFEST'\n"+current+"MANIFEST\n") {
+		t.Errorf("expected the plan to replace the object with its new manifest, got:\n%s", plan)
+	}
+}
+
+func TestCheckLive(t *testing.T) {
+	tests := []struct {
+		name     string
+		live     string
+		manifest string
+		fields   []string
+	}{
+		{
+			name:     "defaults filled in by the server",
+			live:     "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migrate\nspec:\n  template:\n    metadata:\n      labels:\n        job-name: migrate\n    spec:\n      restartPolicy: Never\n      containers:\n      - image: app:v1\n        imagePullPolicy: IfNotPresent\n",
+			manifest: "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migrate\nspec:\n  template:\n    spec:\n      containers:\n      - image: app:v1\n",
+		},
+		{
+			name:     "claim template defaults",
+			live:     "apiVersion: apps/v1\nkind: StatefulSet\nmetadata:\n  name: db\nspec:\n  serviceName: db\n  volumeClaimTemplates:\n  - apiVersion: v1\n    kind: PersistentVolumeClaim\n    metadata:\n      name: data\n    spec:\n      volumeMode: Filesystem\n      resources:\n        requests:\n          storage: 1Gi\n",
+			manifest: "apiVersion: apps/v1\nkind: StatefulSet\nmetadata:\n  name: db\nspec:\n  serviceName: db\n  volumeClaimTemplates:\n  - metadata:\n      name: data\n    spec:\n      resources:\n        requests:\n          storage: 1Gi\n",
+		},
+		{
+			name:     "selector change",
+			live:     fmt.Sprintf(deployment, "1", "web"),
+			manifest: fmt.Sprintf(deployment, "1", "frontend"),
+			fields:   []string{"spec.selector"},
+		},
+		{
+			name:     "job image change",
+			live:     "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migrate\nspec:\n  template:\n    spec:\n      restartPolicy: Never\n      containers:\n      - image: app:v1\n",
+			manifest: "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migrate\nspec:\n  template:\n    spec:\n      containers:\n      - image: app:v2\n",
+			fields:   []string{"spec.template"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			change := CheckLive([]byte(test.live), []byte(test.manifest))
+			if len(test.fields) == 0 {
+				if change != nil {
+					t.Fatalf("expected no recreation, got: %s", change)
+				}
+				return
+			}
+			if change == nil {
+				t.Fatalf("expected %v to need a recreation", test.fields)
+			}
+			if strings.Join(change.Fields, ",") != strings.Join(test.fields, ",") {
+				t.Errorf("expected fields %v, got %v", test.fields, change.Fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package operator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/silogen/cluster-forge/cmd/forger"
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// ReasonInvalid is set when the spec of a release can't be read.
+const ReasonInvalid = "Invalid"
+
+// Controller reconciles the cluster to the ForgeReleases in it: it fetches
+// the released stack, applies it, checks its health and prunes the objects
+// left over from the previous release.
+type Controller struct {
+	client       dynamic.Interface
+	kubeConfig   *rest.Config
+	cacheDir     string
+	pollInterval time.Duration
+}
+
+// NewController creates a controller which keeps the stacks it applied in
+// cacheDir, and checks for releases that are due every pollInterval.
+func NewController(kubeConfig *rest.Config, cacheDir string, pollInterval time.Duration) (*Controller, error) {
+	client, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &Controller{client: client, kubeConfig: kubeConfig, cacheDir: cacheDir, pollInterval: pollInterval}, nil
+}
+
+// Run reconciles the releases which are due until ctx is cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	log.Infof("Watching %s", ForgeReleaseResource.String())
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+	for {
+		c.reconcileAll(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Controller) reconcileAll(ctx context.Context) {
+	list, err := c.client.Resource(ForgeReleaseResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Errorf("Failed to list ForgeReleases: %v", err)
+		return
+	}
+	for i := range list.Items {
+		raw := &list.Items[i]
+		release, err := parseRelease(raw)
+		if err != nil {
+			if ready := release.Status.ready(); ready != nil && ready.Reason == ReasonInvalid && release.Status.ObservedGeneration == release.Generation {
+				continue
+			}
+			log.Errorf("Invalid ForgeRelease %s: %v", raw.GetName(), err)
+			release.Status.ObservedGeneration = release.Generation
+			release.Status.setReady(false, ReasonInvalid, err.Error(), time.Now())
+			c.updateStatus(ctx, raw, release)
+			continue
+		}
+		if !release.due(time.Now()) {
+			continue
+		}
+		c.reconcile(ctx, raw, release)
+	}
+}
+
+// reconcile brings the cluster to the release and records the outcome in its
+// status.
+func (c *Controller) reconcile(ctx context.Context, raw *unstructured.Unstructured, release ForgeRelease) {
+	now := time.Now()
+	defer c.updateStatus(ctx, raw, release)
+	status := &release.Status
+	status.ObservedGeneration = release.Generation
+	status.LastReconcileTime = now.UTC().Format(time.RFC3339)
+
+	if release.Spec.Suspend {
+		status.setReady(false, ReasonSuspended, "Reconciliation is suspended", now)
+		return
+	}
+	log.Infof("Reconciling ForgeRelease %s", release.Name)
+
+	fetchDir, err := os.MkdirTemp(c.cacheDir, "fetch-*")
+	if err != nil {
+		status.setReady(false, ReasonFetchFailed, err.Error(), now)
+		return
+	}
+	defer os.RemoveAll(fetchDir)
+	stackPath, err := fetchSource(release.Spec.Source, fetchDir)
+	if err != nil {
+		log.Errorf("Failed to fetch ForgeRelease %s: %v", release.Name, err)
+		status.setReady(false, ReasonFetchFailed, err.Error(), now)
+		return
+	}
+	revision, err := stackRevision(stackPath)
+	if err != nil {
+		status.setReady(false, ReasonFetchFailed, err.Error(), now)
+		return
+	}
+	status.LastAttemptedRevision = revision
+
+	// A new or failed release is deployed in full, an applied one is only
+	// applied again to correct drift
+	healthTimeout := durationOr(release.Spec.HealthTimeout, defaultHealthTimeout)
+	ready := status.ready()
+	changed := revision != status.LastAppliedRevision || ready == nil || ready.Status != "True"
+	if changed {
+		err = forger.Apply(c.kubeConfig, stackPath, leaseWait)
+	} else {
+		err = forger.Reapply(c.kubeConfig, stackPath, leaseWait)
+	}
+	if err != nil {
+		log.Errorf("Failed to apply ForgeRelease %s: %v", release.Name, err)
+		status.setReady(false, ReasonApplyFailed, err.Error(), now)
+		return
+	}
+
+	err = utils.RunCommand(fmt.Sprintf("kubectl wait --for=condition=Ready -f %s --timeout=%s", filepath.Join(stackPath, "stack.yaml"), healthTimeout))
+	if err != nil {
+		log.Errorf("ForgeRelease %s is not healthy: %v", release.Name, err)
+		status.setReady(false, ReasonHealthCheckFailed, err.Error(), now)
+		return
+	}
+
+	appliedPath := filepath.Join(c.cacheDir, "applied", release.Name)
+	previousRevision := status.LastAppliedRevision
+	status.LastAppliedRevision = revision
+	if release.Spec.Prune && revision != previousRevision {
+		if _, err := os.Stat(appliedPath); err == nil {
+			if err := prune(appliedPath, stackPath); err != nil {
+				log.Errorf("Failed to prune ForgeRelease %s: %v", release.Name, err)
+				status.setReady(false, ReasonPruneFailed, err.Error(), now)
+				return
+			}
+		} else {
+			log.Infof("Not pruning ForgeRelease %s, the previous release was applied before this operator started", release.Name)
+		}
+	}
+	if err := replaceDir(stackPath, appliedPath); err != nil {
+		log.Warnf("Failed to keep ForgeRelease %s for pruning: %v", release.Name, err)
+	}
+	status.setReady(true, ReasonApplied, "Applied revision "+revision, now)
+	log.Infof("ForgeRelease %s is at revision %s", release.Name, revision)
+}
+
+func (c *Controller) updateStatus(ctx context.Context, raw *unstructured.Unstructured, release ForgeRelease) {
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&release.Status)
+	if err != nil {
+		log.Errorf("Failed to convert status of ForgeRelease %s: %v", release.Name, err)
+		return
+	}
+	raw.Object["status"] = status
+	_, err = c.client.Resource(ForgeReleaseResource).UpdateStatus(ctx, raw, metav1.UpdateOptions{})
+	if err != nil {
+		log.Errorf("Failed to update status of ForgeRelease %s: %v", release.Name, err)
+	}
+}
+
+func parseRelease(raw *unstructured.Unstructured) (ForgeRelease, error) {
+	release := ForgeRelease{Name: raw.GetName(), Generation: raw.GetGeneration()}
+	if status, ok := raw.Object["status"].(map[string]interface{}); ok {
+		// A status which can't be read is replaced on the next update
+		_ = runtime.DefaultUnstructuredConverter.FromUnstructured(status, &release.Status)
+	}
+	spec, ok := raw.Object["spec"].(map[string]interface{})
+	if !ok {
+		return release, fmt.Errorf("missing spec")
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &release.Spec); err != nil {
+		return release, err
+	}
+	return release, nil
+}
+
+// replaceDir moves src to dst, replacing what was there.
+func replaceDir(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
@@ -0,0 +1,200 @@
+package operator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDue(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	applied := ForgeReleaseStatus{ObservedGeneration: 1, LastReconcileTime: now.Add(-time.Minute).Format(time.RFC3339)}
+	applied.setReady(true, ReasonApplied, "", now)
+	failed := ForgeReleaseStatus{ObservedGeneration: 1, LastReconcileTime: now.Add(-time.Minute).Format(time.RFC3339)}
+	failed.setReady(false, ReasonApplyFailed, "", now)
+
+	tests := []struct {
+		name    string
+		release ForgeRelease
+		due     bool
+	}{
+		{"never reconciled", ForgeRelease{Generation: 1}, true},
+		{"spec changed", ForgeRelease{Generation: 2, Status: applied}, true},
+		{"applied within interval", ForgeRelease{Generation: 1, Status: applied}, false},
+		{"applied with short interval", ForgeRelease{Generation: 1, Spec: ForgeReleaseSpec{Interval: "30s"}, Status: applied}, true},
+		{"failed is retried", ForgeRelease{Generation: 1, Status: failed}, true},
+	}
+	for _, test := range tests {
+		if due := test.release.due(now); due != test.due {
+			t.Errorf("%s: expected due %v, got %v", test.name, test.due, due)
+		}
+	}
+}
+
+func TestSetReadyKeepsTransitionTime(t *testing.T) {
+	first := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var status ForgeReleaseStatus
+	status.setReady(true, ReasonApplied, "Applied revision a", first)
+	status.setReady(true, ReasonApplied, "Applied revision b", first.Add(time.Hour))
+
+	if len(status.Conditions) != 1 {
+		t.Fatalf("expected one condition, got %v", status.Conditions)
+	}
+	if status.Conditions[0].LastTransitionTime != first.Format(time.RFC3339) || status.Conditions[0].Message != "Applied revision b" {
+		t.Errorf("expected the message to change but not the transition time, got %+v", status.Conditions[0])
+	}
+}
+
+func TestParseRelease(t *testing.T) {
+	raw := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "platform", "generation": int64(3)},
+		"spec": map[string]interface{}{
+			"source":   map[string]interface{}{"git": map[string]interface{}{"url": "https://example.com/estate.git", "path": "stacks/prod"}},
+			"interval": "1m",
+			"prune":    true,
+		},
+	}}
+	release, err := parseRelease(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if release.Name != "platform" || release.Generation != 3 || release.Spec.Source.Git.Path != "stacks/prod" || !release.Spec.Prune {
+		t.Errorf("unexpected release: %+v", release)
+	}
+}
+
+func writeStack(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+}
+
+func TestPruneTargets(t *testing.T) {
+	dir := t.TempDir()
+	previous := filepath.Join(dir, "previous")
+	current := filepath.Join(dir, "current")
+	writeStack(t, previous, map[string]string{
+		"stack.yaml": `apiVersion: forge.silogen.ai/v1alpha1
+kind: XForge
+metadata:
+  name: old
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: old-namespace
+---
+apiVersion: forge.silogen.ai/v1alpha1
+kind: XForge
+metadata:
+  name: kept
+`,
+	})
+	writeStack(t, current, map[string]string{
+		"stack.yaml": `apiVersion: forge.silogen.ai/v1alpha1
+kind: XForge
+metadata:
+  name: kept
+`,
+	})
+
+	previousObjects, err := stackObjects(previous)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	currentObjects, err := stackObjects(current)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	targets := pruneTargets(previousObjects, currentObjects)
+	if len(targets) != 1 {
+		t.Fatalf("expected only the old XForge to be pruned, got %q", targets)
+	}
+}
+
+func TestStackRevision(t *testing.T) {
+	dir := t.TempDir()
+	writeStack(t, dir, map[string]string{"stack.yaml": "kind: XForge\n", "composition.yaml": "kind: Composition\n"})
+
+	first, err := stackRevision(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _ := stackRevision(dir)
+	if first != second {
+		t.Errorf("expected the same files to give the same revision")
+	}
+	writeStack(t, dir, map[string]string{"stack.yaml": "kind: XForge\nspec: {}\n"})
+	if changed, _ := stackRevision(dir); changed == first {
+		t.Errorf("expected a changed file to change the revision")
+	}
+}
+
+func TestRepositoryPath(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "src")
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"", true},
+		{"stacks/platform", true},
+		{"stacks/../platform", true},
+		{"..", false},
+		{"../src-evil", false},
+		{"stacks/../../etc", false},
+	}
+	for _, test := range tests {
+		stackPath, err := repositoryPath(dir, test.path)
+		if test.valid && (err != nil || stackPath != filepath.Join(dir, test.path)) {
+			t.Errorf("expected %q to be inside the repository, got %q, %v", test.path, stackPath, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected %q to be rejected, got %q", test.path, stackPath)
+		}
+	}
+}
+
+func TestFormatReleases(t *testing.T) {
+	var status ForgeReleaseStatus
+	status.LastAppliedRevision = "abc123"
+	status.setReady(false, ReasonApplyFailed, "kubectl apply failed\nmore output", time.Now())
+	table := FormatReleases([]ForgeRelease{{Name: "prod", Status: status}, {Name: "new"}})
+
+	lines := strings.Split(strings.TrimSpace(table), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "NAME") {
+		t.Fatalf("expected a header and a line per release, got:\n%s", table)
+	}
+	if fields := strings.Fields(lines[1]); fields[0] != "prod" || fields[1] != "False" || fields[2] != ReasonApplyFailed || fields[3] != "abc123" {
+		t.Errorf("unexpected line for prod: %s", lines[1])
+	}
+	if strings.Contains(table, "more output") {
+		t.Errorf("expected only the first line of the message, got:\n%s", table)
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "new" || fields[1] != "Unknown" {
+		t.Errorf("unexpected line for a release never reconciled: %s", lines[2])
+	}
+}
+
+func TestFetchSourceRejectsOptions(t *testing.T) {
+	sources := []Source{
+		{OCI: "--config=/etc/passwd"},
+		{Git: &GitSource{URL: "--upload-pack=touch /tmp/pwned"}},
+		{Git: &GitSource{URL: "https://example.com/stacks.git", Ref: "-u"}},
+	}
+	for _, source := range sources {
+		if _, err := fetchSource(source, t.TempDir()); utils.ClassOf(err) != utils.ConfigError {
+			t.Errorf("expected a config error for %+v, got %v", source, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package operator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	goyaml "github.com/go-yaml/yaml"
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// keptKinds are never pruned, as deleting them deletes everything in them.
+var keptKinds = map[string]bool{
+	"Namespace":                true,
+	"CustomResourceDefinition": true,
+}
+
+type stackObject struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+}
+
+// stackDocument is an object of a stack with the document defining it.
+type stackDocument struct {
+	object  stackObject
+	content []byte
+}
+
+func (o stackObject) id() string {
+	return strings.Join([]string{o.APIVersion, o.Kind, o.Metadata.Namespace, o.Metadata.Name}, "/")
+}
+
+// stackObjects reads the objects defined by the yaml files of a stack, keyed
+// by their identity.
+func stackObjects(stackPath string) (map[string]stackDocument, error) {
+	objects := map[string]stackDocument{}
+	files, err := filepath.Glob(filepath.Join(stackPath, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		decoder := goyaml.NewDecoder(bytes.NewReader(data))
+		for {
+			var document interface{}
+			err := decoder.Decode(&document)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+			}
+			if document == nil {
+				continue
+			}
+			content, err := goyaml.Marshal(document)
+			if err != nil {
+				return nil, err
+			}
+			var object stackObject
+			if err := goyaml.Unmarshal(content, &object); err != nil || object.Kind == "" || object.Metadata.Name == "" {
+				continue
+			}
+			objects[object.id()] = stackDocument{object: object, content: content}
+		}
+	}
+	return objects, nil
+}
+
+// pruneTargets returns the documents of the objects in the previous stack
+// which are not in the current one, in a stable order.
+func pruneTargets(previous, current map[string]stackDocument) [][]byte {
+	var ids []string
+	for id := range previous {
+		if _, exists := current[id]; !exists {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var targets [][]byte
+	for _, id := range ids {
+		document := previous[id]
+		if keptKinds[document.object.Kind] {
+			log.Infof("Not pruning %s, %s objects are never pruned", id, document.object.Kind)
+			continue
+		}
+		targets = append(targets, document.content)
+	}
+	return targets
+}
+
+// prune deletes the objects of the previous stack which are not in the
+// current one.
+func prune(previousPath, currentPath string) error {
+	previous, err := stackObjects(previousPath)
+	if err != nil {
+		return err
+	}
+	current, err := stackObjects(currentPath)
+	if err != nil {
+		return err
+	}
+	targets := pruneTargets(previous, current)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	file, err := os.CreateTemp("", "forge-prune-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(bytes.Join(targets, []byte("---\n")))
+	file.Close()
+	if err != nil {
+		return err
+	}
+	log.Infof("Pruning %d objects removed from the release", len(targets))
+	return utils.NewError(utils.ApplyError, utils.RunCommand(fmt.Sprintf("kubectl delete --ignore-not-found -f %s", file.Name())))
+}
@@ -0,0 +1,101 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package operator
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+// fetchSource downloads the stack of a release into dir and returns the path
+// of the stack directory within it.
+func fetchSource(source Source, dir string) (string, error) {
+	switch {
+	case source.OCI != "" && source.Git != nil:
+		return "", utils.Errorf(utils.ConfigError, "source must set one of oci and git, not both")
+	case source.OCI != "":
+		if err := checkSourceArgument("source.oci", source.OCI); err != nil {
+			return "", err
+		}
+		if err := runSourceCommand("oras", "pull", "--output", dir, "--", source.OCI); err != nil {
+			return "", err
+		}
+		return dir, nil
+	case source.Git != nil:
+		if source.Git.URL == "" {
+			return "", utils.Errorf(utils.ConfigError, "source.git.url must be set")
+		}
+		if err := checkSourceArgument("source.git.url", source.Git.URL); err != nil {
+			return "", err
+		}
+		if err := checkSourceArgument("source.git.ref", source.Git.Ref); err != nil {
+			return "", err
+		}
+		stackPath, err := repositoryPath(dir, source.Git.Path)
+		if err != nil {
+			return "", err
+		}
+		args := []string{"clone", "--depth", "1"}
+		if source.Git.Ref != "" {
+			args = append(args, "--branch", source.Git.Ref)
+		}
+		args = append(args, "--", source.Git.URL, dir)
+		if err := runSourceCommand("git", args...); err != nil {
+			return "", err
+		}
+		return stackPath, nil
+	default:
+		return "", utils.Errorf(utils.ConfigError, "source must set one of oci and git")
+	}
+}
+
+// repositoryPath returns the path of the stack within the repository cloned
+// into dir, refusing paths which lead out of it.
+func repositoryPath(dir, path string) (string, error) {
+	stackPath := filepath.Join(dir, path)
+	relativePath, err := filepath.Rel(dir, stackPath)
+	if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return "", utils.Errorf(utils.ConfigError, "source.git.path '%s' is outside the repository", path)
+	}
+	return stackPath, nil
+}
+
+// checkSourceArgument refuses values of the source which git or oras would
+// take for options, e.g. a URL of --upload-pack=<command>.
+func checkSourceArgument(field, value string) error {
+	if strings.HasPrefix(value, "-") {
+		return utils.Errorf(utils.ConfigError, "%s '%s' must not start with '-'", field, value)
+	}
+	return nil
+}
+
+func runSourceCommand(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return utils.Errorf(utils.FetchError, "%s %s failed: %w\nOutput: %s", name, strings.Join(args, " "), err, string(output))
+	}
+	return nil
+}
+
+// stackRevision returns the digest of a stack, which identifies the release
+// whichever way it was fetched, and is the digest in its release record.
+func stackRevision(stackPath string) (string, error) {
+	return utils.StackDigest(stackPath)
+}
@@ -0,0 +1,79 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// ListReleases returns the ForgeReleases in the cluster, or none if the CRD
+// isn't installed. Releases whose spec can't be read are returned with what
+// could be read of them.
+func ListReleases(ctx context.Context, kubeConfig *rest.Config) ([]ForgeRelease, error) {
+	client, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	list, err := client.Resource(ForgeReleaseResource).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ForgeReleases: %w", err)
+	}
+	var releases []ForgeRelease
+	for i := range list.Items {
+		release, _ := parseRelease(&list.Items[i])
+		releases = append(releases, release)
+	}
+	return releases, nil
+}
+
+// FormatReleases returns a table of the releases and their Ready condition,
+// like kubectl get.
+func FormatReleases(releases []ForgeRelease) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tREADY\tREASON\tREVISION\tLAST RECONCILE\tMESSAGE")
+	for _, release := range releases {
+		ready, reason, message := "Unknown", "", ""
+		if condition := release.Status.ready(); condition != nil {
+			ready, reason = condition.Status, condition.Reason
+			// Errors from kubectl span several lines
+			message = strings.SplitN(condition.Message, "\n", 2)[0]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", release.Name, ready, reason,
+			valueOr(release.Status.LastAppliedRevision, "-"), valueOr(release.Status.LastReconcileTime, "-"), message)
+	}
+	w.Flush()
+	return b.String()
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
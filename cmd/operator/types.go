@@ -0,0 +1,157 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package operator
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ForgeReleaseResource is the cluster-scoped ForgeRelease custom resource,
+// defined in operator/crd.yaml.
+var ForgeReleaseResource = schema.GroupVersionResource{
+	Group:    "clusterforge.io",
+	Version:  "v1alpha1",
+	Resource: "forgereleases",
+}
+
+const (
+	defaultInterval      = 5 * time.Minute
+	defaultHealthTimeout = 10 * time.Minute
+	// retryInterval is how soon a failed reconcile is retried.
+	retryInterval = 30 * time.Second
+	// leaseWait is how long to wait for a forge run holding the cluster Lease.
+	leaseWait = 5 * time.Minute
+)
+
+// Condition reasons of the Ready condition.
+const (
+	ReasonApplied           = "Applied"
+	ReasonSuspended         = "Suspended"
+	ReasonFetchFailed       = "FetchFailed"
+	ReasonApplyFailed       = "ApplyFailed"
+	ReasonHealthCheckFailed = "HealthCheckFailed"
+	ReasonPruneFailed       = "PruneFailed"
+)
+
+// ForgeRelease asks the operator to keep the cluster at a released stack.
+type ForgeRelease struct {
+	Name       string
+	Generation int64
+	Spec       ForgeReleaseSpec
+	Status     ForgeReleaseStatus
+}
+
+type ForgeReleaseSpec struct {
+	Source Source `json:"source"`
+	// Interval is how often the release is fetched and enforced, e.g. 5m.
+	Interval string `json:"interval,omitempty"`
+	// HealthTimeout is how long to wait for the stack to become Ready.
+	HealthTimeout string `json:"healthTimeout,omitempty"`
+	// Prune deletes the objects of the previous release which are not in
+	// the new one.
+	Prune bool `json:"prune,omitempty"`
+	// Suspend stops reconciling the release, e.g. during maintenance.
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// Source is where the stack directory of a release is published: an OCI
+// artifact pushed with oras, or a path in a Git repository.
+type Source struct {
+	OCI string     `json:"oci,omitempty"`
+	Git *GitSource `json:"git,omitempty"`
+}
+
+type GitSource struct {
+	URL  string `json:"url"`
+	Ref  string `json:"ref,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type ForgeReleaseStatus struct {
+	ObservedGeneration    int64       `json:"observedGeneration,omitempty"`
+	LastAppliedRevision   string      `json:"lastAppliedRevision,omitempty"`
+	LastAttemptedRevision string      `json:"lastAttemptedRevision,omitempty"`
+	LastReconcileTime     string      `json:"lastReconcileTime,omitempty"`
+	Conditions            []Condition `json:"conditions,omitempty"`
+}
+
+type Condition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+// ready returns the Ready condition, if set.
+func (s ForgeReleaseStatus) ready() *Condition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == "Ready" {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// setReady sets the Ready condition, keeping its transition time if the
+// status doesn't change.
+func (s *ForgeReleaseStatus) setReady(ready bool, reason, message string, now time.Time) {
+	status := "False"
+	if ready {
+		status = "True"
+	}
+	condition := Condition{Type: "Ready", Status: status, Reason: reason, Message: message, LastTransitionTime: now.UTC().Format(time.RFC3339)}
+	if existing := s.ready(); existing != nil {
+		if existing.Status == status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		*existing = condition
+		return
+	}
+	s.Conditions = append(s.Conditions, condition)
+}
+
+// durationOr parses a duration from the spec, using fallback if it is empty
+// or invalid.
+func durationOr(value string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+	return duration
+}
+
+// due reports whether the release should be reconciled at now: when its spec
+// changed, its interval passed, or a failed attempt is to be retried.
+func (r ForgeRelease) due(now time.Time) bool {
+	if r.Status.ObservedGeneration != r.Generation {
+		return true
+	}
+	last, err := time.Parse(time.RFC3339, r.Status.LastReconcileTime)
+	if err != nil {
+		return true
+	}
+	wait := durationOr(r.Spec.Interval, defaultInterval)
+	if condition := r.Status.ready(); condition == nil || condition.Status != "True" {
+		if condition == nil || condition.Reason != ReasonSuspended {
+			wait = retryInterval
+		}
+	}
+	return !now.Before(last.Add(wait))
+}
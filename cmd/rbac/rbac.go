@@ -0,0 +1,334 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const clusterScope = ""
+
+type rule struct {
+	APIGroups       []string `yaml:"apiGroups"`
+	Resources       []string `yaml:"resources"`
+	ResourceNames   []string `yaml:"resourceNames"`
+	Verbs           []string `yaml:"verbs"`
+	NonResourceURLs []string `yaml:"nonResourceURLs"`
+}
+
+type subject struct {
+	Kind      string `yaml:"kind"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+// labelSelector selects the ClusterRoles aggregated into another.
+type labelSelector struct {
+	MatchLabels      map[string]string `yaml:"matchLabels"`
+	MatchExpressions []struct {
+		Key      string   `yaml:"key"`
+		Operator string   `yaml:"operator"`
+		Values   []string `yaml:"values"`
+	} `yaml:"matchExpressions"`
+}
+
+type object struct {
+	Kind       string `yaml:"kind"`
+	APIVersion string `yaml:"apiVersion"`
+	Metadata   struct {
+		Name      string            `yaml:"name"`
+		Namespace string            `yaml:"namespace"`
+		Labels    map[string]string `yaml:"labels"`
+	} `yaml:"metadata"`
+	Rules           []rule `yaml:"rules"`
+	AggregationRule *struct {
+		ClusterRoleSelectors []labelSelector `yaml:"clusterRoleSelectors"`
+	} `yaml:"aggregationRule"`
+	Subjects []subject `yaml:"subjects"`
+	RoleRef  struct {
+		Kind string `yaml:"kind"`
+		Name string `yaml:"name"`
+	} `yaml:"roleRef"`
+}
+
+// permission is a single verb on a resource, in a namespace or cluster-wide.
+type permission struct {
+	namespace string
+	resource  string
+	verb      string
+}
+
+// Change is what a subject, usually a tool's ServiceAccount, gains and loses
+// in permissions between two releases.
+type Change struct {
+	Subject string
+	Gained  []string
+	Lost    []string
+}
+
+func (c Change) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s:", c.Subject)
+	for _, line := range c.Gained {
+		fmt.Fprintf(&sb, "\n  + %s", line)
+	}
+	for _, line := range c.Lost {
+		fmt.Fprintf(&sb, "\n  - %s", line)
+	}
+	return sb.String()
+}
+
+// Compare computes the permissions each subject gains and loses between the
+// RBAC objects of two releases, given as the manifests of each keyed by file.
+// Roles bound but not part of the release, like the built-in cluster-admin,
+// are listed by name since their rules are not known.
+func Compare(previous, current map[string][]byte) []Change {
+	previousPermissions := permissions(previous)
+	currentPermissions := permissions(current)
+
+	subjects := map[string]bool{}
+	for name := range previousPermissions {
+		subjects[name] = true
+	}
+	for name := range currentPermissions {
+		subjects[name] = true
+	}
+
+	var changes []Change
+	for name := range subjects {
+		change := Change{
+			Subject: name,
+			Gained:  describe(difference(currentPermissions[name], previousPermissions[name])),
+			Lost:    describe(difference(previousPermissions[name], currentPermissions[name])),
+		}
+		if len(change.Gained) > 0 || len(change.Lost) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Subject < changes[j].Subject })
+	return changes
+}
+
+// permissions resolves the bindings in the manifests into the permissions
+// of each subject.
+func permissions(manifests map[string][]byte) map[string]map[permission]bool {
+	roles := map[string][]rule{}
+	clusterRoles := map[string]object{}
+	var bindings []object
+	for _, manifest := range manifests {
+		var obj object
+		if yaml.Unmarshal(manifest, &obj) != nil || !strings.HasPrefix(obj.APIVersion, "rbac.authorization.k8s.io/") {
+			continue
+		}
+		switch obj.Kind {
+		case "Role":
+			roles[roleKey("Role", obj.Metadata.Namespace, obj.Metadata.Name)] = obj.Rules
+		case "ClusterRole":
+			clusterRoles[obj.Metadata.Name] = obj
+		case "RoleBinding", "ClusterRoleBinding":
+			bindings = append(bindings, obj)
+		}
+	}
+	for name := range clusterRoles {
+		roles[roleKey("ClusterRole", clusterScope, name)] = clusterRoleRules(name, clusterRoles, map[string]bool{})
+	}
+
+	result := map[string]map[permission]bool{}
+	for _, binding := range bindings {
+		namespace := binding.Metadata.Namespace
+		if binding.Kind == "ClusterRoleBinding" {
+			namespace = clusterScope
+		}
+		roleNamespace := namespace
+		if binding.RoleRef.Kind == "ClusterRole" {
+			roleNamespace = clusterScope
+		}
+		granted := map[permission]bool{}
+		rules, known := roles[roleKey(binding.RoleRef.Kind, roleNamespace, binding.RoleRef.Name)]
+		if !known {
+			granted[permission{namespace: namespace, resource: fmt.Sprintf("%s %s", binding.RoleRef.Kind, binding.RoleRef.Name), verb: "bound to"}] = true
+		}
+		for _, rule := range rules {
+			for _, target := range ruleTargets(rule) {
+				for _, verb := range rule.Verbs {
+					granted[permission{namespace: namespace, resource: target, verb: verb}] = true
+				}
+			}
+		}
+
+		for _, s := range binding.Subjects {
+			name := subjectName(s, binding.Metadata.Namespace)
+			if result[name] == nil {
+				result[name] = map[permission]bool{}
+			}
+			for p := range granted {
+				result[name][p] = true
+			}
+		}
+	}
+	return result
+}
+
+// clusterRoleRules returns the rules of a ClusterRole. The rules of an
+// aggregated ClusterRole are those of the ClusterRoles its selectors match,
+// which the controller manager writes into it in place of its own.
+func clusterRoleRules(name string, clusterRoles map[string]object, visited map[string]bool) []rule {
+	role := clusterRoles[name]
+	if role.AggregationRule == nil {
+		return role.Rules
+	}
+	visited[name] = true
+	var names []string
+	for candidate, candidateRole := range clusterRoles {
+		if visited[candidate] {
+			continue
+		}
+		for _, selector := range role.AggregationRule.ClusterRoleSelectors {
+			if selector.matches(candidateRole.Metadata.Labels) {
+				names = append(names, candidate)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	var rules []rule
+	for _, candidate := range names {
+		rules = append(rules, clusterRoleRules(candidate, clusterRoles, visited)...)
+	}
+	return rules
+}
+
+// matches reports whether the labels match the selector. An empty selector
+// matches nothing, as in aggregation rules.
+func (s labelSelector) matches(labels map[string]string) bool {
+	if len(s.MatchLabels) == 0 && len(s.MatchExpressions) == 0 {
+		return false
+	}
+	for key, value := range s.MatchLabels {
+		if actual, exists := labels[key]; !exists || actual != value {
+			return false
+		}
+	}
+	for _, expression := range s.MatchExpressions {
+		value, exists := labels[expression.Key]
+		in := false
+		for _, candidate := range expression.Values {
+			in = in || (exists && value == candidate)
+		}
+		switch expression.Operator {
+		case "In":
+			if !in {
+				return false
+			}
+		case "NotIn":
+			if in {
+				return false
+			}
+		case "Exists":
+			if !exists {
+				return false
+			}
+		case "DoesNotExist":
+			if exists {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func roleKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+func subjectName(s subject, bindingNamespace string) string {
+	if s.Kind == "ServiceAccount" {
+		namespace := s.Namespace
+		if namespace == "" {
+			namespace = bindingNamespace
+		}
+		return fmt.Sprintf("ServiceAccount %s/%s", namespace, s.Name)
+	}
+	return fmt.Sprintf("%s %s", s.Kind, s.Name)
+}
+
+// ruleTargets lists what a rule applies to: resources qualified by their API
+// group and resource names, or non-resource URLs.
+func ruleTargets(r rule) []string {
+	var targets []string
+	for _, url := range r.NonResourceURLs {
+		targets = append(targets, "url "+url)
+	}
+	groups := r.APIGroups
+	if len(groups) == 0 {
+		groups = []string{""}
+	}
+	for _, group := range groups {
+		for _, resource := range r.Resources {
+			target := resource
+			if group != "" {
+				target += "." + group
+			}
+			if len(r.ResourceNames) == 0 {
+				targets = append(targets, target)
+				continue
+			}
+			for _, name := range r.ResourceNames {
+				targets = append(targets, target+" "+name)
+			}
+		}
+	}
+	return targets
+}
+
+func difference(a, b map[permission]bool) []permission {
+	var result []permission
+	for p := range a {
+		if !b[p] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// describe groups permissions by resource into lines like
+// "get, list secrets in namespace monitoring".
+func describe(perms []permission) []string {
+	type target struct{ namespace, resource string }
+	verbs := map[target][]string{}
+	for _, p := range perms {
+		t := target{p.namespace, p.resource}
+		verbs[t] = append(verbs[t], p.verb)
+	}
+	var lines []string
+	for t, targetVerbs := range verbs {
+		sort.Strings(targetVerbs)
+		scope := "cluster-wide"
+		if t.namespace != clusterScope {
+			scope = "in namespace " + t.namespace
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s", strings.Join(targetVerbs, ", "), t.resource, scope))
+	}
+	sort.Strings(lines)
+	return lines
+}
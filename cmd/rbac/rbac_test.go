@@ -0,0 +1,152 @@
+package rbac
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const clusterRole = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: grafana
+rules:
+- apiGroups: [""]
+  resources: [configmaps%s]
+  verbs: [get, list, watch]
+`
+
+const clusterRoleBinding = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: grafana
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: grafana
+subjects:
+- kind: ServiceAccount
+  name: grafana
+  namespace: monitoring
+`
+
+const roleBinding = `apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: grafana-admin
+  namespace: monitoring
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: admin
+subjects:
+- kind: ServiceAccount
+  name: grafana
+`
+
+func TestCompare(t *testing.T) {
+	previous := map[string][]byte{
+		"ClusterRole_grafana.yaml":        []byte(fmt.Sprintf(clusterRole, "")),
+		"ClusterRoleBinding_grafana.yaml": []byte(clusterRoleBinding),
+		"Deployment_grafana.yaml":         []byte("apiVersion: apps/v1\nkind: Deployment\n"),
+	}
+	current := map[string][]byte{
+		"ClusterRole_grafana.yaml":        []byte(fmt.Sprintf(clusterRole, ", secrets")),
+		"ClusterRoleBinding_grafana.yaml": []byte(clusterRoleBinding),
+		"RoleBinding_grafana-admin.yaml":  []byte(roleBinding),
+	}
+
+	changes := Compare(previous, current)
+	if len(changes) != 1 {
+		t.Fatalf("expected one subject to change, got %v", changes)
+	}
+	change := changes[0]
+	if change.Subject != "ServiceAccount monitoring/grafana" {
+		t.Errorf("unexpected subject %s", change.Subject)
+	}
+	expected := []string{
+		"bound to ClusterRole admin in namespace monitoring",
+		"get, list, watch secrets cluster-wide",
+	}
+	if strings.Join(change.Gained, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected gains %v, got %v", expected, change.Gained)
+	}
+	if len(change.Lost) != 0 {
+		t.Errorf("expected no losses, got %v", change.Lost)
+	}
+
+	reverse := Compare(current, previous)
+	if len(reverse) != 1 || len(reverse[0].Lost) != 2 || len(reverse[0].Gained) != 0 {
+		t.Errorf("expected the reverse upgrade to lose both, got %v", reverse)
+	}
+}
+
+func TestCompareUnchanged(t *testing.T) {
+	manifests := map[string][]byte{
+		"ClusterRole_grafana.yaml":        []byte(fmt.Sprintf(clusterRole, "")),
+		"ClusterRoleBinding_grafana.yaml": []byte(clusterRoleBinding),
+	}
+	if changes := Compare(manifests, manifests); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
+
+const aggregatedClusterRole = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: monitoring-view
+aggregationRule:
+  clusterRoleSelectors:
+  - matchLabels:
+      example.com/aggregate-to-monitoring: "true"
+rules: []
+`
+
+const aggregatedClusterRoleBinding = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: monitoring-view
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: monitoring-view
+subjects:
+- kind: Group
+  name: monitoring-users
+`
+
+const labelledClusterRole = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: %s
+  labels:
+    example.com/aggregate-to-monitoring: "%s"
+rules:
+- apiGroups: [""]
+  resources: [%s]
+  verbs: [get]
+`
+
+func TestCompareAggregatedClusterRoles(t *testing.T) {
+	previous := map[string][]byte{
+		"ClusterRole_monitoring-view.yaml":        []byte(aggregatedClusterRole),
+		"ClusterRoleBinding_monitoring-view.yaml": []byte(aggregatedClusterRoleBinding),
+		"ClusterRole_grafana-view.yaml":           []byte(fmt.Sprintf(labelledClusterRole, "grafana-view", "true", "configmaps")),
+	}
+	current := map[string][]byte{
+		"ClusterRole_monitoring-view.yaml":        []byte(aggregatedClusterRole),
+		"ClusterRoleBinding_monitoring-view.yaml": []byte(aggregatedClusterRoleBinding),
+		"ClusterRole_grafana-view.yaml":           []byte(fmt.Sprintf(labelledClusterRole, "grafana-view", "true", "configmaps")),
+		"ClusterRole_loki-view.yaml":              []byte(fmt.Sprintf(labelledClusterRole, "loki-view", "true", "secrets")),
+		"ClusterRole_tempo-view.yaml":             []byte(fmt.Sprintf(labelledClusterRole, "tempo-view", "false", "pods")),
+	}
+
+	changes := Compare(previous, current)
+	if len(changes) != 1 || changes[0].Subject != "Group monitoring-users" {
+		t.Fatalf("expected the group bound to the aggregated role to change, got %v", changes)
+	}
+	expected := []string{"get secrets cluster-wide"}
+	if strings.Join(changes[0].Gained, "\n") != strings.Join(expected, "\n") || len(changes[0].Lost) != 0 {
+		t.Errorf("expected gains %v, got %v", expected, changes[0])
+	}
+}
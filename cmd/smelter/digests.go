@@ -0,0 +1,94 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"path/filepath"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+// containerLists are the pod spec fields holding containers.
+var containerLists = []string{"containers", "initContainers", "ephemeralContainers"}
+
+// pinImageDigests rewrites the container images of the tool's objects to
+// their digests, if digest pinning is enabled.
+func pinImageDigests(config utils.Config, workingDir string) error {
+	if !utils.DigestPinningEnabled() {
+		return nil
+	}
+	return updateObjects(filepath.Join(workingDir, config.Name), func(file string, object map[string]interface{}) (bool, error) {
+		return pinContainers(object)
+	})
+}
+
+// pinContainers pins the images of the containers anywhere below value, so
+// pod templates of workloads and custom resources are covered alike.
+func pinContainers(value interface{}) (bool, error) {
+	changed := false
+	visit := func(key interface{}, item interface{}) error {
+		if containers, ok := item.([]interface{}); ok && isContainerList(key) {
+			for _, container := range containers {
+				containerMap, _ := container.(map[interface{}]interface{})
+				image, _ := containerMap["image"].(string)
+				pinned, err := utils.PinImage(image)
+				if err != nil {
+					return err
+				}
+				if pinned != image {
+					containerMap["image"] = pinned
+					changed = true
+				}
+			}
+			return nil
+		}
+		itemChanged, err := pinContainers(item)
+		changed = changed || itemChanged
+		return err
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if err := visit(key, item); err != nil {
+				return changed, err
+			}
+		}
+	case map[interface{}]interface{}:
+		for key, item := range value {
+			if err := visit(key, item); err != nil {
+				return changed, err
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			if err := visit(nil, item); err != nil {
+				return changed, err
+			}
+		}
+	}
+	return changed, nil
+}
+
+func isContainerList(key interface{}) bool {
+	for _, list := range containerLists {
+		if key == list {
+			return true
+		}
+	}
+	return false
+}
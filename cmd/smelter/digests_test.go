@@ -0,0 +1,89 @@
+package smelter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+const testPodImages = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: widgets
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: widgets/migrate:1.0
+      containers:
+      - name: widgets
+        image: widgets/server:1.0
+      - name: proxy
+        image: envoy@sha256:eeee
+`
+
+type digestsByImage map[string]string
+
+func (d digestsByImage) Digest(image string) (string, error) {
+	if digest, exists := d[image]; exists {
+		return digest, nil
+	}
+	return "", fmt.Errorf("unknown image %s", image)
+}
+
+func TestPinImageDigests(t *testing.T) {
+	workingDir := t.TempDir()
+	toolDir := filepath.Join(workingDir, "widgets")
+	if err := os.MkdirAll(toolDir, 0755); err != nil {
+		t.Fatalf("Failed to create tool directory: %v", err)
+	}
+	file := filepath.Join(toolDir, "Deployment_widgets.yaml")
+	if err := os.WriteFile(file, []byte(testPodImages), 0644); err != nil {
+		t.Fatalf("Failed to write deployment: %v", err)
+	}
+
+	config := utils.Config{Name: "widgets", Namespace: "widgets"}
+	if err := pinImageDigests(config, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unpinned, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(unpinned) != testPodImages {
+		t.Errorf("expected images to be unchanged without digest pinning, got:\n%s", unpinned)
+	}
+
+	resolver := digestsByImage{"widgets/migrate:1.0": "sha256:aaaa", "widgets/server:1.0": "sha256:bbbb"}
+	if err := utils.EnableDigestPinning(filepath.Join(workingDir, "forge.lock"), resolver); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer utils.DisableDigestPinning()
+	if err := pinImageDigests(config, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var deployment struct {
+		Spec struct {
+			Template struct {
+				Spec struct {
+					InitContainers []struct{ Image string } `yaml:"initContainers"`
+					Containers     []struct{ Image string } `yaml:"containers"`
+				} `yaml:"spec"`
+			} `yaml:"template"`
+		} `yaml:"spec"`
+	}
+	readObject(t, file, &deployment)
+	pod := deployment.Spec.Template.Spec
+	images := []string{pod.InitContainers[0].Image, pod.Containers[0].Image, pod.Containers[1].Image}
+	expected := []string{"widgets/migrate:1.0@sha256:aaaa", "widgets/server:1.0@sha256:bbbb", "envoy@sha256:eeee"}
+	for i := range expected {
+		if images[i] != expected[i] {
+			t.Errorf("expected image %s, got %s", expected[i], images[i])
+		}
+	}
+}
@@ -0,0 +1,161 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// applyHAProfile makes the tool's Deployments highly available if the tool
+// is smelted with the ha profile: they get at least the configured number of
+// replicas, preferably spread over nodes (or zones) and kept apart from each
+// other, and a PodDisruptionBudget allowing one replica down at a time.
+// Deployments with the Recreate strategy are left alone, as they usually
+// must not run more than once, and the replicas of Deployments scaled by a
+// HorizontalPodAutoscaler are left to it.
+func applyHAProfile(config utils.Config, workingDir string) error {
+	profile, ha := utils.ToolProfile(config)
+	if profile != utils.ProfileHA {
+		return nil
+	}
+	toolDir := filepath.Join(workingDir, config.Name)
+
+	autoscaled := map[string]bool{}
+	var budgetSelectors []interface{}
+	err := updateObjects(toolDir, func(file string, object map[string]interface{}) (bool, error) {
+		spec, _ := object["spec"].(map[interface{}]interface{})
+		switch object["kind"] {
+		case "HorizontalPodAutoscaler":
+			target, _ := spec["scaleTargetRef"].(map[interface{}]interface{})
+			if target["kind"] == "Deployment" {
+				autoscaled[fmt.Sprint(target["name"])] = true
+			}
+		case "PodDisruptionBudget":
+			budgetSelectors = append(budgetSelectors, spec["selector"])
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var budgets []map[string]interface{}
+	err = updateObjects(toolDir, func(file string, object map[string]interface{}) (bool, error) {
+		if object["kind"] != "Deployment" || object["apiVersion"] != "apps/v1" {
+			return false, nil
+		}
+		name, _ := utils.ObjectMetadata(object)["name"].(string)
+		spec, _ := object["spec"].(map[interface{}]interface{})
+		strategy, _ := spec["strategy"].(map[interface{}]interface{})
+		if strategy["type"] == "Recreate" {
+			log.Infof("Not making Deployment %s of %s highly available, it uses the Recreate strategy", name, config.Name)
+			return false, nil
+		}
+		selector, _ := spec["selector"].(map[interface{}]interface{})
+		template, _ := spec["template"].(map[interface{}]interface{})
+		podSpec, _ := template["spec"].(map[interface{}]interface{})
+		if selector == nil || podSpec == nil {
+			return false, nil
+		}
+
+		replicas := 1
+		if value, exists := spec["replicas"]; exists {
+			replicas, _ = value.(int)
+		}
+		// Deployments scaled to zero are disabled on purpose
+		if !autoscaled[name] && replicas > 0 && replicas < ha.Replicas {
+			spec["replicas"] = ha.Replicas
+		}
+		if _, exists := podSpec["topologySpreadConstraints"]; !exists {
+			podSpec["topologySpreadConstraints"] = []interface{}{map[interface{}]interface{}{
+				"maxSkew":           1,
+				"topologyKey":       ha.TopologyKey,
+				"whenUnsatisfiable": "ScheduleAnyway",
+				"labelSelector":     selector,
+			}}
+		}
+		affinity, _ := podSpec["affinity"].(map[interface{}]interface{})
+		if affinity == nil {
+			affinity = map[interface{}]interface{}{}
+			podSpec["affinity"] = affinity
+		}
+		if _, exists := affinity["podAntiAffinity"]; !exists {
+			affinity["podAntiAffinity"] = map[interface{}]interface{}{
+				"preferredDuringSchedulingIgnoredDuringExecution": []interface{}{map[interface{}]interface{}{
+					"weight": 100,
+					"podAffinityTerm": map[interface{}]interface{}{
+						"topologyKey":   "kubernetes.io/hostname",
+						"labelSelector": selector,
+					},
+				}},
+			}
+		}
+		if !hasSelector(budgetSelectors, selector) {
+			namespace, _ := utils.ObjectMetadata(object)["namespace"].(string)
+			budgets = append(budgets, map[string]interface{}{
+				"apiVersion": "policy/v1",
+				"kind":       "PodDisruptionBudget",
+				"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+				"spec": map[string]interface{}{
+					"maxUnavailable": 1,
+					"selector":       selector,
+				},
+			})
+		}
+		log.Debugf("Made %s highly available", file)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeDisruptionBudgets(config, budgets, toolDir)
+}
+
+// hasSelector reports whether one of the selectors equals selector.
+func hasSelector(selectors []interface{}, selector map[interface{}]interface{}) bool {
+	for _, existing := range selectors {
+		if reflect.DeepEqual(existing, selector) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeDisruptionBudgets(config utils.Config, budgets []map[string]interface{}, toolDir string) error {
+	for _, budget := range budgets {
+		document, err := yaml.Marshal(budget)
+		if err != nil {
+			return utils.Errorf(utils.RenderError, "failed to write the disruption budgets of %s: %w", config.Name, err)
+		}
+		metadataObject, updated, err := transformDocument(document, config, utils.RunAnnotations(config.Name, utils.SourceDigest(document)))
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(toolDir, fmt.Sprintf("%s_%s.yaml", metadataObject.Kind, metadataObject.Metadata.Name))
+		if err := os.WriteFile(filename, updated, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filename, err)
+		}
+	}
+	return nil
+}
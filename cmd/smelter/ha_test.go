@@ -0,0 +1,196 @@
+package smelter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+const testHAWeb = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: web:1.0
+`
+
+const testHAWorker = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+  namespace: shop
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: worker
+  template:
+    spec:
+      containers:
+      - name: worker
+        image: worker:1.0
+`
+
+const testHAWorkerAutoscaler = `apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: worker
+  namespace: shop
+spec:
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: worker
+`
+
+const testHAWorkerBudget = `apiVersion: policy/v1
+kind: PodDisruptionBudget
+metadata:
+  name: worker-pdb
+  namespace: shop
+spec:
+  minAvailable: 1
+  selector:
+    matchLabels:
+      app: worker
+`
+
+const testHASingleton = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: singleton
+  namespace: shop
+spec:
+  strategy:
+    type: Recreate
+  selector:
+    matchLabels:
+      app: singleton
+  template:
+    spec:
+      containers:
+      - name: singleton
+        image: singleton:1.0
+`
+
+type haDeployment struct {
+	Spec struct {
+		Replicas *int `yaml:"replicas"`
+		Template struct {
+			Spec struct {
+				TopologySpreadConstraints []struct {
+					TopologyKey string `yaml:"topologyKey"`
+				} `yaml:"topologySpreadConstraints"`
+				Affinity struct {
+					PodAntiAffinity map[string]interface{} `yaml:"podAntiAffinity"`
+				} `yaml:"affinity"`
+			} `yaml:"spec"`
+		} `yaml:"template"`
+	} `yaml:"spec"`
+}
+
+func writeHATool(t *testing.T) (string, string) {
+	t.Helper()
+	workingDir := t.TempDir()
+	toolDir := filepath.Join(workingDir, "shop")
+	if err := os.MkdirAll(toolDir, 0755); err != nil {
+		t.Fatalf("Failed to create tool directory: %v", err)
+	}
+	files := map[string]string{
+		"Deployment_web.yaml":                 testHAWeb,
+		"Deployment_worker.yaml":              testHAWorker,
+		"HorizontalPodAutoscaler_worker.yaml": testHAWorkerAutoscaler,
+		"PodDisruptionBudget_worker-pdb.yaml": testHAWorkerBudget,
+		"Deployment_singleton.yaml":           testHASingleton,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(toolDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return workingDir, toolDir
+}
+
+func TestApplyHAProfile(t *testing.T) {
+	utils.RegisterProfile(utils.ProfileHA, &utils.HAProfile{TopologyKey: "topology.kubernetes.io/zone"})
+	defer utils.RegisterProfile("", nil)
+	workingDir, toolDir := writeHATool(t)
+
+	if err := applyHAProfile(utils.Config{Name: "shop", Namespace: "shop"}, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var web haDeployment
+	readObject(t, filepath.Join(toolDir, "Deployment_web.yaml"), &web)
+	if web.Spec.Replicas == nil || *web.Spec.Replicas != utils.DefaultHAReplicas {
+		t.Errorf("expected web to get %d replicas, got %v", utils.DefaultHAReplicas, web.Spec.Replicas)
+	}
+	pod := web.Spec.Template.Spec
+	if len(pod.TopologySpreadConstraints) != 1 || pod.TopologySpreadConstraints[0].TopologyKey != "topology.kubernetes.io/zone" {
+		t.Errorf("unexpected topology spread constraints %+v", pod.TopologySpreadConstraints)
+	}
+	if pod.Affinity.PodAntiAffinity == nil {
+		t.Errorf("expected web to get pod anti-affinity")
+	}
+	var budget struct {
+		Spec struct {
+			MaxUnavailable int `yaml:"maxUnavailable"`
+			Selector       struct {
+				MatchLabels map[string]string `yaml:"matchLabels"`
+			} `yaml:"selector"`
+		} `yaml:"spec"`
+	}
+	readObject(t, filepath.Join(toolDir, "PodDisruptionBudget_web.yaml"), &budget)
+	if budget.Spec.MaxUnavailable != 1 || budget.Spec.Selector.MatchLabels["app"] != "web" {
+		t.Errorf("unexpected disruption budget %+v", budget.Spec)
+	}
+
+	// The autoscaler keeps scaling the worker, and its own budget is kept
+	var worker haDeployment
+	readObject(t, filepath.Join(toolDir, "Deployment_worker.yaml"), &worker)
+	if worker.Spec.Replicas == nil || *worker.Spec.Replicas != 2 {
+		t.Errorf("expected the replicas of the autoscaled worker to stay 2, got %v", worker.Spec.Replicas)
+	}
+	if _, err := os.Stat(filepath.Join(toolDir, "PodDisruptionBudget_worker.yaml")); err == nil {
+		t.Errorf("expected no second disruption budget for the worker")
+	}
+
+	content, err := os.ReadFile(filepath.Join(toolDir, "Deployment_singleton.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content) != testHASingleton {
+		t.Errorf("expected the Recreate Deployment to be unchanged, got:\n%s", content)
+	}
+}
+
+func TestApplyHAProfileOptOut(t *testing.T) {
+	utils.RegisterProfile(utils.ProfileHA, nil)
+	defer utils.RegisterProfile("", nil)
+	workingDir, toolDir := writeHATool(t)
+
+	config := utils.Config{Name: "shop", Namespace: "shop", Profile: utils.ProfileDefault}
+	if err := applyHAProfile(config, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(toolDir, "Deployment_web.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content) != testHAWeb {
+		t.Errorf("expected the tool's own profile to take precedence, got:\n%s", content)
+	}
+}
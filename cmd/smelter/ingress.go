@@ -0,0 +1,151 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// annotationIngressClass is the ingress class annotation which predates
+// ingressClassName.
+const annotationIngressClass = "kubernetes.io/ingress.class"
+
+// templateIngresses applies the cluster's ingress config to the tool: host
+// names of Ingresses and HTTPRoutes are made from the host template, and the
+// configured classes are set on Ingresses and Gateways. Distinct hosts of the
+// tool which the template maps to the same host fail the tool, as their
+// routes would clash.
+func templateIngresses(config utils.Config, workingDir string) error {
+	ingress := utils.RegisteredIngress()
+	if ingress == nil {
+		return nil
+	}
+	hosts := hostTemplater{ingress: *ingress, tool: config.Name, sources: map[string]string{}}
+	return updateObjects(filepath.Join(workingDir, config.Name), func(file string, object map[string]interface{}) (bool, error) {
+		spec, _ := object["spec"].(map[interface{}]interface{})
+		if spec == nil {
+			return false, nil
+		}
+		apiVersion, _ := object["apiVersion"].(string)
+		changed := false
+		switch {
+		case object["kind"] == "Ingress" && strings.HasPrefix(apiVersion, "networking.k8s.io/"):
+			rules, _ := spec["rules"].([]interface{})
+			for _, rule := range rules {
+				ruleMap, _ := rule.(map[interface{}]interface{})
+				ruleChanged, err := hosts.templateHost(ruleMap, "host")
+				if err != nil {
+					return false, err
+				}
+				changed = ruleChanged || changed
+			}
+			tlsList, _ := spec["tls"].([]interface{})
+			for _, tls := range tlsList {
+				tlsMap, _ := tls.(map[interface{}]interface{})
+				tlsChanged, err := hosts.templateHostList(tlsMap, "hosts")
+				if err != nil {
+					return false, err
+				}
+				changed = tlsChanged || changed
+			}
+			if ingress.IngressClass != "" && spec["ingressClassName"] != ingress.IngressClass {
+				spec["ingressClassName"] = ingress.IngressClass
+				changed = true
+			}
+			if annotations, ok := utils.ObjectMetadata(object)["annotations"].(map[interface{}]interface{}); ok && ingress.IngressClass != "" {
+				if _, exists := annotations[annotationIngressClass]; exists {
+					// The annotation must not be set together with ingressClassName
+					delete(annotations, annotationIngressClass)
+					changed = true
+				}
+			}
+		case object["kind"] == "HTTPRoute" && strings.HasPrefix(apiVersion, "gateway.networking.k8s.io/"):
+			routeChanged, err := hosts.templateHostList(spec, "hostnames")
+			if err != nil {
+				return false, err
+			}
+			changed = routeChanged
+		case object["kind"] == "Gateway" && strings.HasPrefix(apiVersion, "gateway.networking.k8s.io/"):
+			if ingress.GatewayClass != "" && spec["gatewayClassName"] != ingress.GatewayClass {
+				spec["gatewayClassName"] = ingress.GatewayClass
+				changed = true
+			}
+		}
+		if changed {
+			log.Debugf("Applied the ingress config to %s", file)
+		}
+		return changed, nil
+	})
+}
+
+// hostTemplater makes the host names of a tool from the host template,
+// remembering which host each one was made from.
+type hostTemplater struct {
+	ingress utils.Ingress
+	tool    string
+	sources map[string]string
+}
+
+// host returns the host name to use for a host of the tool, failing if
+// another of its hosts was given the same name.
+func (h hostTemplater) host(host string) (string, error) {
+	templated := h.ingress.Host(h.tool, host)
+	if source, exists := h.sources[templated]; exists && source != host {
+		return "", utils.Errorf(utils.ConfigError, "hosts %s and %s of %s both become %s with host template %s, add {subdomain} to it to keep them apart", source, host, h.tool, templated, h.ingress.HostTemplate)
+	}
+	h.sources[templated] = host
+	return templated, nil
+}
+
+// templateHost replaces the host name in fields[key], if there is one.
+func (h hostTemplater) templateHost(fields map[interface{}]interface{}, key string) (bool, error) {
+	host, _ := fields[key].(string)
+	if host == "" {
+		return false, nil
+	}
+	templated, err := h.host(host)
+	if err != nil || templated == host {
+		return false, err
+	}
+	fields[key] = templated
+	return true, nil
+}
+
+// templateHostList replaces the host names in the list fields[key].
+func (h hostTemplater) templateHostList(fields map[interface{}]interface{}, key string) (bool, error) {
+	hosts, _ := fields[key].([]interface{})
+	changed := false
+	for i, host := range hosts {
+		hostName, _ := host.(string)
+		if hostName == "" {
+			continue
+		}
+		templated, err := h.host(hostName)
+		if err != nil {
+			return false, err
+		}
+		if templated != hostName {
+			hosts[i] = templated
+			changed = true
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,153 @@
+package smelter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+const testIngress = `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: grafana
+  annotations:
+    kubernetes.io/ingress.class: nginx-public
+spec:
+  rules:
+  - host: grafana.example.local
+  - http: {}
+  tls:
+  - hosts: [grafana.example.local]
+    secretName: grafana-tls
+`
+
+const testHTTPRoute = `apiVersion: gateway.networking.k8s.io/v1
+kind: HTTPRoute
+metadata:
+  name: grafana
+spec:
+  hostnames: [grafana.example.local]
+`
+
+const testGateway = `apiVersion: gateway.networking.k8s.io/v1
+kind: Gateway
+metadata:
+  name: grafana
+spec:
+  gatewayClassName: cloud-lb
+`
+
+func TestTemplateIngresses(t *testing.T) {
+	utils.RegisterIngress(&utils.Ingress{ClusterDomain: "staging.example.com", IngressClass: "nginx", GatewayClass: "cilium"})
+	defer utils.RegisterIngress(nil)
+
+	workingDir := t.TempDir()
+	toolDir := filepath.Join(workingDir, "grafana")
+	if err := os.MkdirAll(toolDir, 0755); err != nil {
+		t.Fatalf("Failed to create tool directory: %v", err)
+	}
+	files := map[string]string{
+		"Ingress_grafana.yaml":   testIngress,
+		"HTTPRoute_grafana.yaml": testHTTPRoute,
+		"Gateway_grafana.yaml":   testGateway,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(toolDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	if err := templateIngresses(utils.Config{Name: "grafana", Namespace: "monitoring"}, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var ingress struct {
+		Metadata struct {
+			Annotations map[string]string `yaml:"annotations"`
+		} `yaml:"metadata"`
+		Spec struct {
+			IngressClassName string `yaml:"ingressClassName"`
+			Rules            []struct {
+				Host string `yaml:"host"`
+			} `yaml:"rules"`
+			TLS []struct {
+				Hosts []string `yaml:"hosts"`
+			} `yaml:"tls"`
+		} `yaml:"spec"`
+	}
+	readObject(t, filepath.Join(toolDir, "Ingress_grafana.yaml"), &ingress)
+	if ingress.Spec.Rules[0].Host != "grafana.staging.example.com" || ingress.Spec.Rules[1].Host != "" {
+		t.Errorf("unexpected rule hosts %+v", ingress.Spec.Rules)
+	}
+	if ingress.Spec.TLS[0].Hosts[0] != "grafana.staging.example.com" {
+		t.Errorf("unexpected TLS hosts %v", ingress.Spec.TLS[0].Hosts)
+	}
+	if ingress.Spec.IngressClassName != "nginx" {
+		t.Errorf("expected ingress class nginx, got %s", ingress.Spec.IngressClassName)
+	}
+	if _, exists := ingress.Metadata.Annotations[annotationIngressClass]; exists {
+		t.Errorf("expected the ingress class annotation to be removed")
+	}
+
+	var route struct {
+		Spec struct {
+			Hostnames []string `yaml:"hostnames"`
+		} `yaml:"spec"`
+	}
+	readObject(t, filepath.Join(toolDir, "HTTPRoute_grafana.yaml"), &route)
+	if len(route.Spec.Hostnames) != 1 || route.Spec.Hostnames[0] != "grafana.staging.example.com" {
+		t.Errorf("unexpected route hostnames %v", route.Spec.Hostnames)
+	}
+
+	var gateway struct {
+		Spec struct {
+			GatewayClassName string `yaml:"gatewayClassName"`
+		} `yaml:"spec"`
+	}
+	readObject(t, filepath.Join(toolDir, "Gateway_grafana.yaml"), &gateway)
+	if gateway.Spec.GatewayClassName != "cilium" {
+		t.Errorf("expected gateway class cilium, got %s", gateway.Spec.GatewayClassName)
+	}
+}
+
+const testIngressTwoHosts = `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: grafana
+spec:
+  rules:
+  - host: grafana.example.local
+  - host: api.example.local
+`
+
+func TestTemplateIngressesHostCollision(t *testing.T) {
+	defer utils.RegisterIngress(nil)
+	tests := []struct {
+		hostTemplate string
+		valid        bool
+	}{
+		// Both hosts would become grafana.staging.example.com
+		{"", false},
+		{"{subdomain}.{cluster_domain}", true},
+	}
+	for _, test := range tests {
+		utils.RegisterIngress(&utils.Ingress{ClusterDomain: "staging.example.com", HostTemplate: test.hostTemplate})
+		workingDir := t.TempDir()
+		toolDir := filepath.Join(workingDir, "grafana")
+		if err := os.MkdirAll(toolDir, 0755); err != nil {
+			t.Fatalf("Failed to create tool directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(toolDir, "Ingress_grafana.yaml"), []byte(testIngressTwoHosts), 0644); err != nil {
+			t.Fatalf("Failed to write ingress: %v", err)
+		}
+		err := templateIngresses(utils.Config{Name: "grafana", Namespace: "monitoring"}, workingDir)
+		if test.valid && err != nil {
+			t.Errorf("host template %q: unexpected error: %v", test.hostTemplate, err)
+		}
+		if !test.valid && utils.ClassOf(err) != utils.ConfigError {
+			t.Errorf("host template %q: expected a config error for the clashing hosts, got %v", test.hostTemplate, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// policyData is passed to the policy files, so constraints can target the
+// tool they ship with, e.g. match: {namespaces: ["{{ .Namespace }}"]}.
+type policyData struct {
+	Tool      string
+	Namespace string
+}
+
+// addPolicies renders the policies referenced by the tool from the policies
+// input directory into the tool's working directory, next to its other
+// objects. Each policy is a directory of YAML files holding Gatekeeper
+// ConstraintTemplates and constraints. The ConstraintTemplates of a policy
+// shared by several tools are shipped by the first of them only.
+func addPolicies(config utils.Config, workingDir string) error {
+	for _, policy := range config.Policies {
+		policyDir := utils.InputPath(filepath.Join(utils.PoliciesDir, policy))
+		files, err := filepath.Glob(filepath.Join(policyDir, "*.yaml"))
+		if err != nil {
+			return fmt.Errorf("failed to list policy %s: %w", policy, err)
+		}
+		if len(files) == 0 {
+			return utils.Errorf(utils.ConfigError, "policy %s of %s not found, expected its YAML files in %s", policy, config.Name, policyDir)
+		}
+		for _, file := range files {
+			if err := addPolicyFile(config, policy, file, workingDir); err != nil {
+				return err
+			}
+		}
+		log.Debugf("Added policy %s to %s", policy, config.Name)
+	}
+	return nil
+}
+
+func addPolicyFile(config utils.Config, policy string, file string, workingDir string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return utils.Errorf(utils.ConfigError, "failed to read policy file %s: %w", file, err)
+	}
+	tmpl, err := template.New(filepath.Base(file)).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return utils.Errorf(utils.ConfigError, "failed to parse policy file %s: %w", file, err)
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, policyData{Tool: config.Name, Namespace: config.Namespace}); err != nil {
+		return utils.Errorf(utils.RenderError, "failed to render policy file %s: %w", file, err)
+	}
+
+	documents, err := splitYAML(rendered.Bytes())
+	if err != nil {
+		return utils.Errorf(utils.RenderError, "failed to split policy file %s: %w", file, err)
+	}
+	if err := os.MkdirAll(filepath.Join(workingDir, config.Name), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", config.Name, err)
+	}
+	annotations := utils.RunAnnotations(config.Name, utils.SourceDigest(content))
+	for _, document := range documents {
+		metadataObject, updatedDocument, err := transformDocument(document, config, annotations)
+		if err != nil {
+			return err
+		}
+		if !utils.IsPolicy(metadataObject.APIVersion) {
+			return utils.Errorf(utils.ValidationError, "%s %s in policy file %s is not a Gatekeeper ConstraintTemplate or constraint", metadataObject.Kind, metadataObject.Metadata.Name, file)
+		}
+		if metadataObject.Kind == "ConstraintTemplate" && !utils.ClaimPolicyTemplates(policy, config.Name) {
+			continue
+		}
+		filename := filepath.Join(workingDir, config.Name, fmt.Sprintf("%s_%s.yaml", metadataObject.Kind, metadataObject.Metadata.Name))
+		if err := os.WriteFile(filename, updatedDocument, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filename, err)
+		}
+	}
+	return nil
+}
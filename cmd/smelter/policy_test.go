@@ -0,0 +1,107 @@
+package smelter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+const testConstraintTemplate = `apiVersion: templates.gatekeeper.sh/v1
+kind: ConstraintTemplate
+metadata:
+  name: k8srequiredlabels
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sRequiredLabels
+`
+
+const testConstraint = `apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: {{ .Tool }}-owner
+spec:
+  match:
+    namespaces: ["{{ .Namespace }}"]
+  parameters:
+    labels: ["owner"]
+`
+
+func TestAddPolicies(t *testing.T) {
+	inputDir := t.TempDir()
+	utils.SetInputDirs([]string{inputDir})
+	defer utils.SetInputDirs([]string{"input"})
+	utils.RegisterPolicies(nil)
+
+	policyDir := filepath.Join(inputDir, utils.PoliciesDir, "required-labels")
+	if err := os.MkdirAll(policyDir, 0755); err != nil {
+		t.Fatalf("Failed to create policy directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(policyDir, "policy.yaml"), []byte(testConstraintTemplate+"---\n"+testConstraint), 0644); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+
+	workingDir := t.TempDir()
+	config := utils.Config{Name: "grafana", Namespace: "monitoring", Policies: []string{"required-labels"}}
+	if err := addPolicies(config, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(workingDir, "grafana", "ConstraintTemplate_k8srequiredlabels.yaml")); err != nil {
+		t.Errorf("expected the ConstraintTemplate to be written: %v", err)
+	}
+	constraint, err := os.ReadFile(filepath.Join(workingDir, "grafana", "K8sRequiredLabels_grafana-owner.yaml"))
+	if err != nil {
+		t.Fatalf("expected the constraint to be written: %v", err)
+	}
+	if !strings.Contains(string(constraint), "- monitoring") {
+		t.Errorf("expected the constraint to match the tool's namespace, got:\n%s", constraint)
+	}
+	if strings.Contains(string(constraint), "namespace: monitoring") {
+		t.Errorf("expected the cluster-scoped constraint to get no namespace, got:\n%s", constraint)
+	}
+
+	config.Policies = []string{"missing"}
+	if err := addPolicies(config, workingDir); utils.ClassOf(err) != utils.ConfigError {
+		t.Errorf("expected a config error for a missing policy, got: %v", err)
+	}
+}
+
+func TestAddPoliciesShared(t *testing.T) {
+	inputDir := t.TempDir()
+	utils.SetInputDirs([]string{inputDir})
+	defer utils.SetInputDirs([]string{"input"})
+
+	policyDir := filepath.Join(inputDir, utils.PoliciesDir, "required-labels")
+	if err := os.MkdirAll(policyDir, 0755); err != nil {
+		t.Fatalf("Failed to create policy directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(policyDir, "policy.yaml"), []byte(testConstraintTemplate+"---\n"+testConstraint), 0644); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+	grafana := utils.Config{Name: "grafana", Namespace: "monitoring", Policies: []string{"required-labels"}}
+	loki := utils.Config{Name: "loki", Namespace: "monitoring", Policies: []string{"required-labels"}}
+	utils.RegisterPolicies([]utils.Config{grafana, loki})
+	defer utils.RegisterPolicies(nil)
+
+	// loki is smelted first, but grafana comes first in the config
+	workingDir := t.TempDir()
+	for _, config := range []utils.Config{loki, grafana} {
+		if err := addPolicies(config, workingDir); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(workingDir, "grafana", "ConstraintTemplate_k8srequiredlabels.yaml")); err != nil {
+		t.Errorf("expected grafana to ship the ConstraintTemplate: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workingDir, "loki", "ConstraintTemplate_k8srequiredlabels.yaml")); err == nil {
+		t.Errorf("expected loki not to ship the ConstraintTemplate again")
+	}
+	if _, err := os.Stat(filepath.Join(workingDir, "loki", "K8sRequiredLabels_loki-owner.yaml")); err != nil {
+		t.Errorf("expected loki to ship its own constraint: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// addPullSecrets lets the tool's pods pull from private registries: it
+// references the pull secret from the pod specs or the ServiceAccounts and,
+// if the credentials are in a secret store, writes the ExternalSecret syncing
+// the pull secret into the namespaces the tool owns. The default
+// ServiceAccounts belong to the namespaces, so rather than patching them, the
+// pod specs running as them reference the pull secret directly.
+func addPullSecrets(config utils.Config, workingDir string) error {
+	secrets := utils.RegisteredPullSecrets()
+	if secrets == nil {
+		return nil
+	}
+	toolDir := filepath.Join(workingDir, config.Name)
+	namespaces := map[string]bool{config.Namespace: true}
+	err := updateObjects(toolDir, func(file string, object map[string]interface{}) (bool, error) {
+		namespace, _ := utils.ObjectMetadata(object)["namespace"].(string)
+		if namespace == "" {
+			namespace = config.Namespace
+		}
+		if object["kind"] == "ServiceAccount" {
+			if secrets.Inject == utils.InjectServiceAccounts {
+				return addPullSecretReference(object, secrets.Name), nil
+			}
+			return false, nil
+		}
+		podSpecs := findPodSpecs(object)
+		if len(podSpecs) == 0 {
+			return false, nil
+		}
+		namespaces[namespace] = true
+		changed := false
+		for _, podSpec := range podSpecs {
+			if secrets.Inject == utils.InjectPodSpecs || runsAsDefault(podSpec) {
+				changed = addPullSecretReference(podSpec, secrets.Name) || changed
+			}
+		}
+		return changed, nil
+	})
+	if err != nil || secrets.ExternalSecret == nil {
+		return err
+	}
+
+	var namespaceNames []string
+	for namespace := range namespaces {
+		if utils.ClaimPullSecretNamespace(namespace, config.Name) {
+			namespaceNames = append(namespaceNames, namespace)
+		}
+	}
+	sort.Strings(namespaceNames)
+	source := secrets.ExternalSecret
+	remoteRef := map[string]interface{}{"key": source.Key}
+	if source.Property != "" {
+		remoteRef["property"] = source.Property
+	}
+	var objects []map[string]interface{}
+	for _, namespace := range namespaceNames {
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "external-secrets.io/v1beta1",
+			"kind":       "ExternalSecret",
+			"metadata":   map[string]interface{}{"name": secrets.Name, "namespace": namespace},
+			"spec": map[string]interface{}{
+				"secretStoreRef": map[string]interface{}{"name": source.Store, "kind": source.StoreKind},
+				"target": map[string]interface{}{
+					"name":     secrets.Name,
+					"template": map[string]interface{}{"type": "kubernetes.io/dockerconfigjson"},
+				},
+				"data": []interface{}{map[string]interface{}{"secretKey": ".dockerconfigjson", "remoteRef": remoteRef}},
+			},
+		})
+	}
+	return writePullSecretObjects(config, objects, toolDir)
+}
+
+// learnPullSecretNamespaces learns the namespaces the pods of the configured
+// tools run in, from the rendered manifests of the rendered tools and the
+// earlier output of the others, so the ExternalSecret of a namespace which is
+// no tool's namespace goes to the same tool whichever tools are smelted.
+func learnPullSecretNamespaces(configs []utils.Config, rendered []ToolResult, toolBaseDir string, preDir string) {
+	secrets := utils.RegisteredPullSecrets()
+	if secrets == nil || secrets.ExternalSecret == nil {
+		return
+	}
+	renderedTools := map[string]bool{}
+	for _, result := range rendered {
+		renderedTools[result.Tool] = result.Err == nil
+	}
+	for _, config := range configs {
+		var documents [][]byte
+		if succeeded, exists := renderedTools[config.Name]; exists {
+			if !succeeded {
+				continue
+			}
+			data, err := os.ReadFile(renderedFile(config.Name, preDir))
+			if err != nil {
+				log.Warnf("Not using the namespaces of %s for its pull secrets: %v", config.Name, err)
+				continue
+			}
+			documents = splitDocuments(data)
+		} else {
+			files, _ := filepath.Glob(filepath.Join(toolBaseDir, config.Name, "*.yaml"))
+			for _, file := range files {
+				if data, err := os.ReadFile(file); err == nil {
+					documents = append(documents, data)
+				}
+			}
+		}
+		for _, document := range documents {
+			if namespace := podNamespace(document, config.Namespace); namespace != "" {
+				utils.LearnPullSecretNamespace(namespace, config.Name)
+			}
+		}
+	}
+}
+
+// podNamespace returns the namespace of a document with pod specs, or "" for
+// other documents. Only documents mentioning containers are parsed, and
+// large ones, in practice CRDs, never are.
+func podNamespace(document []byte, defaultNamespace string) string {
+	if len(document) > streamingThreshold || !bytes.Contains(document, []byte("containers")) {
+		return ""
+	}
+	var object map[string]interface{}
+	if err := yaml.Unmarshal(document, &object); err != nil || len(findPodSpecs(object)) == 0 {
+		return ""
+	}
+	if namespace, _ := utils.ObjectMetadata(object)["namespace"].(string); namespace != "" {
+		return namespace
+	}
+	return defaultNamespace
+}
+
+// runsAsDefault reports whether a pod spec runs as the default
+// ServiceAccount of its namespace.
+func runsAsDefault(podSpec map[interface{}]interface{}) bool {
+	name, _ := podSpec["serviceAccountName"].(string)
+	if name == "" {
+		name, _ = podSpec["serviceAccount"].(string)
+	}
+	return name == "" || name == "default"
+}
+
+// findPodSpecs returns the pod specs anywhere below value, i.e. the maps
+// with a list of containers, so custom resources embedding pods are covered.
+func findPodSpecs(value interface{}) []map[interface{}]interface{} {
+	var podSpecs []map[interface{}]interface{}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, item := range value {
+			podSpecs = append(podSpecs, findPodSpecs(item)...)
+		}
+	case map[interface{}]interface{}:
+		if _, ok := value["containers"].([]interface{}); ok {
+			return append(podSpecs, value)
+		}
+		for _, item := range value {
+			podSpecs = append(podSpecs, findPodSpecs(item)...)
+		}
+	case []interface{}:
+		for _, item := range value {
+			podSpecs = append(podSpecs, findPodSpecs(item)...)
+		}
+	}
+	return podSpecs
+}
+
+// addPullSecretReference adds the secret to the imagePullSecrets of a pod
+// spec or ServiceAccount, unless it is already there.
+func addPullSecretReference(object interface{}, name string) bool {
+	var references []interface{}
+	switch object := object.(type) {
+	case map[string]interface{}:
+		references, _ = object["imagePullSecrets"].([]interface{})
+	case map[interface{}]interface{}:
+		references, _ = object["imagePullSecrets"].([]interface{})
+	}
+	for _, reference := range references {
+		if referenceMap, ok := reference.(map[interface{}]interface{}); ok && referenceMap["name"] == name {
+			return false
+		}
+	}
+	references = append(references, map[interface{}]interface{}{"name": name})
+	switch object := object.(type) {
+	case map[string]interface{}:
+		object["imagePullSecrets"] = references
+	case map[interface{}]interface{}:
+		object["imagePullSecrets"] = references
+	}
+	return true
+}
+
+// writePullSecretObjects writes the generated objects into the tool's
+// directory. Objects outside the tool's namespace get the namespace in their
+// file name, as the objects share their names.
+func writePullSecretObjects(config utils.Config, objects []map[string]interface{}, toolDir string) error {
+	for _, object := range objects {
+		document, err := yaml.Marshal(object)
+		if err != nil {
+			return utils.Errorf(utils.RenderError, "failed to write the pull secrets of %s: %w", config.Name, err)
+		}
+		metadataObject, updated, err := transformDocument(document, config, utils.RunAnnotations(config.Name, utils.SourceDigest(document)))
+		if err != nil {
+			return err
+		}
+		name := metadataObject.Metadata.Name
+		if namespace := metadataObject.Metadata.Namespace; namespace != config.Namespace {
+			name += "-" + namespace
+		}
+		filename := filepath.Join(toolDir, fmt.Sprintf("%s_%s.yaml", metadataObject.Kind, name))
+		if err := os.WriteFile(filename, updated, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filename, err)
+		}
+		log.Debugf("Wrote %s", filename)
+	}
+	return nil
+}
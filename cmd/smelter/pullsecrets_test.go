@@ -0,0 +1,234 @@
+package smelter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+const testPullSecretsDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: widgets
+  namespace: widgets
+spec:
+  template:
+    spec:
+      serviceAccountName: widgets
+      containers:
+      - name: widgets
+        image: registry.example.com/widgets:1.0
+`
+
+const testPullSecretsServiceAccount = `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: widgets
+  namespace: widgets
+`
+
+const testPullSecretsJob = `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: widgets
+spec:
+  template:
+    spec:
+      containers:
+      - name: migrate
+        image: registry.example.com/migrate:1.0
+`
+
+type imagePullSecrets struct {
+	ImagePullSecrets []struct{ Name string } `yaml:"imagePullSecrets"`
+}
+
+func writePullSecretsTool(t *testing.T) (string, string) {
+	t.Helper()
+	workingDir := t.TempDir()
+	toolDir := filepath.Join(workingDir, "widgets")
+	if err := os.MkdirAll(toolDir, 0755); err != nil {
+		t.Fatalf("Failed to create tool directory: %v", err)
+	}
+	files := map[string]string{
+		"Deployment_widgets.yaml":     testPullSecretsDeployment,
+		"ServiceAccount_widgets.yaml": testPullSecretsServiceAccount,
+		"Job_migrate.yaml":            testPullSecretsJob,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(toolDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return workingDir, toolDir
+}
+
+type jobPodSpec struct {
+	Spec struct {
+		Template struct {
+			Spec imagePullSecrets `yaml:"spec"`
+		} `yaml:"template"`
+	} `yaml:"spec"`
+}
+
+func TestAddPullSecretsToPodSpecs(t *testing.T) {
+	utils.RegisterPullSecrets(&utils.PullSecrets{ExternalSecret: &utils.PullSecretSource{Store: "vault", Key: "registry/dockerconfig"}},
+		[]utils.Config{{Name: "widgets", Namespace: "widgets"}})
+	defer utils.RegisterPullSecrets(nil, nil)
+	workingDir, toolDir := writePullSecretsTool(t)
+
+	config := utils.Config{Name: "widgets", Namespace: "widgets"}
+	if err := addPullSecrets(config, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Adding them again must not add a second reference
+	if err := addPullSecrets(config, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, file := range []string{"Deployment_widgets.yaml", "Job_migrate.yaml"} {
+		var workload jobPodSpec
+		readObject(t, filepath.Join(toolDir, file), &workload)
+		references := workload.Spec.Template.Spec.ImagePullSecrets
+		if len(references) != 1 || references[0].Name != utils.DefaultPullSecretName {
+			t.Errorf("expected the pod spec of %s to reference %s once, got %v", file, utils.DefaultPullSecretName, references)
+		}
+	}
+
+	var serviceAccount imagePullSecrets
+	readObject(t, filepath.Join(toolDir, "ServiceAccount_widgets.yaml"), &serviceAccount)
+	if len(serviceAccount.ImagePullSecrets) != 0 {
+		t.Errorf("expected the ServiceAccount to be unchanged, got %v", serviceAccount.ImagePullSecrets)
+	}
+
+	var externalSecret struct {
+		Metadata struct {
+			Namespace string `yaml:"namespace"`
+		} `yaml:"metadata"`
+		Spec struct {
+			SecretStoreRef struct{ Name, Kind string } `yaml:"secretStoreRef"`
+			Target         struct {
+				Name     string `yaml:"name"`
+				Template struct {
+					Type string `yaml:"type"`
+				} `yaml:"template"`
+			} `yaml:"target"`
+			Data []struct {
+				SecretKey string `yaml:"secretKey"`
+				RemoteRef struct {
+					Key string `yaml:"key"`
+				} `yaml:"remoteRef"`
+			} `yaml:"data"`
+		} `yaml:"spec"`
+	}
+	readObject(t, filepath.Join(toolDir, "ExternalSecret_"+utils.DefaultPullSecretName+".yaml"), &externalSecret)
+	spec := externalSecret.Spec
+	if externalSecret.Metadata.Namespace != "widgets" || spec.SecretStoreRef.Name != "vault" || spec.SecretStoreRef.Kind != utils.DefaultSecretStoreKind ||
+		spec.Target.Name != utils.DefaultPullSecretName || spec.Target.Template.Type != "kubernetes.io/dockerconfigjson" ||
+		len(spec.Data) != 1 || spec.Data[0].SecretKey != ".dockerconfigjson" || spec.Data[0].RemoteRef.Key != "registry/dockerconfig" {
+		t.Errorf("unexpected ExternalSecret: %+v", externalSecret)
+	}
+	if _, err := os.Stat(filepath.Join(toolDir, "Secret_"+utils.DefaultPullSecretName+".yaml")); !os.IsNotExist(err) {
+		t.Errorf("expected no Secret with the credentials to be written, got %v", err)
+	}
+}
+
+func TestAddPullSecretsToServiceAccounts(t *testing.T) {
+	utils.RegisterPullSecrets(&utils.PullSecrets{Name: "mirror", Inject: utils.InjectServiceAccounts}, []utils.Config{{Name: "widgets", Namespace: "widgets"}})
+	defer utils.RegisterPullSecrets(nil, nil)
+	workingDir, toolDir := writePullSecretsTool(t)
+
+	config := utils.Config{Name: "widgets", Namespace: "widgets"}
+	if err := addPullSecrets(config, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(toolDir, "Deployment_widgets.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content) != testPullSecretsDeployment {
+		t.Errorf("expected the Deployment to be unchanged, got:\n%s", content)
+	}
+	var serviceAccount imagePullSecrets
+	readObject(t, filepath.Join(toolDir, "ServiceAccount_widgets.yaml"), &serviceAccount)
+	if len(serviceAccount.ImagePullSecrets) != 1 || serviceAccount.ImagePullSecrets[0].Name != "mirror" {
+		t.Errorf("expected the ServiceAccount to reference the pull secret, got %v", serviceAccount.ImagePullSecrets)
+	}
+	// The Job runs as the default ServiceAccount, which is left alone
+	var job jobPodSpec
+	readObject(t, filepath.Join(toolDir, "Job_migrate.yaml"), &job)
+	if references := job.Spec.Template.Spec.ImagePullSecrets; len(references) != 1 || references[0].Name != "mirror" {
+		t.Errorf("expected the pod spec running as default to reference the pull secret, got %v", references)
+	}
+	for _, file := range []string{"ServiceAccount_default.yaml", "ExternalSecret_mirror.yaml", "Secret_mirror.yaml"} {
+		if _, err := os.Stat(filepath.Join(toolDir, file)); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be written for a pre-provisioned Secret, got %v", file, err)
+		}
+	}
+}
+
+func TestAddPullSecretsSharedNamespace(t *testing.T) {
+	tools := []utils.Config{{Name: "operator", Namespace: "widgets"}, {Name: "widgets", Namespace: "widgets"}}
+	utils.RegisterPullSecrets(&utils.PullSecrets{ExternalSecret: &utils.PullSecretSource{Store: "vault", Key: "registry"}}, tools)
+	defer utils.RegisterPullSecrets(nil, nil)
+	workingDir, toolDir := writePullSecretsTool(t)
+	if err := os.MkdirAll(filepath.Join(workingDir, "operator"), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, config := range tools {
+		if err := addPullSecrets(config, workingDir); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	file := "ExternalSecret_" + utils.DefaultPullSecretName + ".yaml"
+	if _, err := os.Stat(filepath.Join(workingDir, "operator", file)); err != nil {
+		t.Errorf("expected the first tool of the namespace to write the ExternalSecret: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(toolDir, file)); !os.IsNotExist(err) {
+		t.Errorf("expected the ExternalSecret to be written once per namespace, got %v", err)
+	}
+}
+
+func TestPrepareToolPullSecretOwner(t *testing.T) {
+	inputDir := t.TempDir()
+	utils.SetInputDirs([]string{inputDir})
+	defer utils.SetInputDirs([]string{"input"})
+	defer utils.RegisterPullSecrets(nil, nil)
+	// Both tools run an agent in the monitoring namespace, which is neither's
+	for _, tool := range []string{"zeta", "alpha"} {
+		agent := "apiVersion: apps/v1\nkind: DaemonSet\nmetadata:\n  name: " + tool + "-agent\n  namespace: monitoring\nspec:\n  template:\n    spec:\n      containers:\n      - name: agent\n        image: registry.example.com/agent:1.0\n"
+		if err := os.WriteFile(filepath.Join(inputDir, tool+".yaml"), []byte(agent), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	configs := []utils.Config{
+		{Name: "zeta", Namespace: "zeta", SourceFile: "zeta.yaml"},
+		{Name: "alpha", Namespace: "alpha", SourceFile: "alpha.yaml"},
+	}
+	secrets := utils.PullSecrets{ExternalSecret: &utils.PullSecretSource{Store: "vault", Key: "registry"}}
+	file := "ExternalSecret_" + utils.DefaultPullSecretName + "-monitoring.yaml"
+	workingDir := t.TempDir()
+	smelt := func(tools ...string) {
+		t.Helper()
+		registered := secrets
+		utils.RegisterPullSecrets(&registered, configs)
+		if err := PrepareTool(configs, tools, workingDir, t.TempDir()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// The first tool by name owns the namespace, whichever tools are smelted
+	smelt("zeta", "alpha")
+	smelt("zeta")
+	if _, err := os.Stat(filepath.Join(workingDir, "alpha", file)); err != nil {
+		t.Errorf("expected alpha to write the ExternalSecret of monitoring: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workingDir, "zeta", file)); !os.IsNotExist(err) {
+		t.Errorf("expected zeta not to write the ExternalSecret of monitoring, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// namespaceNameLabel is set by the API server on every namespace to its name.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// systemNamespace must stay out of fail-closed webhooks: if the webhook's
+// pods can't start, nothing in it, including the control plane's own
+// workloads, could be admitted.
+const systemNamespace = "kube-system"
+
+// webhookKinds are the kinds of admission webhook configurations.
+var webhookKinds = []string{"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"}
+
+// reviewWebhooks applies the tool's webhook overrides to its admission
+// webhooks, and warns about the webhooks which can still block the cluster:
+// those failing closed for every namespace including kube-system. The
+// warnings end up in the tool's result. Only the webhook configuration files
+// are read, so tools without webhooks don't pay for parsing their objects.
+func reviewWebhooks(config utils.Config, workingDir string) error {
+	toolDir := filepath.Join(workingDir, config.Name)
+	var files []string
+	for _, kind := range webhookKinds {
+		matches, err := filepath.Glob(filepath.Join(toolDir, kind+"_*.yaml"))
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", toolDir, err)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return updateFiles(files, func(file string, object map[string]interface{}) (bool, error) {
+		kind := object["kind"]
+		if kind != "ValidatingWebhookConfiguration" && kind != "MutatingWebhookConfiguration" {
+			return false, nil
+		}
+		name, _ := utils.ObjectMetadata(object)["name"].(string)
+		webhooks, _ := object["webhooks"].([]interface{})
+		for _, item := range webhooks {
+			webhook, ok := item.(map[interface{}]interface{})
+			if !ok {
+				continue
+			}
+			if overrides := config.WebhookOverrides; overrides != nil {
+				if overrides.FailurePolicy != "" {
+					webhook["failurePolicy"] = overrides.FailurePolicy
+				}
+				if len(overrides.ExcludeNamespaces) > 0 {
+					excludeNamespaces(webhook, overrides.ExcludeNamespaces)
+				}
+			}
+			if blocksSystemNamespace(webhook) {
+				log.Warnf("%s %s webhook %v in %s fails closed for all namespaces including %s, set webhook-overrides to exclude it or to ignore failures", kind, name, webhook["name"], config.Name, systemNamespace)
+			}
+		}
+		return config.WebhookOverrides != nil, nil
+	})
+}
+
+// blocksSystemNamespace reports whether the webhook fails closed for every
+// namespace including kube-system.
+func blocksSystemNamespace(webhook map[interface{}]interface{}) bool {
+	// The failure policy of admissionregistration.k8s.io/v1 defaults to Fail
+	failsClosed := webhook["failurePolicy"] == nil || webhook["failurePolicy"] == "Fail"
+	return failsClosed && !excludesNamespace(webhook, systemNamespace)
+}
+
+// excludeNamespaces adds the namespaces to the NotIn values of the
+// webhook's namespaceSelector on the namespace name label.
+func excludeNamespaces(webhook map[interface{}]interface{}, namespaces []string) {
+	selector, ok := webhook["namespaceSelector"].(map[interface{}]interface{})
+	if !ok {
+		selector = map[interface{}]interface{}{}
+		webhook["namespaceSelector"] = selector
+	}
+	expressions, _ := selector["matchExpressions"].([]interface{})
+	for _, item := range expressions {
+		expression, _ := item.(map[interface{}]interface{})
+		if expression["key"] == namespaceNameLabel && expression["operator"] == "NotIn" {
+			values, _ := expression["values"].([]interface{})
+			for _, namespace := range namespaces {
+				if !containsValue(values, namespace) {
+					values = append(values, namespace)
+				}
+			}
+			expression["values"] = values
+			return
+		}
+	}
+	values := make([]interface{}, len(namespaces))
+	for i, namespace := range namespaces {
+		values[i] = namespace
+	}
+	selector["matchExpressions"] = append(expressions, map[interface{}]interface{}{
+		"key":      namespaceNameLabel,
+		"operator": "NotIn",
+		"values":   values,
+	})
+}
+
+// excludesNamespace reports whether the webhook's namespaceSelector leaves
+// out the namespace, either by its name label or by a label which only
+// selects namespaces having it.
+func excludesNamespace(webhook map[interface{}]interface{}, namespace string) bool {
+	selector, ok := webhook["namespaceSelector"].(map[interface{}]interface{})
+	if !ok {
+		return false
+	}
+	// kube-system only has its name label, so requiring any other label
+	// leaves it out
+	labels, _ := selector["matchLabels"].(map[interface{}]interface{})
+	for key, value := range labels {
+		if key != namespaceNameLabel || value != namespace {
+			return true
+		}
+	}
+	expressions, _ := selector["matchExpressions"].([]interface{})
+	for _, item := range expressions {
+		expression, _ := item.(map[interface{}]interface{})
+		values, _ := expression["values"].([]interface{})
+		switch expression["operator"] {
+		case "NotIn":
+			if expression["key"] == namespaceNameLabel && containsValue(values, namespace) {
+				return true
+			}
+		case "In":
+			if expression["key"] != namespaceNameLabel || !containsValue(values, namespace) {
+				return true
+			}
+		case "Exists":
+			if expression["key"] != namespaceNameLabel {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsValue(values []interface{}, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
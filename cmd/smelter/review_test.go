@@ -0,0 +1,111 @@
+package smelter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const testRiskyWebhooks = `apiVersion: admissionregistration.k8s.io/v1
+kind: MutatingWebhookConfiguration
+metadata:
+  name: widgets
+webhooks:
+- name: default-fail.widgets.example.com
+- name: excluded.widgets.example.com
+  failurePolicy: Fail
+  namespaceSelector:
+    matchExpressions:
+    - key: kubernetes.io/metadata.name
+      operator: NotIn
+      values: [kube-system]
+- name: labelled.widgets.example.com
+  namespaceSelector:
+    matchLabels:
+      widgets.example.com/inject: "true"
+- name: ignore.widgets.example.com
+  failurePolicy: Ignore
+`
+
+func TestReviewWebhooks(t *testing.T) {
+	workingDir := t.TempDir()
+	toolDir := filepath.Join(workingDir, "widgets")
+	if err := os.MkdirAll(toolDir, 0755); err != nil {
+		t.Fatalf("Failed to create tool directory: %v", err)
+	}
+	file := filepath.Join(toolDir, "MutatingWebhookConfiguration_widgets.yaml")
+	if err := os.WriteFile(file, []byte(testRiskyWebhooks), 0644); err != nil {
+		t.Fatalf("Failed to write webhooks: %v", err)
+	}
+
+	config := utils.Config{Name: "widgets", Namespace: "widgets"}
+	risky, err := reviewWarnings(config, workingDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(risky) != 1 || !strings.HasPrefix(risky[0], "MutatingWebhookConfiguration widgets webhook default-fail.widgets.example.com ") {
+		t.Errorf("expected only the webhook failing closed everywhere to be risky, got %v", risky)
+	}
+
+	config.WebhookOverrides = &utils.WebhookOverrides{ExcludeNamespaces: []string{"kube-system", "cluster-forge"}}
+	risky, err = reviewWarnings(config, workingDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(risky) != 0 {
+		t.Errorf("expected the overrides to make all webhooks safe, got %v", risky)
+	}
+
+	var object struct {
+		Webhooks []struct {
+			Name              string `yaml:"name"`
+			NamespaceSelector struct {
+				MatchExpressions []struct {
+					Key    string   `yaml:"key"`
+					Values []string `yaml:"values"`
+				} `yaml:"matchExpressions"`
+			} `yaml:"namespaceSelector"`
+		} `yaml:"webhooks"`
+	}
+	content, _ := os.ReadFile(file)
+	if err := yaml.Unmarshal(content, &object); err != nil {
+		t.Fatalf("Failed to parse webhooks: %v", err)
+	}
+	for _, webhook := range object.Webhooks {
+		expressions := webhook.NamespaceSelector.MatchExpressions
+		if len(expressions) != 1 || len(expressions[0].Values) != 2 {
+			t.Errorf("expected %s to exclude both namespaces in one expression, got %+v", webhook.Name, expressions)
+		}
+	}
+}
+
+func TestReviewWebhooksWithoutWebhooks(t *testing.T) {
+	workingDir := t.TempDir()
+	toolDir := filepath.Join(workingDir, "widgets")
+	if err := os.MkdirAll(toolDir, 0755); err != nil {
+		t.Fatalf("Failed to create tool directory: %v", err)
+	}
+	// Not parsed, as no webhook configuration is in the tool
+	if err := os.WriteFile(filepath.Join(toolDir, "ConfigMap_widgets.yaml"), []byte("not: [yaml"), 0644); err != nil {
+		t.Fatalf("Failed to write object: %v", err)
+	}
+	config := utils.Config{Name: "widgets", Namespace: "widgets"}
+	if err := reviewWebhooks(config, workingDir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// reviewWarnings reviews the tool's webhooks and returns the warnings.
+func reviewWarnings(config utils.Config, workingDir string) ([]string, error) {
+	collector := &warningCollector{}
+	logger := log.StandardLogger()
+	hooks := logger.ReplaceHooks(collector.with(logger.Hooks))
+	defer logger.ReplaceHooks(hooks)
+	err := reviewWebhooks(config, workingDir)
+	return collector.warnings, err
+}
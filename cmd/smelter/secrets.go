@@ -0,0 +1,136 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxPlaintextLength is the length below which a valid base64 value which
+// decodes to binary is suspected to be plaintext, like "admin" or "password1".
+const maxPlaintextLength = 24
+
+// normalizeSecret checks the payload of a Secret and converts it to the
+// tool's secret format: with "data" everything is base64 encoded, with
+// "stringData" text values are stored readable and only binary values stay
+// in data. Invalid base64 fails the tool.
+func normalizeSecret(object map[string]interface{}, config utils.Config) error {
+	name, _ := utils.ObjectMetadata(object)["name"].(string)
+	data := stringMap(object["data"])
+	stringData := stringMap(object["stringData"])
+
+	decoded := make(map[string][]byte, len(data))
+	for _, key := range sortedKeys(data) {
+		value, err := base64.StdEncoding.DecodeString(data[key])
+		if err != nil {
+			return utils.Errorf(utils.ValidationError, "Secret %s in %s: value of %s is not valid base64, use stringData for plain text", name, config.Name, key)
+		}
+		if looksLikePlaintext(data[key], value) {
+			log.Warnf("Secret %s in %s: value of %s looks like plain text which was not base64 encoded", name, config.Name, key)
+		}
+		decoded[key] = value
+	}
+
+	switch config.SecretFormat {
+	case utils.SecretFormatData:
+		// stringData takes precedence over data when applied
+		for key, value := range stringData {
+			data[key] = base64.StdEncoding.EncodeToString([]byte(value))
+		}
+		stringData = nil
+	case utils.SecretFormatStringData:
+		for key, value := range decoded {
+			if !isText(value) {
+				continue
+			}
+			if _, exists := stringData[key]; !exists {
+				stringData[key] = string(value)
+			}
+			delete(data, key)
+		}
+	default:
+		return nil
+	}
+	setOrDelete(object, "data", data)
+	setOrDelete(object, "stringData", stringData)
+	return nil
+}
+
+// looksLikePlaintext reports whether a value is valid base64 by accident: a
+// short word which decodes to binary rather than text.
+func looksLikePlaintext(encoded string, decoded []byte) bool {
+	if len(encoded) > maxPlaintextLength || strings.ContainsAny(encoded, "+/=") {
+		return false
+	}
+	return !isText(decoded)
+}
+
+// isText reports whether a value is printable UTF-8 text.
+func isText(value []byte) bool {
+	if !utf8.Valid(value) {
+		return false
+	}
+	for _, r := range string(value) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// stringMap converts a decoded YAML mapping of strings.
+func stringMap(value interface{}) map[string]string {
+	result := map[string]string{}
+	mapping, _ := value.(map[interface{}]interface{})
+	for key, item := range mapping {
+		if item == nil {
+			result[fmt.Sprint(key)] = ""
+		} else {
+			result[fmt.Sprint(key)] = fmt.Sprint(item)
+		}
+	}
+	return result
+}
+
+func setOrDelete(object map[string]interface{}, key string, values map[string]string) {
+	if len(values) == 0 {
+		delete(object, key)
+		return
+	}
+	mapping := make(map[interface{}]interface{}, len(values))
+	for name, value := range values {
+		mapping[name] = value
+	}
+	object[key] = mapping
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
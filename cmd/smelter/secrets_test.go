@@ -0,0 +1,102 @@
+package smelter
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	"gopkg.in/yaml.v2"
+)
+
+const testSecret = `apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+data:
+  password: c2VjcmV0
+  keystore: AAECAw==
+stringData:
+  username: admin
+`
+
+func transformSecret(t *testing.T, secret string, format string) (map[string]interface{}, error) {
+	t.Helper()
+	config := utils.Config{Name: "test", Namespace: "test", SecretFormat: format}
+	_, document, err := transformDocument([]byte(secret), config, nil)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]interface{}
+	if err := yaml.Unmarshal(document, &object); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return object, nil
+}
+
+func TestNormalizeSecret(t *testing.T) {
+	t.Run("data", func(t *testing.T) {
+		object, err := transformSecret(t, testSecret, utils.SecretFormatData)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, exists := object["stringData"]; exists {
+			t.Errorf("expected stringData to be moved into data")
+		}
+		data := stringMap(object["data"])
+		if data["username"] != "YWRtaW4=" || data["password"] != "c2VjcmV0" || data["keystore"] != "AAECAw==" {
+			t.Errorf("unexpected data: %v", data)
+		}
+	})
+
+	t.Run("stringData", func(t *testing.T) {
+		object, err := transformSecret(t, testSecret, utils.SecretFormatStringData)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		stringData := stringMap(object["stringData"])
+		if stringData["username"] != "admin" || stringData["password"] != "secret" {
+			t.Errorf("unexpected stringData: %v", stringData)
+		}
+		data := stringMap(object["data"])
+		if len(data) != 1 || data["keystore"] != "AAECAw==" {
+			t.Errorf("expected only the binary value to stay in data, got: %v", data)
+		}
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		object, err := transformSecret(t, testSecret, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(stringMap(object["data"])) != 2 || len(stringMap(object["stringData"])) != 1 {
+			t.Errorf("expected the Secret to be kept as rendered, got: %v", object)
+		}
+	})
+
+	t.Run("invalid base64", func(t *testing.T) {
+		secret := strings.Replace(testSecret, "c2VjcmV0", "not base64!", 1)
+		_, err := transformSecret(t, secret, "")
+		if utils.ClassOf(err) != utils.ValidationError || !strings.Contains(err.Error(), "password") {
+			t.Errorf("expected a validation error for the password, got: %v", err)
+		}
+	})
+}
+
+func TestLooksLikePlaintext(t *testing.T) {
+	for value, expected := range map[string]bool{
+		"c2VjcmV0":                         false, // "secret"
+		"AAECAw==":                         false, // binary, but clearly encoded
+		"password":                         true,
+		"changeme1234":                     true,
+		"aGVsbG8gd29ybGQgdGhpcyBpcyBsb25n": false,
+	} {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", value, err)
+		}
+		if looksLikePlaintext(value, decoded) != expected {
+			t.Errorf("expected looksLikePlaintext(%s) to be %v", value, expected)
+		}
+	}
+}
@@ -18,6 +18,7 @@ package smelter
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"html/template"
 	"os"
@@ -48,21 +49,121 @@ type targettool struct {
 	Type []string
 }
 
-func Smelt(configs []utils.Config, workingDir string) {
+func Smelt(configs []utils.Config, workingDir string, preDir string) error {
+	targetTools, err := ChooseTools(configs, workingDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(preDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", preDir, err)
+	}
+	accessible, _ := strconv.ParseBool(os.Getenv("ACCESSIBLE"))
+	configMap := make(map[string]utils.Config)
+	for _, config := range configs {
+		configMap[config.Name] = config
+	}
+
+	// Render the tools one at a time, so each gets its own progress line,
+	// and learn the scopes of all their CRDs, and the namespaces of the
+	// tools' pods, before transforming any
+	var rendered []ToolResult
+	for i, tool := range targetTools {
+		var result ToolResult
+		err = spinner.New().
+			Title(fmt.Sprintf("Rendering %s (%d/%d)...", tool, i+1, len(targetTools))).
+			Accessible(accessible).
+			Action(func() {
+				result = renderTool(configMap[tool], workingDir, preDir)
+			}).
+			Run()
+		if err != nil {
+			return fmt.Errorf("tool preparation failed: %w", err)
+		}
+		rendered = append(rendered, result)
+	}
+	learnToolScopes(configs, rendered, workingDir, preDir)
+	learnPullSecretNamespaces(configs, rendered, workingDir, preDir)
+
+	var toolErrors []error
+	var completed []string
+	for i, result := range rendered {
+		tool := result.Tool
+		err = spinner.New().
+			Title(fmt.Sprintf("Smelting %s (%d/%d)...", tool, i+1, len(targetTools))).
+			Accessible(accessible).
+			Action(func() {
+				result = transformTool(configMap[tool], workingDir, preDir, result)
+			}).
+			Run()
+		if err != nil {
+			return fmt.Errorf("tool preparation failed: %w", err)
+		}
+		if result.Err != nil {
+			toolErrors = append(toolErrors, fmt.Errorf("%s: %w", tool, result.Err))
+		} else {
+			completed = append(completed, tool)
+		}
+		if !utils.Quiet() {
+			fmt.Println(formatToolResult(result))
+		}
+	}
+
+	// Print toolbox summary.
+	if !utils.Quiet() && len(completed) > 0 {
+		var sb strings.Builder
+		keyword := func(s string) string {
+			return lipgloss.NewStyle().Foreground(lipgloss.Color("212")).Render(s)
+		}
+		fmt.Fprintf(&sb,
+			"%s\n\nCompleted: %s.",
+			lipgloss.NewStyle().Bold(true).Render("Cluster Forge"),
+			keyword(xstrings.EnglishJoin(completed, true)),
+		)
+
+		fmt.Println(
+			lipgloss.NewStyle().
+				Width(40).
+				BorderStyle(lipgloss.RoundedBorder()).
+				BorderForeground(lipgloss.Color("63")).
+				Padding(1, 2).
+				Render(sb.String()),
+		)
+	}
+	return errors.Join(toolErrors...)
+}
+
+// formatToolResult renders the outcome of smelting a tool as a line, followed
+// by its warnings.
+func formatToolResult(result ToolResult) string {
+	var sb strings.Builder
+	if result.Err != nil {
+		fmt.Fprintf(&sb, "%s %s: %v", lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Render("✗"), result.Tool, result.Err)
+	} else {
+		fmt.Fprintf(&sb, "%s %s: %d objects", lipgloss.NewStyle().Foreground(lipgloss.Color("10")).Render("✓"), result.Tool, len(result.Objects))
+	}
+	warning := lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+	for _, message := range result.Warnings {
+		fmt.Fprintf(&sb, "\n  %s %s", warning.Render("!"), message)
+	}
+	return sb.String()
+}
+
+// ChooseTools asks which of the tools to smelt, showing how many objects
+// each tool has in the working directory from an earlier smelt.
+func ChooseTools(configs []utils.Config, workingDir string) ([]string, error) {
 	log.Info("starting up the menu...")
 	var targettool targettool
 	var toolbox = toolbox{Targettool: targettool}
-	var names []string
-	names = append(names, "all")
+	options := []huh.Option[string]{huh.NewOption("all", "all")}
 	for _, config := range configs {
-		names = append(names, config.Name)
+		options = append(options, huh.NewOption(toolStatus(config.Name, workingDir), config.Name))
 	}
 	accessible, _ := strconv.ParseBool(os.Getenv("ACCESSIBLE"))
 
 	form := huh.NewForm(
 		huh.NewGroup(
 			huh.NewMultiSelect[string]().
-				Options(huh.NewOptions(names...)...).
+				Options(options...).
 				Title("Choose your target tools to smelt").
 				Validate(func(t []string) error {
 					if len(t) <= 0 {
@@ -77,98 +178,292 @@ func Smelt(configs []utils.Config, workingDir string) {
 
 	err := form.Run()
 	if err != nil {
-		log.Fatal("Uh oh:", err)
+		return nil, fmt.Errorf("interactive form failed: %w", err)
 	}
 	if toolbox.Targettool.Type[0] == "all" {
+		toolbox.Targettool.Type = nil
 		for _, config := range configs {
 			toolbox.Targettool.Type = append(toolbox.Targettool.Type, config.Name)
 		}
 	}
+	return toolbox.Targettool.Type, nil
+}
 
-	err = spinner.New().
-		Title("Preparing your tools...").
-		Accessible(accessible).
-		Action(func() {
-			if err := PrepareTool(configs, toolbox.Targettool.Type, workingDir); err != nil {
-				log.Errorf("Error during tool preparation: %v", err)
-			}
-		}).
-		Run()
-	if err != nil {
-		log.Fatalf("Tool preparation failed: %v", err)
+// toolStatus labels a tool with the number of objects it has in the working
+// directory.
+func toolStatus(tool, workingDir string) string {
+	files, err := filepath.Glob(filepath.Join(workingDir, tool, "*.yaml"))
+	if err != nil || len(files) == 0 {
+		return tool + " (not smelted)"
 	}
+	return fmt.Sprintf("%s (%d objects)", tool, len(files))
+}
 
-	// Print toolbox summary.
-	{
-		var sb strings.Builder
-		keyword := func(s string) string {
-			return lipgloss.NewStyle().Foreground(lipgloss.Color("212")).Render(s)
-		}
-		fmt.Fprintf(&sb,
-			"%s\n\nCompleted: %s.",
-			lipgloss.NewStyle().Bold(true).Render("Cluster Forge"),
-			keyword(xstrings.EnglishJoin(toolbox.Targettool.Type, true)),
-		)
-
-		fmt.Println(
-			lipgloss.NewStyle().
-				Width(40).
-				BorderStyle(lipgloss.RoundedBorder()).
-				BorderForeground(lipgloss.Color("63")).
-				Padding(1, 2).
-				Render(sb.String()),
-		)
-	}
+// PrepareTool smelts each of the target tools into toolBaseDir, rendering
+// them into preDir first. All tools are rendered before any is transformed,
+// so the scopes of the CRDs of all of them are known. A failing tool does not
+// stop the others; the errors of all failed tools are returned together.
+func PrepareTool(configs []utils.Config, targetTools []string, toolBaseDir string, preDir string) error {
+	return prepareTools(configs, targetTools, nil, toolBaseDir, preDir)
 }
 
-func PrepareTool(configs []utils.Config, targetTools []string, toolBaseDir string) error {
+// prepareTools smelts the target tools like PrepareTool, but transforms the
+// tools in reuse from the manifests they were last rendered into in preDir
+// rather than rendering them again.
+func prepareTools(configs []utils.Config, targetTools []string, reuse map[string]bool, toolBaseDir string, preDir string) error {
 	configMap := make(map[string]utils.Config)
 
 	for _, config := range configs {
 		configMap[config.Name] = config
 	}
 
-	preDir := filepath.Join(toolBaseDir, "pre")
 	if err := os.MkdirAll(preDir, 0755); err != nil {
 		return fmt.Errorf("failed to create directory %s: %w", preDir, err)
 	}
 
+	var rendered []ToolResult
 	for _, tool := range targetTools {
-		if config, exists := configMap[tool]; exists {
-			namespaceObject := false
-			log.Debug("running setup for ", config.Name)
-			config.Filename = filepath.Join(preDir, config.Name+".yaml")
-
-			toolDir := filepath.Join(toolBaseDir, config.Name)
-			files, _ := os.ReadDir(toolDir)
-			for _, file := range files {
-				if !file.IsDir() && !strings.Contains(file.Name(), "ExternalSecret") {
-					_ = os.Remove(filepath.Join(toolDir, file.Name()))
-				}
-			}
+		config, exists := configMap[tool]
+		if !exists {
+			continue
+		}
+		if reuse[tool] {
+			removeToolOutput(config, toolBaseDir)
+			rendered = append(rendered, ToolResult{Tool: tool})
+		} else {
+			rendered = append(rendered, renderTool(config, toolBaseDir, preDir))
+		}
+	}
+	learnToolScopes(configs, rendered, toolBaseDir, preDir)
+	learnPullSecretNamespaces(configs, rendered, toolBaseDir, preDir)
 
-			utils.Templatehelm(config, &utils.DefaultHelmExecutor{})
-			SplitYAML(config, toolBaseDir)
+	var toolErrors []error
+	for _, result := range rendered {
+		if result := transformTool(configMap[result.Tool], toolBaseDir, preDir, result); result.Err != nil {
+			toolErrors = append(toolErrors, fmt.Errorf("%s: %w", result.Tool, result.Err))
+		}
+	}
 
-			files, _ = os.ReadDir(toolDir)
-			for _, file := range files {
-				if !file.IsDir() && strings.Contains(file.Name(), "Namespace") {
-					namespaceObject = true
-					break
-				}
-			}
+	return errors.Join(toolErrors...)
+}
+
+// ToolResult is the outcome of smelting one tool.
+type ToolResult struct {
+	Tool string
+	// Objects are the files written for the tool.
+	Objects []string
+	// Warnings are the warnings logged while smelting the tool.
+	Warnings []string
+	Err      error
+}
+
+// SmeltTool smelts one tool into toolBaseDir, rendering it into preDir
+// first, and reports the outcome. preDir has to exist. Only the CRDs of the
+// tool itself and those already in toolBaseDir are known, see PrepareTool
+// for smelting several tools.
+func SmeltTool(config utils.Config, toolBaseDir string, preDir string) ToolResult {
+	rendered := []ToolResult{renderTool(config, toolBaseDir, preDir)}
+	learnToolScopes([]utils.Config{config}, rendered, toolBaseDir, preDir)
+	learnPullSecretNamespaces([]utils.Config{config}, rendered, toolBaseDir, preDir)
+	return transformTool(config, toolBaseDir, preDir, rendered[0])
+}
+
+// renderTool renders a tool into preDir, collecting the warnings logged.
+func renderTool(config utils.Config, toolBaseDir string, preDir string) ToolResult {
+	result := ToolResult{Tool: config.Name}
+	result.Warnings, result.Err = collectWarnings(func() error {
+		return renderToolManifests(config, toolBaseDir, preDir)
+	})
+	if result.Err != nil {
+		// A partly rendered file is not transformed again later
+		_ = os.Remove(renderedFile(config.Name, preDir))
+	}
+	return result
+}
+
+// transformTool transforms a rendered tool into toolBaseDir, unless
+// rendering failed, and records the outcome in the run report.
+func transformTool(config utils.Config, toolBaseDir string, preDir string, result ToolResult) ToolResult {
+	if result.Err == nil {
+		var warnings []string
+		warnings, result.Err = collectWarnings(func() error {
+			return transformToolManifests(config, toolBaseDir, preDir)
+		})
+		result.Warnings = append(result.Warnings, warnings...)
+	}
+
+	files, _ := os.ReadDir(filepath.Join(toolBaseDir, config.Name))
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".yaml") {
+			result.Objects = append(result.Objects, file.Name())
+		}
+	}
+	report := utils.ToolReport{Name: config.Name, Objects: result.Objects, Warnings: result.Warnings}
+	if result.Err != nil {
+		report.Error = result.Err.Error()
+	}
+	utils.RecordTool(report)
+	return result
+}
+
+// collectWarnings runs step, returning the warnings it logged.
+func collectWarnings(step func() error) ([]string, error) {
+	collector := &warningCollector{}
+	logger := log.StandardLogger()
+	hooks := logger.ReplaceHooks(collector.with(logger.Hooks))
+	err := step()
+	logger.ReplaceHooks(hooks)
+	return collector.warnings, err
+}
 
-			if !namespaceObject {
-				if err := createNamespaceFile(config, toolBaseDir); err != nil {
-					return fmt.Errorf("failed to create namespace file: %w", err)
-				}
+// learnToolScopes learns the scopes of the CRDs of the rendered tools, and
+// of the CRDs the other tools have in toolBaseDir from an earlier smelt, so
+// whether an object gets a namespace doesn't depend on the order of the tools
+// or which of them are smelted. A tool whose CRDs can't be read fails.
+func learnToolScopes(configs []utils.Config, rendered []ToolResult, toolBaseDir string, preDir string) {
+	renderedTools := map[string]bool{}
+	for i, result := range rendered {
+		renderedTools[result.Tool] = true
+		if result.Err == nil {
+			rendered[i].Err = learnRenderedScopes(result.Tool, renderedFile(result.Tool, preDir))
+		}
+	}
+	for _, config := range configs {
+		if renderedTools[config.Name] {
+			continue
+		}
+		files, _ := filepath.Glob(filepath.Join(toolBaseDir, config.Name, "CustomResourceDefinition_*.yaml"))
+		for _, file := range files {
+			document, err := os.ReadFile(file)
+			if err == nil {
+				err = learnScopes(config.Name, [][]byte{document})
 			}
+			if err != nil {
+				log.Warnf("Not using the scope of the CRD in %s: %v", file, err)
+			}
+		}
+	}
+}
+
+// renderedFile is the file a tool is rendered into.
+func renderedFile(tool string, preDir string) string {
+	return filepath.Join(preDir, tool+".yaml")
+}
+
+// renderToolManifests removes the tool's previous output and renders its
+// manifests into preDir.
+func renderToolManifests(config utils.Config, toolBaseDir string, preDir string) error {
+	log.Debug("running setup for ", config.Name)
+	config.Filename = renderedFile(config.Name, preDir)
+	removeToolOutput(config, toolBaseDir)
+
+	if err := utils.Templatehelm(config, &utils.DefaultHelmExecutor{}); err != nil {
+		log.Errorf("Failed to template %s: %v", config.Name, err)
+		return err
+	}
+	return nil
+}
+
+// removeToolOutput removes the tool's previous output from toolBaseDir,
+// except its ExternalSecrets.
+func removeToolOutput(config utils.Config, toolBaseDir string) {
+	toolDir := filepath.Join(toolBaseDir, config.Name)
+	files, _ := os.ReadDir(toolDir)
+	for _, file := range files {
+		if !file.IsDir() && !strings.Contains(file.Name(), "ExternalSecret") {
+			_ = os.Remove(filepath.Join(toolDir, file.Name()))
+		}
+	}
+}
+
+// transformToolManifests splits the rendered manifests of a tool into
+// toolBaseDir and transforms them.
+func transformToolManifests(config utils.Config, toolBaseDir string, preDir string) error {
+	namespaceObject := false
+	config.Filename = renderedFile(config.Name, preDir)
+	toolDir := filepath.Join(toolBaseDir, config.Name)
+
+	if err := SplitYAML(config, toolBaseDir); err != nil {
+		log.Errorf("Failed to split %s: %v", config.Name, err)
+		return err
+	}
+	if err := addPolicies(config, toolBaseDir); err != nil {
+		log.Errorf("Failed to add the policies of %s: %v", config.Name, err)
+		return err
+	}
+	if err := wireWebhookCerts(config, toolBaseDir); err != nil {
+		log.Errorf("Failed to wire the webhook certificates of %s: %v", config.Name, err)
+		return err
+	}
+	if err := reviewWebhooks(config, toolBaseDir); err != nil {
+		log.Errorf("Failed to review the webhooks of %s: %v", config.Name, err)
+		return err
+	}
+	if err := pinImageDigests(config, toolBaseDir); err != nil {
+		log.Errorf("Failed to pin the images of %s: %v", config.Name, err)
+		return err
+	}
+	if err := addPullSecrets(config, toolBaseDir); err != nil {
+		log.Errorf("Failed to add the pull secrets of %s: %v", config.Name, err)
+		return err
+	}
+	if err := rewriteStorageClasses(config, toolBaseDir); err != nil {
+		log.Errorf("Failed to rewrite the storage classes of %s: %v", config.Name, err)
+		return err
+	}
+	if err := templateIngresses(config, toolBaseDir); err != nil {
+		log.Errorf("Failed to apply the ingress config to %s: %v", config.Name, err)
+		return err
+	}
+	if err := applyHAProfile(config, toolBaseDir); err != nil {
+		log.Errorf("Failed to apply the ha profile to %s: %v", config.Name, err)
+		return err
+	}
+
+	files, _ := os.ReadDir(toolDir)
+	for _, file := range files {
+		if !file.IsDir() && strings.Contains(file.Name(), "Namespace") {
+			namespaceObject = true
+			break
 		}
 	}
 
+	if !namespaceObject {
+		if err := createNamespaceFile(config, toolBaseDir); err != nil {
+			return fmt.Errorf("failed to create namespace file: %w", err)
+		}
+	}
+	if err := stampObjectDigests(config, toolBaseDir); err != nil {
+		log.Errorf("Failed to stamp the object digests of %s: %v", config.Name, err)
+		return err
+	}
 	return nil
 }
 
+// warningCollector is a logrus hook keeping the messages of warnings.
+type warningCollector struct {
+	warnings []string
+}
+
+func (c *warningCollector) Levels() []log.Level {
+	return []log.Level{log.WarnLevel}
+}
+
+func (c *warningCollector) Fire(entry *log.Entry) error {
+	c.warnings = append(c.warnings, entry.Message)
+	return nil
+}
+
+// with returns the hooks with the collector added.
+func (c *warningCollector) with(hooks log.LevelHooks) log.LevelHooks {
+	combined := log.LevelHooks{}
+	for level, levelHooks := range hooks {
+		combined[level] = append(combined[level], levelHooks...)
+	}
+	combined.Add(c)
+	return combined
+}
+
 func createNamespaceFile(config utils.Config, toolBaseDir string) error {
 	data := struct {
 		NamespaceName string
@@ -191,8 +486,14 @@ func createNamespaceFile(config utils.Config, toolBaseDir string) error {
 		return fmt.Errorf("failed to create directory %s: %w", namespaceDir, err)
 	}
 
+	// The namespace is generated, so like the generated pull secrets it is
+	// annotated as coming from this run with the template as its source
+	_, document, err := transformDocument(rendered.Bytes(), config, utils.RunAnnotations(config.Name, utils.SourceDigest(rendered.Bytes())))
+	if err != nil {
+		return err
+	}
 	namespaceFilePath := filepath.Join(namespaceDir, "Namespace_"+config.Name+".yaml")
-	if err := os.WriteFile(namespaceFilePath, rendered.Bytes(), 0644); err != nil {
+	if err := os.WriteFile(namespaceFilePath, document, 0644); err != nil {
 		return fmt.Errorf("failed to write namespace file: %w", err)
 	}
 
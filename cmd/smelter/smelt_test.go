@@ -0,0 +1,116 @@
+package smelter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+func TestSmeltTool(t *testing.T) {
+	inputDir := t.TempDir()
+	utils.SetInputDirs([]string{inputDir})
+	defer utils.SetInputDirs([]string{"input"})
+	source := testValidateSourceA + "---\n" + "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n"
+	if err := os.WriteFile(filepath.Join(inputDir, "alpha.yaml"), []byte(source), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	workingDir := t.TempDir()
+	config := utils.Config{Name: "alpha", Namespace: "alpha", SourceFile: "alpha.yaml"}
+	result := SmeltTool(config, workingDir, t.TempDir())
+	if result.Err != nil {
+		t.Fatalf("unexpected error: %v", result.Err)
+	}
+	// The ClusterRole, webhook and ConfigMap, and the generated Namespace
+	if len(result.Objects) != 4 {
+		t.Errorf("expected 4 objects, got %v", result.Objects)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "check.alpha.example.com") {
+		t.Errorf("expected the webhook warning, got %v", result.Warnings)
+	}
+	var namespace struct {
+		Metadata struct {
+			Annotations map[string]string `yaml:"annotations"`
+		} `yaml:"metadata"`
+	}
+	readObject(t, filepath.Join(workingDir, "alpha", "Namespace_alpha.yaml"), &namespace)
+	for _, annotation := range []string{utils.AnnotationTool, utils.AnnotationBuildID, utils.AnnotationSourceDigest, utils.AnnotationObjectDigest} {
+		if namespace.Metadata.Annotations[annotation] == "" {
+			t.Errorf("expected the generated Namespace to have the %s annotation, got %v", annotation, namespace.Metadata.Annotations)
+		}
+	}
+	if status := toolStatus("alpha", workingDir); status != "alpha (4 objects)" {
+		t.Errorf("unexpected status %s", status)
+	}
+	if status := toolStatus("beta", workingDir); status != "beta (not smelted)" {
+		t.Errorf("unexpected status %s", status)
+	}
+
+	result = SmeltTool(utils.Config{Name: "broken", Namespace: "broken"}, workingDir, t.TempDir())
+	if result.Err == nil || utils.ClassOf(result.Err) != utils.ConfigError {
+		t.Errorf("expected a config error, got %v", result.Err)
+	}
+}
+
+func TestPrepareToolScopes(t *testing.T) {
+	inputDir := t.TempDir()
+	utils.SetInputDirs([]string{inputDir})
+	defer utils.SetInputDirs([]string{"input"})
+	defer utils.RegisterResourceScopes(nil)
+	files := map[string]string{
+		"gadget.yaml": testGadget,
+		"crd.yaml":    testGadgetCRD,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	configs := []utils.Config{
+		{Name: "gadgets", Namespace: "gadgets", SourceFile: "gadget.yaml"},
+		{Name: "crds", Namespace: "crds", SourceFile: "crd.yaml"},
+	}
+	gadgetNamespace := func(workingDir string) string {
+		var gadget struct {
+			Metadata struct {
+				Namespace string `yaml:"namespace"`
+			} `yaml:"metadata"`
+		}
+		readObject(t, filepath.Join(workingDir, "gadgets", "Gadget_sprocket.yaml"), &gadget)
+		return gadget.Metadata.Namespace
+	}
+
+	// The Gadget's tool comes before the tool with its CRD
+	utils.RegisterResourceScopes(nil)
+	workingDir := t.TempDir()
+	if err := PrepareTool(configs, []string{"gadgets", "crds"}, workingDir, t.TempDir()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if namespace := gadgetNamespace(workingDir); namespace != "" {
+		t.Errorf("expected the cluster-scoped Gadget to have no namespace, got %s", namespace)
+	}
+
+	// Smelting the Gadget's tool alone uses the CRD smelted before
+	utils.RegisterResourceScopes(nil)
+	if err := PrepareTool(configs, []string{"gadgets"}, workingDir, t.TempDir()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if namespace := gadgetNamespace(workingDir); namespace != "" {
+		t.Errorf("expected the cluster-scoped Gadget to have no namespace, got %s", namespace)
+	}
+}
+
+func TestFormatToolResult(t *testing.T) {
+	line := formatToolResult(ToolResult{Tool: "alpha", Objects: []string{"a.yaml", "b.yaml", "c.yaml"}, Warnings: []string{"risky webhook"}})
+	if !strings.Contains(line, "alpha: 3 objects") || !strings.Contains(line, "risky webhook") {
+		t.Errorf("unexpected result line %q", line)
+	}
+	line = formatToolResult(ToolResult{Tool: "beta", Err: errors.New("no chart")})
+	if !strings.Contains(line, "beta: no chart") {
+		t.Errorf("unexpected result line %q", line)
+	}
+}
@@ -21,13 +21,13 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
 	goyaml "github.com/go-yaml/yaml"
 	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
 
@@ -42,41 +42,48 @@ type k8sObject struct {
 	} `yaml:"metadata"`
 }
 
+// splitYAML splits a multi-document stream into normalized documents.
+// Documents larger than streamingThreshold are passed through as they are.
 func splitYAML(resources []byte) ([][]byte, error) {
-
-	dec := goyaml.NewDecoder(bytes.NewReader(resources))
-
 	var res [][]byte
-	for {
-		var value interface{}
-		err := dec.Decode(&value)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		if value == nil {
+	for _, document := range splitDocuments(resources) {
+		if len(document) > streamingThreshold {
+			res = append(res, document)
 			continue
 		}
-		valueBytes, err := goyaml.Marshal(value)
-		if err != nil {
-			return nil, err
+
+		dec := goyaml.NewDecoder(bytes.NewReader(document))
+		for {
+			var value interface{}
+			err := dec.Decode(&value)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				continue
+			}
+			valueBytes, err := goyaml.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, valueBytes)
 		}
-		res = append(res, valueBytes)
 	}
 	return res, nil
 }
 
 func preclean(data []byte) []byte {
-    // Convert byte slice to string
-    dataStr := string(data)
+	// Convert byte slice to string
+	dataStr := string(data)
 
-    // Remove tab characters
-    cleanedStr := strings.ReplaceAll(dataStr, "\t", "  ")
+	// Remove tab characters
+	cleanedStr := strings.ReplaceAll(dataStr, "\t", "  ")
 
-    // Convert back to byte slice
-    return []byte(cleanedStr)
+	// Convert back to byte slice
+	return []byte(cleanedStr)
 }
 
 func clean(input []byte) ([]byte, error) {
@@ -104,58 +111,172 @@ func clean(input []byte) ([]byte, error) {
 	return output.Bytes(), nil
 }
 
-func SplitYAML(config utils.Config, workingDir string) {
+// learnScopes learns the scopes of the CRDs among the documents of a tool.
+func learnScopes(tool string, documents [][]byte) error {
+	for _, document := range documents {
+		var err error
+		if len(document) > streamingThreshold {
+			err = learnLargeCRDScopes(document)
+		} else {
+			err = utils.LearnCRDScopes(document)
+		}
+		if err != nil {
+			return utils.Errorf(utils.RenderError, "failed to read CRD in %s: %w", tool, err)
+		}
+	}
+	return nil
+}
+
+// learnRenderedScopes learns the scopes of the CRDs in the rendered
+// manifests of a tool, before any tool is split.
+func learnRenderedScopes(tool string, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return utils.Errorf(utils.RenderError, "failed to read rendered manifests for %s: %w", tool, err)
+	}
+	documents, err := splitYAML(preclean(data))
+	if err != nil {
+		return utils.Errorf(utils.RenderError, "failed to split manifests for %s: %w", tool, err)
+	}
+	return learnScopes(tool, documents)
+}
+
+func SplitYAML(config utils.Config, workingDir string) error {
+	stopSplit := utils.StartStage(config.Name, utils.StageSplit)
 	data, err := os.ReadFile(config.Filename)
 	if err != nil {
-		log.Fatal(err)
+		return utils.Errorf(utils.RenderError, "failed to read rendered manifests for %s: %w", config.Name, err)
 	}
 
-  // Use the preclean function
-  preCleanedData := preclean(data)
+	// Use the preclean function
+	preCleanedData := preclean(data)
 
 	result, err := splitYAML(preCleanedData)
 	if err != nil {
-		log.Fatal(err)
+		return utils.Errorf(utils.RenderError, "failed to split manifests for %s: %w", config.Name, err)
+	}
+
+	annotations := utils.RunAnnotations(config.Name, utils.SourceDigest(data))
+
+	// CRDs are often listed after the resources using them, so learn their
+	// scopes before deciding which objects need a namespace.
+	if err := learnScopes(config.Name, result); err != nil {
+		return err
 	}
+	stopSplit()
 
 	for _, res := range result {
+		stopClean := utils.StartStage(config.Name, utils.StageClean)
 		cleanres, err := clean(res)
 		if err != nil {
-			log.Fatal(err)
+			return utils.Errorf(utils.RenderError, "failed to clean manifest in %s: %w", config.Name, err)
 		}
+		stopClean()
 
-		var objectMap map[string]interface{}
-		err = yaml.Unmarshal(cleanres, &objectMap)
-		if err != nil {
-			log.Fatal(err)
-		}
+		stopTransform := utils.StartStage(config.Name, utils.StageTransform)
 		var metadataObject k8sObject
-		err = yaml.Unmarshal(cleanres, &metadataObject)
-		if err != nil {
-			log.Fatal(err)
+		var updatedCleanres []byte
+		if len(cleanres) > streamingThreshold {
+			metadataObject, updatedCleanres, err = transformLargeDocument(cleanres, config, annotations)
+			if err != nil {
+				log.Debugf("Failed to read the metadata of a large document in %s, parsing all of it: %v", config.Name, err)
+				updatedCleanres = nil
+			}
 		}
-		if !utils.IsClusterScoped(metadataObject.Kind, metadataObject.APIVersion) {
-			if metadataObject.Metadata.Namespace == "" {
-				metadataObject.Metadata.Namespace = config.Namespace // Set your default namespace here
-				objectMap["metadata"] = metadataObject.Metadata
+		if updatedCleanres == nil {
+			metadataObject, updatedCleanres, err = transformDocument(cleanres, config, annotations)
+			if err != nil {
+				return err
 			}
-
 		}
+		stopTransform()
 
-		updatedCleanres, err := yaml.Marshal(&objectMap)
-		if err != nil {
-			log.Fatal(err)
-		}
+		stopWrite := utils.StartStage(config.Name, utils.StageWrite)
 
 		err = os.MkdirAll(filepath.Join(workingDir, config.Name), 0755)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("failed to create directory for %s: %w", config.Name, err)
 		}
 
 		filename := filepath.Join(workingDir, config.Name, fmt.Sprintf("%s_%s.yaml", metadataObject.Kind, metadataObject.Metadata.Name))
 		err = os.WriteFile(filename, updatedCleanres, 0644)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("failed to write %s: %w", filename, err)
+		}
+		stopWrite()
+	}
+	return nil
+}
+
+// stampObjectDigests stamps the digest of each of the tool's objects into
+// it, once all transformations are done, so clusters can be compared by what
+// was applied rather than by the manifests the tool was rendered from.
+func stampObjectDigests(config utils.Config, workingDir string) error {
+	toolDir := filepath.Join(workingDir, config.Name)
+	files, err := filepath.Glob(filepath.Join(toolDir, "*.yaml"))
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", toolDir, err)
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		annotations := map[string]string{utils.AnnotationObjectDigest: utils.ObjectDigest(content)}
+		var updated []byte
+		if len(content) > streamingThreshold {
+			_, updated, err = transformLargeDocument(content, config, annotations)
+			if err != nil {
+				log.Debugf("Failed to read the metadata of %s, parsing all of it: %v", file, err)
+				updated = nil
+			}
+		}
+		if updated == nil {
+			var object map[string]interface{}
+			if err := yaml.Unmarshal(content, &object); err != nil {
+				return utils.Errorf(utils.RenderError, "failed to parse %s: %w", file, err)
+			}
+			utils.StampAnnotations(object, annotations)
+			if updated, err = yaml.Marshal(object); err != nil {
+				return utils.Errorf(utils.RenderError, "failed to write %s: %w", file, err)
+			}
 		}
+		if err := os.WriteFile(file, updated, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// transformDocument sets the namespace and annotations of a document. It
+// returns the object's metadata and the rewritten document.
+func transformDocument(document []byte, config utils.Config, annotations map[string]string) (k8sObject, []byte, error) {
+	var metadataObject k8sObject
+	var objectMap map[string]interface{}
+	err := yaml.Unmarshal(document, &objectMap)
+	if err != nil {
+		return metadataObject, nil, utils.Errorf(utils.RenderError, "failed to parse manifest in %s: %w", config.Name, err)
+	}
+	err = yaml.Unmarshal(document, &metadataObject)
+	if err != nil {
+		return metadataObject, nil, utils.Errorf(utils.RenderError, "failed to parse metadata in %s: %w", config.Name, err)
+	}
+	if !utils.IsClusterScoped(metadataObject.Kind, metadataObject.APIVersion) {
+		if metadataObject.Metadata.Namespace == "" {
+			utils.ObjectMetadata(objectMap)["namespace"] = config.Namespace
+		}
+
+	}
+	if metadataObject.Kind == "Secret" && metadataObject.APIVersion == "v1" {
+		if err := normalizeSecret(objectMap, config); err != nil {
+			return metadataObject, nil, err
+		}
+	}
+	utils.StampAnnotations(objectMap, annotations)
+
+	updatedDocument, err := yaml.Marshal(&objectMap)
+	if err != nil {
+		return metadataObject, nil, utils.Errorf(utils.RenderError, "failed to write %s %s: %w", metadataObject.Kind, metadataObject.Metadata.Name, err)
 	}
+	return metadataObject, updatedDocument, nil
 }
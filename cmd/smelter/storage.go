@@ -0,0 +1,151 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"path/filepath"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// annotationStorageClass is the storage class annotation which predates
+// storageClassName, still set by some charts.
+const annotationStorageClass = "volume.beta.kubernetes.io/storage-class"
+
+// rewriteStorageClasses replaces the storage classes of the tool's
+// PersistentVolumeClaims and volume claim templates following the
+// storage-classes config, as charts often hardcode a cloud's classes.
+func rewriteStorageClasses(config utils.Config, workingDir string) error {
+	if !utils.HasStorageClasses() {
+		return nil
+	}
+	return updateObjects(filepath.Join(workingDir, config.Name), func(file string, object map[string]interface{}) (bool, error) {
+		changed := false
+		if object["kind"] == "PersistentVolumeClaim" && object["apiVersion"] == "v1" {
+			changed = rewriteClaimTemplate(object)
+		} else {
+			for _, template := range findClaimTemplates(object) {
+				changed = rewriteClaimTemplate(template) || changed
+			}
+		}
+		if changed {
+			log.Debugf("Rewrote the storage classes of %s", file)
+		}
+		return changed, nil
+	})
+}
+
+// rewriteClaimTemplate maps the storage class of a claim or claim template.
+// The claim only gets a spec if its class is changed.
+func rewriteClaimTemplate(claim interface{}) bool {
+	var metadata, spec map[interface{}]interface{}
+	switch claim := claim.(type) {
+	case map[string]interface{}:
+		metadata, _ = claim["metadata"].(map[interface{}]interface{})
+		spec, _ = claim["spec"].(map[interface{}]interface{})
+	case map[interface{}]interface{}:
+		metadata, _ = claim["metadata"].(map[interface{}]interface{})
+		spec, _ = claim["spec"].(map[interface{}]interface{})
+	}
+	annotations, _ := metadata["annotations"].(map[interface{}]interface{})
+	newSpec := spec == nil
+	if newSpec {
+		spec = map[interface{}]interface{}{}
+	}
+	if !rewriteClaimStorageClass(annotations, spec) {
+		return false
+	}
+	if newSpec && len(spec) > 0 {
+		switch claim := claim.(type) {
+		case map[string]interface{}:
+			claim["spec"] = spec
+		case map[interface{}]interface{}:
+			claim["spec"] = spec
+		}
+	}
+	return true
+}
+
+// findClaimTemplates returns the volume claim templates of a workload: those
+// of a StatefulSet and of the ephemeral volumes of its pod specs. Only these
+// fields are looked at, as CRDs describe the same fields in their schemas.
+func findClaimTemplates(object map[string]interface{}) []map[interface{}]interface{} {
+	if object["kind"] == "CustomResourceDefinition" {
+		return nil
+	}
+	var templates []map[interface{}]interface{}
+	if object["kind"] == "StatefulSet" {
+		spec, _ := object["spec"].(map[interface{}]interface{})
+		list, _ := spec["volumeClaimTemplates"].([]interface{})
+		for _, template := range list {
+			if templateMap, ok := template.(map[interface{}]interface{}); ok {
+				templates = append(templates, templateMap)
+			}
+		}
+	}
+	for _, podSpec := range findPodSpecs(object) {
+		volumes, _ := podSpec["volumes"].([]interface{})
+		for _, volume := range volumes {
+			volumeMap, _ := volume.(map[interface{}]interface{})
+			ephemeral, _ := volumeMap["ephemeral"].(map[interface{}]interface{})
+			if template, ok := ephemeral["volumeClaimTemplate"].(map[interface{}]interface{}); ok {
+				templates = append(templates, template)
+			}
+		}
+	}
+	return templates
+}
+
+// rewriteClaimStorageClass maps the storage class of a claim, given the
+// claim's annotations (possibly nil) and spec. Both the old annotation and
+// storageClassName are mapped; the empty class only matches claims with
+// neither. An explicitly empty class asks for no class at all, to bind a
+// pre-provisioned PersistentVolume, so it is kept. Mapping a class to the
+// empty class removes it, so the claim gets the cluster's default.
+func rewriteClaimStorageClass(annotations, spec map[interface{}]interface{}) bool {
+	annotation, hasAnnotation := annotations[annotationStorageClass]
+	className, hasClassName := spec["storageClassName"]
+	// A null storageClassName is the same as none
+	hasClassName = hasClassName && className != nil
+	if !hasAnnotation && !hasClassName {
+		return mapStorageClassField(spec, "storageClassName")
+	}
+	changed := false
+	if hasAnnotation && annotation != "" {
+		changed = mapStorageClassField(annotations, annotationStorageClass)
+	}
+	if hasClassName && className != "" {
+		changed = mapStorageClassField(spec, "storageClassName") || changed
+	}
+	return changed
+}
+
+// mapStorageClassField maps the storage class in fields[key].
+func mapStorageClassField(fields map[interface{}]interface{}, key string) bool {
+	class, _ := fields[key].(string)
+	mapped, exists := utils.MapStorageClass(class)
+	if !exists || mapped == class {
+		return false
+	}
+	if mapped == "" {
+		delete(fields, key)
+	} else {
+		fields[key] = mapped
+	}
+	return true
+}
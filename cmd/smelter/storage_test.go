@@ -0,0 +1,182 @@
+package smelter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+const testStorageStatefulSet = `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+spec:
+  volumeClaimTemplates:
+  - metadata:
+      name: data
+    spec:
+      storageClassName: gp2
+  - metadata:
+      name: scratch
+    spec:
+      storageClassName: fast
+`
+
+const testStoragePVC = `apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: uploads
+spec:
+  accessModes: [ReadWriteOnce]
+`
+
+const testStorageLegacyPVC = `apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: legacy
+  annotations:
+    volume.beta.kubernetes.io/storage-class: gp2
+`
+
+const testStorageStaticPVC = `apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: static
+spec:
+  storageClassName: ""
+  volumeName: nfs-share
+`
+
+const testStorageDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  template:
+    spec:
+      containers:
+      - name: worker
+      volumes:
+      - name: cache
+        ephemeral:
+          volumeClaimTemplate:
+            spec:
+              storageClassName: gp2
+`
+
+const testStorageCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: stores.example.com
+spec:
+  versions:
+  - name: v1
+    schema:
+      openAPIV3Schema:
+        properties:
+          storage:
+            properties:
+              volumeClaimTemplate:
+                type: object
+`
+
+type claimSpec struct {
+	Metadata struct {
+		Annotations map[string]string `yaml:"annotations"`
+	} `yaml:"metadata"`
+	Spec struct {
+		StorageClassName *string `yaml:"storageClassName"`
+	} `yaml:"spec"`
+}
+
+func TestRewriteStorageClasses(t *testing.T) {
+	utils.RegisterStorageClasses(map[string]string{"gp2": "longhorn", "": "local-path", "fast": ""})
+	defer utils.RegisterStorageClasses(nil)
+
+	workingDir := t.TempDir()
+	toolDir := filepath.Join(workingDir, "db")
+	if err := os.MkdirAll(toolDir, 0755); err != nil {
+		t.Fatalf("Failed to create tool directory: %v", err)
+	}
+	files := map[string]string{
+		"StatefulSet_db.yaml":                              testStorageStatefulSet,
+		"PersistentVolumeClaim_uploads.yaml":               testStoragePVC,
+		"PersistentVolumeClaim_legacy.yaml":                testStorageLegacyPVC,
+		"PersistentVolumeClaim_static.yaml":                testStorageStaticPVC,
+		"Deployment_worker.yaml":                           testStorageDeployment,
+		"CustomResourceDefinition_stores.example.com.yaml": testStorageCRD,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(toolDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	if err := rewriteStorageClasses(utils.Config{Name: "db", Namespace: "db"}, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var statefulSet struct {
+		Spec struct {
+			VolumeClaimTemplates []claimSpec `yaml:"volumeClaimTemplates"`
+		} `yaml:"spec"`
+	}
+	readObject(t, filepath.Join(toolDir, "StatefulSet_db.yaml"), &statefulSet)
+	templates := statefulSet.Spec.VolumeClaimTemplates
+	if class := templates[0].Spec.StorageClassName; class == nil || *class != "longhorn" {
+		t.Errorf("expected gp2 to be rewritten to longhorn, got %v", class)
+	}
+	if class := templates[1].Spec.StorageClassName; class != nil {
+		t.Errorf("expected fast to be removed for the default class, got %s", *class)
+	}
+
+	var uploads claimSpec
+	readObject(t, filepath.Join(toolDir, "PersistentVolumeClaim_uploads.yaml"), &uploads)
+	if class := uploads.Spec.StorageClassName; class == nil || *class != "local-path" {
+		t.Errorf("expected the claim without a class to get local-path, got %v", class)
+	}
+
+	var legacy claimSpec
+	readObject(t, filepath.Join(toolDir, "PersistentVolumeClaim_legacy.yaml"), &legacy)
+	if class := legacy.Metadata.Annotations[annotationStorageClass]; class != "longhorn" {
+		t.Errorf("expected the annotation to be rewritten to longhorn, got %s", class)
+	}
+	if legacy.Spec.StorageClassName != nil {
+		t.Errorf("expected no storageClassName next to the annotation, got %s", *legacy.Spec.StorageClassName)
+	}
+
+	var static claimSpec
+	readObject(t, filepath.Join(toolDir, "PersistentVolumeClaim_static.yaml"), &static)
+	if class := static.Spec.StorageClassName; class == nil || *class != "" {
+		t.Errorf("expected the explicitly empty class of a statically bound claim to be kept, got %v", class)
+	}
+
+	var deployment struct {
+		Spec struct {
+			Template struct {
+				Spec struct {
+					Volumes []struct {
+						Ephemeral struct {
+							VolumeClaimTemplate claimSpec `yaml:"volumeClaimTemplate"`
+						} `yaml:"ephemeral"`
+					} `yaml:"volumes"`
+				} `yaml:"spec"`
+			} `yaml:"template"`
+		} `yaml:"spec"`
+	}
+	readObject(t, filepath.Join(toolDir, "Deployment_worker.yaml"), &deployment)
+	if class := deployment.Spec.Template.Spec.Volumes[0].Ephemeral.VolumeClaimTemplate.Spec.StorageClassName; class == nil || *class != "longhorn" {
+		t.Errorf("expected the ephemeral volume's gp2 to be rewritten to longhorn, got %v", class)
+	}
+
+	// The schema of a CRD describing a claim template is left alone
+	crd, err := os.ReadFile(filepath.Join(toolDir, "CustomResourceDefinition_stores.example.com.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(crd) != testStorageCRD {
+		t.Errorf("expected the CRD to be kept, got:\n%s", crd)
+	}
+}
@@ -0,0 +1,172 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// streamingThreshold is the size above which a document is not unmarshalled
+// as a whole. Large CRDs with multi-MB schemas allocate many times their size
+// when parsed into maps, so only their metadata is parsed and the body is
+// written through unchanged.
+const streamingThreshold = 1 << 20
+
+// crdHeaderIndent is the deepest indentation kept when reading the scope of a
+// large CRD, enough for spec.names.kind and spec.versions[].name but not the
+// schemas below them.
+const crdHeaderIndent = 6
+
+// splitDocuments splits a multi-document stream on its "---" separator lines,
+// without parsing the documents.
+func splitDocuments(data []byte) [][]byte {
+	var documents [][]byte
+	start := 0
+	for offset := 0; offset < len(data); {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += offset + 1
+		}
+		line := bytes.TrimRight(data[offset:end], " \r\n")
+		if bytes.Equal(line, []byte("---")) || bytes.HasPrefix(line, []byte("--- ")) {
+			documents = append(documents, data[start:offset])
+			start = end
+		}
+		offset = end
+	}
+	documents = append(documents, data[start:])
+
+	var nonEmpty [][]byte
+	for _, document := range documents {
+		if len(bytes.TrimSpace(document)) > 0 {
+			nonEmpty = append(nonEmpty, document)
+		}
+	}
+	return nonEmpty
+}
+
+// isTopLevelKey reports whether a line starts a key of the document's
+// top-level mapping.
+func isTopLevelKey(line string) bool {
+	if line == "" || line[0] == ' ' || line[0] == '-' || line[0] == '#' {
+		return false
+	}
+	return strings.Contains(line, ":")
+}
+
+// pruneDocument keeps the lines of a document indented by at most maxIndent,
+// which is enough to read shallow fields without parsing the deep ones.
+func pruneDocument(document []byte, maxIndent int) []byte {
+	var pruned bytes.Buffer
+	for _, line := range strings.Split(string(document), "\n") {
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if strings.TrimSpace(line) != "" && indent <= maxIndent {
+			pruned.WriteString(line + "\n")
+		}
+	}
+	return pruned.Bytes()
+}
+
+// learnLargeCRDScopes learns the scopes of a large CRD from its shallow
+// fields, falling back to parsing all of it if they can't be read alone.
+func learnLargeCRDScopes(document []byte) error {
+	if err := utils.LearnCRDScopes(pruneDocument(document, crdHeaderIndent)); err == nil {
+		return nil
+	}
+	return utils.LearnCRDScopes(document)
+}
+
+// transformLargeDocument sets the namespace and annotations of a large
+// document by rewriting its metadata block, leaving the rest of the text as
+// it is. It returns the object's metadata and the rewritten document.
+func transformLargeDocument(document []byte, config utils.Config, annotations map[string]string) (k8sObject, []byte, error) {
+	var object k8sObject
+	lines := strings.Split(strings.TrimRight(string(document), "\n"), "\n")
+
+	// Find the metadata block and the top-level scalars, leaving out the body
+	metadataStart, metadataEnd := -1, -1
+	var header strings.Builder
+	for i, line := range lines {
+		if !isTopLevelKey(line) {
+			continue
+		}
+		if metadataStart >= 0 && metadataEnd < 0 {
+			metadataEnd = i
+		}
+		if strings.HasPrefix(line, "metadata:") {
+			metadataStart = i
+		} else {
+			header.WriteString(line + "\n")
+		}
+	}
+	if metadataStart >= 0 && metadataEnd < 0 {
+		metadataEnd = len(lines)
+	}
+
+	var metadataBlock string
+	if metadataStart >= 0 {
+		metadataBlock = strings.Join(lines[metadataStart:metadataEnd], "\n") + "\n"
+	}
+	// Only the scalars kind and apiVersion are read from the header, other
+	// top-level keys are cut off from their values and parse as empty.
+	err := yaml.Unmarshal([]byte(pruneDocument([]byte(header.String()), 0)), &object)
+	if err != nil {
+		return object, nil, err
+	}
+	metadataMap := map[string]interface{}{}
+	err = yaml.Unmarshal([]byte(metadataBlock), &metadataMap)
+	if err != nil {
+		return object, nil, err
+	}
+	err = yaml.Unmarshal([]byte(metadataBlock), &object)
+	if err != nil {
+		return object, nil, err
+	}
+
+	if !utils.IsClusterScoped(object.Kind, object.APIVersion) && object.Metadata.Namespace == "" {
+		utils.ObjectMetadata(metadataMap)["namespace"] = config.Namespace
+	}
+	utils.StampAnnotations(metadataMap, annotations)
+	metadata, err := yaml.Marshal(metadataMap)
+	if err != nil {
+		return object, nil, err
+	}
+
+	var output bytes.Buffer
+	output.Grow(len(document) + len(metadata))
+	if metadataStart < 0 {
+		metadataStart, metadataEnd = len(lines), len(lines)
+	}
+	for _, line := range lines[:metadataStart] {
+		output.WriteString(line + "\n")
+	}
+	output.Write(metadata)
+	for _, line := range lines[metadataEnd:] {
+		output.WriteString(line + "\n")
+	}
+	if object.Kind == "" {
+		return object, nil, fmt.Errorf("document has no kind")
+	}
+	return object, output.Bytes(), nil
+}
@@ -0,0 +1,157 @@
+package smelter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// largeCRD returns a namespaced CRD whose schema is over streamingThreshold.
+func largeCRD() string {
+	var sb strings.Builder
+	sb.WriteString(`apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+  annotations:
+    description: |
+      A multi-line
+      annotation
+spec:
+  group: example.com
+  names:
+    kind: Widget
+    plural: widgets
+    shortNames: [wd]
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+`)
+	for i := 0; sb.Len() <= streamingThreshold; i++ {
+		fmt.Fprintf(&sb, "          field%d:\n            type: string\n            description: Field number %d of the widget\n", i, i)
+	}
+	return sb.String()
+}
+
+func TestSplitYAMLLargeDocument(t *testing.T) {
+	dir := t.TempDir()
+	rendered := filepath.Join(dir, "widgets.yaml")
+	manifests := largeCRD() + "---\napiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: small\n"
+	if err := os.WriteFile(rendered, []byte(manifests), 0644); err != nil {
+		t.Fatalf("Failed to write manifests: %v", err)
+	}
+
+	config := utils.Config{Name: "widgets", Namespace: "widgets", Filename: rendered}
+	if err := SplitYAML(config, dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The scope of the large CRD is learned from its shallow fields
+	var widget k8sObject
+	readObject(t, filepath.Join(dir, "widgets", "Widget_small.yaml"), &widget)
+	if widget.Metadata.Namespace != "widgets" {
+		t.Errorf("expected the namespaced Widget to get a namespace, got %q", widget.Metadata.Namespace)
+	}
+
+	// The large document gets the same metadata as through the full path
+	var streamed, parsed map[string]interface{}
+	readObject(t, filepath.Join(dir, "widgets", "CustomResourceDefinition_widgets.example.com.yaml"), &streamed)
+	cleaned, err := clean([]byte(largeCRD()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := transformLargeDocument(cleaned, config, nil); err != nil {
+		t.Fatalf("expected the large document to be streamed, got: %v", err)
+	}
+	_, full, err := transformDocument(cleaned, config, utils.RunAnnotations(config.Name, utils.SourceDigest([]byte(manifests))))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := yaml.Unmarshal(full, &parsed); err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	}
+	if !reflect.DeepEqual(streamed, parsed) {
+		t.Errorf("expected the streamed document to match the parsed one, got metadata %v, expected %v", streamed["metadata"], parsed["metadata"])
+	}
+}
+
+func TestSplitDocuments(t *testing.T) {
+	documents := splitDocuments([]byte("---\na: 1\n---\n\n--- # comment\nb: 2\n...\n---\n"))
+	if len(documents) != 2 {
+		t.Fatalf("expected 2 documents, got %d: %q", len(documents), documents)
+	}
+	if string(documents[0]) != "a: 1\n" {
+		t.Errorf("unexpected first document: %q", documents[0])
+	}
+}
+
+func TestStampObjectDigests(t *testing.T) {
+	dir := t.TempDir()
+	rendered := filepath.Join(dir, "widgets.yaml")
+	manifests := largeCRD() + "---\napiVersion: v1\nkind: PersistentVolumeClaim\nmetadata:\n  name: data\nspec:\n  storageClassName: standard\n"
+	if err := os.WriteFile(rendered, []byte(manifests), 0644); err != nil {
+		t.Fatalf("Failed to write manifests: %v", err)
+	}
+	config := utils.Config{Name: "widgets", Namespace: "widgets", Filename: rendered}
+	if err := SplitYAML(config, dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claimFile := filepath.Join(dir, "widgets", "PersistentVolumeClaim_data.yaml")
+	digest := func(file string) string {
+		var object k8sObject
+		readObject(t, file, &object)
+		return object.Metadata.Annotations[utils.AnnotationObjectDigest]
+	}
+
+	if err := stampObjectDigests(config, dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, file := range []string{claimFile, filepath.Join(dir, "widgets", "CustomResourceDefinition_widgets.example.com.yaml")} {
+		content, _ := os.ReadFile(file)
+		if got := digest(file); got != utils.ObjectDigest(content) {
+			t.Errorf("expected %s to carry its own digest, got %q", filepath.Base(file), got)
+		}
+	}
+
+	// Stamping again changes nothing, a transformation changes the digest
+	stamped := digest(claimFile)
+	if err := stampObjectDigests(config, dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if digest(claimFile) != stamped {
+		t.Errorf("expected stamping twice to keep the digest")
+	}
+	content, _ := os.ReadFile(claimFile)
+	if err := os.WriteFile(claimFile, []byte(strings.Replace(string(content), "standard", "fast", 1)), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := stampObjectDigests(config, dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if digest(claimFile) == stamped {
+		t.Errorf("expected the new storage class to change the digest")
+	}
+}
+
+func readObject(t *testing.T, filename string, object interface{}) {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", filename, err)
+	}
+	if err := yaml.Unmarshal(data, object); err != nil {
+		t.Fatalf("Failed to parse %s: %v", filename, err)
+	}
+}
@@ -0,0 +1,195 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// gatorBinary is Gatekeeper's CLI evaluating the policies, replaced in tests.
+var gatorBinary = "gator"
+
+// ValidationResult is what Validate found in the output of the tools.
+type ValidationResult struct {
+	// Errors are problems which would make the deploy fail or misbehave.
+	Errors []string
+	// Warnings are risky settings which deploy fine.
+	Warnings []string
+}
+
+// validatedObject is the first tool an object was found in, with its content
+// apart from annotations.
+type validatedObject struct {
+	tool    string
+	content []byte
+}
+
+// Validate smelts the target tools into runDir and checks their output
+// together, which catches what smelting one tool at a time can't, like two
+// tools writing different versions of one object. The working directory is
+// not touched. Errors of the smelt itself, e.g. values not matching the
+// chart's schema, are returned as err, after the checks of the tools which
+// did smelt. The objects are evaluated against the policies of the tools,
+// see checkPolicies.
+func Validate(configs []utils.Config, targetTools []string, runDir string) (ValidationResult, error) {
+	// The policies' ConstraintTemplates go to a tool being validated, rather
+	// than to a tool before it in config.yaml which is not
+	var targetConfigs []utils.Config
+	for _, config := range configs {
+		for _, tool := range targetTools {
+			if config.Name == tool {
+				targetConfigs = append(targetConfigs, config)
+			}
+		}
+	}
+	utils.RegisterPolicies(targetConfigs)
+	workingDir := filepath.Join(runDir, "validate")
+	smeltErr := PrepareTool(configs, targetTools, workingDir, filepath.Join(runDir, "validate-pre"))
+
+	var result ValidationResult
+	objects := map[string]validatedObject{}
+	for _, tool := range targetTools {
+		files, err := filepath.Glob(filepath.Join(workingDir, tool, "*.yaml"))
+		if err != nil {
+			return result, fmt.Errorf("failed to list the output of %s: %w", tool, err)
+		}
+		for _, file := range files {
+			if err := validateObject(tool, file, objects, &result); err != nil {
+				return result, err
+			}
+		}
+	}
+	if err := checkPolicies(targetConfigs, workingDir, &result); err != nil {
+		return result, err
+	}
+	return result, smeltErr
+}
+
+// checkPolicies evaluates the output of the tools against the constraints
+// of their policies with gator, as Gatekeeper would admit them, and records
+// each violation as an error. Constraints apply cluster-wide, so every tool's
+// objects are checked against the constraints of all tools. Without gator
+// installed the policies are not checked, which is a warning.
+func checkPolicies(configs []utils.Config, workingDir string, result *ValidationResult) error {
+	args := []string{"test"}
+	hasPolicies := false
+	for _, config := range configs {
+		toolDir := filepath.Join(workingDir, config.Name)
+		if _, err := os.Stat(toolDir); err != nil {
+			continue
+		}
+		args = append(args, "--filename", toolDir)
+		hasPolicies = hasPolicies || len(config.Policies) > 0
+	}
+	if !hasPolicies {
+		return nil
+	}
+	log.Debugf("Running %s %s", gatorBinary, strings.Join(args, " "))
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(gatorBinary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		result.Warnings = append(result.Warnings, "gator is not installed, the objects were not checked against the policies")
+		return nil
+	}
+	// gator exits with 1 both when objects violate constraints, listing one
+	// violation per line, and when it fails
+	violations := strings.TrimSpace(stdout.String())
+	var exitErr *exec.ExitError
+	if err != nil && (!errors.As(err, &exitErr) || exitErr.ExitCode() != 1 || violations == "") {
+		return utils.Errorf(utils.ValidationError, "failed to check the policies: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if err == nil {
+		return nil
+	}
+	for _, violation := range strings.Split(violations, "\n") {
+		if violation = strings.TrimSpace(violation); violation != "" {
+			result.Errors = append(result.Errors, "policy violation: "+violation)
+		}
+	}
+	return nil
+}
+
+// validateObject checks an object of the tool's output, and records it in
+// objects to find conflicts with the other tools.
+func validateObject(tool, file string, objects map[string]validatedObject, result *ValidationResult) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	var object map[string]interface{}
+	if err := yaml.Unmarshal(content, &object); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("%s: %s is not valid yaml: %v", tool, filepath.Base(file), err))
+		return nil
+	}
+	apiVersion, _ := object["apiVersion"].(string)
+	kind, _ := object["kind"].(string)
+	metadata := utils.ObjectMetadata(object)
+	name, _ := metadata["name"].(string)
+	namespace, _ := metadata["namespace"].(string)
+	if apiVersion == "" || kind == "" || name == "" {
+		result.Errors = append(result.Errors, fmt.Sprintf("%s: %s lacks an apiVersion, kind or name", tool, filepath.Base(file)))
+		return nil
+	}
+
+	// Tools may share objects like their Namespace, as long as they agree on
+	// them; the annotations name the tool, so they always differ
+	delete(metadata, "annotations")
+	stripped, err := yaml.Marshal(object)
+	if err != nil {
+		return utils.Errorf(utils.RenderError, "failed to write %s: %w", file, err)
+	}
+	group := ""
+	if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+		group = apiVersion[:i]
+	}
+	key := strings.Join([]string{group, kind, namespace, name}, "/")
+	description := kind + " " + name
+	if namespace != "" {
+		description = fmt.Sprintf("%s %s/%s", kind, namespace, name)
+	}
+	if previous, exists := objects[key]; exists {
+		if previous.tool != tool && !bytes.Equal(previous.content, stripped) {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s is written differently by %s and %s, the last deployed wins", description, previous.tool, tool))
+		}
+	} else {
+		objects[key] = validatedObject{tool: tool, content: stripped}
+	}
+
+	if kind == "ValidatingWebhookConfiguration" || kind == "MutatingWebhookConfiguration" {
+		webhooks, _ := object["webhooks"].([]interface{})
+		for _, item := range webhooks {
+			if webhook, ok := item.(map[interface{}]interface{}); ok && blocksSystemNamespace(webhook) {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %s webhook %v fails closed for all namespaces including %s", tool, description, webhook["name"], systemNamespace))
+			}
+		}
+	}
+	return nil
+}
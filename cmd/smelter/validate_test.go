@@ -0,0 +1,162 @@
+package smelter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+const testValidateSourceA = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: shared-reader
+rules:
+- apiGroups: [""]
+  resources: [configmaps]
+  verbs: [get]
+---
+apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: alpha
+webhooks:
+- name: check.alpha.example.com
+`
+
+const testValidateSourceB = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: shared-reader
+rules:
+- apiGroups: [""]
+  resources: [secrets]
+  verbs: [get]
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+`
+
+func TestValidate(t *testing.T) {
+	inputDir := t.TempDir()
+	utils.SetInputDirs([]string{inputDir})
+	defer utils.SetInputDirs([]string{"input"})
+	for name, content := range map[string]string{"alpha.yaml": testValidateSourceA, "beta.yaml": testValidateSourceB} {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Both tools share the namespace, which is fine as they agree on it
+	configs := []utils.Config{
+		{Name: "alpha", Namespace: "platform", SourceFile: "alpha.yaml"},
+		{Name: "beta", Namespace: "platform", SourceFile: "beta.yaml"},
+	}
+	result, err := Validate(configs, []string{"alpha", "beta"}, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0], "ClusterRole shared-reader is written differently by alpha and beta") {
+		t.Errorf("expected only the conflicting ClusterRole to be an error, got %v", result.Errors)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "check.alpha.example.com") {
+		t.Errorf("expected a warning about the webhook, got %v", result.Warnings)
+	}
+
+	configs = append(configs, utils.Config{Name: "broken", Namespace: "broken"})
+	_, err = Validate(configs, []string{"alpha", "broken"}, t.TempDir())
+	if err == nil || utils.ClassOf(err) != utils.ConfigError {
+		t.Errorf("expected the config error of the broken tool, got %v", err)
+	}
+}
+
+func TestValidatePolicies(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script standing in for gator")
+	}
+	defer func(binary string) { gatorBinary = binary }(gatorBinary)
+	inputDir := t.TempDir()
+	utils.SetInputDirs([]string{inputDir})
+	defer utils.SetInputDirs([]string{"input"})
+	defer utils.RegisterPolicies(nil)
+	policyDir := filepath.Join(inputDir, utils.PoliciesDir, "required-labels")
+	if err := os.MkdirAll(policyDir, 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files := map[string]string{
+		filepath.Join(policyDir, "policy.yaml"): testConstraintTemplate + "---\n" + testConstraint,
+		filepath.Join(inputDir, "beta.yaml"):    testValidateSourceB,
+	}
+	for file, content := range files {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// alpha ships the ConstraintTemplate in a full run, but is not validated
+	configs := []utils.Config{
+		{Name: "alpha", Namespace: "platform", SourceFile: "alpha.yaml", Policies: []string{"required-labels"}},
+		{Name: "beta", Namespace: "platform", SourceFile: "beta.yaml", Policies: []string{"required-labels"}},
+	}
+	utils.RegisterPolicies(configs)
+
+	tests := []struct {
+		name     string
+		exitCode int
+		output   string
+		errors   []string
+		wantErr  bool
+	}{
+		{"no violations", 0, "", nil, false},
+		{"violations", 1, "v1/ConfigMap settings: [beta-owner] Message: missing label owner", []string{"policy violation: v1/ConfigMap settings: [beta-owner] Message: missing label owner"}, false},
+		{"gator fails", 1, "", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scriptDir := t.TempDir()
+			gatorBinary = filepath.Join(scriptDir, "gator")
+			script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s\nprintf '%%s' '%s'\necho 'some error' >&2\nexit %d\n", filepath.Join(scriptDir, "args"), test.output, test.exitCode)
+			if err := os.WriteFile(gatorBinary, []byte(script), 0755); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			runDir := t.TempDir()
+			result, err := Validate(configs, []string{"beta"}, runDir)
+			if test.wantErr {
+				if utils.ClassOf(err) != utils.ValidationError {
+					t.Errorf("expected a validation error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(result.Errors, "\n") != strings.Join(test.errors, "\n") {
+				t.Errorf("expected errors %v, got %v", test.errors, result.Errors)
+			}
+			args, err := os.ReadFile(filepath.Join(scriptDir, "args"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := "test --filename " + filepath.Join(runDir, "validate", "beta") + "\n"; string(args) != expected {
+				t.Errorf("expected gator to be run as %q, got %q", expected, args)
+			}
+			if _, err := os.Stat(filepath.Join(runDir, "validate", "beta", "ConstraintTemplate_k8srequiredlabels.yaml")); err != nil {
+				t.Errorf("expected the validated tool to ship the ConstraintTemplate: %v", err)
+			}
+		})
+	}
+
+	gatorBinary = filepath.Join(t.TempDir(), "missing")
+	result, err := Validate(configs, []string{"beta"}, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "gator is not installed") {
+		t.Errorf("expected a warning that the policies were not checked, got %v", result.Warnings)
+	}
+}
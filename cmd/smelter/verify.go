@@ -0,0 +1,136 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/silogen/cluster-forge/cmd/snapshot"
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// VerifyReproducible smelts the target tools twice into separate directories
+// under runDir and compares the results. The settings of the config are
+// registered anew before each run, so the second run doesn't see what the
+// first one learned. It returns the files which differ between the two runs,
+// relative to the working directory.
+func VerifyReproducible(forgeConfig utils.ForgeConfig, targetTools []string, runDir string) ([]string, error) {
+	var workingDirs []string
+	for run := 1; run <= 2; run++ {
+		workingDir := filepath.Join(runDir, fmt.Sprintf("smelt-%d", run))
+		preDir := filepath.Join(runDir, fmt.Sprintf("pre-%d", run))
+		log.Infof("Smelting run %d of 2 into %s", run, workingDir)
+		if err := utils.RegisterSmeltConfig(forgeConfig); err != nil {
+			return nil, err
+		}
+		if err := PrepareTool(forgeConfig.Tools, targetTools, workingDir, preDir); err != nil {
+			return nil, fmt.Errorf("smelt run %d failed: %w", run, err)
+		}
+		workingDirs = append(workingDirs, workingDir)
+	}
+
+	return compareTrees(workingDirs[0], workingDirs[1])
+}
+
+// SnapshotTools smelts the target tools into runDir and compares each with
+// its snapshot in snapshotsDir/<tool>. With update set, the snapshots are
+// replaced by the new output. It returns the differences per tool, for the
+// tools which have any.
+func SnapshotTools(configs []utils.Config, targetTools []string, runDir string, snapshotsDir string, update bool) (map[string]snapshot.Diff, error) {
+	workingDir := filepath.Join(runDir, "snapshot")
+	if err := PrepareTool(configs, targetTools, workingDir, filepath.Join(runDir, "snapshot-pre")); err != nil {
+		return nil, err
+	}
+
+	diffs := map[string]snapshot.Diff{}
+	for _, tool := range targetTools {
+		toolDir := filepath.Join(workingDir, tool)
+		snapshotDir := filepath.Join(snapshotsDir, tool)
+		diff, err := snapshot.Compare(toolDir, snapshotDir)
+		if err != nil {
+			return nil, err
+		}
+		if diff.Empty() {
+			continue
+		}
+		diffs[tool] = diff
+		if update {
+			log.Infof("Updating snapshot %s", snapshotDir)
+			if err := snapshot.Update(toolDir, snapshotDir); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return diffs, nil
+}
+
+// compareTrees returns the relative paths of the files which differ between
+// two directories, or exist in only one of them, in sorted order.
+func compareTrees(first, second string) ([]string, error) {
+	firstFiles, err := readTree(first)
+	if err != nil {
+		return nil, err
+	}
+	secondFiles, err := readTree(second)
+	if err != nil {
+		return nil, err
+	}
+
+	var differences []string
+	for path, content := range firstFiles {
+		other, exists := secondFiles[path]
+		if !exists || !bytes.Equal(content, other) {
+			differences = append(differences, path)
+		}
+	}
+	for path := range secondFiles {
+		if _, exists := firstFiles[path]; !exists {
+			differences = append(differences, path)
+		}
+	}
+	sort.Strings(differences)
+	return differences, nil
+}
+
+func readTree(root string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relativePath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[relativePath] = content
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", root, err)
+	}
+	return files, nil
+}
@@ -0,0 +1,97 @@
+package smelter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+const testGadgetCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: gadgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Gadget
+    plural: gadgets
+  scope: Cluster
+  versions:
+    - name: v1
+`
+
+const testGadget = `apiVersion: example.com/v1
+kind: Gadget
+metadata:
+  name: sprocket
+`
+
+func TestVerifyReproducible(t *testing.T) {
+	inputDir := t.TempDir()
+	utils.SetInputDirs([]string{inputDir})
+	defer utils.SetInputDirs([]string{"input"})
+	defer utils.RegisterResourceScopes(nil)
+	files := map[string]string{
+		"gadget.yaml": testGadget,
+		"crd.yaml":    testGadgetCRD,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// The Gadget comes before its CRD, whose scope both runs learn before
+	// transforming any tool
+	forgeConfig := utils.ForgeConfig{Tools: []utils.Config{
+		{Name: "gadgets", Namespace: "gadgets", SourceFile: "gadget.yaml"},
+		{Name: "crds", Namespace: "crds", SourceFile: "crd.yaml"},
+	}}
+
+	runDir := t.TempDir()
+	differences, err := VerifyReproducible(forgeConfig, []string{"gadgets", "crds"}, runDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(differences) != 0 {
+		t.Errorf("expected both runs to give the same output, got differences in %v", differences)
+	}
+}
+
+func TestCompareTrees(t *testing.T) {
+	first := t.TempDir()
+	second := t.TempDir()
+	files := []struct {
+		root    string
+		path    string
+		content string
+	}{
+		{first, "alpha/same.yaml", "kind: ConfigMap\n"},
+		{second, "alpha/same.yaml", "kind: ConfigMap\n"},
+		// Annotations count like any other bytes
+		{first, "alpha/changed.yaml", "metadata:\n  annotations:\n    clusterforge.io/build-id: one\n"},
+		{second, "alpha/changed.yaml", "metadata:\n  annotations:\n    clusterforge.io/build-id: two\n"},
+		{first, "alpha/removed.yaml", "kind: Secret\n"},
+		{second, "beta/added.yaml", "kind: Secret\n"},
+	}
+	for _, file := range files {
+		path := filepath.Join(file.root, file.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(file.content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	differences, err := compareTrees(first, second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"alpha/changed.yaml", "alpha/removed.yaml", "beta/added.yaml"}
+	if strings.Join(differences, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected differences %v, got %v", expected, differences)
+	}
+}
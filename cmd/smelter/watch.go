@@ -0,0 +1,273 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// watchInterval is how often Watch looks for changed files.
+var watchInterval = time.Second
+
+// fingerprint identifies the state of a set of files by their sizes and
+// modification times.
+type fingerprint map[string]string
+
+// Watch smelts the target tools, then watches the config file and the input
+// files of the tools and smelts a tool again whenever its part of the config
+// or one of its files changes, until ctx is done. A change of the config's
+// global settings smelts all target tools again. load reads the config.
+//
+// Tools are only rendered again if their chart, values or source changed;
+// otherwise their last rendered manifests are transformed again, e.g. after
+// editing their policies.
+//
+// Failures of a round are printed and watching goes on, so the files can be
+// fixed and saved again.
+func Watch(ctx context.Context, configFile string, load func() (utils.ForgeConfig, error), targetTools []string, workingDir, preDir string) error {
+	forgeConfig, err := load()
+	if err != nil {
+		return err
+	}
+	configPrint := fingerprintFiles([]string{configFile})
+	renderPrints := map[string]fingerprint{}
+	transformPrints := map[string]fingerprint{}
+	for _, config := range forgeConfig.Tools {
+		renderPrints[config.Name] = fingerprintFiles(renderInputs(config))
+		transformPrints[config.Name] = fingerprintFiles(transformInputs(config))
+	}
+	smeltRound(forgeConfig, targetTools, nil, workingDir, preDir)
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		var changed []string
+		render := map[string]bool{}
+		if newPrint := fingerprintFiles([]string{configFile}); !newPrint.equal(configPrint) {
+			configPrint = newPrint
+			newConfig, err := load()
+			if err != nil {
+				fmt.Printf("Not smelting, %s is invalid: %v\n", configFile, err)
+				continue
+			}
+			changed, render = changedTools(forgeConfig, newConfig, targetTools)
+			forgeConfig = newConfig
+		}
+		reload := false
+		for _, config := range forgeConfig.Tools {
+			if newPrint := fingerprintFiles(renderInputs(config)); !newPrint.equal(renderPrints[config.Name]) {
+				log.Infof("Input files of %s changed", config.Name)
+				renderPrints[config.Name] = newPrint
+				changed = append(changed, config.Name)
+				render[config.Name] = true
+				// The tools of a helmfile are read with the config
+				reload = reload || len(config.HelmfileFiles) > 0
+			}
+			if newPrint := fingerprintFiles(transformInputs(config)); !newPrint.equal(transformPrints[config.Name]) {
+				log.Infof("Policies or certificates of %s changed", config.Name)
+				transformPrints[config.Name] = newPrint
+				changed = append(changed, config.Name)
+			}
+		}
+		if reload {
+			newConfig, err := load()
+			if err != nil {
+				fmt.Printf("Not smelting, the config is invalid: %v\n", err)
+				continue
+			}
+			forgeConfig = newConfig
+		}
+		changed = selectedTools(changed, targetTools)
+		if len(changed) > 0 {
+			smeltRound(forgeConfig, changed, render, workingDir, preDir)
+		}
+	}
+}
+
+// smeltRound smelts the tools and prints the outcome. The tools not in render
+// are transformed from the manifests they were last rendered into, if there
+// are any; a nil render renders all tools.
+func smeltRound(forgeConfig utils.ForgeConfig, tools []string, render map[string]bool, workingDir, preDir string) {
+	start := time.Now()
+	// Every round starts from the config alone, e.g. without the scopes of
+	// CRDs learned in earlier rounds
+	if err := utils.RegisterSmeltConfig(forgeConfig); err != nil {
+		fmt.Printf("Not smelting %s: %v\n", strings.Join(tools, ", "), err)
+		return
+	}
+	utils.ResetToolReports()
+	reuse := map[string]bool{}
+	for _, tool := range tools {
+		if render == nil || render[tool] {
+			continue
+		}
+		if _, err := os.Stat(renderedFile(tool, preDir)); err == nil {
+			reuse[tool] = true
+		}
+	}
+	// The lock file is saved even if a tool failed, as registering the
+	// config in the next round forgets the digests not saved
+	err := errors.Join(prepareTools(forgeConfig.Tools, tools, reuse, workingDir, preDir), utils.SaveLockFile())
+	if err != nil {
+		fmt.Printf("Smelting %s failed: %v\n", strings.Join(tools, ", "), err)
+		return
+	}
+	fmt.Printf("Smelted %s in %s, watching for changes\n", strings.Join(tools, ", "), time.Since(start).Round(time.Millisecond))
+}
+
+// changedTools returns the tools whose config differs, or all target tools
+// if the settings shared by all tools changed, and which of them have to be
+// rendered again.
+func changedTools(previous, current utils.ForgeConfig, targetTools []string) ([]string, map[string]bool) {
+	previousTools := map[string]utils.Config{}
+	for _, config := range previous.Tools {
+		previousTools[config.Name] = config
+	}
+	var changed []string
+	render := map[string]bool{}
+	for _, config := range current.Tools {
+		previousConfig, exists := previousTools[config.Name]
+		if !exists || toolYAML(renderSettings(previousConfig)) != toolYAML(renderSettings(config)) {
+			render[config.Name] = true
+		}
+		if !exists || toolYAML(previousConfig) != toolYAML(config) {
+			changed = append(changed, config.Name)
+		}
+	}
+	// The shared settings only apply to the rendered manifests
+	if toolYAML(sharedSettings(previous)) != toolYAML(sharedSettings(current)) {
+		return targetTools, render
+	}
+	return changed, render
+}
+
+// renderSettings returns the settings of a tool its rendered manifests
+// depend on, leaving out those applied to the rendered manifests.
+func renderSettings(config utils.Config) utils.Config {
+	config.Secrets = false
+	config.SecretFormat = ""
+	config.Policies = nil
+	config.WebhookCerts = nil
+	config.WebhookOverrides = nil
+	config.Profile = ""
+	return config
+}
+
+// sharedSettings returns the config without its tools and digest.
+func sharedSettings(forgeConfig utils.ForgeConfig) utils.ForgeConfig {
+	forgeConfig.Tools = nil
+	forgeConfig.Digest = ""
+	return forgeConfig
+}
+
+func toolYAML(value interface{}) string {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// selectedTools returns the tools which are target tools, without
+// duplicates, in the order of the target tools.
+func selectedTools(tools, targetTools []string) []string {
+	wanted := map[string]bool{}
+	for _, tool := range tools {
+		wanted[tool] = true
+	}
+	var selected []string
+	for _, tool := range targetTools {
+		if wanted[tool] {
+			selected = append(selected, tool)
+			wanted[tool] = false
+		}
+	}
+	return selected
+}
+
+// renderInputs returns the input files and directories the rendered
+// manifests of the tool depend on, besides the config.
+func renderInputs(config utils.Config) []string {
+	inputs := []string{utils.InputPath(config.Name)}
+	if config.SourceFile != "" {
+		inputs = append(inputs, utils.InputPath(config.SourceFile))
+	}
+	return append(inputs, config.HelmfileFiles...)
+}
+
+// transformInputs returns the input files and directories applied to the
+// rendered manifests of the tool.
+func transformInputs(config utils.Config) []string {
+	var inputs []string
+	for _, policy := range config.Policies {
+		inputs = append(inputs, utils.InputPath(filepath.Join(utils.PoliciesDir, policy)))
+	}
+	if config.WebhookCerts != nil && config.WebhookCerts.CABundle != "" {
+		inputs = append(inputs, utils.InputPath(config.WebhookCerts.CABundle))
+	}
+	return inputs
+}
+
+// fingerprintFiles fingerprints the files, and the files below the
+// directories, among paths. Missing paths are left out, so creating them
+// changes the fingerprint.
+func fingerprintFiles(paths []string) fingerprint {
+	files := fingerprint{}
+	for _, path := range paths {
+		_ = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			files[file] = fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+	}
+	return files
+}
+
+func (f fingerprint) equal(other fingerprint) bool {
+	if len(f) != len(other) {
+		return false
+	}
+	for file, state := range f {
+		if other[file] != state {
+			return false
+		}
+	}
+	return true
+}
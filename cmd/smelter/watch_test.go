@@ -0,0 +1,126 @@
+package smelter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+const testWatchConfig = `tools:
+  - name: alpha
+    namespace: alpha
+    sourcefile: alpha.yaml
+  - name: beta
+    namespace: beta
+    sourcefile: beta.yaml
+`
+
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", path)
+}
+
+func TestWatch(t *testing.T) {
+	inputDir := t.TempDir()
+	utils.SetInputDirs([]string{inputDir})
+	defer utils.SetInputDirs([]string{"input"})
+	defer func(interval time.Duration) { watchInterval = interval }(watchInterval)
+	watchInterval = 10 * time.Millisecond
+
+	configFile := filepath.Join(inputDir, "config.yaml")
+	files := map[string]string{
+		"config.yaml": testWatchConfig,
+		"alpha.yaml":  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: first\n",
+		"beta.yaml":   "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	load := func() (utils.ForgeConfig, error) {
+		return utils.LoadForgeConfig(configFile)
+	}
+
+	workingDir, preDir := t.TempDir(), t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, configFile, load, []string{"alpha", "beta"}, workingDir, preDir)
+	}()
+	waitForFile(t, filepath.Join(workingDir, "alpha", "ConfigMap_first.yaml"))
+	waitForFile(t, filepath.Join(workingDir, "beta", "ConfigMap_settings.yaml"))
+
+	// Editing the source of alpha smelts alpha only
+	betaFile := filepath.Join(workingDir, "beta", "ConfigMap_settings.yaml")
+	if err := os.Remove(betaFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	source := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: second\n"
+	if err := os.WriteFile(filepath.Join(inputDir, "alpha.yaml"), []byte(source), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitForFile(t, filepath.Join(workingDir, "alpha", "ConfigMap_second.yaml"))
+	if _, err := os.Stat(betaFile); err == nil {
+		t.Errorf("expected beta not to be smelted again")
+	}
+
+	// A shared setting smelts all tools again from their rendered manifests
+	rendered := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: rendered\n"
+	if err := os.WriteFile(filepath.Join(preDir, "beta.yaml"), []byte(rendered), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config := testWatchConfig + "storage-classes:\n  gp2: longhorn\n"
+	if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitForFile(t, filepath.Join(workingDir, "beta", "ConfigMap_rendered.yaml"))
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestChangedTools(t *testing.T) {
+	previous := utils.ForgeConfig{Tools: []utils.Config{
+		{Name: "alpha", Namespace: "alpha"},
+		{Name: "beta", Namespace: "beta"},
+	}, Digest: "one"}
+	current := utils.ForgeConfig{Tools: []utils.Config{
+		{Name: "alpha", Namespace: "alpha"},
+		{Name: "beta", Namespace: "beta-system"},
+		{Name: "gamma", Namespace: "gamma"},
+	}, Digest: "two"}
+	targetTools := []string{"alpha", "beta", "gamma"}
+
+	changed, render := changedTools(previous, current, targetTools)
+	if !reflect.DeepEqual(changed, []string{"beta", "gamma"}) {
+		t.Errorf("expected beta and gamma to change, got %v", changed)
+	}
+	if !reflect.DeepEqual(render, map[string]bool{"beta": true, "gamma": true}) {
+		t.Errorf("expected beta and gamma to be rendered again, got %v", render)
+	}
+
+	// Settings applied to the rendered manifests don't render them again
+	current.Tools[0].Policies = []string{"baseline"}
+	current.StorageClasses = map[string]string{"gp2": "longhorn"}
+	changed, render = changedTools(previous, current, targetTools)
+	if !reflect.DeepEqual(changed, targetTools) {
+		t.Errorf("expected a shared setting to change all tools, got %v", changed)
+	}
+	if render["alpha"] {
+		t.Errorf("expected alpha not to be rendered again")
+	}
+}
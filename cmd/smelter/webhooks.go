@@ -0,0 +1,296 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package smelter
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// annotationInjectCAFrom has cert-manager's cainjector copy the CA of a
+// Certificate into the object's webhook client configs.
+const annotationInjectCAFrom = "cert-manager.io/inject-ca-from"
+
+// webhookService is a Service serving webhooks.
+type webhookService struct {
+	name      string
+	namespace string
+}
+
+// wireWebhookCerts makes the API server trust the webhooks of the tool,
+// following its webhook-certs config. The webhook configurations and the
+// CRDs with conversion webhooks in the tool's working directory are updated
+// in place; with cert-manager, the Certificate for the webhook Services is
+// written next to them.
+func wireWebhookCerts(config utils.Config, workingDir string) error {
+	certs := config.WebhookCerts
+	if certs == nil {
+		return nil
+	}
+	var caBundle string
+	if certs.CABundle != "" {
+		ca, err := readCABundle(utils.InputPath(certs.CABundle))
+		if err != nil {
+			return utils.Errorf(utils.ConfigError, "invalid 'ca-bundle' of %s: %w", config.Name, err)
+		}
+		caBundle = ca
+	}
+	certificateName := config.Name + "-webhook"
+
+	toolDir := filepath.Join(workingDir, config.Name)
+	services := map[webhookService]bool{}
+	wire := func(file string, object map[string]interface{}) (bool, error) {
+		clientConfigs := webhookClientConfigs(object)
+		if len(clientConfigs) == 0 {
+			return false, nil
+		}
+		for _, clientConfig := range clientConfigs {
+			if caBundle != "" {
+				clientConfig["caBundle"] = caBundle
+			}
+			if service, ok := clientConfig["service"].(map[interface{}]interface{}); ok {
+				name, _ := service["name"].(string)
+				namespace, _ := service["namespace"].(string)
+				if namespace == "" {
+					namespace = config.Namespace
+				}
+				services[webhookService{name: name, namespace: namespace}] = true
+			}
+		}
+		if certs.CertManager != nil {
+			annotations, ok := utils.ObjectMetadata(object)["annotations"].(map[interface{}]interface{})
+			if !ok {
+				annotations = map[interface{}]interface{}{}
+				utils.ObjectMetadata(object)["annotations"] = annotations
+			}
+			annotations[annotationInjectCAFrom] = config.Namespace + "/" + certificateName
+		}
+		log.Debugf("Wired the webhook certificates of %s", file)
+		return true, nil
+	}
+	if err := updateObjects(toolDir, wire); err != nil {
+		return err
+	}
+	// updateObjects leaves out large CRDs, those with conversion webhooks
+	// are parsed all the same
+	crds, err := largeConversionWebhookCRDs(toolDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range crds {
+		if err := updateFile(file, wire); err != nil {
+			return err
+		}
+	}
+
+	if certs.CertManager == nil || len(services) == 0 {
+		return nil
+	}
+	return writeWebhookCertificate(config, certificateName, services, toolDir)
+}
+
+// updateObjects calls update with each object in the tool directory, and
+// writes back the objects it changed. Files above streamingThreshold are left
+// out, see updateFiles.
+func updateObjects(toolDir string, update func(file string, object map[string]interface{}) (bool, error)) error {
+	files, err := filepath.Glob(filepath.Join(toolDir, "*.yaml"))
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", toolDir, err)
+	}
+	return updateFiles(files, update)
+}
+
+// updateFiles is updateObjects for the given object files. Files above
+// streamingThreshold, in practice CRDs with multi-MB schemas, are left out,
+// as parsing them costs many times their size and the updates are about
+// workloads and webhooks; those needing them call updateFile.
+func updateFiles(files []string, update func(file string, object map[string]interface{}) (bool, error)) error {
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if info.Size() > streamingThreshold {
+			log.Debugf("Not parsing %s, it is too large", file)
+			continue
+		}
+		if err := updateFile(file, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateFile calls update with the object in file, and writes it back if it
+// changed.
+func updateFile(file string, update func(file string, object map[string]interface{}) (bool, error)) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	var object map[string]interface{}
+	if err := yaml.Unmarshal(content, &object); err != nil {
+		return utils.Errorf(utils.RenderError, "failed to parse %s: %w", file, err)
+	}
+	changed, err := update(file, object)
+	if err != nil || !changed {
+		return err
+	}
+	updated, err := yaml.Marshal(object)
+	if err != nil {
+		return utils.Errorf(utils.RenderError, "failed to write %s: %w", file, err)
+	}
+	if err := os.WriteFile(file, updated, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	return nil
+}
+
+// largeConversionWebhookCRDs returns the CRD files in toolDir above
+// streamingThreshold which have a conversion webhook, telling from their
+// shallow fields so their schemas aren't parsed.
+func largeConversionWebhookCRDs(toolDir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(toolDir, "CustomResourceDefinition_*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", toolDir, err)
+	}
+	var crds []string
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if info.Size() <= streamingThreshold {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		var crd struct {
+			Spec struct {
+				Conversion struct {
+					Strategy string `yaml:"strategy"`
+				} `yaml:"conversion"`
+			} `yaml:"spec"`
+		}
+		// A CRD whose shallow fields can't be read alone is parsed in full
+		err = yaml.Unmarshal(pruneDocument(content, 4), &crd)
+		if err != nil || crd.Spec.Conversion.Strategy == "Webhook" {
+			crds = append(crds, file)
+		}
+	}
+	return crds, nil
+}
+
+// webhookClientConfigs returns the client configs of the webhooks of an
+// admission webhook configuration, or of a CRD's conversion webhook.
+func webhookClientConfigs(object map[string]interface{}) []map[interface{}]interface{} {
+	var clientConfigs []map[interface{}]interface{}
+	switch object["kind"] {
+	case "ValidatingWebhookConfiguration", "MutatingWebhookConfiguration":
+		webhooks, _ := object["webhooks"].([]interface{})
+		for _, webhook := range webhooks {
+			webhookMap, _ := webhook.(map[interface{}]interface{})
+			if clientConfig, ok := webhookMap["clientConfig"].(map[interface{}]interface{}); ok {
+				clientConfigs = append(clientConfigs, clientConfig)
+			}
+		}
+	case "CustomResourceDefinition":
+		spec, _ := object["spec"].(map[interface{}]interface{})
+		conversion, _ := spec["conversion"].(map[interface{}]interface{})
+		if conversion["strategy"] != "Webhook" {
+			return nil
+		}
+		webhook, _ := conversion["webhook"].(map[interface{}]interface{})
+		if clientConfig, ok := webhook["clientConfig"].(map[interface{}]interface{}); ok {
+			clientConfigs = append(clientConfigs, clientConfig)
+		}
+	}
+	return clientConfigs
+}
+
+func readCABundle(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("%s is not a PEM encoded certificate", path)
+	}
+	return base64.StdEncoding.EncodeToString(content), nil
+}
+
+// writeWebhookCertificate writes the cert-manager Certificate for the
+// webhook Services, and the self-signed Issuer if no ClusterIssuer is set.
+func writeWebhookCertificate(config utils.Config, name string, services map[webhookService]bool, toolDir string) error {
+	var dnsNames []string
+	for service := range services {
+		dnsNames = append(dnsNames,
+			fmt.Sprintf("%s.%s.svc", service.name, service.namespace),
+			fmt.Sprintf("%s.%s.svc.cluster.local", service.name, service.namespace))
+	}
+	sort.Strings(dnsNames)
+
+	issuerRef := map[string]interface{}{"name": config.WebhookCerts.CertManager.Issuer, "kind": "ClusterIssuer"}
+	var objects []map[string]interface{}
+	if config.WebhookCerts.CertManager.Issuer == "" {
+		issuerName := name + "-selfsigned"
+		issuerRef = map[string]interface{}{"name": issuerName, "kind": "Issuer"}
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "cert-manager.io/v1",
+			"kind":       "Issuer",
+			"metadata":   map[string]interface{}{"name": issuerName, "namespace": config.Namespace},
+			"spec":       map[string]interface{}{"selfSigned": map[string]interface{}{}},
+		})
+	}
+	objects = append(objects, map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata":   map[string]interface{}{"name": name, "namespace": config.Namespace},
+		"spec": map[string]interface{}{
+			"secretName": config.WebhookCerts.CertManager.SecretName,
+			"dnsNames":   dnsNames,
+			"issuerRef":  issuerRef,
+		},
+	})
+
+	for _, object := range objects {
+		document, err := yaml.Marshal(object)
+		if err != nil {
+			return utils.Errorf(utils.RenderError, "failed to write the webhook certificate of %s: %w", config.Name, err)
+		}
+		metadataObject, updated, err := transformDocument(document, config, utils.RunAnnotations(config.Name, utils.SourceDigest(document)))
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(toolDir, fmt.Sprintf("%s_%s.yaml", metadataObject.Kind, metadataObject.Metadata.Name))
+		if err := os.WriteFile(filename, updated, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filename, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package smelter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+const testWebhookConfiguration = `apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: widgets
+webhooks:
+- name: validate.widgets.example.com
+  clientConfig:
+    service:
+      name: widgets-webhook
+      namespace: widgets
+      path: /validate
+`
+
+const testConversionCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  conversion:
+    strategy: Webhook
+    webhook:
+      clientConfig:
+        service:
+          name: widgets-webhook
+          namespace: widgets
+          path: /convert
+`
+
+// A self-signed certificate, only its PEM structure is checked
+const testCA = `-----BEGIN CERTIFICATE-----
+MIIBdzCCAR2gAwIBAgIUJ0b2dZ5Ck5p5V2nq6V8pC9Xw1ZcwCgYIKoZIzj0EAwIw
+-----END CERTIFICATE-----
+`
+
+func writeWebhookTool(t *testing.T) string {
+	t.Helper()
+	workingDir := t.TempDir()
+	toolDir := filepath.Join(workingDir, "widgets")
+	if err := os.MkdirAll(toolDir, 0755); err != nil {
+		t.Fatalf("Failed to create tool directory: %v", err)
+	}
+	files := map[string]string{
+		"ValidatingWebhookConfiguration_widgets.yaml":       testWebhookConfiguration,
+		"CustomResourceDefinition_widgets.example.com.yaml": testConversionCRD,
+		"Service_widgets-webhook.yaml":                      "apiVersion: v1\nkind: Service\nmetadata:\n  name: widgets-webhook\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(toolDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return workingDir
+}
+
+func TestWireWebhookCertsCertManager(t *testing.T) {
+	workingDir := writeWebhookTool(t)
+	config := utils.Config{
+		Name:         "widgets",
+		Namespace:    "widgets",
+		WebhookCerts: &utils.WebhookCerts{CertManager: &utils.CertManagerWebhookCerts{SecretName: "widgets-webhook-tls"}},
+	}
+	if err := wireWebhookCerts(config, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	toolDir := filepath.Join(workingDir, "widgets")
+	for _, file := range []string{"ValidatingWebhookConfiguration_widgets.yaml", "CustomResourceDefinition_widgets.example.com.yaml"} {
+		var object map[string]interface{}
+		readObject(t, filepath.Join(toolDir, file), &object)
+		metadata := utils.ObjectMetadata(object)
+		annotations, _ := metadata["annotations"].(map[interface{}]interface{})
+		if annotations[annotationInjectCAFrom] != "widgets/widgets-webhook" {
+			t.Errorf("expected %s to get the cainjector annotation, got %v", file, annotations)
+		}
+	}
+
+	certificate, err := os.ReadFile(filepath.Join(toolDir, "Certificate_widgets-webhook.yaml"))
+	if err != nil {
+		t.Fatalf("expected a Certificate: %v", err)
+	}
+	for _, expected := range []string{"secretName: widgets-webhook-tls", "- widgets-webhook.widgets.svc\n", "name: widgets-webhook-selfsigned"} {
+		if !strings.Contains(string(certificate), expected) {
+			t.Errorf("expected the Certificate to contain %q, got:\n%s", expected, certificate)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(toolDir, "Issuer_widgets-webhook-selfsigned.yaml")); err != nil {
+		t.Errorf("expected a self-signed Issuer: %v", err)
+	}
+}
+
+func TestWireWebhookCertsCABundle(t *testing.T) {
+	workingDir := writeWebhookTool(t)
+	inputDir := t.TempDir()
+	utils.SetInputDirs([]string{inputDir})
+	defer utils.SetInputDirs([]string{"input"})
+	if err := os.WriteFile(filepath.Join(inputDir, "ca.crt"), []byte(testCA), 0644); err != nil {
+		t.Fatalf("Failed to write CA: %v", err)
+	}
+
+	config := utils.Config{Name: "widgets", Namespace: "widgets", WebhookCerts: &utils.WebhookCerts{CABundle: "ca.crt"}}
+	if err := wireWebhookCerts(config, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	toolDir := filepath.Join(workingDir, "widgets")
+	var object map[string]interface{}
+	readObject(t, filepath.Join(toolDir, "ValidatingWebhookConfiguration_widgets.yaml"), &object)
+	webhooks := object["webhooks"].([]interface{})
+	clientConfig := webhooks[0].(map[interface{}]interface{})["clientConfig"].(map[interface{}]interface{})
+	if bundle, _ := clientConfig["caBundle"].(string); !strings.HasPrefix(bundle, "LS0tLS1CRUdJTi") {
+		t.Errorf("expected the base64 encoded CA as caBundle, got %v", clientConfig["caBundle"])
+	}
+	if _, err := os.Stat(filepath.Join(toolDir, "Certificate_widgets-webhook.yaml")); !os.IsNotExist(err) {
+		t.Errorf("expected no Certificate with a CA bundle")
+	}
+
+	if err := os.WriteFile(filepath.Join(inputDir, "ca.crt"), []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("Failed to write CA: %v", err)
+	}
+	if err := wireWebhookCerts(config, workingDir); utils.ClassOf(err) != utils.ConfigError {
+		t.Errorf("expected a config error for an invalid CA, got: %v", err)
+	}
+}
+
+func TestUpdateObjectsLargeFiles(t *testing.T) {
+	toolDir := t.TempDir()
+	files := map[string]string{
+		"CustomResourceDefinition_widgets.example.com.yaml": largeCRD(),
+		"ConfigMap_settings.yaml":                           "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(toolDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	var updated []string
+	err := updateObjects(toolDir, func(file string, object map[string]interface{}) (bool, error) {
+		updated = append(updated, filepath.Base(file))
+		return false, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(updated) != 1 || updated[0] != "ConfigMap_settings.yaml" {
+		t.Errorf("expected only the small object to be parsed, got %v", updated)
+	}
+}
+
+func TestWireWebhookCertsLargeCRD(t *testing.T) {
+	workingDir := writeWebhookTool(t)
+	inputDir := t.TempDir()
+	utils.SetInputDirs([]string{inputDir})
+	defer utils.SetInputDirs([]string{"input"})
+	if err := os.WriteFile(filepath.Join(inputDir, "ca.crt"), []byte(testCA), 0644); err != nil {
+		t.Fatalf("Failed to write CA: %v", err)
+	}
+	conversion := "spec:\n  conversion:\n    strategy: Webhook\n    webhook:\n      clientConfig:\n        service:\n          name: widgets-webhook\n          namespace: widgets\n"
+	crd := strings.Replace(largeCRD(), "spec:\n", conversion, 1)
+	crdFile := filepath.Join(workingDir, "widgets", "CustomResourceDefinition_widgets.example.com.yaml")
+	if err := os.WriteFile(crdFile, []byte(crd), 0644); err != nil {
+		t.Fatalf("Failed to write CRD: %v", err)
+	}
+
+	config := utils.Config{Name: "widgets", Namespace: "widgets", WebhookCerts: &utils.WebhookCerts{CABundle: "ca.crt"}}
+	if err := wireWebhookCerts(config, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var object struct {
+		Spec struct {
+			Conversion struct {
+				Webhook struct {
+					ClientConfig struct {
+						CABundle string `yaml:"caBundle"`
+					} `yaml:"clientConfig"`
+				} `yaml:"webhook"`
+			} `yaml:"conversion"`
+		} `yaml:"spec"`
+	}
+	readObject(t, crdFile, &object)
+	if bundle := object.Spec.Conversion.Webhook.ClientConfig.CABundle; !strings.HasPrefix(bundle, "LS0tLS1CRUdJTi") {
+		t.Errorf("expected the conversion webhook of the large CRD to get the CA bundle, got %q", bundle)
+	}
+}
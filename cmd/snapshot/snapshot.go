@@ -0,0 +1,163 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/silogen/cluster-forge/cmd/immutable"
+	"github.com/silogen/cluster-forge/cmd/rbac"
+	"github.com/silogen/cluster-forge/cmd/utils"
+)
+
+// volatileAnnotations change with every forge build or config change without
+// the objects themselves changing, so they are left out of snapshots.
+var volatileAnnotations = []string{utils.AnnotationVersion, utils.AnnotationBuildID}
+
+// Diff lists the files which differ between a directory and its snapshot,
+// relative to both.
+type Diff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+	// Recreate lists the changed objects which can't be updated in place.
+	Recreate []immutable.Change
+	// Permissions lists the RBAC permissions subjects gain or lose. They are
+	// not part of String, so they can be reported together for all tools.
+	Permissions []rbac.Change
+}
+
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d Diff) String() string {
+	var sb strings.Builder
+	for _, path := range d.Added {
+		fmt.Fprintf(&sb, "added:   %s\n", path)
+	}
+	for _, path := range d.Removed {
+		fmt.Fprintf(&sb, "removed: %s\n", path)
+	}
+	for _, path := range d.Changed {
+		fmt.Fprintf(&sb, "changed: %s\n", path)
+	}
+	for _, change := range d.Recreate {
+		fmt.Fprintf(&sb, "warning: %s\n", change)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// Compare compares the files in dir with the snapshot in snapshotDir. A
+// missing snapshot directory counts as empty.
+func Compare(dir, snapshotDir string) (Diff, error) {
+	var diff Diff
+	files, err := readTree(dir)
+	if err != nil {
+		return diff, err
+	}
+	snapshotFiles, err := readTree(snapshotDir)
+	if err != nil {
+		return diff, err
+	}
+
+	for path, content := range files {
+		expected, exists := snapshotFiles[path]
+		if !exists {
+			diff.Added = append(diff.Added, path)
+		} else if !bytes.Equal(content, expected) {
+			diff.Changed = append(diff.Changed, path)
+		}
+	}
+	for path := range snapshotFiles {
+		if _, exists := files[path]; !exists {
+			diff.Removed = append(diff.Removed, path)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	for _, path := range diff.Changed {
+		if change := immutable.Check(snapshotFiles[path], files[path]); change != nil {
+			diff.Recreate = append(diff.Recreate, *change)
+		}
+	}
+	if !diff.Empty() {
+		diff.Permissions = rbac.Compare(snapshotFiles, files)
+	}
+	return diff, nil
+}
+
+// Update replaces the snapshot in snapshotDir with the files in dir.
+func Update(dir, snapshotDir string) error {
+	files, err := readTree(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(snapshotDir); err != nil {
+		return fmt.Errorf("failed to remove old snapshot %s: %w", snapshotDir, err)
+	}
+	for path, content := range files {
+		snapshotPath := filepath.Join(snapshotDir, path)
+		if err := os.MkdirAll(filepath.Dir(snapshotPath), 0755); err != nil {
+			return fmt.Errorf("failed to create snapshot directory: %w", err)
+		}
+		if err := os.WriteFile(snapshotPath, content, 0644); err != nil {
+			return fmt.Errorf("failed to write snapshot %s: %w", snapshotPath, err)
+		}
+	}
+	return nil
+}
+
+// readTree reads the normalized content of all files below root, keyed by
+// their relative path. A missing root gives no files.
+func readTree(root string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return files, nil
+	}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relativePath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(relativePath)] = normalize(content)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", root, err)
+	}
+	return files, nil
+}
+
+// normalize drops the lines holding volatile annotations.
+func normalize(content []byte) []byte {
+	return utils.WithoutAnnotations(content, volatileAnnotations)
+}
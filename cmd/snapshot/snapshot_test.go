@@ -0,0 +1,109 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		fullPath := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output")
+	snapshotDir := filepath.Join(dir, "snapshot")
+
+	writeFiles(t, output, map[string]string{
+		"Deployment_a.yaml": "kind: Deployment\nmetadata:\n  annotations:\n    clusterforge.io/build-id: new\n",
+		"Service_b.yaml":    "kind: Service\nspec: changed\n",
+		"ConfigMap_c.yaml":  "kind: ConfigMap\n",
+	})
+	writeFiles(t, snapshotDir, map[string]string{
+		"Deployment_a.yaml": "kind: Deployment\nmetadata:\n  annotations:\n    clusterforge.io/build-id: old\n",
+		"Service_b.yaml":    "kind: Service\nspec: original\n",
+		"Secret_d.yaml":     "kind: Secret\n",
+	})
+
+	diff, err := Compare(output, snapshotDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(diff.Added) != 1 || diff.Added[0] != "ConfigMap_c.yaml" {
+		t.Errorf("expected ConfigMap_c.yaml to be added, got %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != "Secret_d.yaml" {
+		t.Errorf("expected Secret_d.yaml to be removed, got %v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0] != "Service_b.yaml" {
+		t.Errorf("expected only Service_b.yaml to change, the build id is volatile, got %v", diff.Changed)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output")
+	snapshotDir := filepath.Join(dir, "snapshot")
+
+	writeFiles(t, output, map[string]string{"tool/Service_a.yaml": "kind: Service\n"})
+	writeFiles(t, snapshotDir, map[string]string{"stale.yaml": "kind: Secret\n"})
+
+	if err := Update(output, snapshotDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	diff, err := Compare(output, snapshotDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !diff.Empty() {
+		t.Errorf("expected the output to match the updated snapshot, got:\n%s", diff)
+	}
+}
+
+func TestCompareMissingSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"Service_a.yaml": "kind: Service\n"})
+
+	diff, err := Compare(dir, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(diff.Added) != 1 {
+		t.Errorf("expected all files to be added, got %v", diff)
+	}
+}
+
+func TestCompareImmutableChange(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output")
+	snapshotDir := filepath.Join(dir, "snapshot")
+
+	writeFiles(t, output, map[string]string{
+		"Deployment_a.yaml": "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: a\nspec:\n  selector:\n    matchLabels:\n      app: new\n",
+	})
+	writeFiles(t, snapshotDir, map[string]string{
+		"Deployment_a.yaml": "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: a\nspec:\n  selector:\n    matchLabels:\n      app: old\n",
+	})
+
+	diff, err := Compare(output, snapshotDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(diff.Recreate) != 1 || diff.Recreate[0].Name != "a" {
+		t.Fatalf("expected Deployment a to need a recreation, got %v", diff.Recreate)
+	}
+	if !strings.Contains(diff.String(), "warning: Deployment a must be recreated") {
+		t.Errorf("expected the diff to warn about the recreation, got:\n%s", diff)
+	}
+}
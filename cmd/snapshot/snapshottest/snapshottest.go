@@ -0,0 +1,50 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+// Package snapshottest compares the output of Go tests with snapshots, kept
+// apart from package snapshot so the forge binary doesn't link testing.
+package snapshottest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/silogen/cluster-forge/cmd/snapshot"
+)
+
+// UpdateEnv is the environment variable which makes AssertMatches record new
+// snapshots instead of failing.
+const UpdateEnv = "UPDATE_SNAPSHOTS"
+
+// AssertMatches fails the test if the files in dir differ from the snapshot
+// in snapshotDir. With UPDATE_SNAPSHOTS=1 it records them as the new
+// snapshot instead, to be reviewed with git diff.
+func AssertMatches(t testing.TB, dir, snapshotDir string) {
+	t.Helper()
+	if os.Getenv(UpdateEnv) == "1" {
+		if err := snapshot.Update(dir, snapshotDir); err != nil {
+			t.Fatalf("Failed to update snapshot: %v", err)
+		}
+		return
+	}
+	diff, err := snapshot.Compare(dir, snapshotDir)
+	if err != nil {
+		t.Fatalf("Failed to compare with snapshot: %v", err)
+	}
+	if !diff.Empty() {
+		t.Errorf("output differs from snapshot %s, rerun with %s=1 to accept:\n%s", snapshotDir, UpdateEnv, diff)
+	}
+}
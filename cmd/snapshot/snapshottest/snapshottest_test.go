@@ -0,0 +1,24 @@
+package snapshottest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssertMatches(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output")
+	snapshotDir := filepath.Join(dir, "snapshot")
+	if err := os.MkdirAll(output, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(output, "Service_a.yaml"), []byte("kind: Service\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	t.Setenv(UpdateEnv, "1")
+	AssertMatches(t, output, snapshotDir)
+	t.Setenv(UpdateEnv, "")
+	AssertMatches(t, output, snapshotDir)
+}
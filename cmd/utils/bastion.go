@@ -0,0 +1,256 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Bastion is an SSH jump host through which the cluster's API server is
+// reached, set in the bastion section of config.yaml.
+type Bastion struct {
+	// Host is the bastion's address, with an optional port, e.g. bastion.example.com:2222.
+	Host string `yaml:"host"`
+	User string `yaml:"user"`
+	// Key is the private key to log in with. Without one, ssh uses its
+	// agent and default keys.
+	Key string `yaml:"key"`
+	// KnownHosts is the known_hosts file to check the bastion's host key
+	// against. Without one, ssh uses its default.
+	KnownHosts string `yaml:"known-hosts"`
+}
+
+// Tunnel is an SSH connection to a bastion which forwards the connections of
+// this process and the kubectl and helm commands it runs.
+type Tunnel struct {
+	cmd      *exec.Cmd
+	done     chan error
+	listener net.Listener
+	// controlDir holds the control socket of the SSH connection.
+	controlDir string
+	bastion    Bastion
+	// environment holds the proxy variables from before the tunnel, which
+	// Close restores.
+	environment map[string]*string
+	// ProxyURL is the HTTP proxy of the tunnel.
+	ProxyURL string
+}
+
+// proxyVariables are pointed at the tunnel while it is open.
+var proxyVariables = []string{"HTTPS_PROXY", "https_proxy"}
+
+func validateBastion(bastion *Bastion) error {
+	if bastion == nil {
+		return nil
+	}
+	if bastion.Host == "" {
+		return fmt.Errorf("missing 'host' in bastion")
+	}
+	if bastion.User == "" {
+		return fmt.Errorf("missing 'user' in bastion")
+	}
+	return nil
+}
+
+// sshArgs returns the arguments of an ssh command connecting to the
+// bastion as the master of the given control socket, which forwards the
+// tunnel's connections.
+func (b Bastion) sshArgs(controlPath string) []string {
+	args := []string{
+		"-N",
+		"-M", "-S", controlPath,
+		"-o", "BatchMode=yes",
+		"-o", "ServerAliveInterval=30",
+	}
+	host, port, err := net.SplitHostPort(b.Host)
+	if err != nil {
+		host = b.Host
+	} else {
+		args = append(args, "-p", port)
+	}
+	if b.Key != "" {
+		args = append(args, "-i", expandHome(b.Key))
+	}
+	if b.KnownHosts != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+expandHome(b.KnownHosts), "-o", "StrictHostKeyChecking=yes")
+	}
+	return append(args, b.User+"@"+host)
+}
+
+// forwardArgs returns the arguments of an ssh command forwarding its
+// standard input and output to address, over the master connection.
+func (b Bastion) forwardArgs(controlPath, address string) []string {
+	host, _, err := net.SplitHostPort(b.Host)
+	if err != nil {
+		host = b.Host
+	}
+	return []string{"-S", controlPath, "-W", address, b.User + "@" + host}
+}
+
+// OpenTunnel connects to the bastion and routes the cluster connections of
+// this run through it, by pointing HTTPS_PROXY at an HTTP proxy which
+// forwards each connection through the bastion. This has to happen before
+// the first connection is made. Close the tunnel when done.
+func OpenTunnel(bastion Bastion) (*Tunnel, error) {
+	// The proxy listens before ssh starts, so no other process can take its
+	// port in between
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the SSH tunnel: %w", err)
+	}
+	controlDir, err := os.MkdirTemp("", "forge-bastion-")
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to create the SSH control directory: %w", err)
+	}
+	controlPath := filepath.Join(controlDir, "control")
+	cmd := exec.Command("ssh", bastion.sshArgs(controlPath)...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		listener.Close()
+		os.RemoveAll(controlDir)
+		return nil, Errorf(ConfigError, "failed to start ssh for bastion %s: %w", bastion.Host, err)
+	}
+	tunnel := &Tunnel{
+		cmd:        cmd,
+		done:       make(chan error, 1),
+		listener:   listener,
+		controlDir: controlDir,
+		bastion:    bastion,
+		ProxyURL:   "http://" + listener.Addr().String(),
+	}
+	go func() { tunnel.done <- cmd.Wait() }()
+
+	// Wait until ssh has connected, which it shows by creating the control
+	// socket, or gives up
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		select {
+		case err := <-tunnel.done:
+			tunnel.done <- err
+			tunnel.Close()
+			return nil, Errorf(ConfigError, "failed to connect to bastion %s@%s: %v: %s", bastion.User, bastion.Host, err, strings.TrimSpace(stderr.String()))
+		default:
+		}
+		if _, err := os.Stat(controlPath); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			tunnel.Close()
+			return nil, Errorf(ConfigError, "timed out connecting to bastion %s@%s", bastion.User, bastion.Host)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	go tunnel.serve(controlPath)
+
+	tunnel.environment = map[string]*string{}
+	for _, variable := range proxyVariables {
+		if value, exists := os.LookupEnv(variable); exists {
+			tunnel.environment[variable] = &value
+		} else {
+			tunnel.environment[variable] = nil
+		}
+		os.Setenv(variable, tunnel.ProxyURL)
+	}
+	log.Infof("Connecting to the cluster through bastion %s@%s", bastion.User, bastion.Host)
+	return tunnel, nil
+}
+
+// serve accepts the proxy's connections until the tunnel is closed.
+func (t *Tunnel) serve(controlPath string) {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(conn, controlPath)
+	}
+}
+
+// forward reads the CONNECT request of a proxy connection and forwards the
+// connection to the address asked for through the bastion.
+func (t *Tunnel) forward(conn net.Conn, controlPath string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		return
+	}
+	if request.Method != http.MethodConnect {
+		fmt.Fprint(conn, "HTTP/1.1 405 Method Not Allowed\r\nConnection: close\r\n\r\n")
+		return
+	}
+	cmd := exec.Command("ssh", t.bastion.forwardArgs(controlPath, request.Host)...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		log.Debugf("Failed to forward a connection to %s: %v", request.Host, err)
+		fmt.Fprint(conn, "HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n")
+		return
+	}
+	fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go func() {
+		_, _ = io.Copy(stdin, reader)
+		stdin.Close()
+	}()
+	_, _ = io.Copy(conn, stdout)
+	_ = cmd.Wait()
+}
+
+// Close stops the tunnel and restores the proxy variables.
+func (t *Tunnel) Close() {
+	t.listener.Close()
+	if t.cmd.Process != nil {
+		_ = t.cmd.Process.Kill()
+		<-t.done
+	}
+	os.RemoveAll(t.controlDir)
+	for variable, value := range t.environment {
+		if value != nil {
+			os.Setenv(variable, *value)
+		} else {
+			os.Unsetenv(variable)
+		}
+	}
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
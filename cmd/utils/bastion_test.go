@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadForgeConfigBastion(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := `
+bastion:
+  host: bastion.example.com:2222
+  user: ops
+  key: ~/.ssh/id_ed25519
+tools:
+- name: test
+  namespace: test
+  manifest-url: https://example.com/manifest.yaml
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	forgeConfig, err := LoadForgeConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if forgeConfig.Bastion == nil || forgeConfig.Bastion.User != "ops" {
+		t.Fatalf("unexpected bastion: %+v", forgeConfig.Bastion)
+	}
+
+	args := strings.Join(forgeConfig.Bastion.sshArgs("/tmp/control"), " ")
+	home, _ := os.UserHomeDir()
+	for _, expected := range []string{"-M -S /tmp/control", "-p 2222", "-i " + filepath.Join(home, ".ssh/id_ed25519"), "ops@bastion.example.com"} {
+		if !strings.Contains(args, expected) {
+			t.Errorf("expected ssh arguments to contain %q, got: %s", expected, args)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("bastion:\n  host: bastion.example.com\ntools: []\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	_, err = LoadForgeConfig(path)
+	if ClassOf(err) != ConfigError {
+		t.Errorf("expected a config error for a bastion without a user, got: %v", err)
+	}
+}
+
+func TestOpenTunnelFailure(t *testing.T) {
+	// An ssh which fails to connect
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho 'Permission denied (publickey).' >&2\nexit 255\n"
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write ssh: %v", err)
+	}
+	t.Setenv("PATH", bin)
+
+	_, err := OpenTunnel(Bastion{Host: "bastion.example.com", User: "ops"})
+	if ClassOf(err) != ConfigError || !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("expected a config error with the ssh output, got: %v", err)
+	}
+	if os.Getenv("HTTPS_PROXY") != "" {
+		t.Errorf("expected HTTPS_PROXY not to be set by a failed tunnel")
+	}
+}
+
+func TestOpenTunnel(t *testing.T) {
+	// An ssh whose master creates the control socket, and which echoes the
+	// forwarded connections with their address
+	bin := t.TempDir()
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -S) control=$2; shift ;;
+    -W) echo "forwarded to $2"; exec cat ;;
+  esac
+  shift
+done
+touch "$control"
+exec sleep 60
+`
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write ssh: %v", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+	os.Unsetenv("https_proxy")
+
+	tunnel, err := OpenTunnel(Bastion{Host: "bastion.example.com", User: "ops"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if os.Getenv("HTTPS_PROXY") != tunnel.ProxyURL || os.Getenv("https_proxy") != tunnel.ProxyURL {
+		t.Errorf("expected the proxy variables to point at %s", tunnel.ProxyURL)
+	}
+	conn, err := net.Dial("tcp", strings.TrimPrefix(tunnel.ProxyURL, "http://"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "CONNECT api.example.com:6443 HTTP/1.1\r\nHost: api.example.com:6443\r\n\r\nping\n")
+	reader := bufio.NewReader(conn)
+	var lines []string
+	for len(lines) < 4 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error after %v: %v", lines, err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	expected := []string{"HTTP/1.1 200 Connection established", "", "forwarded to api.example.com:6443", "ping"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+
+	tunnel.Close()
+	if os.Getenv("HTTPS_PROXY") != "http://proxy.example.com:3128" {
+		t.Errorf("expected HTTPS_PROXY to be restored, got %s", os.Getenv("HTTPS_PROXY"))
+	}
+	if _, exists := os.LookupEnv("https_proxy"); exists {
+		t.Errorf("expected https_proxy to be unset again")
+	}
+}
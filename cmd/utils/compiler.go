@@ -53,6 +53,9 @@ type platformpackage struct {
 	Content bytes.Buffer
 	Index   int
 	Type    string
+	// DependsOn are the objects which have to exist before the manifest is
+	// applied.
+	DependsOn []dependency
 }
 
 func shouldSkipFile(file os.DirEntry, dirPath string) bool {
@@ -79,10 +82,10 @@ func CreateCrossplaneObject(config Config, filesDir string, workingDir string) e
 	const maxFileSize = 300 * 1024 // 300KB
 
 	if config.HelmURL == "" && config.SourceFile == "" && config.ManifestURL == "" {
-		return fmt.Errorf("config '%s' is invalid: at least one of HelmURL, SourceFile, or ManifestURL must be provided", config.Name)
+		return Errorf(ConfigError, "config '%s' is invalid: at least one of HelmURL, SourceFile, or ManifestURL must be provided", config.Name)
 	}
 	if config.Namespace == "" {
-		return fmt.Errorf("config '%s' is invalid: Namespace must not be empty", config.Name)
+		return Errorf(ConfigError, "config '%s' is invalid: Namespace must not be empty", config.Name)
 	}
 
 	platformpackage := new(platformpackage)
@@ -98,38 +101,48 @@ func CreateCrossplaneObject(config Config, filesDir string, workingDir string) e
 		}
 	}
 
-	objectFileIndex, namespaceFileIndex, crdFileIndex, secretFileIndex, externalsecretFileIndex := 1, 1, 1, 1, 1
+	objectFileIndex, namespaceFileIndex, crdFileIndex, secretFileIndex, externalsecretFileIndex, policyFileIndex := 1, 1, 1, 1, 1, 1
 	objectFile, err := createNewFile(platformpackage.Name, "object", objectFileIndex)
 	if err != nil {
-		log.Fatalln(err)
+		return Errorf(RenderError, "failed to create output file: %w", err)
 	}
 	defer objectFile.Close()
 
 	crdFile, err := createNewFile(platformpackage.Name, "crd", crdFileIndex)
 	if err != nil {
-		log.Fatalln(err)
+		return Errorf(RenderError, "failed to create output file: %w", err)
 	}
 	defer crdFile.Close()
 
 	namespaceFile, err := createNewFile(platformpackage.Name, "namespace", namespaceFileIndex)
 	if err != nil {
-		log.Fatalln(err)
+		return Errorf(RenderError, "failed to create output file: %w", err)
 	}
 	defer namespaceFile.Close()
 
 	secretFile, err := createNewFile(platformpackage.Name, "secret", secretFileIndex)
 	if err != nil {
-		log.Fatalln(err)
+		return Errorf(RenderError, "failed to create output file: %w", err)
 	}
 	defer secretFile.Close()
 
 	externalSecretFile, err := createNewFile(platformpackage.Name, "externalsecret", externalsecretFileIndex)
 	if err != nil {
-		log.Fatalln(err)
+		return Errorf(RenderError, "failed to create output file: %w", err)
 	}
 	defer externalSecretFile.Close()
 
+	policyFile, err := createNewFile(platformpackage.Name, "policy", policyFileIndex)
+	if err != nil {
+		return Errorf(RenderError, "failed to create output file: %w", err)
+	}
+	defer policyFile.Close()
+
 	files, _ := os.ReadDir(filepath.Join(workingDir, platformpackage.Name))
+	constraints, err := toolConstraints(filepath.Join(workingDir, platformpackage.Name), files)
+	if err != nil {
+		return err
+	}
 	for _, file := range files {
 		if shouldSkipFile(file, filepath.Join(workingDir, platformpackage.Name)) {
 			continue
@@ -138,7 +151,7 @@ func CreateCrossplaneObject(config Config, filesDir string, workingDir string) e
 		platformpackage.Kind = strings.TrimSuffix(platformpackage.Kind, ".yaml")
 		content, err := os.ReadFile(filepath.Join(workingDir, platformpackage.Name+"/"+file.Name()))
 		if err != nil {
-			log.Fatalln(err)
+			return Errorf(RenderError, "failed to read %s: %w", file.Name(), err)
 		}
 		lines := strings.Split(string(content), "\n")
 		for _, line := range lines {
@@ -146,12 +159,22 @@ func CreateCrossplaneObject(config Config, filesDir string, workingDir string) e
 			platformpackage.Content.WriteString(fmt.Sprintf("      %s\n", line))
 		}
 
+		header := readManifestHeader(content)
+		platformpackage.DependsOn = policyDependencies(header, constraints)
+
 		var currentFile *os.File
 		var currentFileSize int64
 		var currentFileIndex *int
 		var currentFileType string
 
 		switch {
+		// Constraint kinds are chosen freely, so policies are recognized by
+		// their API group before matching on the kind
+		case IsPolicy(header.APIVersion):
+			currentFile = policyFile
+			currentFileSize, _ = policyFile.Seek(0, os.SEEK_END)
+			currentFileIndex = &policyFileIndex
+			currentFileType = "policy"
 		case strings.Contains(platformpackage.Kind, "CustomResourceDefinition"):
 			currentFile = crdFile
 			currentFileSize, _ = crdFile.Seek(0, os.SEEK_END)
@@ -189,7 +212,7 @@ func CreateCrossplaneObject(config Config, filesDir string, workingDir string) e
 			*currentFileIndex++
 			currentFile, err = createNewFile(platformpackage.Name, currentFileType, *currentFileIndex)
 			if err != nil {
-				log.Fatalln(err)
+				return Errorf(RenderError, "failed to create output file: %w", err)
 			}
 			defer currentFile.Close() // Ensure the new file is closed after use
 			// Write the header to the file
@@ -200,7 +223,7 @@ func CreateCrossplaneObject(config Config, filesDir string, workingDir string) e
 		platformpackage.Type = strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(strings.TrimSuffix(file.Name(), ".yaml"), "_", "-"), ":", ""))
 		err = temp.Execute(currentFile, platformpackage)
 		if err != nil {
-			log.Fatalln(err)
+			return Errorf(RenderError, "failed to render %s: %w", file.Name(), err)
 		}
 		platformpackage.Content.Reset()
 	}
@@ -210,15 +233,38 @@ func CreateCrossplaneObject(config Config, filesDir string, workingDir string) e
 	crdFile.Close()
 	secretFile.Close()
 	externalSecretFile.Close()
+	policyFile.Close()
 	removeEmptyLines(objectFile.Name())
 	removeEmptyLines(crdFile.Name())
 	removeEmptyLines(secretFile.Name())
 	removeEmptyLines(externalSecretFile.Name())
+	removeEmptyLines(policyFile.Name())
 
 	return nil
 
 }
 
+// toolConstraints returns the Gatekeeper constraints among a tool's files,
+// which its other objects wait for. CRDs are left unread, as they can be
+// large and are never constraints.
+func toolConstraints(toolDir string, files []os.DirEntry) ([]dependency, error) {
+	var constraints []dependency
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), "CustomResourceDefinition_") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(toolDir, file.Name()))
+		if err != nil {
+			return nil, Errorf(RenderError, "failed to read %s: %w", file.Name(), err)
+		}
+		header := readManifestHeader(content)
+		if apiGroup(header.APIVersion) == GatekeeperConstraintsGroup {
+			constraints = append(constraints, dependency{APIVersion: header.APIVersion, Kind: header.Kind, Name: header.Metadata.Name})
+		}
+	}
+	return constraints, nil
+}
+
 func removeEmptyLines(filename string) error {
 	// Read the file
 	data, err := os.ReadFile(filename)
@@ -0,0 +1,139 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// LockFile records what smelt resolved, so later runs give the same output.
+type LockFile struct {
+	// Images maps image references to the digests they were pinned to.
+	Images map[string]string `yaml:"images"`
+}
+
+// DigestResolver looks up the current digest of an image reference.
+type DigestResolver interface {
+	Digest(image string) (string, error)
+}
+
+type dockerDigestResolver struct{}
+
+func (dockerDigestResolver) Digest(image string) (string, error) {
+	output, err := exec.Command("docker", "buildx", "imagetools", "inspect", image, "--format", "{{.Manifest.Digest}}").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", strings.TrimSpace(string(output)), err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+type digestPinning struct {
+	path     string
+	lock     LockFile
+	changed  bool
+	resolver DigestResolver
+}
+
+// imagePinning holds the lock file while digest pinning is enabled.
+var imagePinning *digestPinning
+
+// EnableDigestPinning makes PinImage pin images to digests, reusing the
+// digests recorded in the lock file at path and resolving new images with
+// resolver, or docker if it is nil.
+func EnableDigestPinning(path string, resolver DigestResolver) error {
+	if resolver == nil {
+		resolver = dockerDigestResolver{}
+	}
+	imagePinning = &digestPinning{path: path, resolver: resolver}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read lock file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &imagePinning.lock); err != nil {
+		return Errorf(ConfigError, "invalid lock file %s: %w", path, err)
+	}
+	if imagePinning.lock.Images == nil {
+		imagePinning.lock.Images = map[string]string{}
+	}
+	return nil
+}
+
+// ReloadDigestPinning re-reads the lock file, if digest pinning is enabled,
+// forgetting the digests resolved since it was last read or saved.
+func ReloadDigestPinning() error {
+	if imagePinning == nil {
+		return nil
+	}
+	return EnableDigestPinning(imagePinning.path, imagePinning.resolver)
+}
+
+// DigestPinningEnabled reports whether PinImage pins images.
+func DigestPinningEnabled() bool {
+	return imagePinning != nil
+}
+
+// DisableDigestPinning leaves image references unchanged again.
+func DisableDigestPinning() {
+	imagePinning = nil
+}
+
+// PinImage returns the image reference pinned to its digest, as
+// image:tag@sha256:..., if digest pinning is enabled. References which
+// already have a digest are kept.
+func PinImage(image string) (string, error) {
+	if imagePinning == nil || image == "" || strings.Contains(image, "@") {
+		return image, nil
+	}
+	digest, exists := imagePinning.lock.Images[image]
+	if !exists {
+		var err error
+		digest, err = imagePinning.resolver.Digest(image)
+		if err != nil {
+			return "", Errorf(FetchError, "failed to resolve the digest of %s: %w", image, err)
+		}
+		if !strings.HasPrefix(digest, "sha256:") {
+			return "", Errorf(FetchError, "unexpected digest '%s' for %s", digest, image)
+		}
+		log.Infof("Pinned %s to %s", image, digest)
+		imagePinning.lock.Images[image] = digest
+		imagePinning.changed = true
+	}
+	return image + "@" + digest, nil
+}
+
+// SaveLockFile writes the lock file if images were pinned to new digests.
+func SaveLockFile() error {
+	if imagePinning == nil || !imagePinning.changed {
+		return nil
+	}
+	data, err := yaml.Marshal(imagePinning.lock)
+	if err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	if err := os.WriteFile(imagePinning.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	imagePinning.changed = false
+	return nil
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeResolver map[string]string
+
+func (r fakeResolver) Digest(image string) (string, error) {
+	digest, exists := r[image]
+	if !exists {
+		return "", errors.New("manifest unknown")
+	}
+	return digest, nil
+}
+
+func TestPinImage(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "forge.lock")
+	if err := os.WriteFile(lockPath, []byte("images:\n  nginx:1.27: sha256:aaaa\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resolver := fakeResolver{"nginx:1.27": "sha256:bbbb", "redis:7": "sha256:cccc"}
+	if err := EnableDigestPinning(lockPath, resolver); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer DisableDigestPinning()
+
+	cases := map[string]string{
+		"nginx:1.27":          "nginx:1.27@sha256:aaaa", // from the lock file
+		"redis:7":             "redis:7@sha256:cccc",
+		"busybox@sha256:dddd": "busybox@sha256:dddd",
+		"":                    "",
+	}
+	for image, expected := range cases {
+		pinned, err := PinImage(image)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pinned != expected {
+			t.Errorf("expected %s to be pinned to %s, got %s", image, expected, pinned)
+		}
+	}
+
+	_, err := PinImage("missing:1")
+	if err == nil || ClassOf(err) != FetchError {
+		t.Errorf("expected a fetch error for an unknown image, got %v", err)
+	}
+
+	if err := SaveLockFile(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, line := range []string{"nginx:1.27: sha256:aaaa", "redis:7: sha256:cccc"} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("expected the lock file to contain %q, got:\n%s", line, data)
+		}
+	}
+}
+
+func TestPinImageDisabled(t *testing.T) {
+	pinned, err := PinImage("nginx:1.27")
+	if err != nil || pinned != "nginx:1.27" {
+		t.Errorf("expected the image to be unchanged, got %s, %v", pinned, err)
+	}
+	if err := SaveLockFile(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorClass is the kind of failure which stopped a run. Its value is used as
+// the exit code, so wrappers can branch on it.
+type ErrorClass int
+
+const (
+	GeneralError    ErrorClass = 1
+	ConfigError     ErrorClass = 2
+	FetchError      ErrorClass = 3
+	RenderError     ErrorClass = 4
+	ValidationError ErrorClass = 5
+	ApplyError      ErrorClass = 6
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ConfigError:
+		return "config error"
+	case FetchError:
+		return "source fetch error"
+	case RenderError:
+		return "render error"
+	case ValidationError:
+		return "validation error"
+	case ApplyError:
+		return "apply error"
+	default:
+		return "error"
+	}
+}
+
+// ForgeError is an error of a known class.
+type ForgeError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (e *ForgeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ForgeError) Unwrap() error {
+	return e.Err
+}
+
+// NewError wraps err as an error of the given class. A nil err stays nil.
+func NewError(class ErrorClass, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ForgeError{Class: class, Err: err}
+}
+
+// Errorf formats an error of the given class, like fmt.Errorf.
+func Errorf(class ErrorClass, format string, args ...interface{}) error {
+	return &ForgeError{Class: class, Err: fmt.Errorf(format, args...)}
+}
+
+// ClassOf returns the class of the first ForgeError in err's chain, or
+// GeneralError if there is none.
+func ClassOf(err error) ErrorClass {
+	var forgeError *ForgeError
+	if errors.As(err, &forgeError) {
+		return forgeError.Class
+	}
+	return GeneralError
+}
+
+// ExitCode returns the process exit code for the result of a run.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	return int(ClassOf(err))
+}
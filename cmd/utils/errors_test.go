@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"No error", nil, 0},
+		{"Plain error", errors.New("failed"), 1},
+		{"Config error", Errorf(ConfigError, "missing 'name'"), 2},
+		{"Wrapped fetch error", fmt.Errorf("tool: %w", Errorf(FetchError, "download failed")), 3},
+		{"Joined errors use the first class", errors.Join(Errorf(RenderError, "split failed"), Errorf(ApplyError, "apply failed")), 4},
+		{"Nil error keeps no class", NewError(ApplyError, nil), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := ExitCode(tt.err); code != tt.expected {
+				t.Errorf("expected exit code %d, got %d", tt.expected, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import "fmt"
+
+// Profiles of the profile setting, globally and per tool.
+const (
+	// ProfileDefault keeps the output as the charts render it.
+	ProfileDefault = "default"
+	// ProfileHA makes the Deployments highly available, see HAProfile.
+	ProfileHA = "ha"
+)
+
+// Defaults of the ha profile.
+const (
+	DefaultHAReplicas    = 3
+	DefaultHATopologyKey = "kubernetes.io/hostname"
+)
+
+// HAProfile tunes the ha profile, set in the ha section of config.yaml.
+type HAProfile struct {
+	// Replicas is the least number of replicas of each Deployment.
+	Replicas int `yaml:"replicas"`
+	// TopologyKey is the node label to spread the replicas over, e.g.
+	// topology.kubernetes.io/zone.
+	TopologyKey string `yaml:"topology-key"`
+}
+
+// stackProfile is the profile of tools which don't set their own.
+var stackProfile = ProfileDefault
+
+// haProfile are the settings of the ha profile.
+var haProfile = HAProfile{Replicas: DefaultHAReplicas, TopologyKey: DefaultHATopologyKey}
+
+func validateProfile(profile string) error {
+	switch profile {
+	case "", ProfileDefault, ProfileHA:
+		return nil
+	}
+	return fmt.Errorf("invalid 'profile' '%s', expected %s or %s", profile, ProfileDefault, ProfileHA)
+}
+
+func validateHAProfile(ha *HAProfile) error {
+	if ha != nil && ha.Replicas < 0 {
+		return fmt.Errorf("invalid 'replicas' %d in ha, expected a positive number", ha.Replicas)
+	}
+	return nil
+}
+
+// RegisterProfile sets the profile of the tools which don't set their own,
+// and the settings of the ha profile, filling in the defaults.
+func RegisterProfile(profile string, ha *HAProfile) {
+	stackProfile = profile
+	if stackProfile == "" {
+		stackProfile = ProfileDefault
+	}
+	haProfile = HAProfile{Replicas: DefaultHAReplicas, TopologyKey: DefaultHATopologyKey}
+	if ha != nil && ha.Replicas > 0 {
+		haProfile.Replicas = ha.Replicas
+	}
+	if ha != nil && ha.TopologyKey != "" {
+		haProfile.TopologyKey = ha.TopologyKey
+	}
+}
+
+// ToolProfile returns the profile the tool is smelted with, and the
+// settings of the ha profile.
+func ToolProfile(config Config) (string, HAProfile) {
+	if config.Profile != "" {
+		return config.Profile, haProfile
+	}
+	return stackProfile, haProfile
+}
@@ -0,0 +1,343 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultHelmfileEnvironment is the environment helmfile uses when none
+	// is given.
+	DefaultHelmfileEnvironment = "default"
+	// helmfileValuesFile names the values of a release, written into the
+	// run's directory for helm.
+	helmfileValuesFile = "helmfile-values.yaml"
+)
+
+// HelmfileSource is a helmfile in the input directory whose releases are
+// smelted as tools, set in the helmfiles section of config.yaml.
+type HelmfileSource struct {
+	Path        string `yaml:"path"`
+	Environment string `yaml:"environment"`
+}
+
+type helmfile struct {
+	Environments map[string]helmfileEnvironment `yaml:"environments"`
+	Repositories []helmfileRepository           `yaml:"repositories"`
+	Releases     []helmfileRelease              `yaml:"releases"`
+	// Helmfiles nests other helmfiles, which is not supported.
+	Helmfiles []interface{} `yaml:"helmfiles"`
+}
+
+type helmfileEnvironment struct {
+	Values []interface{} `yaml:"values"`
+}
+
+type helmfileRepository struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	OCI  bool   `yaml:"oci"`
+}
+
+type helmfileRelease struct {
+	Name      string        `yaml:"name"`
+	Namespace string        `yaml:"namespace"`
+	Chart     string        `yaml:"chart"`
+	Version   string        `yaml:"version"`
+	Values    []interface{} `yaml:"values"`
+	Set       []struct {
+		Name  string      `yaml:"name"`
+		Value interface{} `yaml:"value"`
+	} `yaml:"set"`
+	Installed *bool `yaml:"installed"`
+}
+
+// helmfileState is what the parts of a helmfile read so far define.
+type helmfileState struct {
+	dir          string
+	environment  string
+	values       map[string]interface{}
+	repositories map[string]helmfileRepository
+	// files are the helmfile and the values files read.
+	files []string
+}
+
+// helmfilePartRe splits a helmfile into the parts which helmfile renders one
+// after the other, each seeing the environment values of the ones before.
+var helmfilePartRe = regexp.MustCompile(`(?m)^---\s*$`)
+
+func validateHelmfiles(sources []HelmfileSource) error {
+	for _, source := range sources {
+		if source.Path == "" {
+			return fmt.Errorf("missing 'path' in helmfile: %+v", source)
+		}
+	}
+	return nil
+}
+
+// loadHelmfiles returns the installed releases of the helmfiles as tools:
+// each release from a repository of its helmfile becomes a helm tool named
+// after the release, with its values files, inline values and set values
+// merged into one values file. A release can't have the name of another
+// tool.
+func loadHelmfiles(sources []HelmfileSource, tools []Config) ([]Config, error) {
+	names := map[string]bool{}
+	for _, tool := range tools {
+		names[tool.Name] = true
+	}
+	var configs []Config
+	for _, source := range sources {
+		releases, err := loadHelmfile(source)
+		if err != nil {
+			return nil, fmt.Errorf("helmfile %s: %w", source.Path, err)
+		}
+		for _, release := range releases {
+			if names[release.Name] {
+				return nil, fmt.Errorf("helmfile %s: release %s has the name of another tool", source.Path, release.Name)
+			}
+			names[release.Name] = true
+		}
+		configs = append(configs, releases...)
+	}
+	return configs, nil
+}
+
+func loadHelmfile(source HelmfileSource) ([]Config, error) {
+	path := InputPath(source.Path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := &helmfileState{
+		dir:          filepath.Dir(path),
+		environment:  source.Environment,
+		values:       map[string]interface{}{},
+		repositories: map[string]helmfileRepository{},
+		files:        []string{path},
+	}
+	if state.environment == "" {
+		state.environment = DefaultHelmfileEnvironment
+	}
+
+	var releases []helmfileRelease
+	environmentFound := state.environment == DefaultHelmfileEnvironment
+	for i, part := range helmfilePartRe.Split(string(data), -1) {
+		rendered, err := state.render(fmt.Sprintf("%s part %d", source.Path, i+1), part)
+		if err != nil {
+			return nil, err
+		}
+		var parsed helmfile
+		if err := yaml.Unmarshal(rendered, &parsed); err != nil {
+			return nil, fmt.Errorf("failed to parse part %d: %w", i+1, err)
+		}
+		if len(parsed.Helmfiles) > 0 {
+			return nil, fmt.Errorf("nested helmfiles are not supported, list them in config.yaml instead")
+		}
+		if environment, ok := parsed.Environments[state.environment]; ok {
+			environmentFound = true
+			for _, item := range environment.Values {
+				values, err := state.readValues(item)
+				if err != nil {
+					return nil, fmt.Errorf("environment %s: %w", state.environment, err)
+				}
+				state.values = mergeValues(state.values, values)
+			}
+		}
+		for _, repository := range parsed.Repositories {
+			state.repositories[repository.Name] = repository
+		}
+		releases = append(releases, parsed.Releases...)
+	}
+	if !environmentFound {
+		return nil, fmt.Errorf("environment '%s' is not defined", state.environment)
+	}
+
+	var configs []Config
+	environmentFiles := state.files
+	for _, release := range releases {
+		if release.Installed != nil && !*release.Installed {
+			continue
+		}
+		state.files = append([]string(nil), environmentFiles...)
+		config, err := state.releaseConfig(release)
+		if err != nil {
+			return nil, fmt.Errorf("release %s: %w", release.Name, err)
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// releaseConfig turns a release into a helm tool.
+func (s *helmfileState) releaseConfig(release helmfileRelease) (Config, error) {
+	if release.Name == "" || release.Chart == "" || release.Namespace == "" {
+		return Config{}, fmt.Errorf("missing 'name', 'namespace' or 'chart'")
+	}
+	repositoryName, chart, found := strings.Cut(release.Chart, "/")
+	repository, known := s.repositories[repositoryName]
+	if !found || !known {
+		return Config{}, fmt.Errorf("chart '%s' is not from a repository of the helmfile, only repository charts are supported", release.Chart)
+	}
+	if repository.OCI {
+		return Config{}, fmt.Errorf("chart '%s' is from an OCI repository, only HTTP repositories are supported", release.Chart)
+	}
+
+	values := map[string]interface{}{}
+	for _, item := range release.Values {
+		itemValues, err := s.readValues(item)
+		if err != nil {
+			return Config{}, err
+		}
+		values = mergeValues(values, itemValues)
+	}
+	for _, set := range release.Set {
+		setValue(values, strings.Split(set.Name, "."), toJSONValue(set.Value))
+	}
+	content, err := yaml.Marshal(values)
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{
+		Name:           release.Name,
+		Namespace:      release.Namespace,
+		HelmName:       release.Name,
+		HelmChartName:  chart,
+		HelmURL:        repository.URL,
+		HelmVersion:    release.Version,
+		HelmfileValues: content,
+		HelmfileFiles:  s.files,
+	}, nil
+}
+
+// readValues reads a values item of an environment or release: the path of
+// a values file relative to the helmfile, rendered first if it ends in
+// .gotmpl, or inline values.
+func (s *helmfileState) readValues(item interface{}) (map[string]interface{}, error) {
+	path, isPath := item.(string)
+	if !isPath {
+		values, _ := toJSONValue(item).(map[string]interface{})
+		if values == nil {
+			return nil, fmt.Errorf("invalid values %v, expected a file or a map", item)
+		}
+		return values, nil
+	}
+	path = filepath.Join(s.dir, path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s.files = append(s.files, path)
+	if strings.HasSuffix(path, ".gotmpl") {
+		data, err = s.render(path, string(data))
+		if err != nil {
+			return nil, err
+		}
+	}
+	var values map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	converted, _ := toJSONValue(values).(map[string]interface{})
+	if converted == nil {
+		converted = map[string]interface{}{}
+	}
+	return converted, nil
+}
+
+// render executes a helmfile template with the environment values read so
+// far. It offers the functions of helmfile most templates use.
+func (s *helmfileState) render(name, text string) ([]byte, error) {
+	funcs := template.FuncMap{
+		"env": os.Getenv,
+		"requiredEnv": func(name string) (string, error) {
+			value := os.Getenv(name)
+			if value == "" {
+				return "", fmt.Errorf("required environment variable %s is not set", name)
+			}
+			return value, nil
+		},
+		"default": func(fallback, value interface{}) interface{} {
+			if value == nil || value == "" {
+				return fallback
+			}
+			return value
+		},
+		"quote": func(value interface{}) string {
+			return fmt.Sprintf("%q", fmt.Sprint(value))
+		},
+		"get": func(path string, fallback interface{}, values map[string]interface{}) interface{} {
+			var current interface{} = values
+			for _, key := range strings.Split(path, ".") {
+				object, ok := current.(map[string]interface{})
+				if !ok {
+					return fallback
+				}
+				if current, ok = object[key]; !ok {
+					return fallback
+				}
+			}
+			return current
+		},
+	}
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+	data := map[string]interface{}{
+		"Environment": map[string]interface{}{"Name": s.environment, "Values": s.values},
+		"Values":      s.values,
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return b.Bytes(), nil
+}
+
+// setValue sets the value at the path of keys, creating the maps on the way.
+func setValue(values map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := values[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			values[key] = next
+		}
+		values = next
+	}
+	values[path[len(path)-1]] = value
+}
+
+// writeHelmfileValues writes the values of a tool read from a helmfile next
+// to its rendered manifests, in the run's directory, and returns the path
+// for helm. The values can hold secrets from the environment, so they are
+// kept out of the input directory, where they could be committed.
+func writeHelmfileValues(config Config) (string, error) {
+	path := filepath.Join(filepath.Dir(config.Filename), config.Name+"-"+helmfileValuesFile)
+	if err := os.WriteFile(path, config.HelmfileValues, 0600); err != nil {
+		return "", fmt.Errorf("failed to write %s for %s: %w", helmfileValuesFile, config.Name, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testHelmfile = `environments:
+  default:
+    values:
+      - replicas: 1
+  production:
+    values:
+      - environments/production.yaml
+---
+repositories:
+  - name: grafana
+    url: https://grafana.github.io/helm-charts
+releases:
+  - name: grafana
+    namespace: monitoring
+    chart: grafana/grafana
+    version: 8.5.1
+    values:
+      - values/grafana.yaml.gotmpl
+      - ingress:
+          enabled: true
+    set:
+      - name: persistence.size
+        value: 20Gi
+  - name: loki
+    namespace: monitoring
+    chart: grafana/loki
+    installed: {{ eq .Environment.Name "production" }}
+`
+
+func writeHelmfile(t *testing.T, dir string) {
+	files := map[string]string{
+		"helmfile.yaml":                testHelmfile,
+		"environments/production.yaml": "replicas: 3\n",
+		"values/grafana.yaml.gotmpl":   "replicas: {{ .Values.replicas }}\nenv: {{ .Environment.Name }}\ningress:\n  hosts: [grafana.local]\n",
+		"config.yaml":                  "helmfiles:\n  - path: helmfile.yaml\n    environment: production\ntools:\n  - name: cert-manager\n    namespace: cert-manager\n    sourcefile: cert-manager.yaml\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+}
+
+func TestLoadHelmfile(t *testing.T) {
+	dir := t.TempDir()
+	writeHelmfile(t, dir)
+	SetInputDirs([]string{dir})
+	defer SetInputDirs([]string{"input"})
+
+	forgeConfig, err := LoadForgeConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(forgeConfig.Tools) != 3 {
+		t.Fatalf("expected cert-manager, grafana and loki, got %+v", forgeConfig.Tools)
+	}
+	grafana := forgeConfig.Tools[1]
+	if grafana.Name != "grafana" || grafana.HelmChartName != "grafana" || grafana.HelmURL != "https://grafana.github.io/helm-charts" || grafana.HelmVersion != "8.5.1" {
+		t.Errorf("unexpected grafana tool: %+v", grafana)
+	}
+	values := string(grafana.HelmfileValues)
+	for _, expected := range []string{"replicas: 3", "env: production", "enabled: true", "- grafana.local", "size: 20Gi"} {
+		if !strings.Contains(values, expected) {
+			t.Errorf("expected %q in the merged values, got:\n%s", expected, values)
+		}
+	}
+	if len(grafana.HelmfileFiles) != 3 {
+		t.Errorf("expected the helmfile and two values files as inputs, got %v", grafana.HelmfileFiles)
+	}
+
+	// In the default environment, loki is not installed
+	source := HelmfileSource{Path: "helmfile.yaml"}
+	releases, err := loadHelmfile(source)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(releases) != 1 || !strings.Contains(string(releases[0].HelmfileValues), "replicas: 1") {
+		t.Errorf("expected only grafana with one replica, got %+v", releases)
+	}
+
+	source.Environment = "staging"
+	if _, err := loadHelmfile(source); err == nil {
+		t.Errorf("expected an error for an undefined environment")
+	}
+	if _, err := loadHelmfiles([]HelmfileSource{{Path: "helmfile.yaml"}}, []Config{{Name: "grafana"}}); err == nil {
+		t.Errorf("expected an error for a release with the name of another tool")
+	}
+}
+
+func TestWriteHelmfileValues(t *testing.T) {
+	inputDir := t.TempDir()
+	SetInputDirs([]string{inputDir})
+	defer SetInputDirs([]string{"input"})
+	preDir := t.TempDir()
+
+	config := Config{Name: "grafana", Filename: filepath.Join(preDir, "grafana.yaml"), HelmfileValues: []byte("replicas: 3\n")}
+	path, err := writeHelmfileValues(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filepath.Dir(path) != preDir {
+		t.Errorf("expected the values in the run's directory %s, got %s", preDir, path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "replicas: 3\n" {
+		t.Fatalf("expected the values in %s, got %q, %v", path, content, err)
+	}
+	if entries, _ := os.ReadDir(inputDir); len(entries) != 0 {
+		t.Errorf("expected nothing to be written to the input directory, got %v", entries)
+	}
+}
@@ -0,0 +1,108 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultHostTemplate is the host template used unless one is configured.
+const DefaultHostTemplate = "{tool}.{cluster_domain}"
+
+// hostPlaceholders are the placeholders of a host template.
+var hostPlaceholders = []string{"{tool}", "{cluster_domain}", "{subdomain}"}
+
+var placeholderPattern = regexp.MustCompile(`\{[^}]*\}`)
+
+// Ingress is the ingress setup of the cluster, set in the ingress section of
+// config.yaml. smelt applies it to the Ingresses, Gateways and HTTPRoutes of
+// all tools.
+type Ingress struct {
+	// ClusterDomain is the DNS domain of the cluster, e.g. staging.example.com.
+	ClusterDomain string `yaml:"cluster-domain"`
+	// HostTemplate makes the host names, from {tool}, {cluster_domain} and
+	// {subdomain}, the first label of the chart's host name.
+	HostTemplate string `yaml:"host-template"`
+	// IngressClass is the ingressClassName of all Ingresses, if set.
+	IngressClass string `yaml:"ingress-class"`
+	// GatewayClass is the gatewayClassName of all Gateways, if set.
+	GatewayClass string `yaml:"gateway-class"`
+}
+
+// clusterIngress is the registered ingress setup, if any.
+var clusterIngress *Ingress
+
+func validateIngress(ingress *Ingress) error {
+	if ingress == nil {
+		return nil
+	}
+	template := ingress.HostTemplate
+	if template == "" {
+		template = DefaultHostTemplate
+	}
+	for _, placeholder := range placeholderPattern.FindAllString(template, -1) {
+		if !containsString(hostPlaceholders, placeholder) {
+			return fmt.Errorf("unknown placeholder %s in 'host-template', must be one of %s", placeholder, strings.Join(hostPlaceholders, ", "))
+		}
+	}
+	if strings.Contains(template, "{cluster_domain}") && ingress.ClusterDomain == "" {
+		return fmt.Errorf("missing 'cluster-domain' in ingress")
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterIngress makes smelt apply the ingress setup to the tools, or stop
+// doing so if ingress is nil.
+func RegisterIngress(ingress *Ingress) {
+	if ingress != nil && ingress.HostTemplate == "" {
+		ingress.HostTemplate = DefaultHostTemplate
+	}
+	clusterIngress = ingress
+}
+
+// RegisteredIngress returns the registered ingress setup, or nil.
+func RegisteredIngress() *Ingress {
+	return clusterIngress
+}
+
+// Host returns the host name to use for a host of the tool. A wildcard host
+// stays a wildcard of the templated name.
+func (i Ingress) Host(tool, host string) string {
+	wildcard := strings.HasPrefix(host, "*.")
+	host = strings.TrimPrefix(host, "*.")
+	subdomain, _, _ := strings.Cut(host, ".")
+	templated := strings.NewReplacer(
+		"{tool}", tool,
+		"{cluster_domain}", i.ClusterDomain,
+		"{subdomain}", subdomain,
+	).Replace(i.HostTemplate)
+	if wildcard {
+		return "*." + templated
+	}
+	return templated
+}
@@ -0,0 +1,35 @@
+package utils
+
+import "testing"
+
+func TestIngressHost(t *testing.T) {
+	ingress := Ingress{ClusterDomain: "staging.example.com", HostTemplate: DefaultHostTemplate}
+	if host := ingress.Host("grafana", "grafana.local"); host != "grafana.staging.example.com" {
+		t.Errorf("unexpected host %s", host)
+	}
+	ingress.HostTemplate = "{subdomain}-{tool}.{cluster_domain}"
+	if host := ingress.Host("harbor", "notary.harbor.local"); host != "notary-harbor.staging.example.com" {
+		t.Errorf("unexpected host %s", host)
+	}
+	if host := ingress.Host("harbor", "*.harbor.local"); host != "*.harbor-harbor.staging.example.com" {
+		t.Errorf("unexpected wildcard host %s", host)
+	}
+}
+
+func TestValidateIngress(t *testing.T) {
+	cases := []struct {
+		ingress Ingress
+		valid   bool
+	}{
+		{Ingress{ClusterDomain: "example.com"}, true},
+		{Ingress{HostTemplate: "{tool}.example.com"}, true},
+		{Ingress{}, false},
+		{Ingress{ClusterDomain: "example.com", HostTemplate: "{name}.{cluster_domain}"}, false},
+	}
+	for _, c := range cases {
+		err := validateIngress(&c.ingress)
+		if (err == nil) != c.valid {
+			t.Errorf("validateIngress(%+v): expected valid=%v, got %v", c.ingress, c.valid, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LogOptions are the logging settings given on the command line.
+type LogOptions struct {
+	Quiet bool
+	// Levels is a comma separated list of module=level pairs, e.g.
+	// "smelter=debug,caster=warn". A bare level sets the default level.
+	Levels string
+	// Dir is the directory of the log file, logs by default.
+	Dir string
+}
+
+// logModules are the modules whose level can be set separately.
+var logModules = []string{"main", "smelter", "caster", "forger", "operator", "cleaner", "rbac", "snapshot", "immutable", "utils"}
+
+var quiet bool
+
+var moduleLogFormatter = &moduleFormatter{
+	Formatter:    &log.TextFormatter{},
+	defaultLevel: log.InfoLevel,
+	levels:       map[string]log.Level{},
+}
+
+// moduleFormatter drops entries below the level set for the module which
+// logged them, and formats the rest with the wrapped formatter.
+type moduleFormatter struct {
+	log.Formatter
+	defaultLevel log.Level
+	levels       map[string]log.Level
+}
+
+func (f *moduleFormatter) Format(entry *log.Entry) ([]byte, error) {
+	level := f.defaultLevel
+	if entry.Caller != nil {
+		if moduleLevel, exists := f.levels[moduleOf(entry.Caller.Function)]; exists {
+			level = moduleLevel
+		}
+	}
+	if entry.Level > level {
+		return nil, nil
+	}
+	// The caller is only needed for filtering, so keep it out of the output
+	entry.Caller = nil
+	return f.Formatter.Format(entry)
+}
+
+// moduleOf returns the package name of a fully qualified function name,
+// e.g. "smelter" for "github.com/silogen/cluster-forge/cmd/smelter.PrepareTool".
+func moduleOf(function string) string {
+	name := function[strings.LastIndex(function, "/")+1:]
+	return strings.SplitN(name, ".", 2)[0]
+}
+
+// ParseLogLevels parses a list like "smelter=debug,caster=warn" into levels
+// per module. A bare level is returned as the default level.
+func ParseLogLevels(spec string) (map[string]log.Level, *log.Level, error) {
+	levels := map[string]log.Level{}
+	var defaultLevel *log.Level
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		module, levelStr, found := strings.Cut(item, "=")
+		if !found {
+			levelStr = module
+		}
+		level, err := log.ParseLevel(strings.TrimSpace(levelStr))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid log level in '%s': %w", item, err)
+		}
+		if !found {
+			defaultLevel = &level
+			continue
+		}
+		module = strings.TrimSpace(module)
+		if !isLogModule(module) {
+			return nil, nil, fmt.Errorf("unknown module '%s' in '%s', expected one of %s", module, item, strings.Join(logModules, ", "))
+		}
+		levels[module] = level
+	}
+	return levels, defaultLevel, nil
+}
+
+func isLogModule(module string) bool {
+	for _, logModule := range logModules {
+		if module == logModule {
+			return true
+		}
+	}
+	return false
+}
+
+// configureLogLevels installs the module formatter. The logger itself has to
+// let through the most verbose of the levels so the formatter can see them.
+func configureLogLevels(defaultLevel log.Level, levels map[string]log.Level) {
+	moduleLogFormatter.defaultLevel = defaultLevel
+	moduleLogFormatter.levels = levels
+
+	maxLevel := defaultLevel
+	for _, level := range levels {
+		if level > maxLevel {
+			maxLevel = level
+		}
+	}
+	log.SetLevel(maxLevel)
+	log.SetReportCaller(true)
+	log.SetFormatter(moduleLogFormatter)
+}
+
+// LogToStdout sends the log to stdout with full timestamps, for commands
+// which report their progress through the log.
+func LogToStdout() {
+	log.SetOutput(os.Stdout)
+	moduleLogFormatter.Formatter = &log.TextFormatter{
+		FullTimestamp: true,
+	}
+}
+
+// Quiet reports whether --quiet was given, in which case only warnings,
+// errors and prompts should be shown.
+func Quiet() bool {
+	return quiet
+}
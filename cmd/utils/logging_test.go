@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"runtime"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestParseLogLevels(t *testing.T) {
+	levels, defaultLevel, err := ParseLogLevels("smelter=debug, caster=warn,error")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if levels["smelter"] != log.DebugLevel || levels["caster"] != log.WarnLevel {
+		t.Errorf("unexpected module levels: %v", levels)
+	}
+	if defaultLevel == nil || *defaultLevel != log.ErrorLevel {
+		t.Errorf("expected default level error, got %v", defaultLevel)
+	}
+
+	if _, _, err := ParseLogLevels("smelter=loud"); err == nil {
+		t.Errorf("expected error for invalid level")
+	}
+	if levels, _, err := ParseLogLevels("operator=debug"); err != nil || levels["operator"] != log.DebugLevel {
+		t.Errorf("expected the operator's level to be set, got %v, %v", levels, err)
+	}
+	if levels, _, err := ParseLogLevels("cleaner=debug,rbac=warn"); err != nil || levels["cleaner"] != log.DebugLevel || levels["rbac"] != log.WarnLevel {
+		t.Errorf("expected the cleaner's and rbac's levels to be set, got %v, %v", levels, err)
+	}
+	if _, _, err := ParseLogLevels("smelt=debug"); err == nil {
+		t.Errorf("expected error for unknown module")
+	}
+}
+
+func TestModuleFormatter(t *testing.T) {
+	formatter := &moduleFormatter{
+		Formatter:    &log.TextFormatter{DisableTimestamp: true},
+		defaultLevel: log.InfoLevel,
+		levels:       map[string]log.Level{"smelter": log.DebugLevel, "caster": log.WarnLevel},
+	}
+
+	tests := []struct {
+		name     string
+		function string
+		level    log.Level
+		shown    bool
+	}{
+		{"Debug from smelter", "github.com/silogen/cluster-forge/cmd/smelter.PrepareTool", log.DebugLevel, true},
+		{"Info from caster", "github.com/silogen/cluster-forge/cmd/caster.Cast.func1", log.InfoLevel, false},
+		{"Warn from caster", "github.com/silogen/cluster-forge/cmd/caster.Cast", log.WarnLevel, true},
+		{"Debug from main", "main.runSmelt", log.DebugLevel, false},
+		{"Info from main", "main.runSmelt", log.InfoLevel, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &log.Entry{
+				Logger:  log.New(),
+				Level:   tt.level,
+				Message: "message",
+				Caller:  &runtime.Frame{Function: tt.function},
+			}
+			output, err := formatter.Format(entry)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if shown := len(output) > 0; shown != tt.shown {
+				t.Errorf("expected shown=%v, got output %q", tt.shown, string(output))
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"runtime/debug"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Annotations stamped into every generated object, so resources on a cluster
+// can be traced back to the forge release and run which produced them.
+const (
+	AnnotationVersion      = "clusterforge.io/version"
+	AnnotationTool         = "clusterforge.io/tool"
+	AnnotationBuildID      = "clusterforge.io/build-id"
+	AnnotationSourceDigest = "clusterforge.io/source-digest"
+	AnnotationObjectDigest = "clusterforge.io/object-digest"
+)
+
+// Version is the forge release, set at build time with
+// -ldflags "-X github.com/silogen/cluster-forge/cmd/utils.Version=v1.2.3".
+var Version = ""
+
+var buildID string
+
+// toolBuildIDs holds the build id of each tool, see SetToolBuildIDs.
+var toolBuildIDs map[string]string
+
+// ForgeVersion returns the forge release. Without one set at build time it
+// falls back to the module version or VCS revision recorded by go build.
+func ForgeVersion() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return "dev-" + setting.Value
+		}
+	}
+	return "dev"
+}
+
+// SetBuildID derives the build id from the forge version and the digest of
+// the config. It identifies what the output was built from rather than when,
+// so smelting the same config twice gives byte-identical output.
+func SetBuildID(configDigest string) {
+	sum := sha256.Sum256([]byte(ForgeVersion() + "\n" + configDigest))
+	buildID = hex.EncodeToString(sum[:])[:16]
+}
+
+// SetToolBuildIDs derives the build id of each tool from the forge version,
+// the tool's entry in the config and the settings shared by all tools. The
+// objects of a tool keep their build id while other tools' entries change,
+// e.g. when smelting again tools whose entries were edited.
+func SetToolBuildIDs(forgeConfig ForgeConfig) {
+	shared := forgeConfig
+	shared.Tools = nil
+	shared.Digest = ""
+	// The bastion is how the cluster is reached, not part of the output
+	shared.Bastion = nil
+	sharedYAML, _ := yaml.Marshal(shared)
+	toolBuildIDs = map[string]string{}
+	for _, config := range forgeConfig.Tools {
+		configYAML, _ := yaml.Marshal(config)
+		sum := sha256.Sum256([]byte(ForgeVersion() + "\n" + string(sharedYAML) + "---\n" + string(configYAML)))
+		toolBuildIDs[config.Name] = hex.EncodeToString(sum[:])[:16]
+	}
+}
+
+// ToolBuildID returns the build id of the tool's objects, or the build id of
+// the run for tools not registered with SetToolBuildIDs.
+func ToolBuildID(tool string) string {
+	if id, exists := toolBuildIDs[tool]; exists {
+		return id
+	}
+	return BuildID()
+}
+
+// BuildID returns the build id, as set by SetBuildID.
+func BuildID() string {
+	if buildID == "" {
+		SetBuildID("")
+	}
+	return buildID
+}
+
+// SourceDigest returns the digest of the manifests a tool was generated from.
+func SourceDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ObjectDigest returns the digest of a generated object as written, after
+// every transformation. The annotations which change with every build, and
+// the object digest itself, are left out, so the digest only changes with
+// the object.
+func ObjectDigest(content []byte) string {
+	return SourceDigest(WithoutAnnotations(content, []string{AnnotationVersion, AnnotationBuildID, AnnotationObjectDigest}))
+}
+
+// WithoutAnnotations drops the lines holding the annotations from a YAML
+// document, without parsing it.
+func WithoutAnnotations(content []byte, annotations []string) []byte {
+	var output bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, len(content)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isAnnotationLine(line, annotations) {
+			continue
+		}
+		output.WriteString(line + "\n")
+	}
+	return output.Bytes()
+}
+
+func isAnnotationLine(line string, annotations []string) bool {
+	trimmed := strings.TrimSpace(line)
+	for _, annotation := range annotations {
+		if strings.HasPrefix(trimmed, annotation+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// RunAnnotations returns the annotations stamped into the objects of a tool.
+func RunAnnotations(tool string, sourceDigest string) map[string]string {
+	return map[string]string{
+		AnnotationVersion:      ForgeVersion(),
+		AnnotationTool:         tool,
+		AnnotationBuildID:      ToolBuildID(tool),
+		AnnotationSourceDigest: sourceDigest,
+	}
+}
+
+// StampAnnotations adds annotations to the metadata of an object parsed with
+// yaml.v2, overwriting existing values with the same keys.
+func StampAnnotations(object map[string]interface{}, annotations map[string]string) {
+	metadata := ObjectMetadata(object)
+	existing, ok := metadata["annotations"].(map[interface{}]interface{})
+	if !ok {
+		existing = map[interface{}]interface{}{}
+		metadata["annotations"] = existing
+	}
+	for key, value := range annotations {
+		existing[key] = value
+	}
+}
+
+// ObjectMetadata returns the metadata of an object parsed with yaml.v2,
+// adding an empty one if it has none.
+func ObjectMetadata(object map[string]interface{}) map[interface{}]interface{} {
+	metadata, ok := object["metadata"].(map[interface{}]interface{})
+	if !ok {
+		metadata = map[interface{}]interface{}{}
+		object["metadata"] = metadata
+	}
+	return metadata
+}
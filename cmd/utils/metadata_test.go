@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestStampAnnotations(t *testing.T) {
+	manifest := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: example
+  annotations:
+    existing: kept
+    clusterforge.io/tool: stale
+`)
+	var object map[string]interface{}
+	if err := yaml.Unmarshal(manifest, &object); err != nil {
+		t.Fatalf("Failed to parse manifest: %v", err)
+	}
+
+	StampAnnotations(object, RunAnnotations("example-tool", SourceDigest(manifest)))
+
+	annotations := ObjectMetadata(object)["annotations"].(map[interface{}]interface{})
+	if annotations["existing"] != "kept" {
+		t.Errorf("expected existing annotation to be kept, got %v", annotations["existing"])
+	}
+	if annotations[AnnotationTool] != "example-tool" {
+		t.Errorf("expected tool annotation to be overwritten, got %v", annotations[AnnotationTool])
+	}
+	if annotations[AnnotationBuildID] != BuildID() {
+		t.Errorf("expected build id %s, got %v", BuildID(), annotations[AnnotationBuildID])
+	}
+	if digest, _ := annotations[AnnotationSourceDigest].(string); !strings.HasPrefix(digest, "sha256:") {
+		t.Errorf("expected a sha256 source digest, got %v", annotations[AnnotationSourceDigest])
+	}
+	if ObjectMetadata(object)["name"] != "example" {
+		t.Errorf("expected the rest of the metadata to be kept")
+	}
+}
+
+func TestStampAnnotationsWithoutMetadata(t *testing.T) {
+	object := map[string]interface{}{"kind": "Namespace"}
+	StampAnnotations(object, map[string]string{AnnotationVersion: "v1.0.0"})
+
+	annotations := ObjectMetadata(object)["annotations"].(map[interface{}]interface{})
+	if annotations[AnnotationVersion] != "v1.0.0" {
+		t.Errorf("expected version annotation, got %v", annotations[AnnotationVersion])
+	}
+}
+
+func TestSetBuildID(t *testing.T) {
+	defer SetBuildID("")
+
+	SetBuildID(SourceDigest([]byte("tools: []")))
+	first := BuildID()
+	SetBuildID(SourceDigest([]byte("tools: []")))
+	if BuildID() != first {
+		t.Errorf("expected the same config to give the same build id, got %s and %s", first, BuildID())
+	}
+	SetBuildID(SourceDigest([]byte("tools: [changed]")))
+	if BuildID() == first {
+		t.Errorf("expected a different config to give a different build id")
+	}
+}
+
+func TestSetToolBuildIDs(t *testing.T) {
+	defer SetToolBuildIDs(ForgeConfig{})
+
+	forgeConfig := ForgeConfig{Tools: []Config{
+		{Name: "alpha", Namespace: "alpha"},
+		{Name: "beta", Namespace: "beta"},
+	}}
+	SetToolBuildIDs(forgeConfig)
+	alpha, beta := ToolBuildID("alpha"), ToolBuildID("beta")
+	if alpha == beta {
+		t.Errorf("expected the tools to get different build ids")
+	}
+
+	// Another tool's entry, the bastion and the config digest leave the
+	// build id of a tool alone
+	forgeConfig.Tools[1].Namespace = "beta-system"
+	forgeConfig.Bastion = &Bastion{Host: "bastion.example.com"}
+	forgeConfig.Digest = "changed"
+	SetToolBuildIDs(forgeConfig)
+	if ToolBuildID("alpha") != alpha {
+		t.Errorf("expected the build id of alpha to be kept, got %s and %s", alpha, ToolBuildID("alpha"))
+	}
+	if ToolBuildID("beta") == beta {
+		t.Errorf("expected the changed entry of beta to change its build id")
+	}
+
+	forgeConfig.StorageClasses = map[string]string{"gp2": "longhorn"}
+	SetToolBuildIDs(forgeConfig)
+	if ToolBuildID("alpha") == alpha {
+		t.Errorf("expected a shared setting to change the build id of alpha")
+	}
+	if ToolBuildID("gamma") != BuildID() {
+		t.Errorf("expected an unknown tool to get the build id of the run")
+	}
+}
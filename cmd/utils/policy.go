@@ -0,0 +1,122 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// GatekeeperTemplatesGroup holds Gatekeeper's ConstraintTemplates.
+	GatekeeperTemplatesGroup = "templates.gatekeeper.sh"
+	// GatekeeperConstraintsGroup holds the constraint kinds created by the
+	// ConstraintTemplates. They are all cluster-scoped.
+	GatekeeperConstraintsGroup = "constraints.gatekeeper.sh"
+	// PoliciesDir is the input directory holding the organization's policies
+	// which tools can reference.
+	PoliciesDir = "policies"
+)
+
+// policyOwners holds the tool shipping the ConstraintTemplates of each
+// policy.
+var policyOwners map[string]string
+
+// RegisterPolicies records the first tool referencing each policy as the one
+// shipping its ConstraintTemplates. The templates are cluster-wide, so tools
+// sharing a policy ship only their own constraints.
+func RegisterPolicies(tools []Config) {
+	policyOwners = map[string]string{}
+	for _, tool := range tools {
+		for _, policy := range tool.Policies {
+			if _, exists := policyOwners[policy]; !exists {
+				policyOwners[policy] = tool.Name
+			}
+		}
+	}
+}
+
+// ClaimPolicyTemplates reports whether the tool ships the ConstraintTemplates
+// of the policy. A policy which was not registered goes to the first tool
+// claiming it.
+func ClaimPolicyTemplates(policy, tool string) bool {
+	if policyOwners == nil {
+		policyOwners = map[string]string{}
+	}
+	owner, exists := policyOwners[policy]
+	if !exists {
+		policyOwners[policy] = tool
+		return true
+	}
+	return owner == tool
+}
+
+// IsPolicy reports whether objects of the given apiVersion are Gatekeeper
+// policies, which are deployed in the policy phase.
+func IsPolicy(apiVersion string) bool {
+	group := apiGroup(apiVersion)
+	return group == GatekeeperTemplatesGroup || group == GatekeeperConstraintsGroup
+}
+
+func apiGroup(apiVersion string) string {
+	if slash := strings.Index(apiVersion, "/"); slash >= 0 {
+		return apiVersion[:slash]
+	}
+	return ""
+}
+
+// manifestHeader is what the policy phase needs to know of a manifest.
+type manifestHeader struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+}
+
+func readManifestHeader(content []byte) manifestHeader {
+	var header manifestHeader
+	_ = yaml.Unmarshal(content, &header)
+	return header
+}
+
+// dependency is an object which has to exist before a crossplane Object
+// applies its manifest.
+type dependency struct {
+	APIVersion string
+	Kind       string
+	Name       string
+}
+
+// policyDependencies returns what a tool's manifest waits for, which puts the
+// tool's policies in a phase ahead of its other objects: its constraints wait
+// for the CRDs Gatekeeper creates from their ConstraintTemplates, and its
+// other objects wait for its constraints, so they are admitted under them.
+func policyDependencies(header manifestHeader, constraints []dependency) []dependency {
+	switch apiGroup(header.APIVersion) {
+	case GatekeeperTemplatesGroup:
+		return nil
+	case GatekeeperConstraintsGroup:
+		return []dependency{{
+			APIVersion: "apiextensions.k8s.io/v1",
+			Kind:       "CustomResourceDefinition",
+			Name:       strings.ToLower(header.Kind) + "." + GatekeeperConstraintsGroup,
+		}}
+	}
+	return constraints
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateCrossplaneObjectPolicyPhase(t *testing.T) {
+	outputDir := t.TempDir()
+	workingDir := t.TempDir()
+	toolDir := filepath.Join(workingDir, "grafana")
+	if err := os.MkdirAll(toolDir, 0755); err != nil {
+		t.Fatalf("Failed to create working directory: %v", err)
+	}
+	files := map[string]string{
+		"K8sRequiredNamespaceLabels_owner.yaml": "apiVersion: constraints.gatekeeper.sh/v1beta1\nkind: K8sRequiredNamespaceLabels\nmetadata:\n  name: owner\n",
+		"Deployment_grafana.yaml":               "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: grafana\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(toolDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	config := Config{Name: "grafana", Namespace: "grafana", SourceFile: "grafana.yaml"}
+	if err := CreateCrossplaneObject(config, outputDir, workingDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	policies, err := os.ReadFile(filepath.Join(outputDir, "cm-grafana-policy-1.yaml"))
+	if err != nil {
+		t.Fatalf("expected a policy file: %v", err)
+	}
+	if !strings.Contains(string(policies), "kind: K8sRequiredNamespaceLabels") {
+		t.Errorf("expected the constraint in the policy phase, got:\n%s", policies)
+	}
+	if !strings.Contains(string(policies), "name: k8srequirednamespacelabels.constraints.gatekeeper.sh") {
+		t.Errorf("expected the constraint to wait for its CRD, got:\n%s", policies)
+	}
+	objects, err := os.ReadFile(filepath.Join(outputDir, "cm-grafana-object-1.yaml"))
+	if err != nil {
+		t.Fatalf("expected an object file: %v", err)
+	}
+	if !strings.Contains(string(objects), "dependsOn:\n      apiVersion: constraints.gatekeeper.sh/v1beta1\n      kind: K8sRequiredNamespaceLabels\n      name: owner") {
+		t.Errorf("expected the Deployment to wait for the tool's constraint, got:\n%s", objects)
+	}
+	namespaces, _ := os.ReadFile(filepath.Join(outputDir, "namespace-grafana-1.yaml"))
+	if strings.Contains(string(namespaces), "K8sRequiredNamespaceLabels") {
+		t.Errorf("expected the constraint not to be taken for a Namespace")
+	}
+}
+
+func TestConstraintsAreClusterScoped(t *testing.T) {
+	if !IsClusterScoped("K8sRequiredLabels", "constraints.gatekeeper.sh/v1beta1") {
+		t.Errorf("expected Gatekeeper constraints to be cluster-scoped")
+	}
+	if !IsClusterScoped("ConstraintTemplate", "templates.gatekeeper.sh/v1") {
+		t.Errorf("expected ConstraintTemplates to be cluster-scoped")
+	}
+}
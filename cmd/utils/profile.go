@@ -0,0 +1,165 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Stages of the pipeline timed by --profile-run.
+const (
+	StageFetch     = "fetch"
+	StageRender    = "render"
+	StageSplit     = "split"
+	StageClean     = "clean"
+	StageTransform = "transform"
+	StageWrite     = "write"
+	StageCompile   = "compile"
+	StageBuild     = "build"
+)
+
+// stageProfile is the time and memory spent in one stage of one tool,
+// summed over all the times it ran.
+type stageProfile struct {
+	tool      string
+	stage     string
+	calls     int
+	wall      time.Duration
+	allocated uint64
+}
+
+// profiler collects stage timings for --profile-run. It is nil unless
+// profiling is enabled, so timing costs nothing in normal runs.
+var profiler *runProfiler
+
+type runProfiler struct {
+	mu     sync.Mutex
+	stages []*stageProfile
+	index  map[string]*stageProfile
+}
+
+// EnableProfiling starts collecting per-stage timings for ProfileReport.
+func EnableProfiling() {
+	profiler = &runProfiler{index: map[string]*stageProfile{}}
+}
+
+// StartStage starts timing a stage of a tool, and returns the function which
+// stops it. Memory is measured as the bytes allocated during the stage.
+//
+//	defer utils.StartStage(config.Name, utils.StageRender)()
+func StartStage(tool, stage string) func() {
+	if profiler == nil {
+		return func() {}
+	}
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	allocatedBefore := memStats.TotalAlloc
+	start := time.Now()
+	return func() {
+		wall := time.Since(start)
+		runtime.ReadMemStats(&memStats)
+		profiler.record(tool, stage, wall, memStats.TotalAlloc-allocatedBefore)
+	}
+}
+
+func (p *runProfiler) record(tool, stage string, wall time.Duration, allocated uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := tool + "\x00" + stage
+	profile, exists := p.index[key]
+	if !exists {
+		profile = &stageProfile{tool: tool, stage: stage}
+		p.index[key] = profile
+		p.stages = append(p.stages, profile)
+	}
+	profile.calls++
+	profile.wall += wall
+	profile.allocated += allocated
+}
+
+// ProfileReport returns a table of the time and memory spent per tool and
+// stage, in the order the stages first ran, followed by totals per stage.
+// It is empty unless profiling is enabled.
+func ProfileReport() string {
+	if profiler == nil {
+		return ""
+	}
+	profiler.mu.Lock()
+	defer profiler.mu.Unlock()
+
+	var totals []*stageProfile
+	totalIndex := map[string]*stageProfile{}
+	var sb strings.Builder
+	writer := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "TOOL\tSTAGE\tCALLS\tWALL\tALLOCATED")
+	for _, profile := range profiler.stages {
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\t%s\n", profile.tool, profile.stage, profile.calls, profile.wall.Round(time.Millisecond), formatBytes(profile.allocated))
+		total, exists := totalIndex[profile.stage]
+		if !exists {
+			total = &stageProfile{tool: "total", stage: profile.stage}
+			totalIndex[profile.stage] = total
+			totals = append(totals, total)
+		}
+		total.calls += profile.calls
+		total.wall += profile.wall
+		total.allocated += profile.allocated
+	}
+	for _, total := range totals {
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\t%s\n", total.tool, total.stage, total.calls, total.wall.Round(time.Millisecond), formatBytes(total.allocated))
+	}
+	writer.Flush()
+	return sb.String()
+}
+
+// profileStages returns the time and memory spent per tool and stage, in
+// the order the stages first ran.
+func profileStages() []StageReport {
+	stages := []StageReport{}
+	if profiler == nil {
+		return stages
+	}
+	profiler.mu.Lock()
+	defer profiler.mu.Unlock()
+	for _, profile := range profiler.stages {
+		stages = append(stages, StageReport{
+			Tool:            profile.tool,
+			Stage:           profile.stage,
+			Calls:           profile.calls,
+			DurationSeconds: profile.wall.Seconds(),
+			AllocatedBytes:  profile.allocated,
+		})
+	}
+	return stages
+}
+
+func formatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStartStageDisabled(t *testing.T) {
+	profiler = nil
+	StartStage("tool", StageRender)()
+	if report := ProfileReport(); report != "" {
+		t.Errorf("expected no report without profiling, got: %s", report)
+	}
+}
+
+func TestProfileReport(t *testing.T) {
+	EnableProfiling()
+	defer func() { profiler = nil }()
+
+	StartStage("kyverno", StageRender)()
+	StartStage("kyverno", StageWrite)()
+	StartStage("kyverno", StageWrite)()
+	StartStage("cnpg", StageWrite)()
+
+	report := ProfileReport()
+	lines := strings.Split(strings.TrimSpace(report), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected a header, 3 stages and 2 totals, got:\n%s", report)
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "kyverno" || fields[1] != StageWrite || fields[2] != "2" {
+		t.Errorf("expected kyverno write to be called twice, got: %s", lines[2])
+	}
+	if fields := strings.Fields(lines[5]); fields[0] != "total" || fields[1] != StageWrite || fields[2] != "3" {
+		t.Errorf("expected 3 writes in total, got: %s", lines[5])
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[uint64]string{
+		512:             "512 B",
+		2048:            "2.0 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+	}
+	for bytes, expected := range tests {
+		if formatted := formatBytes(bytes); formatted != expected {
+			t.Errorf("formatBytes(%d) = %s, expected %s", bytes, formatted, expected)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"fmt"
+)
+
+// Ways of handing the pull secret to pods, see PullSecrets.Inject.
+const (
+	// InjectPodSpecs adds the pull secret to every pod spec.
+	InjectPodSpecs = "pod-specs"
+	// InjectServiceAccounts adds the pull secret to the tools'
+	// ServiceAccounts, and to the pod specs running as the default one.
+	InjectServiceAccounts = "service-accounts"
+)
+
+// DefaultPullSecretName is the name of the pull secrets, unless set in the
+// config.
+const DefaultPullSecretName = "cluster-forge-registry"
+
+// DefaultSecretStoreKind is the kind of the store of ExternalSecrets, unless
+// set in the config.
+const DefaultSecretStoreKind = "ClusterSecretStore"
+
+// PullSecrets lets the tools pull from private registries, set in the
+// pull-secrets section of config.yaml. The credentials never pass through
+// forge: the kubernetes.io/dockerconfigjson Secret is either provisioned in
+// the namespaces beforehand, or synced from a secret store by an
+// ExternalSecret which smelt generates.
+type PullSecrets struct {
+	// Name is the name of the Secrets, DefaultPullSecretName if empty.
+	Name string `yaml:"name"`
+	// Inject is InjectPodSpecs (the default) or InjectServiceAccounts.
+	Inject string `yaml:"inject"`
+	// ExternalSecret is where the credentials are kept, if smelt is to
+	// generate ExternalSecrets for them. Without it, the Secrets must exist.
+	ExternalSecret *PullSecretSource `yaml:"external-secret"`
+}
+
+// PullSecretSource is the .dockerconfigjson of the registries in a secret
+// store of the External Secrets Operator.
+type PullSecretSource struct {
+	// Store is the name of the SecretStore or ClusterSecretStore.
+	Store string `yaml:"store"`
+	// StoreKind is DefaultSecretStoreKind if empty.
+	StoreKind string `yaml:"store-kind"`
+	// Key and Property locate the .dockerconfigjson in the store.
+	Key      string `yaml:"key"`
+	Property string `yaml:"property"`
+}
+
+// pullSecrets are the registered pull secrets, if any, pullSecretOwners the
+// tool writing the ExternalSecret of each tool's namespace, and
+// pullSecretUsers the tools running pods in each namespace.
+var (
+	pullSecrets      *PullSecrets
+	pullSecretOwners map[string]string
+	pullSecretUsers  map[string][]string
+)
+
+func validatePullSecrets(secrets *PullSecrets) error {
+	if secrets == nil {
+		return nil
+	}
+	switch secrets.Inject {
+	case "", InjectPodSpecs, InjectServiceAccounts:
+	default:
+		return fmt.Errorf("invalid 'inject' %s in pull-secrets, must be %s or %s", secrets.Inject, InjectPodSpecs, InjectServiceAccounts)
+	}
+	if source := secrets.ExternalSecret; source != nil {
+		if source.Store == "" || source.Key == "" {
+			return fmt.Errorf("pull-secrets external-secret needs 'store' and 'key'")
+		}
+		switch source.StoreKind {
+		case "", "SecretStore", "ClusterSecretStore":
+		default:
+			return fmt.Errorf("invalid 'store-kind' %s in pull-secrets, must be SecretStore or ClusterSecretStore", source.StoreKind)
+		}
+	}
+	return nil
+}
+
+// RegisterPullSecrets makes smelt add the pull secrets to the tools, or
+// stop adding them if secrets is nil. The ExternalSecret of a namespace is
+// written by the first of the tools deployed into it, so tools sharing a
+// namespace don't write it twice, see ClaimPullSecretNamespace.
+func RegisterPullSecrets(secrets *PullSecrets, tools []Config) {
+	if secrets != nil && secrets.Name == "" {
+		secrets.Name = DefaultPullSecretName
+	}
+	if secrets != nil && secrets.Inject == "" {
+		secrets.Inject = InjectPodSpecs
+	}
+	if secrets != nil && secrets.ExternalSecret != nil && secrets.ExternalSecret.StoreKind == "" {
+		secrets.ExternalSecret.StoreKind = DefaultSecretStoreKind
+	}
+	pullSecrets = secrets
+	pullSecretOwners = map[string]string{}
+	pullSecretUsers = map[string][]string{}
+	for _, tool := range tools {
+		if _, exists := pullSecretOwners[tool.Namespace]; !exists {
+			pullSecretOwners[tool.Namespace] = tool.Name
+		}
+	}
+}
+
+// RegisteredPullSecrets returns the registered pull secrets, or nil.
+func RegisteredPullSecrets() *PullSecrets {
+	return pullSecrets
+}
+
+// LearnPullSecretNamespace records that the tool runs pods in the
+// namespace. All tools are to be learned before any claims a namespace.
+func LearnPullSecretNamespace(namespace, tool string) {
+	if pullSecretUsers == nil {
+		pullSecretUsers = map[string][]string{}
+	}
+	for _, user := range pullSecretUsers[namespace] {
+		if user == tool {
+			return
+		}
+	}
+	pullSecretUsers[namespace] = append(pullSecretUsers[namespace], tool)
+}
+
+// ClaimPullSecretNamespace reports whether the tool writes the
+// ExternalSecret of the namespace. A namespace which is no tool's namespace
+// goes to the first by name of the tools running pods in it, so the owner
+// doesn't depend on which tools are smelted.
+func ClaimPullSecretNamespace(namespace, tool string) bool {
+	if owner, exists := pullSecretOwners[namespace]; exists {
+		return owner == tool
+	}
+	for _, user := range pullSecretUsers[namespace] {
+		if user < tool {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadForgeConfigPullSecrets(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	config := `pull-secrets:
+  inject: everything
+  external-secret:
+    store: vault
+    key: registry/dockerconfig
+tools: []
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err := LoadForgeConfig(configPath)
+	if err == nil || ClassOf(err) != ConfigError {
+		t.Errorf("expected a config error for an unknown 'inject', got %v", err)
+	}
+}
+
+func TestValidatePullSecretsExternalSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets PullSecrets
+		valid   bool
+	}{
+		{"existing secret", PullSecrets{Name: "mirror"}, true},
+		{"external secret", PullSecrets{ExternalSecret: &PullSecretSource{Store: "vault", Key: "registry"}}, true},
+		{"missing key", PullSecrets{ExternalSecret: &PullSecretSource{Store: "vault"}}, false},
+		{"unknown store kind", PullSecrets{ExternalSecret: &PullSecretSource{Store: "vault", Key: "registry", StoreKind: "Vault"}}, false},
+	}
+	for _, test := range tests {
+		err := validatePullSecrets(&test.secrets)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestClaimPullSecretNamespace(t *testing.T) {
+	RegisterPullSecrets(&PullSecrets{}, []Config{
+		{Name: "operator", Namespace: "shared"},
+		{Name: "dashboard", Namespace: "shared"},
+	})
+	defer RegisterPullSecrets(nil, nil)
+
+	if ClaimPullSecretNamespace("shared", "dashboard") {
+		t.Errorf("expected the shared namespace to belong to the first tool")
+	}
+	if !ClaimPullSecretNamespace("shared", "operator") {
+		t.Errorf("expected the first tool to own its namespace")
+	}
+	LearnPullSecretNamespace("monitoring", "operator")
+	LearnPullSecretNamespace("monitoring", "dashboard")
+	if !ClaimPullSecretNamespace("monitoring", "dashboard") || ClaimPullSecretNamespace("monitoring", "operator") {
+		t.Errorf("expected a namespace of no tool to go to the first tool by name running pods in it")
+	}
+}
@@ -0,0 +1,264 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ReleaseRecordFile is written into every stack by cast, and applied
+	// with it to record what the cluster runs.
+	ReleaseRecordFile = "forge-release.yaml"
+	// ReleaseNamespace holds the records in the cluster, one ConfigMap per
+	// stack, see ReleaseConfigMapName.
+	ReleaseNamespace = "cluster-forge"
+	// ReleaseRecordLabel marks the record ConfigMaps, so they can be listed.
+	ReleaseRecordLabel = "clusterforge.io/release-record"
+	// releaseKey is the ConfigMap key holding the record as JSON.
+	releaseKey = "release.json"
+	// ReleaseFormatVersion is increased when the record changes
+	// incompatibly, so readers can tell records they don't understand.
+	ReleaseFormatVersion = 1
+)
+
+// ReleaseRecord describes the stack installed in a cluster: which stack, the
+// forge release which cast it, and the tools and versions in it.
+type ReleaseRecord struct {
+	FormatVersion int    `json:"formatVersion"`
+	Stack         string `json:"stack"`
+	// StackDigest identifies the stack's yaml files, like the revision of a
+	// ForgeRelease.
+	StackDigest  string       `json:"stackDigest"`
+	ForgeVersion string       `json:"forgeVersion"`
+	Tools        []ToolRecord `json:"tools"`
+}
+
+// ToolRecord is a tool in a release, with where it came from.
+type ToolRecord struct {
+	Name         string `json:"name"`
+	Namespace    string `json:"namespace,omitempty"`
+	Chart        string `json:"chart,omitempty"`
+	ChartVersion string `json:"chartVersion,omitempty"`
+	Repository   string `json:"repository,omitempty"`
+	ManifestURL  string `json:"manifestURL,omitempty"`
+	SourceFile   string `json:"sourceFile,omitempty"`
+	// BuildID is the build id of the objects the tool was smelted into.
+	BuildID string `json:"buildID,omitempty"`
+}
+
+// NewReleaseRecord describes the stack cast from the given tools, reading
+// the smelt run of each tool from its objects in workingDir.
+func NewReleaseRecord(stack string, configs []Config, tools []string, workingDir string) ReleaseRecord {
+	record := ReleaseRecord{FormatVersion: ReleaseFormatVersion, Stack: stack, ForgeVersion: ForgeVersion()}
+	for _, config := range configs {
+		if !containsString(tools, config.Name) {
+			continue
+		}
+		record.Tools = append(record.Tools, ToolRecord{
+			Name:         config.Name,
+			Namespace:    config.Namespace,
+			Chart:        config.HelmChartName,
+			ChartVersion: config.HelmVersion,
+			Repository:   config.HelmURL,
+			ManifestURL:  config.ManifestURL,
+			SourceFile:   config.SourceFile,
+			BuildID:      toolBuildID(filepath.Join(workingDir, config.Name)),
+		})
+	}
+	sort.Slice(record.Tools, func(i, j int) bool { return record.Tools[i].Name < record.Tools[j].Name })
+	return record
+}
+
+// toolBuildID returns the build id annotation of the first object in toolDir
+// which has one.
+func toolBuildID(toolDir string) string {
+	files, _ := filepath.Glob(filepath.Join(toolDir, "*.yaml"))
+	sort.Strings(files)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var object map[string]interface{}
+		if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&object); err != nil || object == nil {
+			continue
+		}
+		annotations, _ := ObjectMetadata(object)["annotations"].(map[interface{}]interface{})
+		if buildID, ok := annotations[AnnotationBuildID].(string); ok {
+			return buildID
+		}
+	}
+	return ""
+}
+
+// releaseNameRe matches what can't be in the name of a record's ConfigMap.
+var releaseNameRe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// ReleaseConfigMapName returns the name of the ConfigMap holding the record
+// of a stack. Each stack has its own, so stacks deployed side by side, e.g.
+// by several ForgeReleases, don't overwrite each other's records.
+func ReleaseConfigMapName(stack string) string {
+	name := strings.Trim(releaseNameRe.ReplaceAllString(strings.ToLower(stack), "-"), "-")
+	if len(name) > 200 {
+		name = strings.TrimRight(name[:200], "-")
+	}
+	return "forge-release-" + name
+}
+
+// WriteReleaseRecord records the digest of the stack in stackPath and writes
+// the record into it, as a ConfigMap applied with the stack.
+func WriteReleaseRecord(record ReleaseRecord, stackPath string) error {
+	digest, err := StackDigest(stackPath)
+	if err != nil {
+		return err
+	}
+	record.StackDigest = digest
+	content, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{"app.kubernetes.io/managed-by": "cluster-forge", ReleaseRecordLabel: "true"}
+	documents := []interface{}{
+		map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": ReleaseNamespace},
+		},
+		map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      ReleaseConfigMapName(record.Stack),
+				"namespace": ReleaseNamespace,
+				"labels":    labels,
+			},
+			"data": map[string]string{releaseKey: string(content)},
+		},
+	}
+	var b bytes.Buffer
+	for i, document := range documents {
+		if i > 0 {
+			b.WriteString("---\n")
+		}
+		out, err := yaml.Marshal(document)
+		if err != nil {
+			return err
+		}
+		b.Write(out)
+	}
+	if err := os.WriteFile(filepath.Join(stackPath, ReleaseRecordFile), b.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write release record: %w", err)
+	}
+	return nil
+}
+
+// ParseReleaseRecord reads the record from the data of its ConfigMap.
+func ParseReleaseRecord(data map[string]string) (ReleaseRecord, error) {
+	var record ReleaseRecord
+	content, ok := data[releaseKey]
+	if !ok {
+		return record, fmt.Errorf("release record has no %s", releaseKey)
+	}
+	if err := json.Unmarshal([]byte(content), &record); err != nil {
+		return record, fmt.Errorf("failed to parse release record: %w", err)
+	}
+	if record.FormatVersion > ReleaseFormatVersion {
+		return record, fmt.Errorf("release record has format version %d, this forge release reads up to %d", record.FormatVersion, ReleaseFormatVersion)
+	}
+	return record, nil
+}
+
+// ReadReleaseRecordFile reads the record cast into a stack, if it has one.
+func ReadReleaseRecordFile(stackPath string) (*ReleaseRecord, error) {
+	data, err := os.ReadFile(filepath.Join(stackPath, ReleaseRecordFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var object struct {
+			Kind string            `yaml:"kind"`
+			Data map[string]string `yaml:"data"`
+		}
+		err := decoder.Decode(&object)
+		if err == io.EOF {
+			return nil, fmt.Errorf("no ConfigMap in %s", ReleaseRecordFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", ReleaseRecordFile, err)
+		}
+		if object.Kind == "ConfigMap" {
+			record, err := ParseReleaseRecord(object.Data)
+			return &record, err
+		}
+	}
+}
+
+// StackDigest returns a digest of the yaml files of a stack, which
+// identifies it whichever way it was fetched. The release record is left out,
+// as it holds the digest.
+func StackDigest(stackPath string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(stackPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".yaml") && path != filepath.Join(stackPath, ReleaseRecordFile) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read stack %s: %w", stackPath, err)
+	}
+	if len(files) == 0 {
+		return "", Errorf(FetchError, "no yaml files found in stack %s", stackPath)
+	}
+	sort.Strings(files)
+
+	hash := sha256.New()
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		relativePath, _ := filepath.Rel(stackPath, file)
+		fmt.Fprintf(hash, "%s\n%d\n", filepath.ToSlash(relativePath), len(content))
+		hash.Write(content)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReleaseRecord(t *testing.T) {
+	workingDir := t.TempDir()
+	toolDir := filepath.Join(workingDir, "grafana")
+	if err := os.MkdirAll(toolDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	object := "kind: Deployment\nmetadata:\n  name: grafana\n  annotations:\n    clusterforge.io/build-id: abc123\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "Deployment_grafana.yaml"), []byte(object), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	configs := []Config{
+		{Name: "grafana", Namespace: "grafana", HelmChartName: "grafana", HelmVersion: "8.5.1", HelmURL: "https://grafana.github.io/helm-charts"},
+		{Name: "loki", Namespace: "loki", HelmChartName: "loki"},
+	}
+	record := NewReleaseRecord("platform", configs, []string{"grafana"}, workingDir)
+	if len(record.Tools) != 1 || record.Tools[0].ChartVersion != "8.5.1" || record.Tools[0].BuildID != "abc123" {
+		t.Fatalf("expected grafana with its version and build id, got %+v", record.Tools)
+	}
+
+	stackPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(stackPath, "stack.yaml"), []byte("kind: XForge\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	digest, err := StackDigest(stackPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := WriteReleaseRecord(record, stackPath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if after, _ := StackDigest(stackPath); after != digest {
+		t.Errorf("expected the record to be left out of the stack digest")
+	}
+
+	written, err := ReadReleaseRecordFile(stackPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written == nil || written.StackDigest != digest || written.Stack != "platform" || len(written.Tools) != 1 {
+		t.Errorf("expected the record with the stack digest, got %+v", written)
+	}
+
+	if _, err := ParseReleaseRecord(map[string]string{releaseKey: `{"formatVersion": 99}`}); err == nil {
+		t.Errorf("expected an error for a newer format version")
+	}
+	if missing, err := ReadReleaseRecordFile(t.TempDir()); missing != nil || err != nil {
+		t.Errorf("expected no record for a stack without one, got %v, %v", missing, err)
+	}
+}
+
+func TestReleaseConfigMapName(t *testing.T) {
+	tests := map[string]string{
+		"platform":          "forge-release-platform",
+		"Platform_2024.10":  "forge-release-platform-2024-10",
+		"-edge-":            "forge-release-edge",
+		"monitoring-stack1": "forge-release-monitoring-stack1",
+	}
+	for stack, expected := range tests {
+		if name := ReleaseConfigMapName(stack); name != expected {
+			t.Errorf("expected %s for stack %s, got %s", expected, stack, name)
+		}
+	}
+	if ReleaseConfigMapName("platform") == ReleaseConfigMapName("monitoring") {
+		t.Errorf("expected stacks to have their own records")
+	}
+}
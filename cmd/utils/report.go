@@ -0,0 +1,171 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RunReport is the record of a smelt or cast run, written as JSON when the
+// run ends, e.g. to archive as a CI artifact.
+type RunReport struct {
+	Command         string        `json:"command"`
+	Version         string        `json:"version"`
+	BuildID         string        `json:"buildId,omitempty"`
+	Workspace       string        `json:"workspace"`
+	Started         time.Time     `json:"started"`
+	DurationSeconds float64       `json:"durationSeconds"`
+	ExitCode        int           `json:"exitCode"`
+	Error           string        `json:"error,omitempty"`
+	Stages          []StageReport `json:"stages"`
+	Tools           []ToolReport  `json:"tools"`
+	Stack           *StackReport  `json:"stack,omitempty"`
+	// LockFileDigest is the sha256 of forge.lock, if there is one.
+	LockFileDigest string `json:"lockFileDigest,omitempty"`
+}
+
+// StageReport is the time and memory one tool spent in one stage.
+type StageReport struct {
+	Tool            string  `json:"tool"`
+	Stage           string  `json:"stage"`
+	Calls           int     `json:"calls"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	AllocatedBytes  uint64  `json:"allocatedBytes"`
+}
+
+// ToolReport is the outcome of smelting one tool.
+type ToolReport struct {
+	Name string `json:"name"`
+	// Objects are the files written for the tool, e.g. Deployment_grafana.yaml.
+	Objects  []string `json:"objects"`
+	Warnings []string `json:"warnings"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// StackReport is the stack built by cast.
+type StackReport struct {
+	Path  string   `json:"path"`
+	Files []string `json:"files"`
+	// Applied is set when cast --in-cluster deployed the stack.
+	Applied bool `json:"applied"`
+}
+
+type runRecorder struct {
+	mu       sync.Mutex
+	path     string
+	lockFile string
+	report   RunReport
+}
+
+// runReport records the current run, nil unless a report was started.
+var runReport *runRecorder
+
+// StartRunReport starts recording the run of command, to be written to path
+// by WriteRunReport. The stage timings are only recorded with --profile-run.
+func StartRunReport(command string, workspace Workspace, path string) {
+	runReport = &runRecorder{path: path, lockFile: workspace.LockFile()}
+	runReport.report = RunReport{
+		Command:   command,
+		Version:   ForgeVersion(),
+		Workspace: workspace.Name,
+		Started:   time.Now().UTC(),
+		Stages:    []StageReport{},
+		Tools:     []ToolReport{},
+	}
+}
+
+// RecordTool adds the outcome of smelting a tool to the run report.
+func RecordTool(tool ToolReport) {
+	if runReport == nil {
+		return
+	}
+	if tool.Objects == nil {
+		tool.Objects = []string{}
+	}
+	if tool.Warnings == nil {
+		tool.Warnings = []string{}
+	}
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+	runReport.report.Tools = append(runReport.report.Tools, tool)
+}
+
+// ResetToolReports drops the tools recorded so far, so a report of a watch
+// run holds the tools of its last round only.
+func ResetToolReports() {
+	if runReport == nil {
+		return
+	}
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+	runReport.report.Tools = []ToolReport{}
+}
+
+// RecordStack adds the stack built by the run to the run report.
+func RecordStack(path string, applied bool) {
+	if runReport == nil {
+		return
+	}
+	files, _ := filepath.Glob(filepath.Join(path, "*.yaml"))
+	stack := &StackReport{Path: path, Files: []string{}, Applied: applied}
+	for _, file := range files {
+		stack.Files = append(stack.Files, filepath.Base(file))
+	}
+	sort.Strings(stack.Files)
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+	runReport.report.Stack = stack
+}
+
+// WriteRunReport completes the run report with the outcome of the run and
+// writes it, if one was started.
+func WriteRunReport(runErr error) error {
+	if runReport == nil {
+		return nil
+	}
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+	report := runReport.report
+	report.BuildID = BuildID()
+	report.DurationSeconds = time.Since(report.Started).Seconds()
+	report.ExitCode = ExitCode(runErr)
+	if runErr != nil {
+		report.Error = runErr.Error()
+	}
+	report.Stages = profileStages()
+	if data, err := os.ReadFile(runReport.lockFile); err == nil {
+		report.LockFileDigest = SourceDigest(data)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to write run report: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(runReport.path), 0755); err != nil {
+		return fmt.Errorf("failed to write run report: %w", err)
+	}
+	if err := os.WriteFile(runReport.path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write run report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteRunReport(t *testing.T) {
+	root := t.TempDir()
+	workspace := Workspace{Name: "staging", Root: root}
+	if err := os.WriteFile(workspace.LockFile(), []byte("images: {}\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stackDir := filepath.Join(root, "stacks", "platform")
+	if err := os.MkdirAll(stackDir, 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(stackDir, "composition.yaml"), []byte("kind: Composition\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reportPath := filepath.Join(root, "reports", "smelt.json")
+
+	// As with --profile-run
+	EnableProfiling()
+	StartRunReport("smelt", workspace, reportPath)
+	defer func() { runReport, profiler = nil, nil }()
+	StartStage("grafana", StageRender)()
+	// An earlier watch round
+	RecordTool(ToolReport{Name: "loki"})
+	ResetToolReports()
+	RecordTool(ToolReport{Name: "grafana", Objects: []string{"Deployment_grafana.yaml"}})
+	RecordStack(stackDir, false)
+	if err := WriteRunReport(Errorf(RenderError, "broken chart")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var report RunReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Command != "smelt" || report.Workspace != "staging" || report.ExitCode != int(RenderError) || report.Error != "broken chart" {
+		t.Errorf("unexpected run outcome: %+v", report)
+	}
+	if len(report.Stages) != 1 || report.Stages[0].Tool != "grafana" || report.Stages[0].Stage != StageRender {
+		t.Errorf("unexpected stages: %+v", report.Stages)
+	}
+	if len(report.Tools) != 1 || report.Tools[0].Name != "grafana" || len(report.Tools[0].Objects) != 1 || report.Tools[0].Warnings == nil {
+		t.Errorf("unexpected tools: %+v", report.Tools)
+	}
+	if report.Stack == nil || len(report.Stack.Files) != 1 || report.Stack.Applied {
+		t.Errorf("unexpected stack: %+v", report.Stack)
+	}
+	if report.LockFileDigest != SourceDigest([]byte("images: {}\n")) {
+		t.Errorf("unexpected lock file digest %s", report.LockFileDigest)
+	}
+}
+
+func TestWriteRunReportNotStarted(t *testing.T) {
+	RecordTool(ToolReport{Name: "grafana"})
+	if err := WriteRunReport(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWriteRunReportWithoutProfiling(t *testing.T) {
+	root := t.TempDir()
+	reportPath := filepath.Join(root, "smelt.json")
+	StartRunReport("smelt", Workspace{Name: "staging", Root: root}, reportPath)
+	defer func() { runReport = nil }()
+	StartStage("grafana", StageRender)()
+	if profiler != nil {
+		t.Errorf("expected the report not to enable profiling")
+	}
+	if err := WriteRunReport(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var report RunReport
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Stages == nil || len(report.Stages) != 0 {
+		t.Errorf("expected no stages, got %+v", report.Stages)
+	}
+}
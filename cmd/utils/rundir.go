@@ -0,0 +1,94 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RunDirPattern matches the names of the workspace's run directories in the
+// temp directory. They are named after the absolute path of the workspace, so
+// workspaces and checkouts sharing a machine never see each other's runs.
+func (w Workspace) RunDirPattern() string {
+	root, err := filepath.Abs(w.Root)
+	if err != nil {
+		root = w.Root
+	}
+	sum := sha256.Sum256([]byte(root))
+	return "forge-run-" + hex.EncodeToString(sum[:])[:12] + "-*"
+}
+
+// RunDir is a working directory private to a single run, for intermediate
+// files which used to live in the shared working/pre and output directories.
+// Concurrent runs on the same machine each get their own. It is locked while
+// in use, so clean can tell it apart from ones left behind.
+type RunDir struct {
+	Path string
+	keep bool
+	lock *RunLock
+}
+
+// NewRunDir creates a new run directory of the workspace under the system
+// temp directory (TMPDIR). If keep is set, Cleanup leaves it in place for
+// debugging.
+func NewRunDir(workspace Workspace, keep bool) (*RunDir, error) {
+	path, err := os.MkdirTemp("", workspace.RunDirPattern())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run directory: %w", err)
+	}
+	lock, err := AcquireRunLock(path, "run", 0)
+	if err != nil {
+		return nil, err
+	}
+	runDir := &RunDir{Path: path, keep: keep, lock: lock}
+	for _, dir := range []string{runDir.PreDir(), runDir.OutputDir()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create run directory: %w", err)
+		}
+	}
+	log.Debugf("Using run directory %s", path)
+	return runDir, nil
+}
+
+// PreDir holds the rendered manifests of each tool before they are split.
+func (r *RunDir) PreDir() string {
+	return filepath.Join(r.Path, "pre")
+}
+
+// OutputDir holds the compiled templates which are built into the stack image.
+func (r *RunDir) OutputDir() string {
+	return filepath.Join(r.Path, "output")
+}
+
+// Cleanup removes the run directory, unless it is to be kept.
+func (r *RunDir) Cleanup() {
+	r.lock.Release()
+	if r.keep {
+		log.Infof("Keeping run directory %s", r.Path)
+		fmt.Fprintf(os.Stderr, "Kept working directory: %s\n", r.Path)
+		return
+	}
+	if err := os.RemoveAll(r.Path); err != nil {
+		log.Warnf("Failed to remove run directory %s: %v", r.Path, err)
+	}
+}
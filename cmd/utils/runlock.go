@@ -0,0 +1,113 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RunLockFile is created in the project directory while a run which writes
+// to the shared working and stacks directories is in progress.
+const RunLockFile = ".forge-run.lock"
+
+// errLocked is returned by tryLock when another process holds the lock.
+var errLocked = errors.New("locked")
+
+// RunLock is an advisory lock on the project directory, held for a run.
+type RunLock struct {
+	file *os.File
+}
+
+// AcquireRunLock locks dir for this run. If another run holds the lock, it
+// waits for up to wait before giving up with an error naming the holder.
+func AcquireRunLock(dir string, command string, wait time.Duration) (*RunLock, error) {
+	path := filepath.Join(dir, RunLockFile)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	waiting := false
+	for {
+		err = tryLock(file)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errLocked) {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		holder, _ := os.ReadFile(path)
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("another forge run is in progress in %s (%s); wait for it to finish or use --lock-wait to queue behind it",
+				dir, strings.TrimSpace(string(holder)))
+		}
+		if !waiting {
+			log.Infof("Waiting for lock %s held by %s", path, strings.TrimSpace(string(holder)))
+			waiting = true
+		}
+		time.Sleep(time.Second)
+	}
+
+	hostname, _ := os.Hostname()
+	holder := fmt.Sprintf("%s by pid %d on %s since %s", command, os.Getpid(), hostname, time.Now().Format(time.RFC3339))
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(holder+"\n"), 0)
+	}
+	return &RunLock{file: file}, nil
+}
+
+// RunLockHolder returns the holder of the lock on dir, or "" if no run holds
+// it. Unlike AcquireRunLock it never creates the lock file.
+func RunLockHolder(dir string) (string, error) {
+	path := filepath.Join(dir, RunLockFile)
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to open lock file: %w", err)
+	}
+	defer file.Close()
+	err = tryLock(file)
+	if errors.Is(err, errLocked) {
+		holder, _ := os.ReadFile(path)
+		return strings.TrimSpace(string(holder)), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return "", unlock(file)
+}
+
+// Release unlocks the project directory.
+func (l *RunLock) Release() {
+	l.file.Truncate(0)
+	if err := unlock(l.file); err != nil {
+		log.Warnf("Failed to release lock %s: %v", l.file.Name(), err)
+	}
+	l.file.Close()
+}
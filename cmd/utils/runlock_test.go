@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAcquireRunLock(t *testing.T) {
+	dir, err := os.MkdirTemp("", "lock-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock, err := AcquireRunLock(dir, "smelt", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = AcquireRunLock(dir, "cast", 0)
+	if err == nil {
+		t.Fatalf("expected second lock to fail while the first is held")
+	}
+	if !strings.Contains(err.Error(), "smelt by pid") {
+		t.Errorf("expected error to name the holder, got: %v", err)
+	}
+
+	lock.Release()
+	lock, err = AcquireRunLock(dir, "cast", 0)
+	if err != nil {
+		t.Fatalf("expected lock to be free after release: %v", err)
+	}
+	lock.Release()
+}
@@ -0,0 +1,440 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// validateHelmValues checks the values of a tool against the
+// values.schema.json of the chart in chartDir and of its subcharts, so
+// mistakes in the values fail before rendering. Like helm, it validates the
+// chart's defaults merged with the tool's values, and each enabled subchart
+// with its part of them.
+func validateHelmValues(config Config, chartDir, valuesPath string) error {
+	files, err := readChartDir(chartDir)
+	if err != nil {
+		return Errorf(FetchError, "failed to read chart %s of %s: %w", config.HelmChartName, config.Name, err)
+	}
+	chart, err := loadChart(files)
+	if err != nil {
+		return Errorf(FetchError, "failed to read chart %s of %s: %w", config.HelmChartName, config.Name, err)
+	}
+	if !chart.hasSchema() {
+		log.Debugf("Chart %s of %s has no values schema", config.HelmChartName, config.Name)
+		return nil
+	}
+	values, err := readValues(valuesPath)
+	if err != nil {
+		return Errorf(ConfigError, "failed to read values of %s: %w", config.Name, err)
+	}
+
+	problems, err := chart.validate(values, "")
+	if err != nil {
+		return Errorf(FetchError, "invalid values schema in chart %s of %s: %w", config.HelmChartName, config.Name, err)
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return Errorf(ValidationError, "values of %s do not match the chart's schema:\n  %s", config.Name, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// chartSchema is what validating values needs of a chart.
+type chartSchema struct {
+	name         string
+	schema       []byte
+	defaults     map[string]interface{}
+	dependencies []chartDependency
+	subcharts    []*chartSchema
+}
+
+// chartDependency is a dependency in Chart.yaml, which gets the values
+// under its alias or name, if its condition holds.
+type chartDependency struct {
+	Name      string `yaml:"name"`
+	Alias     string `yaml:"alias"`
+	Condition string `yaml:"condition"`
+}
+
+// chartFile reports whether validating values reads the file of a chart.
+func chartFile(path string) bool {
+	switch filepath.Base(path) {
+	case "Chart.yaml", "values.yaml", "values.schema.json":
+		return true
+	}
+	return strings.HasSuffix(path, ".tgz")
+}
+
+// readChartDir reads the files of the chart in dir and its subcharts which
+// validating values needs, by their path in the chart.
+func readChartDir(dir string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !chartFile(path) {
+			return err
+		}
+		relative, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(relative)], err = os.ReadFile(path)
+		return err
+	})
+	return files, err
+}
+
+// readChartArchive reads the files of a packaged chart like readChartDir.
+// The files of the archive are in a directory named after the chart.
+func readChartArchive(data []byte) (map[string][]byte, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	tarReader := tar.NewReader(gzipReader)
+	files := map[string][]byte{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, path, found := strings.Cut(header.Name, "/")
+		if header.Typeflag != tar.TypeReg || !found || !chartFile(path) {
+			continue
+		}
+		if files[path], err = io.ReadAll(tarReader); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// loadChart reads a chart and its subcharts, in its charts directory either
+// unpacked or packaged, from its files.
+func loadChart(files map[string][]byte) (*chartSchema, error) {
+	var metadata struct {
+		Name         string            `yaml:"name"`
+		Dependencies []chartDependency `yaml:"dependencies"`
+	}
+	if err := yaml.Unmarshal(files["Chart.yaml"], &metadata); err != nil {
+		return nil, fmt.Errorf("invalid Chart.yaml: %w", err)
+	}
+	chart := &chartSchema{name: metadata.Name, schema: files["values.schema.json"], dependencies: metadata.Dependencies}
+	if data, exists := files["values.yaml"]; exists {
+		defaults, err := parseValues(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid values.yaml of chart %s: %w", metadata.Name, err)
+		}
+		chart.defaults = defaults
+	}
+
+	subchartFiles := map[string]map[string][]byte{}
+	var archives []string
+	for path := range files {
+		rest, inCharts := strings.CutPrefix(path, "charts/")
+		if !inCharts {
+			continue
+		}
+		name, subchartPath, inDir := strings.Cut(rest, "/")
+		if !inDir {
+			if strings.HasSuffix(rest, ".tgz") {
+				archives = append(archives, path)
+			}
+			continue
+		}
+		if subchartFiles[name] == nil {
+			subchartFiles[name] = map[string][]byte{}
+		}
+		subchartFiles[name][subchartPath] = files[path]
+	}
+	for _, path := range archives {
+		archiveFiles, err := readChartArchive(files[path])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read subchart %s: %w", path, err)
+		}
+		subchartFiles[path] = archiveFiles
+	}
+	names := make([]string, 0, len(subchartFiles))
+	for name := range subchartFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		subchart, err := loadChart(subchartFiles[name])
+		if err != nil {
+			return nil, err
+		}
+		chart.subcharts = append(chart.subcharts, subchart)
+	}
+	return chart, nil
+}
+
+// hasSchema reports whether the chart or any of its subcharts has a values
+// schema.
+func (c *chartSchema) hasSchema() bool {
+	if c.schema != nil {
+		return true
+	}
+	for _, subchart := range c.subcharts {
+		if subchart.hasSchema() {
+			return true
+		}
+	}
+	return false
+}
+
+// validate validates the chart's defaults merged with values, and its
+// subcharts with their part of them. The paths of the problems start with
+// prefix, the path of the chart's values in the parent chart's.
+func (c *chartSchema) validate(values map[string]interface{}, prefix string) ([]string, error) {
+	merged := mergeValues(c.defaults, values)
+	var problems []string
+	if c.schema != nil {
+		chartProblems, err := validateValues(c.schema, merged, prefix)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, chartProblems...)
+	}
+	for _, subchart := range c.subcharts {
+		for _, dependency := range c.subchartDependencies(subchart) {
+			if !conditionHolds(dependency.Condition, merged) {
+				continue
+			}
+			key := dependency.Name
+			if dependency.Alias != "" {
+				key = dependency.Alias
+			}
+			// Like helm, the subchart sees its own values and the globals
+			subchartValues := map[string]interface{}{}
+			if own, ok := merged[key].(map[string]interface{}); ok {
+				for name, value := range own {
+					subchartValues[name] = value
+				}
+			}
+			if globals, ok := merged["global"].(map[string]interface{}); ok {
+				subchartGlobals, _ := subchartValues["global"].(map[string]interface{})
+				subchartValues["global"] = mergeValues(subchartGlobals, globals)
+			}
+			subchartProblems, err := subchart.validate(subchartValues, prefix+key+".")
+			if err != nil {
+				return nil, fmt.Errorf("subchart %s: %w", key, err)
+			}
+			problems = append(problems, subchartProblems...)
+		}
+	}
+	return problems, nil
+}
+
+// subchartDependencies returns the dependencies of the chart in Chart.yaml
+// using the subchart, several if it is aliased more than once. A subchart
+// which isn't declared is used under its name.
+func (c *chartSchema) subchartDependencies(subchart *chartSchema) []chartDependency {
+	var dependencies []chartDependency
+	for _, dependency := range c.dependencies {
+		if dependency.Name == subchart.name {
+			dependencies = append(dependencies, dependency)
+		}
+	}
+	if len(dependencies) == 0 {
+		dependencies = append(dependencies, chartDependency{Name: subchart.name})
+	}
+	return dependencies
+}
+
+// conditionHolds evaluates the condition of a dependency, a comma-separated
+// list of value paths of which the first one set decides, like helm.
+func conditionHolds(condition string, values map[string]interface{}) bool {
+	if condition == "" {
+		return true
+	}
+	for _, path := range strings.Split(condition, ",") {
+		var value interface{} = values
+		for _, key := range strings.Split(strings.TrimSpace(path), ".") {
+			valueMap, _ := value.(map[string]interface{})
+			value = valueMap[key]
+		}
+		if enabled, ok := value.(bool); ok {
+			return enabled
+		}
+	}
+	return true
+}
+
+// pullChart downloads and unpacks the chart of a tool, with its
+// dependencies, into a temporary directory, returning the chart's directory
+// and a function removing it.
+func pullChart(config Config, helmExec HelmExecutor) (string, func(), error) {
+	tmpDir, err := os.MkdirTemp("", "forge-chart-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	args := []string{"pull", config.HelmChartName, "--repo", config.HelmURL, "--untar", "--untardir", tmpDir}
+	if config.HelmVersion != "" {
+		args = append(args, "--version", config.HelmVersion)
+	}
+	var stderr bytes.Buffer
+	stopFetch := StartStage(config.Name, StageFetch)
+	err = helmExec.RunHelmCommand(args, &bytes.Buffer{}, &stderr)
+	stopFetch()
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("helm pull failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	// The chart is unpacked into a directory named after it
+	chartDir := filepath.Join(tmpDir, filepath.Base(config.HelmChartName))
+	if _, err := os.Stat(chartDir); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("chart %s not found after helm pull", config.HelmChartName)
+	}
+	return chartDir, cleanup, nil
+}
+
+func readValues(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseValues(data)
+}
+
+func parseValues(data []byte) (map[string]interface{}, error) {
+	var values map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	converted, _ := toJSONValue(values).(map[string]interface{})
+	if converted == nil {
+		converted = map[string]interface{}{}
+	}
+	return converted, nil
+}
+
+// toJSONValue converts the maps decoded by yaml.v2 into the string keyed
+// maps of JSON.
+func toJSONValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			converted[fmt.Sprint(key)] = toJSONValue(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, item := range value {
+			converted[i] = toJSONValue(item)
+		}
+		return converted
+	default:
+		return value
+	}
+}
+
+// mergeValues returns the chart defaults overridden by the given values.
+// Maps are merged, everything else is replaced, and a null value removes
+// the default, as helm does.
+func mergeValues(defaults, values map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(defaults))
+	for key, value := range defaults {
+		merged[key] = value
+	}
+	for key, value := range values {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		valueMap, isMap := value.(map[string]interface{})
+		defaultMap, defaultIsMap := merged[key].(map[string]interface{})
+		if isMap && defaultIsMap {
+			merged[key] = mergeValues(defaultMap, valueMap)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// validateValues validates values against a JSON schema. It returns one
+// problem per invalid value, prefixed with the value's path below prefix, or
+// an error if the schema itself is invalid.
+func validateValues(schemaData []byte, values map[string]interface{}, prefix string) ([]string, error) {
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource("values.schema.json", bytes.NewReader(schemaData)); err != nil {
+		return nil, err
+	}
+	schema, err := compiler.Compile("values.schema.json")
+	if err != nil {
+		return nil, err
+	}
+
+	err = schema.Validate(values)
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return nil, err
+	}
+	var problems []string
+	collectProblems(validationErr, prefix, &problems)
+	sort.Strings(problems)
+	return problems, nil
+}
+
+// collectProblems flattens the tree of validation errors into the messages
+// of its leaves, which name the values actually failing.
+func collectProblems(err *jsonschema.ValidationError, prefix string, problems *[]string) {
+	if len(err.Causes) == 0 {
+		*problems = append(*problems, fmt.Sprintf("%s: %s", valuesPath(err.InstanceLocation, prefix), err.Message))
+		return
+	}
+	for _, cause := range err.Causes {
+		collectProblems(cause, prefix, problems)
+	}
+}
+
+// valuesPath turns a JSON pointer like /image/tag into the dotted path used
+// for helm values, image.tag, below the prefix of a subchart's values.
+func valuesPath(pointer, prefix string) string {
+	if pointer == "" {
+		if prefix == "" {
+			return "(root)"
+		}
+		return strings.TrimSuffix(prefix, ".")
+	}
+	parts := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, part := range parts {
+		parts[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+	}
+	return prefix + strings.Join(parts, ".")
+}
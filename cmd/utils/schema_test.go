@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testValuesSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "properties": {
+    "replicaCount": {"type": "integer", "minimum": 1},
+    "image": {
+      "type": "object",
+      "properties": {"tag": {"type": "string"}},
+      "additionalProperties": false
+    }
+  },
+  "required": ["image"]
+}`
+
+// schemaChartExecutor pulls a chart with the test schema and renders nothing.
+func schemaChartExecutor(t *testing.T) *MockHelmExecutor {
+	return &MockHelmExecutor{
+		RunFunc: func(args []string, stdout io.Writer, stderr io.Writer) error {
+			switch args[0] {
+			case "pull":
+				var untarDir string
+				for i, arg := range args {
+					if arg == "--untardir" {
+						untarDir = args[i+1]
+					}
+				}
+				chartDir := filepath.Join(untarDir, args[1])
+				if err := os.MkdirAll(chartDir, 0755); err != nil {
+					t.Fatalf("Failed to create chart: %v", err)
+				}
+				os.WriteFile(filepath.Join(chartDir, "values.schema.json"), []byte(testValuesSchema), 0644)
+				os.WriteFile(filepath.Join(chartDir, "values.yaml"), []byte("replicaCount: 1\nimage:\n  tag: v1\n"), 0644)
+				return nil
+			case "template":
+				return nil
+			}
+			return errors.New("unexpected command")
+		},
+	}
+}
+
+func TestTemplatehelmValuesSchema(t *testing.T) {
+	inputDir := t.TempDir()
+	SetInputDirs([]string{inputDir})
+	defer SetInputDirs([]string{"input"})
+	if err := os.MkdirAll(filepath.Join(inputDir, "test"), 0755); err != nil {
+		t.Fatalf("Failed to create input directory: %v", err)
+	}
+
+	config := Config{
+		Name:          "test",
+		HelmURL:       "https://example.com/repo",
+		HelmChartName: "example-chart",
+		Values:        "values.yaml",
+		Namespace:     "test",
+		Filename:      filepath.Join(t.TempDir(), "output.yaml"),
+	}
+	writeValues := func(values string) {
+		if err := os.WriteFile(filepath.Join(inputDir, "test", "values.yaml"), []byte(values), 0644); err != nil {
+			t.Fatalf("Failed to write values: %v", err)
+		}
+	}
+
+	t.Run("Valid values", func(t *testing.T) {
+		writeValues("replicaCount: 3\n")
+		if err := Templatehelm(config, schemaChartExecutor(t)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Invalid values", func(t *testing.T) {
+		writeValues("replicaCount: \"3\"\nimage:\n  tga: v2\n")
+		err := Templatehelm(config, schemaChartExecutor(t))
+		if ClassOf(err) != ValidationError {
+			t.Fatalf("expected a validation error, got: %v", err)
+		}
+		for _, path := range []string{"replicaCount:", "image:"} {
+			if !strings.Contains(err.Error(), path) {
+				t.Errorf("expected the error to name %s, got: %v", path, err)
+			}
+		}
+	})
+
+	t.Run("Removed required value", func(t *testing.T) {
+		writeValues("image: null\n")
+		err := Templatehelm(config, schemaChartExecutor(t))
+		if ClassOf(err) != ValidationError || !strings.Contains(err.Error(), "(root):") {
+			t.Fatalf("expected a validation error for the missing image, got: %v", err)
+		}
+	})
+}
+
+const testPortSchema = `{"type": "object", "properties": {"port": {"type": "integer"}}}`
+
+// writeChartArchive packages the files of a chart like helm, in a directory
+// named after the chart.
+func writeChartArchive(t *testing.T, path, name string, files map[string]string) {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for file, content := range files {
+		if err := tarWriter.WriteHeader(&tar.Header{Name: name + "/" + file, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateHelmValuesSubcharts(t *testing.T) {
+	// A chart without a schema of its own, with a packaged subchart enabled
+	// by a condition and an unpacked one under an alias
+	chartDir := t.TempDir()
+	files := map[string]string{
+		"Chart.yaml":                         "name: platform\ndependencies:\n- name: redis\n  condition: redis.enabled\n- name: exporter\n  alias: metrics\n",
+		"values.yaml":                        "redis:\n  enabled: true\n",
+		"charts/exporter/Chart.yaml":         "name: exporter\n",
+		"charts/exporter/values.yaml":        "port: 9100\n",
+		"charts/exporter/values.schema.json": testPortSchema,
+	}
+	for file, content := range files {
+		path := filepath.Join(chartDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	writeChartArchive(t, filepath.Join(chartDir, "charts", "redis-1.0.0.tgz"), "redis", map[string]string{
+		"Chart.yaml":         "name: redis\n",
+		"values.yaml":        "port: 6379\n",
+		"values.schema.json": testPortSchema,
+	})
+	config := Config{Name: "platform", HelmChartName: "platform"}
+	valuesPath := filepath.Join(t.TempDir(), "values.yaml")
+
+	tests := []struct {
+		name     string
+		values   string
+		problems []string
+	}{
+		{"valid", "redis:\n  port: 6380\nmetrics:\n  port: 9101\n", nil},
+		{"invalid", "redis:\n  port: high\nmetrics:\n  port: low\n", []string{"metrics.port:", "redis.port:"}},
+		{"disabled subchart", "redis:\n  enabled: false\n  port: high\n", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := os.WriteFile(valuesPath, []byte(test.values), 0644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err := validateHelmValues(config, chartDir, valuesPath)
+			if len(test.problems) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if ClassOf(err) != ValidationError {
+				t.Fatalf("expected a validation error, got: %v", err)
+			}
+			for _, problem := range test.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("expected the error to name %s, got: %v", problem, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeValues(t *testing.T) {
+	defaults := map[string]interface{}{
+		"image":    map[string]interface{}{"repository": "nginx", "tag": "v1"},
+		"replicas": 1,
+		"debug":    true,
+	}
+	values := map[string]interface{}{
+		"image": map[string]interface{}{"tag": "v2"},
+		"debug": nil,
+	}
+	merged := mergeValues(defaults, values)
+
+	image := merged["image"].(map[string]interface{})
+	if image["repository"] != "nginx" || image["tag"] != "v2" {
+		t.Errorf("unexpected image values: %v", image)
+	}
+	if merged["replicas"] != 1 {
+		t.Errorf("expected the default replicas to be kept, got: %v", merged["replicas"])
+	}
+	if _, exists := merged["debug"]; exists {
+		t.Errorf("expected a null value to remove the default")
+	}
+}
@@ -0,0 +1,117 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ScopeCluster    = "Cluster"
+	ScopeNamespaced = "Namespaced"
+)
+
+// ResourceScope maps a custom resource type to its scope, for types which
+// are neither in the built-in list nor defined by a CRD in the input.
+type ResourceScope struct {
+	Kind       string `yaml:"kind"`
+	APIVersion string `yaml:"api-version"`
+	Scope      string `yaml:"scope"`
+}
+
+// configuredScopes holds the user-defined scopes, keyed by scopeKey.
+// The value is true for cluster-scoped resources.
+var configuredScopes = map[string]bool{}
+
+// learnedScopes holds the scopes read from CRDs in the input during this run,
+// keyed like configuredScopes.
+var learnedScopes = map[string]bool{}
+
+type customResourceDefinition struct {
+	Kind string `yaml:"kind"`
+	Spec struct {
+		Group    string `yaml:"group"`
+		Version  string `yaml:"version"`
+		Versions []struct {
+			Name string `yaml:"name"`
+		} `yaml:"versions"`
+		Names struct {
+			Kind string `yaml:"kind"`
+		} `yaml:"names"`
+		Scope string `yaml:"scope"`
+	} `yaml:"spec"`
+}
+
+func scopeKey(kind, apiVersion string) string {
+	return strings.ToLower(apiVersion + "/" + kind)
+}
+
+// RegisterResourceScopes makes the given scopes take precedence over the
+// built-in list in IsClusterScoped. It replaces the scopes registered and
+// learned before, so every run starts from its own config.
+func RegisterResourceScopes(scopes []ResourceScope) {
+	configuredScopes = map[string]bool{}
+	learnedScopes = map[string]bool{}
+	for _, scope := range scopes {
+		configuredScopes[scopeKey(scope.Kind, scope.APIVersion)] = strings.EqualFold(scope.Scope, ScopeCluster)
+	}
+}
+
+// LearnCRDScopes records the scope of every version of the resource defined
+// by the given document, if it is a CustomResourceDefinition. Other documents
+// are ignored.
+func LearnCRDScopes(document []byte) error {
+	var crd customResourceDefinition
+	err := yaml.Unmarshal(document, &crd)
+	if err != nil {
+		return err
+	}
+	if crd.Kind != "CustomResourceDefinition" || crd.Spec.Names.Kind == "" || crd.Spec.Scope == "" {
+		return nil
+	}
+
+	versions := []string{}
+	if crd.Spec.Version != "" {
+		versions = append(versions, crd.Spec.Version)
+	}
+	for _, version := range crd.Spec.Versions {
+		versions = append(versions, version.Name)
+	}
+	for _, version := range versions {
+		apiVersion := crd.Spec.Group + "/" + version
+		learnedScopes[scopeKey(crd.Spec.Names.Kind, apiVersion)] = strings.EqualFold(crd.Spec.Scope, ScopeCluster)
+	}
+	return nil
+}
+
+func validateResourceScopes(scopes []ResourceScope) error {
+	for _, scope := range scopes {
+		if scope.Kind == "" {
+			return fmt.Errorf("missing 'kind' in resource-scopes entry: %+v", scope)
+		}
+		if scope.APIVersion == "" {
+			return fmt.Errorf("missing 'api-version' in resource-scopes entry: %+v", scope)
+		}
+		if !strings.EqualFold(scope.Scope, ScopeCluster) && !strings.EqualFold(scope.Scope, ScopeNamespaced) {
+			return fmt.Errorf("'scope' must be %s or %s in resource-scopes entry: %+v", ScopeCluster, ScopeNamespaced, scope)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadForgeConfig(t *testing.T) {
+	dir, err := os.MkdirTemp("", "config-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("List of tools", func(t *testing.T) {
+		path := filepath.Join(dir, "list.yaml")
+		content := `
+- name: test
+  namespace: test
+  manifest-url: https://example.com/manifest.yaml
+`
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+
+		forgeConfig, err := LoadForgeConfig(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(forgeConfig.Tools) != 1 || forgeConfig.Tools[0].Name != "test" {
+			t.Errorf("unexpected tools: %+v", forgeConfig.Tools)
+		}
+	})
+
+	t.Run("Mapping with resource scopes", func(t *testing.T) {
+		path := filepath.Join(dir, "mapping.yaml")
+		content := `
+resource-scopes:
+  - kind: Widget
+    api-version: example.com/v1
+    scope: Cluster
+tools:
+  - name: test
+    namespace: test
+    manifest-url: https://example.com/manifest.yaml
+`
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+
+		forgeConfig, err := LoadForgeConfig(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(forgeConfig.Tools) != 1 {
+			t.Errorf("expected 1 tool, got %d", len(forgeConfig.Tools))
+		}
+		if len(forgeConfig.ResourceScopes) != 1 || forgeConfig.ResourceScopes[0].Kind != "Widget" {
+			t.Errorf("unexpected resource scopes: %+v", forgeConfig.ResourceScopes)
+		}
+	})
+
+	t.Run("Invalid scope (should fail)", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.yaml")
+		content := `
+resource-scopes:
+  - kind: Widget
+    api-version: example.com/v1
+    scope: Global
+tools: []
+`
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+
+		if _, err := LoadForgeConfig(path); err == nil {
+			t.Errorf("expected error for invalid scope")
+		}
+	})
+}
+
+func TestIsClusterScopedWithConfiguredScopes(t *testing.T) {
+	defer func() { configuredScopes = map[string]bool{} }()
+
+	RegisterResourceScopes([]ResourceScope{
+		{Kind: "Widget", APIVersion: "example.com/v1", Scope: "Cluster"},
+		{Kind: "ClusterRole", APIVersion: "rbac.authorization.k8s.io/v1", Scope: "namespaced"},
+	})
+
+	if !IsClusterScoped("Widget", "example.com/v1") {
+		t.Errorf("expected Widget to be cluster-scoped")
+	}
+	if IsClusterScoped("Widget", "example.com/v2") {
+		t.Errorf("expected Widget v2 to be namespaced")
+	}
+	if IsClusterScoped("ClusterRole", "rbac.authorization.k8s.io/v1") {
+		t.Errorf("expected configured scope to override the built-in list")
+	}
+	if !IsClusterScoped("Namespace", "v1") {
+		t.Errorf("expected Namespace to be cluster-scoped")
+	}
+}
+
+func TestLearnCRDScopes(t *testing.T) {
+	defer func() { learnedScopes = map[string]bool{} }()
+
+	crd := `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: gadgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Gadget
+    plural: gadgets
+  scope: Cluster
+  versions:
+    - name: v1alpha1
+    - name: v1
+`
+	if err := LearnCRDScopes([]byte(crd)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := LearnCRDScopes([]byte("apiVersion: v1\nkind: ConfigMap\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !IsClusterScoped("Gadget", "example.com/v1") || !IsClusterScoped("Gadget", "example.com/v1alpha1") {
+		t.Errorf("expected all versions of Gadget to be cluster-scoped")
+	}
+	if IsClusterScoped("ConfigMap", "v1") {
+		t.Errorf("expected ConfigMap to be namespaced")
+	}
+
+	// Registering the scopes of a run forgets those learned before
+	RegisterResourceScopes([]ResourceScope{{Kind: "Gadget", APIVersion: "example.com/v1", Scope: "Namespaced"}})
+	defer func() { configuredScopes = map[string]bool{} }()
+	if IsClusterScoped("Gadget", "example.com/v1alpha1") {
+		t.Errorf("expected the learned scopes to be reset")
+	}
+	if err := LearnCRDScopes([]byte(crd)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if IsClusterScoped("Gadget", "example.com/v1") {
+		t.Errorf("expected configured scope to override the learned scope")
+	}
+}
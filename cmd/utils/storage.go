@@ -0,0 +1,40 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+// storageClasses maps the storage classes charts ask for to the classes to
+// use instead, set in the storage-classes section of config.yaml. The empty
+// class stands for claims without a class, which get the cluster's default.
+var storageClasses map[string]string
+
+// RegisterStorageClasses makes smelt rewrite the storage classes of the
+// tools' volume claims following the given mapping.
+func RegisterStorageClasses(classes map[string]string) {
+	storageClasses = classes
+}
+
+// MapStorageClass returns the storage class to use instead of class, and
+// whether it is mapped at all. An empty class is the cluster's default.
+func MapStorageClass(class string) (string, bool) {
+	mapped, exists := storageClasses[class]
+	return mapped, exists
+}
+
+// HasStorageClasses reports whether any storage classes are mapped.
+func HasStorageClasses() bool {
+	return len(storageClasses) > 0
+}
@@ -106,38 +106,152 @@ var clusterScopedResources = []ClusterScopedResource{
 	{"VolumeAttachment", "storage.k8s.io/v1"},
 	{"Connector", "tailscale.com/v1alpha1"},
 	{"ProxyClass", "tailscale.com/v1alpha1"},
+	{"ConstraintTemplate", "templates.gatekeeper.sh/v1"},
 	{"Audit", "warden.gke.io/v1"},
 }
 
-func LoadConfig(filename string) ([]Config, error) {
+// ForgeConfig is the content of the input config file. The file is either
+// a plain list of tools, or a mapping with a 'tools' list and global sections.
+type ForgeConfig struct {
+	ResourceScopes []ResourceScope `yaml:"resource-scopes"`
+	Tools          []Config        `yaml:"tools"`
+	// Bastion is the SSH jump host to reach the cluster through, if any.
+	Bastion *Bastion `yaml:"bastion"`
+	// PullSecrets are the credentials of private registries, if any.
+	PullSecrets *PullSecrets `yaml:"pull-secrets"`
+	// StorageClasses maps the storage classes of the tools' volume claims
+	// to the classes of the cluster.
+	StorageClasses map[string]string `yaml:"storage-classes"`
+	// Ingress is how the tools are exposed on the cluster, if set.
+	Ingress *Ingress `yaml:"ingress"`
+	// Profile is the profile of the tools which don't set their own.
+	Profile string `yaml:"profile"`
+	// HA tunes the ha profile.
+	HA *HAProfile `yaml:"ha"`
+	// Helmfiles are helmfiles whose releases are smelted as tools too.
+	Helmfiles []HelmfileSource `yaml:"helmfiles"`
+	// Digest is the digest of the config file, which identifies the run.
+	Digest string `yaml:"-"`
+}
+
+func LoadForgeConfig(filename string) (ForgeConfig, error) {
+	var forgeConfig ForgeConfig
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, err
+		return forgeConfig, NewError(ConfigError, err)
 	}
 
-	var configs []Config
-	err = yaml.Unmarshal(data, &configs)
+	var raw interface{}
+	err = yaml.Unmarshal(data, &raw)
 	if err != nil {
-		return nil, err
+		return forgeConfig, NewError(ConfigError, err)
+	}
+	if _, isList := raw.([]interface{}); isList {
+		err = yaml.Unmarshal(data, &forgeConfig.Tools)
+	} else {
+		err = yaml.Unmarshal(data, &forgeConfig)
+	}
+	if err != nil {
+		return forgeConfig, NewError(ConfigError, err)
+	}
+
+	err = validateHelmfiles(forgeConfig.Helmfiles)
+	if err != nil {
+		return forgeConfig, NewError(ConfigError, err)
+	}
+	helmfileTools, err := loadHelmfiles(forgeConfig.Helmfiles, forgeConfig.Tools)
+	if err != nil {
+		return forgeConfig, NewError(ConfigError, err)
+	}
+	forgeConfig.Tools = append(forgeConfig.Tools, helmfileTools...)
+
+	err = validateConfig(forgeConfig.Tools)
+	if err != nil {
+		return forgeConfig, NewError(ConfigError, err)
 	}
-	err = validateConfig(configs)
+	err = validateResourceScopes(forgeConfig.ResourceScopes)
+	if err != nil {
+		return forgeConfig, NewError(ConfigError, err)
+	}
+	err = validateBastion(forgeConfig.Bastion)
+	if err != nil {
+		return forgeConfig, NewError(ConfigError, err)
+	}
+	err = validatePullSecrets(forgeConfig.PullSecrets)
+	if err != nil {
+		return forgeConfig, NewError(ConfigError, err)
+	}
+	err = validateIngress(forgeConfig.Ingress)
+	if err != nil {
+		return forgeConfig, NewError(ConfigError, err)
+	}
+	err = validateProfile(forgeConfig.Profile)
+	if err != nil {
+		return forgeConfig, NewError(ConfigError, err)
+	}
+	err = validateHAProfile(forgeConfig.HA)
+	if err != nil {
+		return forgeConfig, NewError(ConfigError, err)
+	}
+	if len(helmfileTools) > 0 {
+		// The releases read from helmfiles are part of the config too
+		releases, err := yaml.Marshal(helmfileTools)
+		if err != nil {
+			return forgeConfig, NewError(ConfigError, err)
+		}
+		data = append(data, releases...)
+	}
+	forgeConfig.Digest = SourceDigest(data)
+	return forgeConfig, nil
+}
+
+// RegisterSmeltConfig registers the global settings of the config for
+// smelting, replacing those of an earlier run in the same process.
+func RegisterSmeltConfig(forgeConfig ForgeConfig) error {
+	RegisterResourceScopes(forgeConfig.ResourceScopes)
+	RegisterStorageClasses(forgeConfig.StorageClasses)
+	RegisterIngress(forgeConfig.Ingress)
+	RegisterProfile(forgeConfig.Profile, forgeConfig.HA)
+	RegisterPullSecrets(forgeConfig.PullSecrets, forgeConfig.Tools)
+	RegisterPolicies(forgeConfig.Tools)
+	SetBuildID(forgeConfig.Digest)
+	SetToolBuildIDs(forgeConfig)
+	return ReloadDigestPinning()
+}
+
+func LoadConfig(filename string) ([]Config, error) {
+	forgeConfig, err := LoadForgeConfig(filename)
 	if err != nil {
 		return nil, err
 	}
-	return configs, nil
+	return forgeConfig.Tools, nil
 }
 
+// Secret formats of a tool's 'secret-format'. Left empty, Secrets are kept
+// as rendered.
+const (
+	// SecretFormatData base64 encodes all Secret values into data.
+	SecretFormatData = "data"
+	// SecretFormatStringData keeps text Secret values readable in stringData.
+	SecretFormatStringData = "stringData"
+)
+
 type Config struct {
-	HelmChartName       string `yaml:"helm-chart-name"`
-	HelmURL             string `yaml:"helm-url"`
-	Values              string `yaml:"values"`
-	Secrets             bool   `yaml:"secrets"`
-	Name                string `yaml:"name"`
-	HelmName            string `yaml:"helm-name"`
-	ManifestURL         string `yaml:"manifest-url"`
-	HelmVersion         string `yaml:"helm-version"`
-	Namespace           string `yaml:"namespace"`
-	SourceFile          string `yaml:"sourcefile"`
+	HelmChartName       string            `yaml:"helm-chart-name"`
+	HelmURL             string            `yaml:"helm-url"`
+	Values              string            `yaml:"values"`
+	Secrets             bool              `yaml:"secrets"`
+	Name                string            `yaml:"name"`
+	HelmName            string            `yaml:"helm-name"`
+	ManifestURL         string            `yaml:"manifest-url"`
+	HelmVersion         string            `yaml:"helm-version"`
+	Namespace           string            `yaml:"namespace"`
+	SourceFile          string            `yaml:"sourcefile"`
+	SecretFormat        string            `yaml:"secret-format"`
+	Policies            []string          `yaml:"policies"`
+	WebhookCerts        *WebhookCerts     `yaml:"webhook-certs"`
+	WebhookOverrides    *WebhookOverrides `yaml:"webhook-overrides"`
+	Profile             string            `yaml:"profile"`
 	Filename            string
 	CRDFiles            []string
 	NamespaceFiles      []string
@@ -145,9 +259,13 @@ type Config struct {
 	ExternalSecretFiles []string
 	ObjectFiles         []string
 	CastName            string
+	// HelmfileValues are the merged values of a tool read from a helmfile,
+	// and HelmfileFiles the helmfile and values files they came from.
+	HelmfileValues []byte
+	HelmfileFiles  []string
 }
 
-func Setup() {
+func Setup(logOptions LogOptions) error {
 	logLevelStr := os.Getenv("LOG_LEVEL")
 	if logLevelStr == "" {
 		logLevelStr = "DEFAULT"
@@ -156,19 +274,35 @@ func Setup() {
 	if err != nil {
 		logLevel = log.InfoLevel
 	}
+	if logOptions.Quiet {
+		quiet = true
+		logLevel = log.WarnLevel
+	}
 
-	log.SetLevel(logLevel)
+	moduleLevels, defaultLevel, err := ParseLogLevels(logOptions.Levels)
+	if err != nil {
+		return Errorf(ConfigError, "invalid --log option: %w", err)
+	}
+	if defaultLevel != nil {
+		logLevel = *defaultLevel
+	}
+	configureLogLevels(logLevel, moduleLevels)
 
 	logfilename := os.Getenv("LOG_NAME")
 	if logfilename == "" {
 		logfilename = "forge.log"
 	}
-	logfilename = "logs/" + logfilename
+	logDir := logOptions.Dir
+	if logDir == "" {
+		logDir = "logs"
+	}
+	logfilename = filepath.Join(logDir, logfilename)
 	file, err := os.OpenFile(logfilename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to open log file: %w", err)
 	}
 	log.SetOutput(file)
+	return nil
 }
 
 type HelmExecutor interface {
@@ -187,11 +321,11 @@ func (e *DefaultHelmExecutor) RunHelmCommand(args []string, stdout io.Writer, st
 
 func Templatehelm(config Config, helmExec HelmExecutor) error {
 	if config.HelmURL == "" && config.SourceFile == "" && config.ManifestURL == "" {
-		return fmt.Errorf("invalid configuration: at least one of HelmURL, SourceFile, or ManifestURL must be provided")
+		return Errorf(ConfigError, "invalid configuration: at least one of HelmURL, SourceFile, or ManifestURL must be provided")
 	}
 
 	if config.Namespace == "" {
-		return fmt.Errorf("invalid configuration: Namespace must not be empty")
+		return Errorf(ConfigError, "invalid configuration: Namespace must not be empty")
 	}
 	file, err := os.Create(config.Filename)
 	if err != nil {
@@ -200,15 +334,22 @@ func Templatehelm(config Config, helmExec HelmExecutor) error {
 	defer file.Close()
 
 	if config.HelmURL != "" {
-		if config.Values == "" {
-			valuesPath := fmt.Sprintf("input/%s/values.yaml", config.Name)
-			cmdFetchValues := exec.Command("helm", "show", "values", "--repo", config.HelmURL, config.HelmChartName)
-			output, err := cmdFetchValues.Output()
-			if err != nil {
-				return fmt.Errorf("failed to fetch values.yaml for %s: %w", config.Name, err)
+		// The chart is downloaded once, for its default values, the values
+		// schemas and rendering
+		chartDir, cleanup, err := pullChart(config, helmExec)
+		if err != nil {
+			return Errorf(FetchError, "failed to fetch chart %s of %s: %w", config.HelmChartName, config.Name, err)
+		}
+		defer cleanup()
+
+		if config.HelmfileValues == nil && config.Values == "" {
+			valuesPath := filepath.Join(inputDirs[0], config.Name, "values.yaml")
+			output, err := os.ReadFile(filepath.Join(chartDir, "values.yaml"))
+			if err != nil && !os.IsNotExist(err) {
+				return Errorf(FetchError, "failed to read values.yaml for %s: %w", config.Name, err)
 			}
 
-			err = os.MkdirAll(fmt.Sprintf("input/%s", config.Name), 0755)
+			err = os.MkdirAll(filepath.Dir(valuesPath), 0755)
 			if err != nil {
 				return fmt.Errorf("failed to create input directory for %s: %w", config.Name, err)
 			}
@@ -221,30 +362,48 @@ func Templatehelm(config Config, helmExec HelmExecutor) error {
 			config.Values = "values.yaml"
 		}
 
-		args := []string{"template", config.HelmName, "--repo", config.HelmURL, config.HelmChartName, "-f", "input/" + config.Name + "/" + config.Values, "--include-crds"}
-		if config.HelmVersion != "" {
-			args = append(args, "--version", config.HelmVersion)
+		valuesPath := InputPath(filepath.Join(config.Name, config.Values))
+		if config.HelmfileValues != nil {
+			valuesPath, err = writeHelmfileValues(config)
+			if err != nil {
+				return err
+			}
+		}
+		if err := validateHelmValues(config, chartDir, valuesPath); err != nil {
+			return err
 		}
+
+		// A fixed release name keeps the output reproducible
+		releaseName := config.HelmName
+		if releaseName == "" {
+			releaseName = config.Name
+		}
+		args := []string{"template", releaseName, chartDir, "-f", valuesPath, "--include-crds"}
 		if config.Namespace != "" {
 			args = append(args, "--namespace", config.Namespace)
 		}
 
 		var stderr bytes.Buffer
+		stopRender := StartStage(config.Name, StageRender)
 		err = helmExec.RunHelmCommand(args, file, &stderr)
+		stopRender()
 		if err != nil {
-			return fmt.Errorf("helm command failed: %s: %w", stderr.String(), err)
+			return Errorf(RenderError, "helm command failed: %s: %w", stderr.String(), err)
 		}
 	} else if config.SourceFile != "" {
-		srcFilePath := filepath.Join("input", config.SourceFile)
-		dstFilePath := filepath.Join("working/pre", config.Name+".yaml")
-		err := CopyFile(srcFilePath, dstFilePath)
+		srcFilePath := InputPath(config.SourceFile)
+		stopFetch := StartStage(config.Name, StageFetch)
+		err := CopyFile(srcFilePath, config.Filename)
+		stopFetch()
 		if err != nil {
-			return fmt.Errorf("failed to copy file: %w", err)
+			return Errorf(FetchError, "failed to copy file: %w", err)
 		}
 	} else if config.ManifestURL != "" {
+		stopFetch := StartStage(config.Name, StageFetch)
 		err := downloadFile(config.Filename, config.ManifestURL)
+		stopFetch()
 		if err != nil {
-			return fmt.Errorf("failed to download manifest: %w", err)
+			return Errorf(FetchError, "failed to download manifest: %w", err)
 		}
 	}
 
@@ -281,6 +440,9 @@ func downloadFile(filepath string, url string) error {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
 
 	// Create the file
 	out, err := os.Create(filepath)
@@ -348,12 +510,44 @@ func validateConfig(configs []Config) error {
 				return fmt.Errorf("missing 'helm-name' in config with 'helm-url': %+v", config)
 			}
 		}
+		switch config.SecretFormat {
+		case "", SecretFormatData, SecretFormatStringData:
+		default:
+			return fmt.Errorf("invalid 'secret-format' '%s' for %s, expected %s or %s", config.SecretFormat, config.Name, SecretFormatData, SecretFormatStringData)
+		}
+		switch config.Profile {
+		case "", ProfileDefault, ProfileHA:
+		default:
+			return fmt.Errorf("invalid 'profile' '%s' for %s, expected %s or %s", config.Profile, config.Name, ProfileDefault, ProfileHA)
+		}
+		if err := validateWebhookCerts(config); err != nil {
+			return err
+		}
+		if err := validateWebhookOverrides(config); err != nil {
+			return err
+		}
+		for _, policy := range config.Policies {
+			if policy == "" || strings.ContainsAny(policy, `/\`) {
+				return fmt.Errorf("invalid policy '%s' for %s, expected the name of a directory in %s", policy, config.Name, PoliciesDir)
+			}
+		}
 	}
 	return nil
 }
 
-// isClusterScoped checks if a given resource is cluster-scoped.
+// IsClusterScoped checks if a given resource is cluster-scoped. Scopes from
+// the config take precedence over scopes learned from CRDs in the input,
+// which take precedence over the built-in list.
 func IsClusterScoped(resourceName, apiVersion string) bool {
+	if clusterScoped, exists := configuredScopes[scopeKey(resourceName, apiVersion)]; exists {
+		return clusterScoped
+	}
+	if clusterScoped, exists := learnedScopes[scopeKey(resourceName, apiVersion)]; exists {
+		return clusterScoped
+	}
+	if apiGroup(apiVersion) == GatekeeperConstraintsGroup {
+		return true
+	}
 	for _, resource := range clusterScopedResources {
 		if strings.EqualFold(resource.Name, resourceName) && strings.EqualFold(resource.APIVersion, apiVersion) {
 			return true
@@ -365,7 +559,7 @@ func IsClusterScoped(resourceName, apiVersion string) bool {
 func RunCommand(cmd string) error {
 	output, err := exec.Command("sh", "-c", cmd).CombinedOutput()
 	if err != nil {
-		log.Fatalf("Command %s failed: %v\nOutput: %s", cmd, err, string(output))
+		return fmt.Errorf("command %s failed: %w\nOutput: %s", cmd, err, string(output))
 	}
 	log.Infof(string(output))
 	return nil
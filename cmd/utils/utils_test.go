@@ -4,6 +4,8 @@ import (
 	"errors"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -19,13 +21,22 @@ func TestTemplatehelm(t *testing.T) {
 	tempFile := "test_output.yaml"
 	defer os.Remove(tempFile)
 
+	var pulls int
 	mockExecutor := &MockHelmExecutor{
 		RunFunc: func(args []string, stdout io.Writer, stderr io.Writer) error {
-			if args[0] != "template" {
-				return errors.New("unexpected command")
+			switch args[0] {
+			case "pull":
+				pulls++
+				return os.MkdirAll(filepath.Join(args[len(args)-1], args[1]), 0755)
+			case "template":
+				// The chart is rendered as pulled rather than downloaded again
+				if strings.Contains(strings.Join(args, " "), "--repo") {
+					return errors.New("chart downloaded again")
+				}
+				stdout.Write([]byte("mock helm output"))
+				return nil
 			}
-			stdout.Write([]byte("mock helm output"))
-			return nil
+			return errors.New("unexpected command")
 		},
 	}
 
@@ -54,6 +65,9 @@ func TestTemplatehelm(t *testing.T) {
 		if string(output) != "mock helm output" {
 			t.Errorf("unexpected output: %s", string(output))
 		}
+		if pulls != 1 {
+			t.Errorf("expected the chart to be pulled once, got %d", pulls)
+		}
 	})
 
 	// Failing configuration test case (Missing HelmURL)
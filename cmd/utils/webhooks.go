@@ -0,0 +1,75 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import "fmt"
+
+// WebhookCerts sets how the webhooks of a tool get their serving
+// certificates trusted by the API server: through cert-manager, or with a CA
+// bundle from the input directory.
+type WebhookCerts struct {
+	CertManager *CertManagerWebhookCerts `yaml:"cert-manager"`
+	// CABundle is the input path of the PEM encoded CA which signed the
+	// webhooks' serving certificates.
+	CABundle string `yaml:"ca-bundle"`
+}
+
+// CertManagerWebhookCerts has cert-manager issue the serving certificate
+// into the Secret the webhook server reads, and its cainjector fill in the
+// CA bundles.
+type CertManagerWebhookCerts struct {
+	SecretName string `yaml:"secret-name"`
+	// Issuer is the ClusterIssuer to use. A self-signed Issuer is generated
+	// if it is empty.
+	Issuer string `yaml:"issuer"`
+}
+
+func validateWebhookCerts(config Config) error {
+	certs := config.WebhookCerts
+	if certs == nil {
+		return nil
+	}
+	if (certs.CertManager == nil) == (certs.CABundle == "") {
+		return fmt.Errorf("'webhook-certs' of %s needs either 'cert-manager' or 'ca-bundle'", config.Name)
+	}
+	if certs.CertManager != nil && certs.CertManager.SecretName == "" {
+		return fmt.Errorf("missing 'secret-name' in 'webhook-certs' of %s, the Secret the webhook server reads its certificate from", config.Name)
+	}
+	return nil
+}
+
+// WebhookOverrides are applied to every admission webhook of a tool, so a
+// broken webhook can't block the cluster's admission path.
+type WebhookOverrides struct {
+	// FailurePolicy replaces the webhooks' failurePolicy, Ignore or Fail.
+	FailurePolicy string `yaml:"failure-policy"`
+	// ExcludeNamespaces are left out of the webhooks' namespaceSelector.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+}
+
+func validateWebhookOverrides(config Config) error {
+	overrides := config.WebhookOverrides
+	if overrides == nil {
+		return nil
+	}
+	switch overrides.FailurePolicy {
+	case "", "Ignore", "Fail":
+	default:
+		return fmt.Errorf("invalid 'failure-policy' '%s' in 'webhook-overrides' of %s, expected Ignore or Fail", overrides.FailurePolicy, config.Name)
+	}
+	return nil
+}
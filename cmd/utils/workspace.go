@@ -0,0 +1,130 @@
+/**
+ * Copyright 2024 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+const (
+	// DefaultWorkspace is the project directory itself.
+	DefaultWorkspace = "default"
+	// WorkspacesDir holds the named workspaces.
+	WorkspacesDir = "workspaces"
+)
+
+var workspaceNameRe = regexp.MustCompile("^[a-z0-9][a-z0-9_-]*$")
+
+// inputDirs are searched in order for the values and source files referenced
+// by the config.
+var inputDirs = []string{"input"}
+
+// Workspace is a directory with its own config, working files, stacks and
+// logs, so one machine can manage several cluster estates. Named workspaces
+// live in workspaces/<name> with the same layout as the project directory.
+type Workspace struct {
+	Name string
+	Root string
+}
+
+// OpenWorkspace opens the named workspace, or the one named by the
+// FORGE_WORKSPACE environment variable if name is empty.
+func OpenWorkspace(name string) (Workspace, error) {
+	if name == "" {
+		name = os.Getenv("FORGE_WORKSPACE")
+	}
+	if name == "" || name == DefaultWorkspace {
+		return Workspace{Name: DefaultWorkspace, Root: "."}, nil
+	}
+	if !workspaceNameRe.MatchString(name) {
+		return Workspace{}, Errorf(ConfigError, "invalid workspace name '%s': it can only contain lowercase letters (a-z), digits (0-9), hyphens (-), and underscores (_)", name)
+	}
+
+	workspace := Workspace{Name: name, Root: filepath.Join(WorkspacesDir, name)}
+	if _, err := os.Stat(workspace.ConfigFile()); os.IsNotExist(err) {
+		return Workspace{}, Errorf(ConfigError, "workspace '%s' not found, create its config at %s", name, workspace.ConfigFile())
+	}
+	for _, dir := range []string{workspace.WorkingDir(), workspace.StacksDir(), workspace.LogsDir()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return Workspace{}, fmt.Errorf("failed to create workspace directory: %w", err)
+		}
+	}
+	return workspace, nil
+}
+
+func (w Workspace) InputDir() string {
+	return filepath.Join(w.Root, "input")
+}
+
+func (w Workspace) ConfigFile() string {
+	return filepath.Join(w.InputDir(), "config.yaml")
+}
+
+func (w Workspace) WorkingDir() string {
+	return filepath.Join(w.Root, "working")
+}
+
+func (w Workspace) StacksDir() string {
+	return filepath.Join(w.Root, "stacks")
+}
+
+func (w Workspace) OutputDir() string {
+	return filepath.Join(w.Root, "output")
+}
+
+// SnapshotsDir holds the reviewed output of each tool, see the snapshot command.
+func (w Workspace) SnapshotsDir() string {
+	return filepath.Join(w.Root, "snapshots")
+}
+
+// LockFile records the digests images were pinned to, see smelt --pin-digests.
+func (w Workspace) LockFile() string {
+	return filepath.Join(w.Root, "forge.lock")
+}
+
+func (w Workspace) LogsDir() string {
+	return filepath.Join(w.Root, "logs")
+}
+
+// InputDirs returns the workspace's input directory followed by the shared
+// one, so workspaces only need to hold the files they change.
+func (w Workspace) InputDirs() []string {
+	if w.InputDir() == "input" {
+		return []string{"input"}
+	}
+	return []string{w.InputDir(), "input"}
+}
+
+// SetInputDirs sets the directories searched by InputPath.
+func SetInputDirs(dirs []string) {
+	inputDirs = dirs
+}
+
+// InputPath returns the path of a file relative to the input directories,
+// taking the first one it exists in. New files go in the first directory.
+func InputPath(relativePath string) string {
+	for _, dir := range inputDirs {
+		path := filepath.Join(dir, relativePath)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(inputDirs[0], relativePath)
+}
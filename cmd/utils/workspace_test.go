@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenWorkspace(t *testing.T) {
+	t.Setenv("FORGE_WORKSPACE", "")
+
+	workspace, err := OpenWorkspace("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if workspace.Name != DefaultWorkspace || workspace.ConfigFile() != filepath.Join("input", "config.yaml") {
+		t.Errorf("expected the project directory, got %+v", workspace)
+	}
+
+	_, err = OpenWorkspace("Bad/Name")
+	if ClassOf(err) != ConfigError {
+		t.Errorf("expected a config error for an invalid name, got: %v", err)
+	}
+
+	_, err = OpenWorkspace("does-not-exist")
+	if ClassOf(err) != ConfigError {
+		t.Errorf("expected a config error for a missing workspace, got: %v", err)
+	}
+}
+
+func TestInputPath(t *testing.T) {
+	dir, err := os.MkdirTemp("", "workspace-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	workspaceInput := filepath.Join(dir, "workspace")
+	sharedInput := filepath.Join(dir, "shared")
+	for _, file := range []string{filepath.Join(workspaceInput, "a.yaml"), filepath.Join(sharedInput, "a.yaml"), filepath.Join(sharedInput, "b.yaml")} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(file, []byte("{}"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	SetInputDirs([]string{workspaceInput, sharedInput})
+	defer SetInputDirs([]string{"input"})
+
+	tests := map[string]string{
+		"a.yaml": filepath.Join(workspaceInput, "a.yaml"),
+		"b.yaml": filepath.Join(sharedInput, "b.yaml"),
+		"c.yaml": filepath.Join(workspaceInput, "c.yaml"),
+	}
+	for file, expected := range tests {
+		if path := InputPath(file); path != expected {
+			t.Errorf("InputPath(%s) = %s, expected %s", file, path, expected)
+		}
+	}
+}
+
+func TestNewRunDirPerWorkspace(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	first := Workspace{Name: "first", Root: filepath.Join(WorkspacesDir, "first")}
+	second := Workspace{Name: "second", Root: filepath.Join(WorkspacesDir, "second")}
+	if first.RunDirPattern() == second.RunDirPattern() {
+		t.Fatalf("expected workspaces to have their own run directories, both use %s", first.RunDirPattern())
+	}
+
+	runDir, err := NewRunDir(first, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer runDir.Cleanup()
+	for workspace, expected := range map[Workspace]int{first: 1, second: 0} {
+		matches, err := filepath.Glob(filepath.Join(os.TempDir(), workspace.RunDirPattern()))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(matches) != expected {
+			t.Errorf("expected %d run directories of %s, got %v", expected, workspace.Name, matches)
+		}
+	}
+}
@@ -8,6 +8,11 @@ case "$TASK" in
     echo "Executing forge"
     /usr/local/bin/forge "$@"
     ;;
+  operator)
+    echo "Executing operator"
+    exec /usr/local/bin/forge operator "$@"
+    ;;
+  *)
     echo "Unknown task: $TASK"
     exit 1
     ;;
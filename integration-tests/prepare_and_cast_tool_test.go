@@ -68,7 +68,7 @@ func TestIntegrationPrepareAndCastTool(t *testing.T) {
 		}
 	}
 
-	err := smelter.PrepareTool(successConfigs, []string{"amd-device-plugin"}, workingDir)
+	err := smelter.PrepareTool(successConfigs, []string{"amd-device-plugin"}, workingDir, filepath.Join(workingDir, "pre"))
 	if err != nil {
 		t.Fatalf("PrepareTool failed: %v", err)
 	}
@@ -89,9 +89,13 @@ func TestIntegrationPrepareAndCastTool(t *testing.T) {
 		t.Fatalf("CastTool failed: %v", err)
 	}
 
-	caster.PreparePackageDirectory(stacksDir, castname)
+	if _, err := caster.PreparePackageDirectory(workingDir, stacksDir, castname); err != nil {
+		t.Fatalf("PreparePackageDirectory failed: %v", err)
+	}
 
-	caster.CopyFilesWithSpinner(outputDir, filepath.Join(stacksDir, castname), imagename)
+	if err := caster.CopyFilesWithSpinner(outputDir, filepath.Join(stacksDir, castname), imagename); err != nil {
+		t.Fatalf("CopyFilesWithSpinner failed: %v", err)
+	}
 
 	expectedOutputs := []string{
 		filepath.Join(outputDir, "cm-amd-device-plugin-object-1.yaml"),
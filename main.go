@@ -17,20 +17,70 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/silogen/cluster-forge/cmd/caster"
+	"github.com/silogen/cluster-forge/cmd/cleaner"
 	"github.com/silogen/cluster-forge/cmd/forger"
+	"github.com/silogen/cluster-forge/cmd/immutable"
+	"github.com/silogen/cluster-forge/cmd/operator"
+	"github.com/silogen/cluster-forge/cmd/rbac"
 	"github.com/silogen/cluster-forge/cmd/smelter"
 	"github.com/silogen/cluster-forge/cmd/utils"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
+// inClusterOptions are the flags of cast --in-cluster.
+type inClusterOptions struct {
+	enabled bool
+	image   string
+	timeout time.Duration
+}
+
+// options are the flags shared by the commands.
+type options struct {
+	log         utils.LogOptions
+	workspace   string
+	keepWorkdir bool
+	lockWait    time.Duration
+	profileRun  bool
+	pinDigests  bool
+	report      string
+	kubectl     forger.KubectlOptions
+}
+
+// pluginName is the name of the binary when it is installed as a kubectl
+// plugin, run as kubectl forge.
+const pluginName = "kubectl-forge"
+
 func main() {
-	var rootCmd = &cobra.Command{Use: "app"}
+	var opts options
+	var rootCmd = &cobra.Command{Use: "app", Version: utils.ForgeVersion()}
+	if isPlugin(os.Args[0]) {
+		opts.kubectl.NoPrompt = true
+		rootCmd.Use = "forge"
+		rootCmd.Annotations = map[string]string{cobra.CommandDisplayNameAnnotation: "kubectl forge"}
+	}
+	rootCmd.PersistentFlags().BoolVar(&opts.log.Quiet, "quiet", false, "Only show warnings, errors and prompts")
+	rootCmd.PersistentFlags().DurationVar(&opts.lockWait, "lock-wait", 0, "How long to wait for another run on the same directory or cluster to finish, e.g. 5m (default: fail immediately)")
+	rootCmd.PersistentFlags().StringVar(&opts.log.Levels, "log", "", "Log levels per module, e.g. smelter=debug,caster=warn (modules: main, smelter, caster, forger, operator, cleaner, rbac, snapshot, immutable, utils)")
+	rootCmd.PersistentFlags().BoolVar(&opts.profileRun, "profile-run", false, "Report the time and memory spent per stage and tool when the run ends")
+	rootCmd.PersistentFlags().StringVar(&opts.workspace, "workspace", "", "Named workspace in workspaces/ to use instead of the project directory (default: $FORGE_WORKSPACE)")
 
+	var smeltTools []string
+	var smeltWatch bool
 	var smeltCmd = &cobra.Command{
 		Use:   "smelt",
 		Short: "Run smelt",
@@ -40,11 +90,14 @@ This output can then be edited or customized if needed before casting.
 
 The reason for customizing is to create cluster specific configurations.
 For example, you could template a 'baseDomain' which could then be input and templated at the forge step.`,
-		Run: func(cmd *cobra.Command, args []string) {
-			runSmelt()
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSmelt(opts, smeltTools, smeltWatch)
 		},
 	}
+	smeltCmd.Flags().StringSliceVar(&smeltTools, "tools", nil, "Tools to smelt without asking (default: choose in a menu)")
+	smeltCmd.Flags().BoolVar(&smeltWatch, "watch", false, "Keep running and smelt a tool again whenever its config or input files change")
 
+	var inCluster inClusterOptions
 	var castCmd = &cobra.Command{
 		Use:   "cast",
 		Short: "Run cast",
@@ -52,8 +105,22 @@ For example, you could template a 'baseDomain' which could then be input and tem
 
 This step creates a container image which can be used during forge step to deploy all the components in a stack to a cluster.`,
 
-		Run: func(cmd *cobra.Command, args []string) {
-			runCast()
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCast(opts, inCluster)
+		},
+	}
+
+	castCmd.Flags().BoolVar(&inCluster.enabled, "in-cluster", false, "After casting, deploy the stack from a Job inside the target cluster")
+	castCmd.Flags().StringVar(&inCluster.image, "forge-image", forger.DefaultImage, "Image of the in-cluster Job")
+	castCmd.Flags().DurationVar(&inCluster.timeout, "job-timeout", 30*time.Minute, "How long to wait for the in-cluster Job")
+
+	var applyStackCmd = &cobra.Command{
+		Use:    "apply-stack <stack directory>",
+		Short:  "Deploy a stack non-interactively, used by the in-cluster Job",
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runApplyStack(opts, args[0])
 		},
 	}
 
@@ -63,64 +130,895 @@ This step creates a container image which can be used during forge step to deplo
 		Long: `The forge command deploys a stack from the cast phase into a cluster.
 It reads the KUBECONFIG env variable to find a destination, and deploys the stack.`,
 
-		Run: func(cmd *cobra.Command, args []string) {
-			runForge()
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runForge(opts)
+		},
+	}
+
+	var operatorCacheDir string
+	var operatorPollInterval time.Duration
+	var operatorCmd = &cobra.Command{
+		Use:   "operator",
+		Short: "Run the in-cluster operator",
+		Long: `The operator command runs a controller which keeps the cluster at the stacks referenced by ForgeRelease resources.
+It fetches each release from an OCI artifact or Git path, deploys it like the forge command, waits for it to become Ready
+and prunes the objects removed since the previous release. Applied releases are applied again every interval to correct drift.
+It uses the in-cluster service account, or KUBECONFIG when run outside the cluster. See operator/ for the CRD and deployment.`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runOperator(opts, operatorCacheDir, operatorPollInterval)
+		},
+	}
+	operatorCmd.Flags().StringVar(&operatorCacheDir, "cache-dir", filepath.Join(os.TempDir(), "forge-operator"), "Directory for fetched and applied stacks")
+	operatorCmd.Flags().DurationVar(&operatorPollInterval, "poll-interval", 10*time.Second, "How often to check for releases which are due")
+
+	var verifyTools []string
+	var verifyCmd = &cobra.Command{
+		Use:   "verify-reproducible",
+		Short: "Check that smelting twice gives identical output",
+		Long: `The verify-reproducible command smelts the tools twice into scratch directories and compares the results byte for byte.
+It fails and lists the differing files if anything, such as a chart generating random passwords, makes the output change between runs.
+The working directory is not touched.`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVerifyReproducible(opts, verifyTools)
+		},
+	}
+	verifyCmd.Flags().StringSliceVar(&verifyTools, "tools", nil, "Tools to check (default: all tools in the config)")
+	verifyCmd.Flags().BoolVar(&opts.keepWorkdir, "keep-workdir", false, "Keep the scratch directory with both results for debugging")
+
+	var validateTools []string
+	var validateStrict bool
+	var validateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Check the config by running the whole smelt pipeline in a scratch directory",
+		Long: `The validate command fetches, renders, splits and transforms the tools like smelt, checking the values against the charts' schemas,
+into a scratch directory, and then checks the output of all tools together: for objects without a kind or name, for objects which
+two tools write differently, for objects violating the tools' Gatekeeper policies (using gator, if installed) and for webhooks which can
+block kube-system. Nothing in the workspace is written and no cluster is contacted,
+so it can gate changes to the config. It fails if smelting any tool fails or errors are found, and with --strict on warnings too.`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runValidate(opts, validateTools, validateStrict)
+		},
+	}
+	validateCmd.Flags().StringSliceVar(&validateTools, "tools", nil, "Tools to validate (default: all tools in the config)")
+	validateCmd.Flags().BoolVar(&validateStrict, "strict", false, "Fail on warnings too")
+	validateCmd.Flags().BoolVar(&opts.keepWorkdir, "keep-workdir", false, "Keep the scratch directory for debugging")
+
+	var snapshotTools []string
+	var snapshotUpdate bool
+	var recreatePlan string
+	var snapshotCmd = &cobra.Command{
+		Use:   "snapshot",
+		Short: "Compare the smelted output with reviewed snapshots",
+		Long: `The snapshot command smelts the tools into a scratch directory and compares the output of each with its snapshot in snapshots/<tool>.
+It fails and lists the changed files if the output differs, e.g. after upgrading a chart. Rerun with --update to record the new output,
+and review the changes with git diff before committing them. Volatile annotations like the build id are left out of snapshots.
+Changes to immutable fields, like a Deployment's selector, are flagged since those objects have to be deleted and created again;
+--recreate-plan writes a script doing so.`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSnapshot(opts, snapshotTools, snapshotUpdate, recreatePlan)
+		},
+	}
+	snapshotCmd.Flags().StringSliceVar(&snapshotTools, "tools", nil, "Tools to snapshot (default: all tools in the config)")
+	snapshotCmd.Flags().BoolVar(&snapshotUpdate, "update", false, "Record the new output as the snapshots")
+	snapshotCmd.Flags().BoolVar(&opts.keepWorkdir, "keep-workdir", false, "Keep the scratch directory for debugging")
+	snapshotCmd.Flags().StringVar(&recreatePlan, "recreate-plan", "", "Write a script recreating the objects whose immutable fields changed to this file")
+
+	var statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show the stack installed in the cluster, its ForgeReleases and whether a stack is being deployed",
+		Long: `The status command shows the stack installed in the cluster with its tools and versions, as recorded when it was deployed,
+lists the ForgeReleases with their Ready condition and applied revision, and shows who holds the cluster Lease if a forge run is deploying a stack. It uses the current kubectl context.`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStatus(opts)
+		},
+	}
+
+	var driftRecreatePlan string
+	var driftCmd = &cobra.Command{
+		Use:     "drift [stack directory]",
+		Aliases: []string{"diff"},
+		Short:   "Compare a stack with the cluster",
+		Long: `The drift command compares the composition and stack of a stack, by default the one installed or else the newest in stacks/, with the cluster using kubectl diff,
+and checks that the Objects applying the tools' manifests are synced and ready. It prints the differences and the failing Objects and fails
+if the cluster has drifted from the stack, so it can be run on a schedule. It also reads the objects of the stack's manifests from the cluster
+and lists those whose immutable fields differ, which applying the stack can't update in place; --recreate-plan writes a script recreating them.
+It uses the current kubectl context.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stackPath := ""
+			if len(args) > 0 {
+				stackPath = args[0]
+			}
+			return runDrift(opts, stackPath, driftRecreatePlan)
+		},
+	}
+	driftCmd.Flags().StringVar(&driftRecreatePlan, "recreate-plan", "", "Write a script recreating the objects whose immutable fields differ from the cluster to this file")
+
+	var compareContexts []string
+	var compareCmd = &cobra.Command{
+		Use:   "compare --context <a> --context <b>",
+		Short: "Compare what forge installed on two clusters",
+		Long: `The compare command inventories the release record and the objects generated by forge on two clusters, given as kubeconfig contexts,
+and lists the differences: tools installed on only one of them, tools at other chart versions, objects missing on one of them,
+objects generated differently, and different forge versions and stacks. Listing every kind the clusters serve takes a while on
+large clusters. It opens the bastion tunnel of the workspace's config, if it has one, for each cluster. It fails if the clusters differ, e.g. to check staging before promoting a release to prod.`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCompare(opts, compareContexts)
+		},
+	}
+	compareCmd.Flags().StringArrayVar(&compareContexts, "context", nil, "A kubeconfig context of the clusters to compare, given twice")
+	compareCmd.Flags().StringVar(&opts.kubectl.Kubeconfig, "kubeconfig", "", "Path to the kubeconfig file, like kubectl (default: $KUBECONFIG or ~/.kube/config)")
+
+	for _, cmd := range []*cobra.Command{castCmd, statusCmd, driftCmd} {
+		cmd.Flags().StringVar(&opts.kubectl.Kubeconfig, "kubeconfig", "", "Path to the kubeconfig file, like kubectl (default: $KUBECONFIG or ~/.kube/config)")
+		cmd.Flags().StringVar(&opts.kubectl.Context, "context", "", "The kubeconfig context to use, like kubectl (default: the current context)")
+	}
+
+	var cleanOptions cleaner.Options
+	var cleanAll bool
+	var cleanCmd = &cobra.Command{
+		Use:   "clean",
+		Short: "Remove generated files",
+		Long: `The clean command removes files generated by earlier runs.
+Use --working to remove the smelted tools, --cache to remove intermediate files left behind by earlier runs,
+and --keep-stacks to remove all but the newest stacks. --all selects --working and --cache.`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cleanAll {
+				cleanOptions.Working = true
+				cleanOptions.Cache = true
+			}
+			return runClean(opts, cleanOptions)
 		},
 	}
+	cleanCmd.Flags().BoolVar(&cleanOptions.Working, "working", false, "Remove the smelted tools in the working directory")
+	cleanCmd.Flags().BoolVar(&cleanOptions.Cache, "cache", false, "Remove intermediate files left behind by earlier runs")
+	cleanCmd.Flags().IntVar(&cleanOptions.KeepStacks, "keep-stacks", -1, "Remove all but this many of the newest stacks")
+	cleanCmd.Flags().BoolVar(&cleanAll, "all", false, "Same as --working --cache")
+	cleanCmd.Flags().BoolVar(&cleanOptions.DryRun, "dry-run", false, "Only list what would be removed")
+
+	smeltCmd.Flags().BoolVar(&opts.keepWorkdir, "keep-workdir", false, "Keep the per-run directory of intermediate files for debugging")
+	smeltCmd.Flags().BoolVar(&opts.pinDigests, "pin-digests", false, "Rewrite container images to the digests recorded in forge.lock, resolving new ones")
+	castCmd.Flags().BoolVar(&opts.keepWorkdir, "keep-workdir", false, "Keep the per-run directory of intermediate files for debugging")
+	smeltCmd.Flags().StringVar(&opts.report, "report", "", "Where to write the JSON run report (default: smelt-report.json in the logs directory)")
+	castCmd.Flags().StringVar(&opts.report, "report", "", "Where to write the JSON run report (default: cast-report.json in the logs directory)")
+
+	rootCmd.AddCommand(smeltCmd, castCmd, forgeCmd, applyStackCmd, operatorCmd, verifyCmd, snapshotCmd, validateCmd, statusCmd, driftCmd, compareCmd, cleanCmd)
+	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
+	err := rootCmd.Execute()
+	if reportErr := utils.WriteRunReport(err); reportErr != nil {
+		log.Warnf("%v", reportErr)
+	}
+	if opts.profileRun {
+		fmt.Fprint(os.Stderr, utils.ProfileReport())
+	}
+	if err != nil {
+		printErrorSummary(err)
+		os.Exit(utils.ExitCode(err))
+	}
+}
+
+// isPlugin reports whether the binary was run by kubectl as a plugin.
+func isPlugin(arg0 string) bool {
+	return strings.TrimSuffix(filepath.Base(arg0), ".exe") == pluginName
+}
+
+// printErrorSummary reports the error which stopped the run, one line per
+// failure, so it is visible even when the log goes to a file.
+func printErrorSummary(err error) {
+	log.Errorf("Run failed (%s): %v", utils.ClassOf(err), err)
+	fmt.Fprintf(os.Stderr, "Error (%s):\n", utils.ClassOf(err))
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintf(os.Stderr, "  %s\n", line)
+	}
+}
 
-	rootCmd.AddCommand(smeltCmd, castCmd, forgeCmd)
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// setup opens the workspace and starts logging into it.
+func setup(opts options) (utils.Workspace, error) {
+	workspace, err := utils.OpenWorkspace(opts.workspace)
+	if err != nil {
+		return workspace, err
 	}
+	utils.SetInputDirs(workspace.InputDirs())
+	if opts.profileRun {
+		utils.EnableProfiling()
+	}
+	opts.log.Dir = workspace.LogsDir()
+	if err := utils.Setup(opts.log); err != nil {
+		return workspace, err
+	}
+	log.Printf("starting up in workspace %s...", workspace.Name)
+	return workspace, nil
 }
 
-func runSmelt() {
-	workingDir := "./working"
-	utils.Setup()
-	log.Println("starting up...")
-	configs, err := utils.LoadConfig("input/config.yaml")
+func runSmelt(opts options, tools []string, watch bool) (err error) {
+	workspace, err := setup(opts)
 	if err != nil {
-		log.Fatalf("Failed to read config: %v", err)
+		return err
 	}
-	for _, config := range configs {
+	startRunReport(opts, workspace, "smelt")
+	forgeConfig, err := loadSmeltConfig(workspace)
+	if err != nil {
+		return err
+	}
+	if len(tools) > 0 {
+		tools, err = selectTools(forgeConfig.Tools, tools)
+		if err != nil {
+			return err
+		}
+	}
+	if !utils.Quiet() {
+		fmt.Print(utils.ForgeLogo)
+		fmt.Println("Smelting")
+	}
+	lock, err := utils.AcquireRunLock(workspace.Root, "smelt", opts.lockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	runDir, err := utils.NewRunDir(workspace, opts.keepWorkdir)
+	if err != nil {
+		return err
+	}
+	defer runDir.Cleanup()
+	if opts.pinDigests {
+		if err := utils.EnableDigestPinning(workspace.LockFile(), nil); err != nil {
+			return err
+		}
+		// Digests resolved before a tool failed are kept too, so rerunning
+		// doesn't resolve them again
+		defer func() {
+			if saveErr := utils.SaveLockFile(); err == nil {
+				err = saveErr
+			}
+		}()
+	}
+	if watch {
+		if len(tools) == 0 {
+			tools, err = smelter.ChooseTools(forgeConfig.Tools, workspace.WorkingDir())
+			if err != nil {
+				return err
+			}
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		load := func() (utils.ForgeConfig, error) {
+			return loadSmeltConfig(workspace)
+		}
+		return smelter.Watch(ctx, workspace.ConfigFile(), load, tools, workspace.WorkingDir(), runDir.PreDir())
+	}
+	if len(tools) > 0 {
+		return smelter.PrepareTool(forgeConfig.Tools, tools, workspace.WorkingDir(), runDir.PreDir())
+	}
+	return smelter.Smelt(forgeConfig.Tools, workspace.WorkingDir(), runDir.PreDir())
+}
+
+// startRunReport starts the report of the run, written when the run ends.
+func startRunReport(opts options, workspace utils.Workspace, command string) {
+	path := opts.report
+	if path == "" {
+		path = filepath.Join(workspace.LogsDir(), command+"-report.json")
+	}
+	utils.StartRunReport(command, workspace, path)
+}
+
+// loadSmeltConfig reads the workspace's config and registers its settings
+// for smelting.
+func loadSmeltConfig(workspace utils.Workspace) (utils.ForgeConfig, error) {
+	forgeConfig, err := utils.LoadForgeConfig(workspace.ConfigFile())
+	if err != nil {
+		return forgeConfig, fmt.Errorf("failed to read config: %w", err)
+	}
+	for _, config := range forgeConfig.Tools {
 		log.Printf("Read config for : %+v", config.Name)
 	}
-	fmt.Print(utils.ForgeLogo)
-	fmt.Println("Smelting")
-	smelter.Smelt(configs, workingDir)
+	return forgeConfig, utils.RegisterSmeltConfig(forgeConfig)
 }
 
-func runCast() {
-	workingDir := "./working"
-	stacksDir := "./stacks"
-	filesDir := "./output"
-	utils.Setup()
-	log.Println("starting up...")
-	configs, err := utils.LoadConfig("input/config.yaml")
+func runCast(opts options, inCluster inClusterOptions) error {
+	workspace, err := setup(opts)
 	if err != nil {
-		log.Fatalf("Failed to read config: %v", err)
+		return err
 	}
+	startRunReport(opts, workspace, "cast")
+	forger.UseKubectlOptions(opts.kubectl)
+	forgeConfig, err := utils.LoadForgeConfig(workspace.ConfigFile())
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	configs := forgeConfig.Tools
 	for _, config := range configs {
 		log.Printf("Read config for : %+v", config.Name)
 	}
-	fmt.Print(utils.ForgeLogo)
-	fmt.Println("Casting")
-	caster.Cast(configs, filesDir, workingDir, stacksDir)
+	if !utils.Quiet() {
+		fmt.Print(utils.ForgeLogo)
+		fmt.Println("Casting")
+	}
+	lock, err := utils.AcquireRunLock(workspace.Root, "cast", opts.lockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	runDir, err := utils.NewRunDir(workspace, opts.keepWorkdir)
+	if err != nil {
+		return err
+	}
+	defer runDir.Cleanup()
+	stackPath, err := caster.Cast(configs, runDir.OutputDir(), workspace.WorkingDir(), workspace.StacksDir())
+	if err != nil {
+		return err
+	}
+	utils.RecordStack(stackPath, false)
+	if !inCluster.enabled {
+		return nil
+	}
+
+	closeTunnel, err := openBastion(forgeConfig)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	kubeConfig, err := forger.KubeConfig()
+	if err != nil {
+		return err
+	}
+	if err := forger.ApplyInCluster(kubeConfig, stackPath, inCluster.image, inCluster.timeout); err != nil {
+		return err
+	}
+	utils.RecordStack(stackPath, true)
+	return nil
 }
 
-func runForge() {
-	stacksDir := "./stacks"
-	utils.Setup()
-	log.Println("starting up...")
-	configs, err := utils.LoadConfig("input/config.yaml")
+func runForge(opts options) error {
+	workspace, err := setup(opts)
 	if err != nil {
-		log.Fatalf("Failed to read config: %v", err)
+		return err
 	}
-	for _, config := range configs {
+	forgeConfig, err := utils.LoadForgeConfig(workspace.ConfigFile())
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	for _, config := range forgeConfig.Tools {
 		log.Printf("Read config for : %+v", config.Name)
 	}
-	fmt.Print(utils.ForgeLogo)
-	fmt.Println("Forging")
-	forger.Forge(stacksDir)
+	if !utils.Quiet() {
+		fmt.Print(utils.ForgeLogo)
+		fmt.Println("Forging")
+	}
+	closeTunnel, err := openBastion(forgeConfig)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	return forger.Forge(workspace.StacksDir(), opts.lockWait)
+}
+
+// openBastion tunnels the cluster connections through the bastion in the
+// config, if there is one, and returns the function closing the tunnel.
+func openBastion(forgeConfig utils.ForgeConfig) (func(), error) {
+	if forgeConfig.Bastion == nil {
+		return func() {}, nil
+	}
+	tunnel, err := utils.OpenTunnel(*forgeConfig.Bastion)
+	if err != nil {
+		return nil, err
+	}
+	return tunnel.Close, nil
+}
+
+// openWorkspaceBastion opens the bastion tunnel of the workspace's config.
+// The read-only commands also run outside a project, where there is no
+// config and so no bastion to open.
+func openWorkspaceBastion(opts options) (func(), error) {
+	bastion, err := workspaceBastion(opts)
+	if err != nil {
+		return nil, err
+	}
+	return openBastion(utils.ForgeConfig{Bastion: bastion})
+}
+
+// workspaceBastion returns the bastion of the workspace's config, or nil if
+// there is none.
+func workspaceBastion(opts options) (*utils.Bastion, error) {
+	workspace, err := utils.OpenWorkspace(opts.workspace)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(workspace.ConfigFile()); err != nil {
+		return nil, nil
+	}
+	forgeConfig, err := utils.LoadForgeConfig(workspace.ConfigFile())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	return forgeConfig.Bastion, nil
+}
+
+// setupInCluster sets up logging to stdout, for kubectl logs, and returns
+// the client configuration, for commands which run inside the cluster
+// without a workspace. The log file goes to logDir.
+func setupInCluster(opts options, logDir string) (*rest.Config, error) {
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	opts.log.Dir = logDir
+	if err := utils.Setup(opts.log); err != nil {
+		return nil, err
+	}
+	utils.LogToStdout()
+	kubeConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, utils.Errorf(utils.ConfigError, "failed to configure Kubernetes client: %w", err)
+	}
+	return kubeConfig, nil
+}
+
+func runApplyStack(opts options, stackPath string) error {
+	kubeConfig, err := setupInCluster(opts, filepath.Join(os.TempDir(), "forge-logs"))
+	if err != nil {
+		return err
+	}
+	return forger.Apply(kubeConfig, stackPath, opts.lockWait)
+}
+
+func runOperator(opts options, cacheDir string, pollInterval time.Duration) error {
+	kubeConfig, err := setupInCluster(opts, cacheDir)
+	if err != nil {
+		return err
+	}
+	controller, err := operator.NewController(kubeConfig, cacheDir, pollInterval)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return controller.Run(ctx)
+}
+
+func runVerifyReproducible(opts options, tools []string) error {
+	workspace, err := setup(opts)
+	if err != nil {
+		return err
+	}
+	forgeConfig, err := utils.LoadForgeConfig(workspace.ConfigFile())
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := utils.RegisterSmeltConfig(forgeConfig); err != nil {
+		return err
+	}
+	tools, err = selectTools(forgeConfig.Tools, tools)
+	if err != nil {
+		return err
+	}
+	// Fetching missing values files writes to the input directory
+	lock, err := utils.AcquireRunLock(workspace.Root, "verify-reproducible", opts.lockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	runDir, err := utils.NewRunDir(workspace, opts.keepWorkdir)
+	if err != nil {
+		return err
+	}
+	defer runDir.Cleanup()
+
+	differences, err := smelter.VerifyReproducible(forgeConfig, tools, runDir.Path)
+	if err != nil {
+		return err
+	}
+	if len(differences) > 0 {
+		return utils.Errorf(utils.ValidationError, "smelt output is not reproducible, these files differ between two runs:\n%s", strings.Join(differences, "\n"))
+	}
+	if !utils.Quiet() {
+		fmt.Printf("Smelt output of %d tools is reproducible\n", len(tools))
+	}
+	return nil
+}
+
+func runSnapshot(opts options, tools []string, update bool, recreatePlan string) error {
+	workspace, err := setup(opts)
+	if err != nil {
+		return err
+	}
+	forgeConfig, err := utils.LoadForgeConfig(workspace.ConfigFile())
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	// Pull secrets are left out, as they depend on the cluster
+	forgeConfig.PullSecrets = nil
+	if err := utils.RegisterSmeltConfig(forgeConfig); err != nil {
+		return err
+	}
+	tools, err = selectTools(forgeConfig.Tools, tools)
+	if err != nil {
+		return err
+	}
+	lock, err := utils.AcquireRunLock(workspace.Root, "snapshot", opts.lockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	runDir, err := utils.NewRunDir(workspace, opts.keepWorkdir)
+	if err != nil {
+		return err
+	}
+	defer runDir.Cleanup()
+
+	diffs, err := smelter.SnapshotTools(forgeConfig.Tools, tools, runDir.Path, workspace.SnapshotsDir(), update)
+	if err != nil {
+		return err
+	}
+	var changed []string
+	var recreate []immutable.Change
+	var permissions []rbac.Change
+	for _, tool := range tools {
+		if diff, exists := diffs[tool]; exists {
+			changed = append(changed, tool)
+			recreate = append(recreate, diff.Recreate...)
+			permissions = append(permissions, diff.Permissions...)
+			fmt.Printf("%s:\n%s\n", tool, diff)
+		}
+	}
+	if len(permissions) > 0 {
+		// Chart upgrades granting more privileges are easy to miss among
+		// the changed files, so list them all together
+		fmt.Println("\nRBAC permission changes:")
+		for _, change := range permissions {
+			fmt.Println(change)
+		}
+	}
+	if recreatePlan != "" && len(recreate) > 0 {
+		if err := os.WriteFile(recreatePlan, []byte(immutable.Plan(recreate)), 0755); err != nil {
+			return fmt.Errorf("failed to write recreate plan: %w", err)
+		}
+		fmt.Printf("Wrote a plan recreating %d objects to %s\n", len(recreate), recreatePlan)
+	}
+	if update {
+		if !utils.Quiet() {
+			fmt.Printf("Updated snapshots of %d of %d tools in %s, review them with git diff\n", len(changed), len(tools), workspace.SnapshotsDir())
+		}
+		return nil
+	}
+	if len(changed) > 0 {
+		return utils.Errorf(utils.ValidationError, "output of %s differs from the snapshots, review the changes and rerun with --update to accept them", strings.Join(changed, ", "))
+	}
+	if !utils.Quiet() {
+		fmt.Printf("Output of %d tools matches the snapshots\n", len(tools))
+	}
+	return nil
+}
+
+func runValidate(opts options, tools []string, strict bool) error {
+	workspace, err := setup(opts)
+	if err != nil {
+		return err
+	}
+	forgeConfig, err := utils.LoadForgeConfig(workspace.ConfigFile())
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	// Pull secrets are left out, as they depend on the cluster
+	forgeConfig.PullSecrets = nil
+	if err := utils.RegisterSmeltConfig(forgeConfig); err != nil {
+		return err
+	}
+	tools, err = selectTools(forgeConfig.Tools, tools)
+	if err != nil {
+		return err
+	}
+	runDir, err := utils.NewRunDir(workspace, opts.keepWorkdir)
+	if err != nil {
+		return err
+	}
+	defer runDir.Cleanup()
+	// Values files fetched for tools without any go to the scratch
+	// directory rather than the input directory
+	utils.SetInputDirs(append([]string{filepath.Join(runDir.Path, "input")}, workspace.InputDirs()...))
+
+	result, err := smelter.Validate(forgeConfig.Tools, tools, runDir.Path)
+	for _, warning := range result.Warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+	for _, problem := range result.Errors {
+		fmt.Printf("error: %s\n", problem)
+	}
+	if err != nil {
+		return err
+	}
+	if len(result.Errors) > 0 {
+		return utils.Errorf(utils.ValidationError, "validation found %d errors", len(result.Errors))
+	}
+	if strict && len(result.Warnings) > 0 {
+		return utils.Errorf(utils.ValidationError, "validation found %d warnings", len(result.Warnings))
+	}
+	if !utils.Quiet() {
+		fmt.Printf("Validated %d tools\n", len(tools))
+	}
+	return nil
+}
+
+// selectTools checks the tools given on the command line against the config.
+// No tools selects all of them.
+func selectTools(configs []utils.Config, tools []string) ([]string, error) {
+	if len(tools) == 0 {
+		for _, config := range configs {
+			tools = append(tools, config.Name)
+		}
+		return tools, nil
+	}
+	for _, tool := range tools {
+		found := false
+		for _, config := range configs {
+			if config.Name == tool {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, utils.Errorf(utils.ConfigError, "unknown tool '%s' in --tools", tool)
+		}
+	}
+	return tools, nil
+}
+
+// setupWithoutWorkspace starts logging into the temp directory, for the
+// commands which are run from anywhere like kubectl.
+func setupWithoutWorkspace(opts options) error {
+	opts.log.Dir = filepath.Join(os.TempDir(), "forge-logs")
+	if err := os.MkdirAll(opts.log.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	return utils.Setup(opts.log)
+}
+
+func runStatus(opts options) error {
+	if err := setupWithoutWorkspace(opts); err != nil {
+		return err
+	}
+	opts.kubectl.NoPrompt = true
+	forger.UseKubectlOptions(opts.kubectl)
+	closeTunnel, err := openWorkspaceBastion(opts)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	kubeConfig, err := forger.KubeConfig()
+	if err != nil {
+		return err
+	}
+	holder, err := forger.ClusterLockHolder(kubeConfig)
+	if err != nil {
+		return err
+	}
+	if holder != "" {
+		fmt.Printf("A stack is being deployed by %s\n", holder)
+	}
+	installed, err := forger.InstalledReleases(kubeConfig)
+	if err != nil {
+		return err
+	}
+	for _, record := range installed {
+		fmt.Print(forger.FormatRelease(record))
+	}
+	if len(installed) == 0 {
+		fmt.Println("No release recorded in the cluster")
+	}
+	releases, err := operator.ListReleases(context.Background(), kubeConfig)
+	if err != nil {
+		return err
+	}
+	if len(releases) == 0 {
+		fmt.Println("No ForgeReleases found")
+		return nil
+	}
+	fmt.Print(operator.FormatReleases(releases))
+	return nil
+}
+
+func runDrift(opts options, stackPath string, recreatePlan string) error {
+	workspace, err := setup(opts)
+	if err != nil {
+		return err
+	}
+	opts.kubectl.NoPrompt = true
+	forger.UseKubectlOptions(opts.kubectl)
+	closeTunnel, err := openWorkspaceBastion(opts)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	kubeConfig, err := forger.KubeConfig()
+	if err != nil {
+		return err
+	}
+	installed, err := forger.InstalledReleases(kubeConfig)
+	if err != nil {
+		return err
+	}
+	if stackPath == "" {
+		stackPath, err = driftStack(workspace, installed)
+		if err != nil {
+			return err
+		}
+	}
+	if record := forger.FindRelease(installed, filepath.Base(stackPath)); record != nil {
+		digest, err := utils.StackDigest(stackPath)
+		if err != nil {
+			return err
+		}
+		if digest != record.StackDigest {
+			log.Warnf("The cluster runs stack %s (%s), not %s (%s)", record.Stack, record.StackDigest, stackPath, digest)
+		}
+	} else if len(installed) > 0 {
+		log.Warnf("Stack %s is not recorded as installed in the cluster", filepath.Base(stackPath))
+	}
+	diff, err := forger.Drift(stackPath)
+	if err != nil {
+		return err
+	}
+	// The diff covers the composition and stack; whether the tools'
+	// manifests are applied as they are shows in the state of the Objects
+	// wrapping them
+	names, err := forger.StackObjects(stackPath)
+	if err != nil {
+		return err
+	}
+	statuses, err := forger.ObjectStatuses(kubeConfig, names)
+	if err != nil {
+		return err
+	}
+	unhealthy := 0
+	for _, status := range statuses {
+		if !status.Healthy() {
+			unhealthy++
+		}
+	}
+	// The Objects can't apply manifests changing immutable fields of the
+	// objects in the cluster, so those are compared with the cluster too
+	manifests, err := forger.StackManifests(stackPath)
+	if err != nil {
+		return err
+	}
+	recreate, err := forger.LiveRecreates(kubeConfig, manifests)
+	if err != nil {
+		return err
+	}
+	if diff == "" && unhealthy == 0 && len(recreate) == 0 {
+		fmt.Printf("The cluster matches %s, its %d Objects are synced and ready\n", stackPath, len(statuses))
+		return nil
+	}
+	fmt.Print(diff)
+	if unhealthy > 0 {
+		fmt.Printf("%d of %d Objects are not synced and ready:\n%s", unhealthy, len(statuses), forger.FormatObjectStatuses(statuses))
+	}
+	for _, change := range recreate {
+		fmt.Println(change)
+	}
+	if recreatePlan != "" && len(recreate) > 0 {
+		if err := os.WriteFile(recreatePlan, []byte(immutable.Plan(recreate)), 0755); err != nil {
+			return fmt.Errorf("failed to write recreate plan: %w", err)
+		}
+		fmt.Printf("Wrote a plan recreating %d objects to %s\n", len(recreate), recreatePlan)
+	}
+	return utils.Errorf(utils.ValidationError, "the cluster has drifted from %s", stackPath)
+}
+
+func runCompare(opts options, contexts []string) error {
+	if len(contexts) != 2 {
+		return utils.Errorf(utils.ConfigError, "compare needs two clusters, give --context twice")
+	}
+	if err := setupWithoutWorkspace(opts); err != nil {
+		return err
+	}
+	var inventories []forger.Inventory
+	for _, contextName := range contexts {
+		inventory, err := takeInventory(opts, contextName)
+		if err != nil {
+			return err
+		}
+		inventories = append(inventories, inventory)
+	}
+	differences := forger.CompareInventories(inventories[0], inventories[1])
+	if len(differences) == 0 {
+		fmt.Printf("%s and %s have the same tools and objects\n", contexts[0], contexts[1])
+		return nil
+	}
+	for _, difference := range differences {
+		fmt.Println(difference)
+	}
+	return utils.Errorf(utils.ValidationError, "%s and %s differ in %d ways", contexts[0], contexts[1], len(differences))
+}
+
+// takeInventory takes the inventory of a context of the kubeconfig, through
+// a bastion tunnel of its own. The clients are pointed at the tunnel
+// directly, as Go reads HTTPS_PROXY only once per process.
+func takeInventory(opts options, contextName string) (forger.Inventory, error) {
+	kubeConfig, err := forger.KubeConfigForContext(opts.kubectl.Kubeconfig, contextName)
+	if err != nil {
+		return forger.Inventory{}, err
+	}
+	bastion, err := workspaceBastion(opts)
+	if err != nil {
+		return forger.Inventory{}, err
+	}
+	if bastion != nil {
+		tunnel, err := utils.OpenTunnel(*bastion)
+		if err != nil {
+			return forger.Inventory{}, err
+		}
+		defer tunnel.Close()
+		proxyURL, err := url.Parse(tunnel.ProxyURL)
+		if err != nil {
+			return forger.Inventory{}, fmt.Errorf("invalid tunnel address %s: %w", tunnel.ProxyURL, err)
+		}
+		kubeConfig.Proxy = http.ProxyURL(proxyURL)
+	}
+	if !utils.Quiet() {
+		fmt.Printf("Taking inventory of %s...\n", contextName)
+	}
+	return forger.TakeInventory(contextName, kubeConfig)
+}
+
+// driftStack returns the stack to compare the cluster with: the installed
+// one if it is in the workspace, otherwise the newest. A cluster running
+// several stacks needs the stack to be named.
+func driftStack(workspace utils.Workspace, installed []utils.ReleaseRecord) (string, error) {
+	if len(installed) > 1 {
+		var stacks []string
+		for _, record := range installed {
+			stacks = append(stacks, record.Stack)
+		}
+		return "", utils.Errorf(utils.ConfigError, "the cluster runs several stacks (%s), name the one to compare", strings.Join(stacks, ", "))
+	}
+	if len(installed) == 1 {
+		stackPath := filepath.Join(workspace.StacksDir(), installed[0].Stack)
+		if _, err := os.Stat(stackPath); err == nil {
+			return stackPath, nil
+		}
+		log.Warnf("The installed stack %s is not in %s, comparing with the newest stack", installed[0].Stack, workspace.StacksDir())
+	}
+	return forger.LatestStack(workspace.StacksDir())
+}
+
+func runClean(opts options, cleanOptions cleaner.Options) error {
+	workspace, err := setup(opts)
+	if err != nil {
+		return err
+	}
+	if !cleanOptions.Working && !cleanOptions.Cache && cleanOptions.KeepStacks < 0 {
+		return utils.Errorf(utils.ConfigError, "nothing to clean, use --working, --cache, --keep-stacks or --all")
+	}
+	// A dry run removes nothing, so it doesn't wait for other runs either
+	if !cleanOptions.DryRun {
+		lock, err := utils.AcquireRunLock(workspace.Root, "clean", opts.lockWait)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+
+	removed, err := cleaner.Clean(cleanOptions, workspace)
+	if err != nil {
+		return err
+	}
+	verb := "Removed"
+	if cleanOptions.DryRun {
+		verb = "Would remove"
+	}
+	for _, path := range removed {
+		fmt.Printf("%s %s\n", verb, path)
+	}
+	if len(removed) == 0 && !utils.Quiet() {
+		fmt.Println("Nothing to remove")
+	}
+	return nil
 }